@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	iofs "io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"astrocam/pkg/astrocam"
+)
+
+// command is a subcommand: astrocam-go NAME [flags] [args].
+type command struct {
+	args    string // argument synopsis for the usage message
+	summary string
+	run     func(args []string) int // returns the exit code
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"run":          {"[-test] [-area NAME]...", "scan, archive and upload continuously (the default)", runCommand},
+		"stations":     {"[-uploads N] PROFILE...", "run several station profiles in one process, taking turns for uploads", stationsCommand},
+		"pack":         {"AREA...", "archive the waiting frames of the given areas once, for the next run to upload", packCommand},
+		"upload":       {"FILE...", "upload archives to the configured server with the configured credentials", uploadCommand},
+		"reupload":     {"[-keep] PATH|GLOB...", "upload archives again, e.g. from the failed directory, waiting SAI_UPLOAD_THROTTLE between them", reuploadCommand},
+		"status":       {"", "show the status of the running instance", statusCommand},
+		"trigger":      {"", "make the running instance scan now instead of at the next interval", triggerCommand},
+		"init":         {"[-profile NAME]", "create config.env and areas.txt by answering a few questions", initCommand},
+		"check-config": {"[-connect]", "validate config.env and areas.txt and report problems", checkConfigCommand},
+		"report":       {"[-night YYYY-MM-DD]", "print the statistics of a night (default: the last one)", reportCommand},
+		"history":      {"[-from DATE] [-to DATE]", "export the recorded upload attempts as CSV", historyCommand},
+		"bench":        {"[-frames N] [-uplink MBIT] DIR", "pack sample frames with every archive format and recommend SAI_ARCHIVE_MODE", benchCommand},
+		"update":       {"[-check] [-yes]", "install the latest release after verifying its checksum, and restart the running instance", updateCommand},
+	}
+}
+
+// usage prints the command-line synopsis, including the subcommands.
+func usage() {
+	out := flag.CommandLine.Output()
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(out, "Usage: %s [COMMAND] [flags] [args]\n\nCommands:\n", name)
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(out, "  %-13s %-24s %s\n", n, commands[n].args, commands[n].summary)
+	}
+	fmt.Fprintf(out, "\nWithout a command, %s runs continuously. Flags:\n", name)
+	flag.PrintDefaults()
+}
+
+// newFlagSet returns the flag set of a subcommand, with a usage message
+// naming its arguments.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n\n%s\n", filepath.Base(os.Args[0]), name, commands[name].args, commands[name].summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseWithConfigFlags adds the config.env override flags to fs, parses args
+// and applies the overrides given.
+func parseWithConfigFlags(fs *flag.FlagSet, args []string) {
+	var settings configFlags
+	settings.register(fs)
+	fs.Parse(args)
+	settings.apply(fs)
+}
+
+// signalContext returns a context cancelled on SIGINT/SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+func runCommand(args []string) int {
+	fs := newFlagSet("run")
+	var opts runOptions
+	opts.register(fs)
+	parseWithConfigFlags(fs, args)
+	return runUntilSignal(opts)
+}
+
+// stationsCommand runs one uploader per profile, as -profile NAME would,
+// until SIGINT or SIGTERM. The stations share the upload link: at most
+// -uploads of them upload at once, taking turns.
+func stationsCommand(args []string) int {
+	fs := newFlagSet("stations")
+	parallel := fs.Int("uploads", 1, "Uploads running at once across all stations")
+	parseWithConfigFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		slog.Info("Shutdown signal received, performing cleanup", "signal", sig)
+		stopOnce.Do(func() { close(stop) })
+	}()
+
+	var apps []*astrocam.AstroCam
+	var locks []*astrocam.FileLock
+	for _, name := range fs.Args() {
+		slog.Info("Starting station", "profile", name)
+		lock, err := acquireLockFile(astrocam.StationFileName(name, "astrocam.lock"))
+		if err != nil {
+			slog.Error(err.Error(), "profile", name)
+			return 1
+		}
+		defer lock.Release()
+		locks = append(locks, lock)
+		app, err := astrocam.NewStation(name, false)
+		if err != nil {
+			slog.Error("Initialization failed", "profile", name, "error", err)
+			return 1
+		}
+		defer app.Close()
+		if err := app.LockCameraDirectory(); err != nil && !errors.Is(err, iofs.ErrNotExist) {
+			slog.Error(err.Error(), "profile", name)
+			return 1
+		}
+		handlePauseSignals(app, stop)
+		apps = append(apps, app)
+	}
+	astrocam.ShareUploads(apps, *parallel)
+
+	// A station restarting after an update restarts them all
+	restart := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, app := range apps {
+		wg.Add(1)
+		go func(app *astrocam.AstroCam) {
+			defer wg.Done()
+			app.Run(stop)
+		}(app)
+		go func(app *astrocam.AstroCam) {
+			select {
+			case <-app.RestartRequested():
+				stopOnce.Do(func() { close(restart); close(stop) })
+			case <-stop:
+			}
+		}(app)
+	}
+	wg.Wait()
+	select {
+	case <-restart:
+	default:
+		return 0
+	}
+	for _, app := range apps {
+		app.Close()
+	}
+	for _, lock := range locks {
+		lock.Release()
+	}
+	if err := restartExecutable(); err != nil {
+		slog.Error("Cannot restart, start the program again to run the update", "error", err)
+		return 1
+	}
+	return 0
+}
+
+func packCommand(args []string) int {
+	fs := newFlagSet("pack")
+	parseWithConfigFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	lock, err := acquireInstanceLock()
+	if err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+	defer lock.Release()
+
+	app, err := astrocam.New(false)
+	if err != nil {
+		slog.Error("Initialization failed", "error", err)
+		return 1
+	}
+	defer app.Close()
+
+	status := 0
+	for _, area := range fs.Args() {
+		archive, err := app.PackArea(area)
+		switch {
+		case err != nil:
+			slog.Error("Cannot pack area", "area", area, "error", err)
+			status = 1
+		case archive == "":
+			fmt.Printf("%s: no archive created (no frames waiting)\n", area)
+		default:
+			fmt.Printf("%s: %s\n", area, archive)
+		}
+	}
+	return status
+}
+
+func uploadCommand(args []string) int {
+	fs := newFlagSet("upload")
+	parseWithConfigFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	config := astrocam.LoadConfig()
+	status := 0
+	for _, path := range fs.Args() {
+		if err := astrocam.UploadFile(ctx, config, path); err != nil {
+			slog.Error("Upload failed", "file", path, "error", err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func reuploadCommand(args []string) int {
+	fs := newFlagSet("reupload")
+	keep := fs.Bool("keep", false, "Keep archives from the failed and needs-review directories after they were uploaded")
+	parseWithConfigFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	config := astrocam.LoadConfig()
+	archives, err := astrocam.ResolveArchives(config, fs.Args())
+	if err != nil {
+		slog.Error("Cannot find archives", "error", err)
+		return 1
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	failed := 0
+	for i, path := range archives {
+		if i > 0 {
+			select {
+			case <-time.After(config.UploadThrottle):
+			case <-ctx.Done():
+				slog.Info("Interrupted", "uploaded", i-failed, "remaining", len(archives)-i)
+				return 1
+			}
+		}
+		if err := astrocam.ReuploadArchive(ctx, config, path, *keep); err != nil {
+			slog.Error("Upload failed", "file", path, "error", err)
+			failed++
+		}
+	}
+	fmt.Printf("%d of %d archives uploaded\n", len(archives)-failed, len(archives))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func statusCommand(args []string) int {
+	fs := newFlagSet("status")
+	parseWithConfigFlags(fs, args)
+
+	data, err := astrocam.FetchStatus(astrocam.LoadConfig())
+	if err != nil {
+		slog.Error("Cannot get status", "error", err)
+		return 1
+	}
+	os.Stdout.Write(data)
+	return 0
+}
+
+func triggerCommand(args []string) int {
+	fs := newFlagSet("trigger")
+	parseWithConfigFlags(fs, args)
+
+	message, err := astrocam.ControlInstance(astrocam.LoadConfig(), "trigger")
+	if err != nil {
+		slog.Error("Cannot trigger a scan", "error", err)
+		return 1
+	}
+	fmt.Println(message)
+	return 0
+}
+
+func checkConfigCommand(args []string) int {
+	fs := newFlagSet("check-config")
+	connect := fs.Bool("connect", false, "Also ask the upload server whether it accepts uploads")
+	parseWithConfigFlags(fs, args)
+
+	if reportConfig(astrocam.LoadConfig(), *connect) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func reportCommand(args []string) int {
+	fs := newFlagSet("report")
+	night := fs.String("night", "", "Date of the evening the night started")
+	parseWithConfigFlags(fs, args)
+
+	start := astrocam.LastNight(time.Now())
+	if *night != "" {
+		t, err := time.ParseInLocation("2006-01-02", *night, time.Local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -night %q, expected YYYY-MM-DD\n", *night)
+			return 2
+		}
+		start = t
+	}
+	report, err := astrocam.NightReport(start)
+	if err != nil {
+		slog.Error("Cannot create report", "error", err)
+		return 1
+	}
+	fmt.Print(report)
+	return 0
+}
+
+func benchCommand(args []string) int {
+	fs := newFlagSet("bench")
+	frames := fs.Int("frames", 0, "Number of frames to pack (default SAI_COUNT)")
+	uplink := fs.Float64("uplink", 0, "Upload speed in Mbit/s (default: measured from the recorded uploads)")
+	parseWithConfigFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	config := astrocam.LoadConfig()
+	if *frames <= 0 {
+		*frames = config.Count
+	}
+	if err := astrocam.BenchArchivers(config, fs.Arg(0), *frames, *uplink*1e6/8, os.Stdout); err != nil {
+		slog.Error("Benchmark failed", "error", err)
+		return 1
+	}
+	return 0
+}
+
+func historyCommand(args []string) int {
+	fs := newFlagSet("history")
+	from := fs.String("from", "", "First day to export (YYYY-MM-DD)")
+	to := fs.String("to", "", "Last day to export (YYYY-MM-DD)")
+	parseWithConfigFlags(fs, args)
+
+	var fromTime, toTime time.Time
+	for _, d := range []struct {
+		flag  string
+		value string
+		t     *time.Time
+	}{{"from", *from, &fromTime}, {"to", *to, &toTime}} {
+		if d.value == "" {
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02", d.value, time.Local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -%s %q, expected YYYY-MM-DD\n", d.flag, d.value)
+			return 2
+		}
+		*d.t = t
+	}
+	if !toTime.IsZero() {
+		toTime = toTime.AddDate(0, 0, 1) // the whole last day
+	}
+	if err := astrocam.ExportUploadHistory(os.Stdout, fromTime, toTime); err != nil {
+		slog.Error("Cannot export upload history", "error", err)
+		return 1
+	}
+	return 0
+}
+
+// reportConfig prints the main settings and the findings of CheckConfig and
+// returns the number of errors found.
+func reportConfig(config *astrocam.Config, connect bool) int {
+	areas, err := astrocam.LoadAreas()
+	if err != nil {
+		slog.Error("Cannot read areas.txt", "error", err)
+	}
+
+	fmt.Printf("Server:              %s\n", config.Server)
+	fmt.Printf("Camera directory:    %s\n", config.CameraDirectory)
+	fmt.Printf("Processed directory: %s\n", config.ProcessedDirectory)
+	fmt.Printf("Frames per archive:  %d\n", config.Count)
+	fmt.Printf("Scan interval:       %ds\n", max(config.Interval, astrocam.MIN_INTERVAL))
+	fmt.Printf("Archive mode:        %s\n", config.ArchiveMode)
+	fmt.Printf("Areas:               %d %v\n", len(areas), areas)
+	fmt.Println()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	errors, warnings := 0, 0
+	for _, f := range astrocam.CheckConfig(ctx, config, areas, connect) {
+		switch f.Level {
+		case "error":
+			errors++
+		case "warning":
+			warnings++
+		}
+		fmt.Printf("%-8s %-24s %s\n", strings.ToUpper(f.Level), f.Setting, f.Message)
+	}
+	fmt.Printf("\n%d error(s), %d warning(s)\n", errors, warnings)
+	return errors
+}
+
+// updateCommand installs the latest release, when it is newer than this
+// build, after asking for confirmation, and asks the running instance to
+// restart with it.
+func updateCommand(args []string) int {
+	fs := newFlagSet("update")
+	check := fs.Bool("check", false, "Only report whether a newer release is available")
+	yes := fs.Bool("yes", false, "Install without asking")
+	parseWithConfigFlags(fs, args)
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	config := astrocam.LoadConfig()
+	release, err := astrocam.LatestRelease(ctx, config)
+	if err != nil {
+		slog.Error("Cannot check for a new release", "error", err)
+		return 1
+	}
+	running := astrocam.Version
+	if running == "" {
+		running = "a development build"
+	}
+	fmt.Printf("Running %s, the latest release is %s %s\n", running, release.Version, release.URL)
+	if astrocam.Version != "" && !release.NewerThanRunning() {
+		fmt.Println("Up to date")
+		return 0
+	}
+	if *check {
+		return 0
+	}
+	if !*yes {
+		w := &wizard{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+		install, err := w.confirm("Install "+release.Version+"?", false)
+		if err != nil || !install {
+			return 1
+		}
+	}
+	if err := astrocam.InstallRelease(ctx, config, release); err != nil {
+		slog.Error("Update failed, keeping the running version", "error", err)
+		return 1
+	}
+	if message, err := astrocam.ControlInstance(config, "restart"); err == nil {
+		fmt.Println("Running instance:", message)
+	} else {
+		fmt.Printf("Installed %s; restart astrocam-go, or the service, to run it\n", release.Version)
+	}
+	return 0
+}
@@ -0,0 +1,368 @@
+// Command astrocam-go uploads new camera frames to the processing server. It
+// is a thin command-line wrapper around package astrocam.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"syscall"
+
+	"astrocam/pkg/astrocam"
+)
+
+// Version is set by build flags during release builds
+var version string
+
+// startedExecutable is the path the program was started from, which an update
+// replaces: os.Executable follows the running file once it is moved aside.
+var startedExecutable, _ = os.Executable()
+
+func main() {
+	// Disable Windows QuickEdit mode first thing to prevent console freezing
+	// This function is implemented in platform-specific files (quickedit_*.go)
+	disableQuickEditMode()
+	useUTF8Console()
+
+	astrocam.Version = version
+	astrocam.SetLogColor(colorTerminal())
+	astrocam.SetupLogging("text")
+
+	// astrocam-go COMMAND ...
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+
+	// Define all flags consistently using flag package
+	var opts runOptions
+	opts.register(flag.CommandLine)
+	var settings configFlags
+	settings.register(flag.CommandLine)
+	showVersion := flag.Bool("version", false, "Show version information")
+	serviceCommand := flag.String("service", "", "Windows service control: install, uninstall, start or stop")
+	selfTest := flag.Bool("selftest", false, "Run one cycle against a built-in test server with synthetic frames and exit 0 on success")
+
+	// Parse all flags
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() > 0 {
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	settings.apply(flag.CommandLine)
+
+	// Handle version flag after parsing
+	if *showVersion {
+		if version != "" {
+			fmt.Printf("AstroCam-GO %s\n", version)
+		} else {
+			fmt.Println("AstroCam-GO (development build)")
+		}
+		return
+	}
+
+	if *selfTest {
+		if err := astrocam.SelfTest(); err != nil {
+			fmt.Println("SELF-TEST FAILED:", err)
+			os.Exit(1)
+		}
+		fmt.Println("SELF-TEST PASSED")
+		return
+	}
+
+	if *serviceCommand != "" {
+		if err := controlService(*serviceCommand); err != nil {
+			slog.Error("Service command failed", "command", *serviceCommand, "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Started by the Windows service control manager
+	if isWindowsService() {
+		runService()
+		return
+	}
+
+	os.Exit(runUntilSignal(opts))
+}
+
+// runOptions are the command-line settings of a continuous run.
+type runOptions struct {
+	testMode   bool
+	tray       bool       // show the status in a tray icon instead of a console window (Windows)
+	tui        bool       // show a dashboard in the terminal instead of the scrolling log
+	areas      stringList // restrict the run to these areas
+	cpuProfile string     // write a CPU profile of the whole run to this file
+	memProfile string     // write a heap profile to this file on exit
+}
+
+// register adds the run flags to fs.
+func (o *runOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.testMode, "test", false, "Run in test mode (exit on errors, timeout after 2 minutes)")
+	fs.Var(&o.areas, "area", "Process only this area (repeatable); other areas' frames are left alone")
+	fs.BoolVar(&o.tray, "tray", false, "Show the status in a tray icon and log to astrocam-tray.log instead of a console window (Windows)")
+	fs.BoolVar(&o.tui, "tui", false, "Show a status dashboard in the terminal instead of the scrolling log")
+	fs.StringVar(&o.cpuProfile, "cpuprofile", "", "Write a CPU profile of the run to this file, for go tool pprof")
+	fs.StringVar(&o.memProfile, "memprofile", "", "Write a heap profile to this file on exit, for go tool pprof")
+}
+
+// configFlags are command-line overrides of the config.env settings: -server
+// for SAI_SERVER, -camera-directory for SAI_CAMERA_DIRECTORY and so on, plus
+// the repeatable -fits-key KEYWORD=VALUE for SAI_FITS_KEY_<KEYWORD> and
+// -upload-field NAME=VALUE for SAI_UPLOAD_FIELD_<NAME>, and -v/-debug and
+// -q/-quiet for SAI_LOG_LEVEL debug and warn.
+type configFlags struct {
+	profile      string
+	fitsKeys     stringList
+	uploadFields stringList
+	verbose      bool
+	quiet        bool
+}
+
+// configFlagName returns the flag overriding a config.env key.
+func configFlagName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(key, "SAI_"), "_", "-"))
+}
+
+// register adds a flag for every config.env setting to fs.
+func (c *configFlags) register(fs *flag.FlagSet) {
+	for _, key := range astrocam.ConfigKeys() {
+		fs.String(configFlagName(key), "", "Override "+key)
+	}
+	fs.Var(&c.fitsKeys, "fits-key", "Override SAI_FITS_KEY_<KEYWORD> with KEYWORD=VALUE (repeatable)")
+	fs.Var(&c.uploadFields, "upload-field", "Override SAI_UPLOAD_FIELD_<NAME> with NAME=VALUE (repeatable)")
+	fs.StringVar(&c.profile, "profile", "", "Use config.NAME.env, areas.NAME.txt and a separate temp directory")
+	for _, name := range []string{"v", "debug"} {
+		fs.BoolVar(&c.verbose, name, false, "Print debug messages too, such as every frame handled (SAI_LOG_LEVEL=debug)")
+	}
+	for _, name := range []string{"q", "quiet"} {
+		fs.BoolVar(&c.quiet, name, false, "Print only warnings and errors (SAI_LOG_LEVEL=warn)")
+	}
+}
+
+// apply passes the config flags given on the command line (after fs.Parse)
+// to astrocam.SetConfigOverrides and selects the profile. Flags that were not
+// given leave the environment and config.env settings alone.
+func (c *configFlags) apply(fs *flag.FlagSet) {
+	if err := astrocam.SetProfile(c.profile); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		os.Exit(2)
+	}
+	keys := make(map[string]string)
+	for _, key := range astrocam.ConfigKeys() {
+		keys[configFlagName(key)] = key
+	}
+	overrides := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := keys[f.Name]; ok {
+			overrides[key] = f.Value.String()
+		}
+	})
+	switch {
+	case c.verbose && c.quiet:
+		fmt.Fprintln(fs.Output(), "-debug and -quiet cannot be used together")
+		os.Exit(2)
+	case c.verbose:
+		overrides["SAI_LOG_LEVEL"] = "debug"
+		astrocam.SetLogLevel(slog.LevelDebug)
+	case c.quiet:
+		overrides["SAI_LOG_LEVEL"] = "warn"
+		astrocam.SetLogLevel(slog.LevelWarn)
+	}
+	for _, kv := range c.fitsKeys {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			overrides["SAI_FITS_KEY_"+strings.ToUpper(name)] = value
+		} else {
+			slog.Warn("Ignoring -fits-key without KEYWORD=VALUE", "value", kv)
+		}
+	}
+	for _, kv := range c.uploadFields {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			overrides["SAI_UPLOAD_FIELD_"+strings.ToUpper(name)] = value
+		} else {
+			slog.Warn("Ignoring -upload-field without NAME=VALUE", "value", kv)
+		}
+	}
+	astrocam.SetConfigOverrides(overrides)
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runUntilSignal runs the uploader until SIGINT or SIGTERM and returns the
+// exit code.
+func runUntilSignal(opts runOptions) int {
+	if opts.tray {
+		detachConsole()
+	}
+
+	// Set up signal handling for graceful shutdown; the tray menu can end the
+	// run too
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	quit := func() { stopOnce.Do(func() { close(stop) }) }
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		slog.Info("Shutdown signal received, performing cleanup", "signal", sig)
+		quit()
+	}()
+
+	if opts.cpuProfile != "" {
+		stopProfile, err := startCPUProfile(opts.cpuProfile)
+		if err != nil {
+			slog.Error("Cannot start CPU profile", "file", opts.cpuProfile, "error", err)
+			return 1
+		}
+		defer stopProfile()
+	}
+	if opts.memProfile != "" {
+		defer writeHeapProfile(opts.memProfile)
+	}
+
+	ok, restart := runAstroCam(opts, stop, quit)
+	if !ok {
+		return 1
+	}
+	if restart {
+		if err := restartExecutable(); err != nil {
+			slog.Error("Cannot restart, start the program again to run the update", "error", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// stdoutTerminal reports whether standard output is a terminal that
+// understands ANSI escape sequences, enabling them on Windows.
+func stdoutTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	return enableVirtualTerminal()
+}
+
+// colorTerminal reports whether warnings and errors are to be printed in
+// color: on a terminal, unless NO_COLOR is set (https://no-color.org).
+func colorTerminal() bool {
+	return os.Getenv("NO_COLOR") == "" && stdoutTerminal()
+}
+
+// startCPUProfile profiles the CPU usage into path until the returned
+// function is called.
+func startCPUProfile(path string) (func(), error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	slog.Info("Writing CPU profile", "file", path)
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+	}, nil
+}
+
+// writeHeapProfile writes the live heap, after a garbage collection, to path.
+func writeHeapProfile(path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		slog.Error("Cannot write heap profile", "file", path, "error", err)
+		return
+	}
+	defer f.Close()
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		slog.Error("Cannot write heap profile", "file", path, "error", err)
+		return
+	}
+	slog.Info("Heap profile written", "file", path)
+}
+
+// acquireInstanceLock takes the lock that prevents multiple instances from
+// running simultaneously. The lock file is placed next to the executable (or
+// in the current directory as fallback), which also protects the temp
+// directory kept there.
+func acquireInstanceLock() (*astrocam.FileLock, error) {
+	return acquireLockFile(astrocam.ProfileFileName("astrocam.lock"))
+}
+
+// acquireLockFile takes the instance lock file of the given name next to the
+// executable.
+func acquireLockFile(lockPath string) (*astrocam.FileLock, error) {
+	if execPath, err := os.Executable(); err == nil {
+		lockPath = filepath.Join(filepath.Dir(execPath), lockPath)
+	}
+	return astrocam.AcquireFileLock(lockPath)
+}
+
+// runAstroCam acquires the instance lock, initializes the uploader and runs
+// it until stop is closed, or quit is called from the tray menu. It returns
+// false if startup failed, and restart when the uploader stopped to be started
+// again, e.g. after an update.
+func runAstroCam(opts runOptions, stop <-chan struct{}, quit func()) (ok, restart bool) {
+	lock, err := acquireInstanceLock()
+	if err != nil {
+		slog.Error(err.Error())
+		return false, false
+	}
+	defer lock.Release()
+
+	app, err := astrocam.New(opts.testMode)
+	if err != nil {
+		slog.Error("Initialization failed", "error", err)
+		return false, false
+	}
+	defer app.Close()
+	if len(opts.areas) > 0 {
+		app.RestrictAreas(opts.areas)
+	}
+
+	// A second installation working on the same camera directory is refused
+	// too. If the directory is not there yet (share not mounted), the lock is
+	// taken by the first scan that finds it.
+	if err := app.LockCameraDirectory(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error(err.Error())
+		return false, false
+	}
+
+	handlePauseSignals(app, stop)
+	if opts.tray {
+		defer showTray(app, quit)()
+	}
+	if opts.tui {
+		defer startTUI(app, quit)()
+	}
+	app.Run(stop)
+	select {
+	case <-app.RestartRequested():
+		return true, true
+	default:
+		return true, false
+	}
+}
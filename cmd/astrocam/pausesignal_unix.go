@@ -0,0 +1,35 @@
+//go:build !windows
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"astrocam/pkg/astrocam"
+)
+
+// handlePauseSignals pauses app on SIGUSR1 and resumes it on SIGUSR2 until
+// stop is closed.
+func handlePauseSignals(app *astrocam.AstroCam, stop <-chan struct{}) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(sigChan)
+		for {
+			select {
+			case sig := <-sigChan:
+				slog.Info("Signal received", "signal", sig)
+				if sig == syscall.SIGUSR1 {
+					app.Pause()
+				} else {
+					app.Resume()
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
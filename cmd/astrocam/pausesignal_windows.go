@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "astrocam/pkg/astrocam"
+
+// No pause signals on Windows; the PAUSE file in the temp directory works on
+// every system
+func handlePauseSignals(app *astrocam.AstroCam, stop <-chan struct{}) {}
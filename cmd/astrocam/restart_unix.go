@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// restartExecutable replaces the process with a new run of the executable,
+// which may have been updated meanwhile, with the same arguments.
+func restartExecutable() error {
+	if startedExecutable == "" {
+		return errors.New("executable path unknown")
+	}
+	return syscall.Exec(startedExecutable, os.Args, os.Environ())
+}
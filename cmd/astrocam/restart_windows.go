@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// restartExecutable starts the executable, which may have been updated
+// meanwhile, again with the same arguments in the same console; the caller
+// then exits. A service is restarted by the service manager instead.
+func restartExecutable() error {
+	if startedExecutable == "" {
+		return errors.New("executable path unknown")
+	}
+	_, err := os.StartProcess(startedExecutable, os.Args, &os.ProcAttr{
+		Env:   os.Environ(),
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+	})
+	return err
+}
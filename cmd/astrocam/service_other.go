@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// isWindowsService reports whether the process was started by the Windows
+// service control manager; never on this platform.
+func isWindowsService() bool {
+	return false
+}
+
+// runService is only reachable on Windows.
+func runService() {}
+
+// controlService rejects -service: on Linux and macOS run astrocam-go under
+// systemd or launchd instead.
+func controlService(command string) error {
+	return fmt.Errorf("-service is only supported on Windows, use systemd or launchd on this system")
+}
@@ -0,0 +1,213 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"astrocam/pkg/astrocam"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceName        = "AstroCam"
+	serviceDisplayName = "AstroCam image uploader"
+	serviceDescription = "Archives new camera frames and uploads them to the processing server."
+	serviceLogFile     = "astrocam-service.log"
+
+	// serviceStopWait bounds how long a stop request waits for the main loop.
+	// An upload in progress may take longer; the archive is kept in temp and
+	// uploaded again after the next start.
+	serviceStopWait = 20 * time.Second
+)
+
+// isWindowsService reports whether the process was started by the Windows
+// service control manager.
+func isWindowsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// astrocamService runs the uploader under the service control manager.
+type astrocamService struct{}
+
+func (s *astrocamService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	type result struct{ ok, restart bool }
+	done := make(chan result, 1)
+	go func() {
+		ok, restart := runAstroCam(runOptions{}, stop, nil)
+		done <- result{ok, restart}
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+
+	for {
+		select {
+		case r := <-done:
+			// The uploader exited on its own, which only happens on startup
+			// errors and for a restart. Either way, exiting with an error
+			// makes the service manager start the service again.
+			if r.restart {
+				restartOnErrorExit()
+				slog.Info("Exiting for the service manager to restart the service")
+			}
+			if !r.ok || r.restart {
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(serviceStopWait / time.Millisecond)}
+				slog.Info("Service stop requested, performing cleanup")
+				close(stop)
+				select {
+				case <-done:
+				case <-time.After(serviceStopWait):
+					slog.Warn("Main loop still busy, stopping service anyway")
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// runService runs as a service. There is no console, so log messages go to
+// astrocam-service.log next to the executable.
+func runService() {
+	if execPath, err := os.Executable(); err == nil {
+		logPath := filepath.Join(filepath.Dir(execPath), serviceLogFile)
+		if f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			astrocam.SetLogOutput(f)
+			astrocam.SetupLogging("text")
+		}
+	}
+
+	slog.Info("Starting as Windows service", "name", serviceName)
+	if err := svc.Run(serviceName, &astrocamService{}); err != nil {
+		slog.Error("Service failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// controlService implements -service install|uninstall|start|stop.
+func controlService(command string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("cannot connect to the service manager (run as Administrator): %w", err)
+	}
+	defer m.Disconnect()
+
+	if command == "install" {
+		return installService(m)
+	}
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+
+	switch command {
+	case "uninstall":
+		if err := s.Delete(); err != nil {
+			return err
+		}
+		slog.Info("Service removed", "name", serviceName)
+	case "start":
+		if err := s.Start(); err != nil {
+			return err
+		}
+		slog.Info("Service started", "name", serviceName)
+	case "stop":
+		state, err := s.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(serviceStopWait + 10*time.Second)
+		for state.State != svc.Stopped {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("service did not stop within %s", serviceStopWait+10*time.Second)
+			}
+			time.Sleep(300 * time.Millisecond)
+			if state, err = s.Query(); err != nil {
+				return err
+			}
+		}
+		slog.Info("Service stopped", "name", serviceName)
+	default:
+		return fmt.Errorf("unknown service command %q (use install, uninstall, start or stop)", command)
+	}
+	return nil
+}
+
+// installService registers the executable as an automatically started
+// service that the service manager restarts if it crashes.
+func installService(m *mgr.Mgr) error {
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not get executable path: %w", err)
+	}
+	execPath, err = filepath.Abs(execPath)
+	if err != nil {
+		return err
+	}
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, execPath, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: time.Minute}
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, 24*60*60); err != nil {
+		slog.Warn("Cannot set service recovery actions", "error", err)
+	}
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		slog.Warn("Cannot set service recovery actions", "error", err)
+	}
+
+	slog.Info("Service installed", "name", serviceName, "executable", execPath)
+	return nil
+}
+
+// restartOnErrorExit makes the service manager apply the recovery actions
+// also when the service exits with an error instead of crashing, which
+// services installed by older versions do not.
+func restartOnErrorExit() {
+	m, err := mgr.Connect()
+	if err != nil {
+		slog.Warn("Cannot connect to the service manager", "error", err)
+		return
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		slog.Warn("Cannot open the service", "error", err)
+		return
+	}
+	defer s.Close()
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		slog.Warn("Cannot set service recovery actions", "error", err)
+	}
+}
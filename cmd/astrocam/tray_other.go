@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"log/slog"
+
+	"astrocam/pkg/astrocam"
+)
+
+// detachConsole ignores -tray: there is no tray icon on this platform.
+func detachConsole() {
+	slog.Warn("-tray is only supported on Windows, keeping the console")
+}
+
+// showTray does nothing on this platform.
+func showTray(app *astrocam.AstroCam, quit func()) func() {
+	return func() {}
+}
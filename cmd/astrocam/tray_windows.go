@@ -0,0 +1,375 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+
+	"astrocam/pkg/astrocam"
+
+	"golang.org/x/sys/windows"
+)
+
+// trayLogFile is the log of a -tray run, next to the executable: there is no
+// console to write it to.
+const trayLogFile = "astrocam-tray.log"
+
+// trayRefresh is the time between two looks at the pipeline state.
+const trayRefresh = 2 * time.Second
+
+var (
+	user32                     = windows.NewLazySystemDLL("user32.dll")
+	shell32                    = windows.NewLazySystemDLL("shell32.dll")
+	kernel32                   = windows.NewLazySystemDLL("kernel32.dll")
+	procRegisterClassExW       = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW        = user32.NewProc("CreateWindowExW")
+	procDestroyWindow          = user32.NewProc("DestroyWindow")
+	procDefWindowProcW         = user32.NewProc("DefWindowProcW")
+	procGetMessageW            = user32.NewProc("GetMessageW")
+	procTranslateMessage       = user32.NewProc("TranslateMessage")
+	procDispatchMessageW       = user32.NewProc("DispatchMessageW")
+	procPostMessageW           = user32.NewProc("PostMessageW")
+	procPostQuitMessage        = user32.NewProc("PostQuitMessage")
+	procRegisterWindowMessageW = user32.NewProc("RegisterWindowMessageW")
+	procLoadIconW              = user32.NewProc("LoadIconW")
+	procCreatePopupMenu        = user32.NewProc("CreatePopupMenu")
+	procAppendMenuW            = user32.NewProc("AppendMenuW")
+	procTrackPopupMenu         = user32.NewProc("TrackPopupMenu")
+	procDestroyMenu            = user32.NewProc("DestroyMenu")
+	procSetForegroundWindow    = user32.NewProc("SetForegroundWindow")
+	procGetCursorPos           = user32.NewProc("GetCursorPos")
+	procShellNotifyIconW       = shell32.NewProc("Shell_NotifyIconW")
+	procFreeConsole            = kernel32.NewProc("FreeConsole")
+)
+
+const (
+	wmNull        = 0x0000
+	wmDestroy     = 0x0002
+	wmClose       = 0x0010
+	wmCommand     = 0x0111
+	wmLButtonUp   = 0x0202
+	wmRButtonUp   = 0x0205
+	wmTrayIcon    = 0x8000 + 1 // WM_APP+1: mouse events on the icon
+	wmTrayUpdate  = 0x8000 + 2 // WM_APP+2: the pipeline state changed
+	nimAdd        = 0
+	nimModify     = 1
+	nimDelete     = 2
+	nifMessage    = 0x1
+	nifIcon       = 0x2
+	nifTip        = 0x4
+	mfString      = 0x0
+	mfChecked     = 0x8
+	mfSeparator   = 0x800
+	tpmRightAlign = 0x8
+	tpmBottom     = 0x20
+	idiApp        = 32512
+	idiError      = 32513
+	idiWarning    = 32515
+	idiInfo       = 32516
+)
+
+// Tray menu commands
+const (
+	menuPause = iota + 1
+	menuScan
+	menuLog
+	menuExit
+)
+
+// notifyIconData is NOTIFYICONDATAW.
+type notifyIconData struct {
+	Size            uint32
+	Wnd             uintptr
+	ID              uint32
+	Flags           uint32
+	CallbackMessage uint32
+	Icon            uintptr
+	Tip             [128]uint16
+	State           uint32
+	StateMask       uint32
+	Info            [256]uint16
+	Version         uint32
+	InfoTitle       [64]uint16
+	InfoFlags       uint32
+	GuidItem        windows.GUID
+	BalloonIcon     uintptr
+}
+
+// wndClassEx is WNDCLASSEXW.
+type wndClassEx struct {
+	Size       uint32
+	Style      uint32
+	WndProc    uintptr
+	ClsExtra   int32
+	WndExtra   int32
+	Instance   windows.Handle
+	Icon       uintptr
+	Cursor     uintptr
+	Background uintptr
+	MenuName   *uint16
+	ClassName  *uint16
+	IconSm     uintptr
+}
+
+type point struct{ X, Y int32 }
+
+// message is MSG.
+type message struct {
+	Window  uintptr
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	Pt      point
+}
+
+// trayIcon is the notification-area icon of a -tray run. Its window and
+// messages belong to one locked thread; the pipeline state is looked up on
+// another goroutine, as it may wait for the disk or a reload.
+type trayIcon struct {
+	app            *astrocam.AstroCam
+	quit           func()
+	logPath        string
+	window         uintptr
+	taskbarCreated uintptr // message sent when Explorer restarts and the icon has to be added again
+	closed         chan struct{}
+
+	mu       sync.Mutex
+	activity astrocam.Activity
+	summary  string
+}
+
+// tray is the icon the window procedure works on.
+var tray *trayIcon
+
+// trayLogPath returns the path of the -tray log next to the executable.
+func trayLogPath() string {
+	name := astrocam.ProfileFileName(trayLogFile)
+	if execPath, err := os.Executable(); err == nil {
+		return filepath.Join(filepath.Dir(execPath), name)
+	}
+	if path, err := filepath.Abs(name); err == nil {
+		return path
+	}
+	return name
+}
+
+// detachConsole sends the log of a -tray run to astrocam-tray.log next to
+// the executable and lets go of the console, so that a console window opened
+// for the program closes. Without the log file the console stays.
+func detachConsole() {
+	path := trayLogPath()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		slog.Error("Cannot open the tray log, keeping the console", "file", path, "error", err)
+		return
+	}
+	astrocam.SetLogOutput(f)
+	astrocam.SetupLogging("text")
+	procFreeConsole.Call()
+}
+
+// showTray adds a tray icon showing whether app is idle, archiving,
+// uploading, paused or in trouble, with a menu to pause uploads, scan now,
+// open the log and exit through quit. The returned function removes it.
+func showTray(app *astrocam.AstroCam, quit func()) func() {
+	t := &trayIcon{app: app, quit: quit, logPath: trayLogPath(), closed: make(chan struct{})}
+	t.activity, t.summary = app.Activity()
+	started := make(chan error, 1)
+	go func() {
+		defer close(t.closed)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		t.run(started)
+	}()
+	if err := <-started; err != nil {
+		slog.Error("Cannot show the tray icon", "error", err)
+		return func() {}
+	}
+	go t.watch()
+	return func() {
+		procPostMessageW.Call(t.window, wmClose, 0, 0)
+		<-t.closed
+	}
+}
+
+// run creates the hidden window receiving the icon's messages, adds the icon
+// and handles the messages until the window is closed.
+func (t *trayIcon) run(started chan<- error) {
+	var instance windows.Handle
+	windows.GetModuleHandleEx(0, nil, &instance)
+	className, _ := windows.UTF16PtrFromString("AstroCamTray")
+	class := wndClassEx{WndProc: windows.NewCallback(trayWindowProc), Instance: instance, ClassName: className}
+	class.Size = uint32(unsafe.Sizeof(class))
+	if r, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&class))); r == 0 {
+		started <- fmt.Errorf("RegisterClassEx: %w", err)
+		return
+	}
+	tray = t
+	// A top-level window that is never shown: a message-only window would
+	// miss the TaskbarCreated broadcast
+	window, _, err := procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(className)), uintptr(unsafe.Pointer(className)),
+		0, 0, 0, 0, 0, 0, 0, uintptr(instance), 0)
+	if window == 0 {
+		started <- fmt.Errorf("CreateWindowEx: %w", err)
+		return
+	}
+	t.window = window
+	taskbarCreated, _ := windows.UTF16PtrFromString("TaskbarCreated")
+	t.taskbarCreated, _, _ = procRegisterWindowMessageW.Call(uintptr(unsafe.Pointer(taskbarCreated)))
+	started <- nil
+	if err := t.notify(nimAdd); err != nil {
+		// Started before Explorer, e.g. at logon: TaskbarCreated adds it
+		slog.Warn("Tray icon not shown yet", "error", err)
+	}
+
+	var m message
+	for {
+		r, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0)
+		if int32(r) <= 0 {
+			return
+		}
+		procTranslateMessage.Call(uintptr(unsafe.Pointer(&m)))
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&m)))
+	}
+}
+
+// watch looks at the pipeline state every trayRefresh and has the icon
+// updated when it changed, until the icon is removed.
+func (t *trayIcon) watch() {
+	ticker := time.NewTicker(trayRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.closed:
+			return
+		}
+		activity, summary := t.app.Activity()
+		t.mu.Lock()
+		changed := activity != t.activity || summary != t.summary
+		t.activity, t.summary = activity, summary
+		t.mu.Unlock()
+		if changed {
+			procPostMessageW.Call(t.window, wmTrayUpdate, 0, 0)
+		}
+	}
+}
+
+// notify adds, updates or removes the icon.
+func (t *trayIcon) notify(action uintptr) error {
+	t.mu.Lock()
+	activity, summary := t.activity, t.summary
+	t.mu.Unlock()
+
+	icon := uintptr(idiApp)
+	switch activity {
+	case astrocam.ActivityArchiving, astrocam.ActivityUploading:
+		icon = idiInfo
+	case astrocam.ActivityPaused:
+		icon = idiWarning
+	case astrocam.ActivityError:
+		icon = idiError
+	}
+	data := notifyIconData{Wnd: t.window, ID: 1, Flags: nifMessage | nifIcon | nifTip, CallbackMessage: wmTrayIcon}
+	data.Size = uint32(unsafe.Sizeof(data))
+	data.Icon, _, _ = procLoadIconW.Call(0, icon)
+	tip, _ := windows.UTF16FromString(fmt.Sprintf("AstroCam-GO: %s\n%s", activity, summary))
+	// The last element stays 0 for a tip cut short
+	copy(data.Tip[:len(data.Tip)-1], tip)
+	if r, _, err := procShellNotifyIconW.Call(action, uintptr(unsafe.Pointer(&data))); r == 0 {
+		return fmt.Errorf("Shell_NotifyIcon: %w", err)
+	}
+	return nil
+}
+
+// trayWindowProc handles the messages of the tray window.
+func trayWindowProc(window, msg, wParam, lParam uintptr) uintptr {
+	t := tray
+	switch {
+	case t == nil:
+	case msg == wmTrayIcon:
+		if event := lParam & 0xffff; event == wmLButtonUp || event == wmRButtonUp {
+			t.showMenu()
+		}
+		return 0
+	case msg == wmTrayUpdate:
+		t.notify(nimModify)
+		return 0
+	case msg == wmCommand:
+		t.command(wParam & 0xffff)
+		return 0
+	case t.taskbarCreated != 0 && msg == t.taskbarCreated:
+		t.notify(nimAdd)
+		return 0
+	case msg == wmClose:
+		t.notify(nimDelete)
+		procDestroyWindow.Call(window)
+		return 0
+	case msg == wmDestroy:
+		procPostQuitMessage.Call(0)
+		return 0
+	}
+	r, _, _ := procDefWindowProcW.Call(window, msg, wParam, lParam)
+	return r
+}
+
+// showMenu shows the tray menu at the mouse pointer.
+func (t *trayIcon) showMenu() {
+	menu, _, _ := procCreatePopupMenu.Call()
+	if menu == 0 {
+		return
+	}
+	defer procDestroyMenu.Call(menu)
+	pause := uintptr(mfString)
+	if t.app.Paused() {
+		pause |= mfChecked
+	}
+	appendMenu(menu, pause, menuPause, "Pause pipeline")
+	appendMenu(menu, mfString, menuScan, "Scan now")
+	appendMenu(menu, mfString, menuLog, "Open log")
+	appendMenu(menu, mfSeparator, 0, "")
+	appendMenu(menu, mfString, menuExit, "Exit")
+
+	var p point
+	procGetCursorPos.Call(uintptr(unsafe.Pointer(&p)))
+	// Without it the menu stays open when clicking elsewhere
+	procSetForegroundWindow.Call(t.window)
+	procTrackPopupMenu.Call(menu, tpmRightAlign|tpmBottom, uintptr(p.X), uintptr(p.Y), 0, t.window, 0)
+	procPostMessageW.Call(t.window, wmNull, 0, 0)
+}
+
+func appendMenu(menu, flags, id uintptr, label string) {
+	text, _ := windows.UTF16PtrFromString(label)
+	procAppendMenuW.Call(menu, flags, id, uintptr(unsafe.Pointer(text)))
+}
+
+// command carries out a choice from the tray menu.
+func (t *trayIcon) command(id uintptr) {
+	switch id {
+	case menuPause:
+		if t.app.Paused() {
+			t.app.Resume()
+		} else {
+			t.app.Pause()
+		}
+	case menuScan:
+		t.app.TriggerScan()
+	case menuLog:
+		verb, _ := windows.UTF16PtrFromString("open")
+		file, _ := windows.UTF16PtrFromString(t.logPath)
+		if err := windows.ShellExecute(0, verb, file, nil, nil, windows.SW_SHOWNORMAL); err != nil {
+			slog.Warn("Cannot open the log", "file", t.logPath, "error", err)
+		}
+	case menuExit:
+		slog.Info("Exit chosen from the tray menu, performing cleanup")
+		t.quit()
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"astrocam/pkg/astrocam"
+)
+
+// startTUI shows the terminal dashboard of app in place of the scrolling log
+// and carries out the keys typed on standard input: p pauses or resumes
+// uploads, s scans now and q quits through quit. The returned function ends
+// the dashboard and prints the log again. Without a terminal on standard
+// output the log is kept.
+func startTUI(app *astrocam.AstroCam, quit func()) func() {
+	if !stdoutTerminal() {
+		slog.Warn("-tui needs a terminal that understands ANSI escape sequences, keeping the log output")
+		return func() {}
+	}
+	astrocam.SetLogOutput(io.Discard)
+	astrocam.SetupLogging("text")
+
+	done := make(chan struct{})
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		app.RunTUI(os.Stdout, done)
+	}()
+	go func() {
+		input := bufio.NewScanner(os.Stdin)
+		for input.Scan() {
+			switch strings.ToLower(strings.TrimSpace(input.Text())) {
+			case "p":
+				if app.Paused() {
+					app.Resume()
+				} else {
+					app.Pause()
+				}
+			case "s":
+				app.TriggerScan()
+			case "q":
+				slog.Info("Quit typed on the dashboard, performing cleanup")
+				quit()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-ended
+		astrocam.SetLogOutput(os.Stdout)
+		astrocam.SetupLogging("text")
+	}
+}
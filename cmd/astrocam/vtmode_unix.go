@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+// enableVirtualTerminal reports whether the console understands ANSI escape
+// sequences, which terminals on this platform do.
+func enableVirtualTerminal() bool {
+	return true
+}
+
+// useUTF8Console does nothing: terminals on this platform take UTF-8.
+func useUTF8Console() {}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+// enableVirtualTerminal turns on the processing of ANSI escape sequences on
+// the console of standard output and reports whether the console supports
+// it (Windows 10 and later).
+func enableVirtualTerminal() bool {
+	handle := windows.Handle(windows.Stdout)
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return false
+	}
+	return windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
+
+// useUTF8Console makes the console show the UTF-8 output of the program,
+// e.g. translated log messages, instead of reading it in the legacy code
+// page.
+func useUTF8Console() {
+	windows.SetConsoleOutputCP(65001) // CP_UTF8
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"astrocam/pkg/astrocam"
+)
+
+// wizard asks the questions of the init command on a terminal.
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// errAborted is returned when the input ends before all questions were answered.
+var errAborted = errors.New("setup aborted")
+
+// ask prints a question with its default answer and returns the answer once
+// check accepts it. An empty answer selects the default.
+func (w *wizard) ask(question, def string, check func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(w.out, "%s: ", question)
+		}
+		line, err := w.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(w.out)
+			return "", errAborted
+		}
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = def
+		}
+		// config.env treats everything after # as a comment
+		if strings.Contains(answer, "#") {
+			fmt.Fprintln(w.out, "  The # character cannot be used in config.env values.")
+			continue
+		}
+		if check != nil {
+			if err := check(answer); err != nil {
+				fmt.Fprintf(w.out, "  %v\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// confirm asks a yes/no question.
+func (w *wizard) confirm(question string, def bool) (bool, error) {
+	defAnswer := "n"
+	if def {
+		defAnswer = "y"
+	}
+	answer, err := w.ask(question+" (y/n)", defAnswer, func(s string) error {
+		switch strings.ToLower(s) {
+		case "y", "yes", "n", "no":
+			return nil
+		}
+		return errors.New("please answer y or n")
+	})
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(strings.ToLower(answer), "y"), nil
+}
+
+// checkServerURL accepts http(s) upload URLs with a host.
+func checkServerURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("please enter a full URL, e.g. https://your-server.com/cgi-bin/upload.py")
+	}
+	return nil
+}
+
+// checkCount accepts a positive number of frames.
+func checkCount(s string) error {
+	if n, err := strconv.Atoi(s); err != nil || n < 1 {
+		return errors.New("please enter a whole number of at least 1")
+	}
+	return nil
+}
+
+// checkArchiveMode accepts the SAI_ARCHIVE_MODE values.
+func checkArchiveMode(s string) error {
+	switch s {
+	case "auto", "rar", "zip", "zip-uncompressed":
+		return nil
+	}
+	return errors.New("please enter auto, rar, zip or zip-uncompressed")
+}
+
+// checkNotEmpty rejects empty answers.
+func checkNotEmpty(s string) error {
+	if s == "" {
+		return errors.New("an answer is required")
+	}
+	return nil
+}
+
+func initCommand(args []string) int {
+	fs := newFlagSet("init")
+	profile := fs.String("profile", "", "Write config.NAME.env and areas.NAME.txt for the named profile")
+	fs.Parse(args)
+	if err := astrocam.SetProfile(*profile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	dir := "."
+	if execPath, err := os.Executable(); err == nil {
+		dir = filepath.Dir(execPath)
+	}
+	w := &wizard{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	if err := w.run(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// run asks for the station settings and writes config.env and areas.txt to
+// dir, then checks the result like check-config.
+func (w *wizard) run(dir string) error {
+	configPath := filepath.Join(dir, astrocam.ProfileFileName("config.env"))
+	areasPath := filepath.Join(dir, astrocam.ProfileFileName("areas.txt"))
+
+	fmt.Fprintf(w.out, "This creates %s.\nPress Enter to accept the suggestion in brackets.\n\n", configPath)
+	if _, err := os.Stat(configPath); err == nil {
+		overwrite, err := w.confirm(filepath.Base(configPath)+" already exists. Replace it?", false)
+		if err != nil {
+			return err
+		}
+		if !overwrite {
+			return errAborted
+		}
+	}
+
+	defaults := astrocam.DefaultConfig()
+	server, err := w.ask("Upload server URL", "", checkServerURL)
+	if err != nil {
+		return err
+	}
+	username, err := w.ask("Username (empty if the server needs none)", "", nil)
+	if err != nil {
+		return err
+	}
+	var password string
+	if username != "" {
+		if password, err = w.ask("Password", "", checkNotEmpty); err != nil {
+			return err
+		}
+	}
+	cameraDir, err := w.ask("Folder where the camera saves frames", filepath.Join(dir, "data"), checkNotEmpty)
+	if err != nil {
+		return err
+	}
+	processedDir, err := w.ask("Folder for frames that have been uploaded", filepath.Join(dir, "processed"), checkNotEmpty)
+	if err != nil {
+		return err
+	}
+	count, err := w.ask("Frames per archive", strconv.Itoa(defaults.Count), checkCount)
+	if err != nil {
+		return err
+	}
+	archiveMode, err := w.ask("Archive format (auto, rar, zip, zip-uncompressed)", defaults.ArchiveMode, checkArchiveMode)
+	if err != nil {
+		return err
+	}
+	postfix, err := w.ask("Archive name postfix, e.g. _STL-11000M (optional)", "", nil)
+	if err != nil {
+		return err
+	}
+
+	writeAreas := true
+	if _, err := os.Stat(areasPath); err == nil {
+		replace, err := w.confirm(filepath.Base(areasPath)+" already exists. Replace it?", false)
+		if err != nil {
+			return err
+		}
+		writeAreas = replace
+	}
+	var areas []string
+	if writeAreas {
+		answer, err := w.ask("Sky areas observed (separated by spaces or commas)", "", checkNotEmpty)
+		if err != nil {
+			return err
+		}
+		areas = strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+	}
+
+	var config strings.Builder
+	fmt.Fprintf(&config, "# AstroCam configuration written by \"astrocam-go init\"\n")
+	fmt.Fprintf(&config, "# See config.env.example for all available settings\n\n")
+	fmt.Fprintf(&config, "SAI_SERVER=%s\n", server)
+	fmt.Fprintf(&config, "SAI_USERNAME=%s\n", username)
+	fmt.Fprintf(&config, "SAI_PASSWORD=%s\n\n", password)
+	fmt.Fprintf(&config, "SAI_CAMERA_DIRECTORY=%s\n", cameraDir)
+	fmt.Fprintf(&config, "SAI_PROCESSED_DIRECTORY=%s\n\n", processedDir)
+	fmt.Fprintf(&config, "SAI_COUNT=%s\n", count)
+	fmt.Fprintf(&config, "SAI_ARCHIVE_MODE=%s\n", archiveMode)
+	fmt.Fprintf(&config, "SAI_POSTFIX=%s\n", postfix)
+
+	// The file holds the password: readable by the owner only
+	if err := writeFileAtomic(configPath, []byte(config.String()), 0600); err != nil {
+		return fmt.Errorf("cannot write config.env: %w", err)
+	}
+	fmt.Fprintf(w.out, "\nWrote %s\n", configPath)
+	if writeAreas {
+		if err := writeFileAtomic(areasPath, []byte(strings.Join(areas, "\n")+"\n"), 0644); err != nil {
+			return fmt.Errorf("cannot write areas.txt: %w", err)
+		}
+		fmt.Fprintf(w.out, "Wrote %s\n", areasPath)
+	}
+
+	fmt.Fprintln(w.out, "\nChecking the new configuration:")
+	if errs := reportConfig(astrocam.LoadConfig(), false); errs > 0 {
+		fmt.Fprintln(w.out, "Fix the errors above in config.env, then run check-config again.")
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data, so that an interrupted write never
+// leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
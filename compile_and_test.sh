@@ -20,21 +20,21 @@ echo "✓ Go version: $GO_VERSION"
 # Build executables
 echo ""
 echo "Building astrocam-go for Linux..."
-go build -o astrocam-go
+go build -o astrocam-go ./cmd/astrocam
 if [ $? -ne 0 ]; then
     echo "ERROR: Linux build failed"
     exit 1
 fi
 
 echo "Building astrocam-go for Windows (64-bit)..."
-GOOS=windows GOARCH=amd64 go build -ldflags="-s -w" -o astrocam-go-win64.exe
+GOOS=windows GOARCH=amd64 go build -ldflags="-s -w" -o astrocam-go-win64.exe ./cmd/astrocam
 if [ $? -ne 0 ]; then
     echo "ERROR: Windows 64-bit build failed"
     exit 1
 fi
 
 echo "Building astrocam-go for Windows (32-bit)..."
-GOOS=windows GOARCH=386 go build -ldflags="-s -w" -o astrocam-go-win32.exe
+GOOS=windows GOARCH=386 go build -ldflags="-s -w" -o astrocam-go-win32.exe ./cmd/astrocam
 if [ $? -ne 0 ]; then
     echo "ERROR: Windows 32-bit build failed"
     exit 1
@@ -85,7 +85,7 @@ echo "Preparing test environment..."
 
 # Clean directories
 echo "Cleaning test directories..."
-rm -f test_data/1_semka/* test_data/2_otpravleno/* temp/* 2>/dev/null || true
+rm -rf test_data/1_semka/* test_data/2_otpravleno/* temp/* 2>/dev/null || true
 
 # Create directories if they don't exist
 mkdir -p test_data/1_semka test_data/2_otpravleno temp
@@ -148,6 +148,14 @@ else
     RAR_AVAILABLE="NO"
 fi
 
+# Self-test against the built-in upload server
+echo ""
+echo "Running self-test..."
+if ! ./astrocam-go -selftest; then
+    echo "ERROR: Self-test failed"
+    exit 1
+fi
+
 echo ""
 echo "========================================="
 echo "RUNNING AUTOMATED TEST"
@@ -201,16 +209,19 @@ fi
 
 echo ""
 echo "2. Archives created in temp directory:"
-if [ "$(ls -A temp/ 2>/dev/null)" ]; then
-    ls -la temp/
-    ARCHIVE_COUNT=$(ls temp/ | wc -l)
+# Archives wait in per-area subdirectories of temp/; temp/partial/ holds
+# unfinished ones
+ARCHIVES=$(find temp -mindepth 2 -maxdepth 2 \( -name '*.zip' -o -name '*.rar' \) -not -path 'temp/partial/*' 2>/dev/null)
+if [ -n "$ARCHIVES" ]; then
+    ls -la $ARCHIVES
+    ARCHIVE_COUNT=$(echo "$ARCHIVES" | wc -l)
     echo "   ✓ $ARCHIVE_COUNT archives created"
     
     # Check archive types
-    if ls temp/*.rar >/dev/null 2>&1; then
+    if echo "$ARCHIVES" | grep -q '\.rar$'; then
         echo "   ✓ RAR archives found (rar command available)"
     fi
-    if ls temp/*.zip >/dev/null 2>&1; then
+    if echo "$ARCHIVES" | grep -q '\.zip$'; then
         echo "   ✓ ZIP archives found (built-in format)"
     fi
 else
@@ -277,7 +288,7 @@ if ! kill -0 "$MOCK_PID" 2>/dev/null; then
     echo "ERROR: Failed to start mock server for disk space test"
 else
     # Prepare fresh test data for disk space test
-    rm -f test_data/1_semka/* test_data/2_otpravleno/* temp/* 2>/dev/null || true
+    rm -rf test_data/1_semka/* test_data/2_otpravleno/* temp/* 2>/dev/null || true
     for area in 064; do
         for i in 1 2 3; do
             timestamp="2025-01-0${i}_12-00-0${i}"
@@ -320,7 +331,7 @@ EOFCONFIG
     fi
 
     # Check that archives are preserved (not deleted) in temp/
-    if ls temp/*.zip temp/*.rar 2>/dev/null | grep -q .; then
+    if find temp -mindepth 2 -maxdepth 2 \( -name '*.zip' -o -name '*.rar' \) -not -path 'temp/partial/*' 2>/dev/null | grep -q .; then
         echo "   ✓ Archive preserved in temp/ (not deleted on server error)"
     else
         echo "   (no archive to check — may not have been created in this test)"
@@ -0,0 +1,251 @@
+package astrocam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DEFAULT_ACK_TIMEOUT is how long an uploaded archive waits for the server to
+// confirm its ingestion before it is uploaded again.
+const DEFAULT_ACK_TIMEOUT = time.Hour
+
+// Ingestion states returned by IngestionChecker.Ingestion
+const (
+	ingestionDone    = "ingested"
+	ingestionPending = "pending"
+	ingestionFailed  = "failed"
+)
+
+// IngestionChecker is implemented by uploaders that can ask the destination
+// whether it ingested an archive it received, for SAI_ACK_URL. Receipt
+// returns the ID the destination gave the archive of the last successful
+// upload ("" = none). Ingestion asks about the archive of that name and ID
+// and returns "ingested", "pending" or "failed" and the destination's
+// message.
+type IngestionChecker interface {
+	Receipt() string
+	Ingestion(ctx context.Context, name, id string) (status, message string, err error)
+}
+
+// ackSuffix marks the sidecar of an uploaded archive waiting for the server
+// to confirm its ingestion.
+const ackSuffix = ".ack"
+
+// pendingAck is stored in the ".ack" sidecar of an archive, so that the wait
+// for the ingestion survives restarts.
+type pendingAck struct {
+	ID       string    `json:"id"`
+	Server   string    `json:"server"`
+	Uploaded time.Time `json:"uploaded"`
+}
+
+// archiveIDPattern finds the archive ID in an upload response:
+// "UNMW_ARCHIVE_ID:<id>", in the style of the UNMW_STATUS markers.
+var archiveIDPattern = regexp.MustCompile(`UNMW_ARCHIVE_ID:\s*([A-Za-z0-9._:-]+)`)
+
+// parseArchiveID returns the archive ID of an upload response: an
+// UNMW_ARCHIVE_ID marker, or "archive_id" or "id" in a JSON answer.
+func parseArchiveID(body string) string {
+	if m := archiveIDPattern.FindStringSubmatch(body); m != nil {
+		return m[1]
+	}
+	var answer map[string]any
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	if strings.HasPrefix(strings.TrimSpace(body), "{") && decoder.Decode(&answer) == nil {
+		for _, key := range []string{"archive_id", "id"} {
+			if v, ok := answer[key]; ok && v != nil {
+				return fmt.Sprint(v)
+			}
+		}
+	}
+	return ""
+}
+
+// parseIngestion interprets an answer of SAI_ACK_URL: an UNMW_STATUS marker
+// (OK: ingested, ERROR: failed, anything else pending), or a JSON object
+// whose "status" or "state" is "ingested", "done", "ok", "complete" or
+// "success", "failed", "error", "rejected" or "lost", or anything else while
+// the server is still working on the archive.
+func parseIngestion(body []byte) (string, string) {
+	text := strings.TrimSpace(string(body))
+	switch {
+	case strings.Contains(text, "UNMW_STATUS:ERROR"):
+		return ingestionFailed, text
+	case strings.Contains(text, "UNMW_STATUS:OK"):
+		return ingestionDone, ""
+	}
+	var answer map[string]any
+	if json.NewDecoder(bytes.NewReader(body)).Decode(&answer) != nil {
+		return ingestionPending, text
+	}
+	values := make(map[string]any, len(answer))
+	for key, value := range answer {
+		values[strings.ToLower(key)] = value
+	}
+	message, _ := values["message"].(string)
+	for _, key := range []string{"status", "state"} {
+		state, ok := values[key].(string)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(state) {
+		case "ingested", "done", "ok", "complete", "completed", "success":
+			return ingestionDone, message
+		case "failed", "error", "rejected", "lost":
+			if message == "" {
+				message = state
+			}
+			return ingestionFailed, message
+		}
+		return ingestionPending, message
+	}
+	return ingestionPending, text
+}
+
+// Receipt returns the archive ID of the last successful upload.
+func (u *HTTPUploader) Receipt() string {
+	return u.receipt
+}
+
+// Ingestion asks AckURL, with {id} and {name} replaced by the archive ID and
+// name, whether the server ingested the archive. An unknown archive (404) is
+// still pending: the server may not have registered it yet.
+func (u *HTTPUploader) Ingestion(ctx context.Context, name, id string) (string, string, error) {
+	if u.AckURL == "" {
+		return ingestionDone, "", nil
+	}
+	target := strings.NewReplacer("{id}", url.QueryEscape(id), "{name}", url.QueryEscape(name)).Replace(u.AckURL)
+	resp, err := u.get(ctx, target, 30*time.Second)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ingestionPending, "archive not known to the server yet", nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return "", "", fmt.Errorf("ingestion status returned %s", resp.Status)
+	}
+	status, message := parseIngestion(body)
+	return status, message, nil
+}
+
+// awaitIngestion keeps an uploaded archive, with SAI_ACK_URL, until the
+// server confirms that it ingested it, and reports whether it did so. The
+// archive ID from the upload response, or else the archive name, is stored in
+// the ".ack" sidecar that pollIngestion follows.
+func (ac *AstroCam) awaitIngestion(uploader Uploader, archiveFile, server string) bool {
+	if ac.config.AckURL == "" {
+		return false
+	}
+	checker, ok := uploader.(IngestionChecker)
+	if !ok {
+		slog.Debug("The uploader of this destination cannot check ingestion, deleting the archive", "server", server)
+		return false
+	}
+	name := filepath.Base(archiveFile)
+	ack := pendingAck{ID: checker.Receipt(), Server: server, Uploaded: ac.clock.Now()}
+	if ack.ID == "" {
+		ack.ID = name
+	}
+	data, _ := json.Marshal(ack)
+	if err := os.WriteFile(archiveFile+ackSuffix, data, 0644); err != nil {
+		slog.Warn("Cannot record the wait for ingestion, deleting the archive", "archive", name, "error", err)
+		return false
+	}
+	slog.Info("Keeping the archive until the server confirms its ingestion", "archive", name, "id", ack.ID)
+	return true
+}
+
+// awaitingIngestion reports whether an archive was uploaded and waits for
+// the server to confirm its ingestion.
+func awaitingIngestion(archiveFile string) bool {
+	_, err := os.Stat(archiveFile + ackSuffix)
+	return err == nil
+}
+
+// pollIngestion asks the server, once per scan, about the archives waiting
+// for their ingestion to be confirmed. A confirmed archive is deleted. One
+// the server failed to ingest, or did not confirm within SAI_ACK_TIMEOUT, is
+// uploaded again like after a failed upload.
+func (ac *AstroCam) pollIngestion() {
+	if ac.config.AckURL == "" {
+		return
+	}
+	sidecars, _ := filepath.Glob(filepath.Join(ac.tempDirectory, "*"+ackSuffix))
+	inAreas, _ := filepath.Glob(filepath.Join(ac.tempDirectory, "*", "*"+ackSuffix))
+	sidecars = append(sidecars, inAreas...)
+	if len(sidecars) == 0 {
+		return
+	}
+	for _, sidecar := range sidecars {
+		archiveFile := strings.TrimSuffix(sidecar, ackSuffix)
+		name := filepath.Base(archiveFile)
+		var ack pendingAck
+		data, err := os.ReadFile(sidecar)
+		if err == nil {
+			err = json.Unmarshal(data, &ack)
+		}
+		if err != nil {
+			slog.Warn("Invalid ingestion record, uploading the archive again", "archive", name, "error", err)
+			os.Remove(sidecar)
+			continue
+		}
+
+		status, message, err := ac.ingestionStatus(ack, name)
+		switch {
+		case err != nil:
+			slog.Debug("Cannot ask the server about the ingestion", "archive", name, "error", err)
+		case status == ingestionDone:
+			slog.Info("Server confirmed the ingestion", "archive", name, "id", ack.ID)
+			os.Remove(sidecar)
+			if ac.keepUploaded(archiveFile) {
+				continue
+			}
+			if err := ac.deleteFile(archiveFile); err != nil {
+				slog.Warn("Error deleting file after ingestion", "archive", name, "error", err)
+			}
+			removeArchiveMeta(archiveFile)
+			continue
+		case status == ingestionFailed:
+			slog.Error("Server failed to ingest the archive, uploading it again", "archive", name, "id", ack.ID, "response", message)
+			os.Remove(sidecar)
+			ac.recordUploadFailure(archiveFile, fmt.Errorf("server failed to ingest the archive: %s", message))
+			continue
+		}
+		if waited := ac.clock.Now().Sub(ack.Uploaded); waited > ac.config.AckTimeout {
+			slog.Error("Server did not confirm the ingestion, uploading the archive again", "archive", name, "id", ack.ID, "waited", waited.Round(time.Second))
+			os.Remove(sidecar)
+			ac.recordUploadFailure(archiveFile, fmt.Errorf("ingestion not confirmed within %s (SAI_ACK_TIMEOUT)", ac.config.AckTimeout))
+		}
+	}
+}
+
+// ingestionStatus asks the server an archive was uploaded to about its
+// ingestion.
+func (ac *AstroCam) ingestionStatus(ack pendingAck, name string) (string, string, error) {
+	uploader, err := ac.uploaderFor(ack.Server)
+	if err != nil {
+		return "", "", err
+	}
+	checker, ok := uploader.(IngestionChecker)
+	if !ok {
+		return ingestionDone, "", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return checker.Ingestion(ctx, name, ack.ID)
+}
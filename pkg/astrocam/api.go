@@ -0,0 +1,162 @@
+package astrocam
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// apiReloadWait is how long POST /api/reload waits for the main loop to apply
+// the new configuration. A reload requested during a long upload is still
+// applied afterwards; the caller just gets an "accepted" answer instead.
+const apiReloadWait = 30 * time.Second
+
+// apiStatus is the document returned by GET /api/status: the health report
+// plus the control state.
+type apiStatus struct {
+	healthReport
+	OperatorPaused bool                  `json:"operator_paused"`
+	PauseFile      bool                  `json:"pause_file"`     // the PAUSE file pauses the pipeline
+	Unsafe         bool                  `json:"unsafe"`         // SAI_SAFETY_MONITOR marked the observatory unsafe
+	Offline        bool                  `json:"offline"`        // the upload server could not be reached at the last attempt
+	CaptivePortal  bool                  `json:"captive_portal"` // the last attempt was answered by a captive portal
+	Areas          map[string]int        `json:"areas"`          // frames waiting per area at the last scan
+	AreaActivity   map[string]areaReport `json:"area_activity"`
+}
+
+// apiResponse is the reply to the control endpoints.
+type apiResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// writeJSON sends v as an indented JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// registerAPI adds the control API to the status server mux:
+//
+//	GET  /api/status   pipeline state as JSON
+//	POST /api/pause    stop packing and uploading until resumed
+//	POST /api/resume   resume after /api/pause
+//	POST /api/trigger  scan immediately instead of waiting for the next tick
+//	POST /api/reload   re-read config.env and areas.txt
+//	POST /api/restart  restart the program, e.g. after an update
+//
+// The POST endpoints refuse requests from other web sites and, with
+// SAI_STATUS_TOKEN, requests without the token (see refuseControl).
+func (ac *AstroCam) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/api/status", ac.handleAPIStatus)
+	mux.HandleFunc("/api/pause", ac.apiPost(func() apiResponse {
+		ac.setOperatorPause(true)
+		return apiResponse{OK: true, Message: "pipeline paused"}
+	}))
+	mux.HandleFunc("/api/resume", ac.apiPost(func() apiResponse {
+		ac.setOperatorPause(false)
+		return apiResponse{OK: true, Message: "pipeline resumed"}
+	}))
+	mux.HandleFunc("/api/trigger", ac.apiPost(func() apiResponse {
+		ac.requestScan()
+		return apiResponse{OK: true, Message: "scan requested"}
+	}))
+	mux.HandleFunc("/api/reload", ac.handleAPIReload)
+	mux.HandleFunc("/api/restart", ac.apiPost(func() apiResponse {
+		ac.requestRestart()
+		return apiResponse{OK: true, Message: "restart requested"}
+	}))
+}
+
+// apiPost wraps a control action so that it only answers POST requests that
+// refuseControl accepts.
+func (ac *AstroCam) apiPost(action func() apiResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ac.acceptControl(w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, action())
+	}
+}
+
+// acceptControl answers a request to a POST endpoint that is not a POST or
+// that refuseControl refuses, and reports whether it may go ahead.
+func (ac *AstroCam) acceptControl(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, apiResponse{Error: "use POST"})
+		return false
+	}
+	if code, reason := ac.refuseControl(r); code != 0 {
+		if code == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeJSON(w, code, apiResponse{Error: reason})
+		return false
+	}
+	return true
+}
+
+func (ac *AstroCam) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, apiResponse{Error: "use GET"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ac.statusSnapshot())
+}
+
+// statusSnapshot collects the document served on /api/status and written to
+// SAI_STATUS_FILE.
+func (ac *AstroCam) statusSnapshot() apiStatus {
+	ac.configMu.RLock()
+	status := apiStatus{healthReport: ac.healthReport(), Areas: make(map[string]int)}
+	ac.configMu.RUnlock()
+	status.OperatorPaused = ac.operatorPaused.Load()
+	status.PauseFile = ac.pauseFile.Load()
+	status.Unsafe = ac.unsafe.Load()
+	status.Offline = ac.offline.Load()
+	status.CaptivePortal = ac.captivePortal.Load()
+	status.AreaActivity = ac.areaReports()
+
+	ac.status.mu.Lock()
+	for area, count := range ac.status.areaCounts {
+		status.Areas[area] = count
+	}
+	ac.status.mu.Unlock()
+	return status
+}
+
+// handleAPIReload hands a reload request to the main loop and waits for the
+// result: 200 when applied, 500 when the new configuration could not be
+// loaded (the old one stays in effect), 202 when the main loop is busy and
+// will apply it later, and 409 when another reload is already pending.
+func (ac *AstroCam) handleAPIReload(w http.ResponseWriter, r *http.Request) {
+	if !ac.acceptControl(w, r) {
+		return
+	}
+
+	reply := make(chan error, 1)
+	select {
+	case ac.reloadRequests <- reply:
+	default:
+		writeJSON(w, http.StatusConflict, apiResponse{Error: "a reload is already pending"})
+		return
+	}
+
+	select {
+	case err := <-reply:
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, apiResponse{OK: true, Message: "configuration reloaded"})
+	case <-time.After(apiReloadWait):
+		writeJSON(w, http.StatusAccepted, apiResponse{OK: true, Message: "reload queued, the pipeline is busy"})
+	}
+}
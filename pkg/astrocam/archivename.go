@@ -0,0 +1,63 @@
+package astrocam
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// archiveStamp is the last archive name issued for an area: the name up to
+// the time of packing, and its sequence number within that second.
+type archiveStamp struct {
+	stamp string
+	seq   int
+}
+
+// newArchiveName returns the path of a new archive of area packed at now,
+// YYYY-MM-DD_[PREFIX]AREA_HHMMSS[POSTFIX].ext in the area's temp directory.
+// Further archives of the area packed within the same second, and archives
+// whose name is taken by one still waiting or kept, get -02, -03 and so on
+// after the time, zero-padded so that they sort in packing order by name (as
+// sortByArchiveName does) up to -99, far more than a second sees. Without
+// that, a second group packed in the same second would replace the first
+// archive before it was uploaded, or on the server after.
+func (ac *AstroCam) newArchiveName(area string, now time.Time) string {
+	stamp := fmt.Sprintf("%s_%s%s_%s", now.Format("2006-01-02"), ac.config.Prefix, area, now.Format("150405"))
+	ac.archiveNamesMu.Lock()
+	defer ac.archiveNamesMu.Unlock()
+	seq := 1
+	if last := ac.archiveNames[area]; last.stamp == stamp {
+		seq = last.seq + 1
+	}
+	for ; ; seq++ {
+		name := stamp
+		if seq > 1 {
+			name += fmt.Sprintf("-%02d", seq)
+		}
+		name += ac.config.Postfix + ac.archiver.Extension()
+		if !ac.archiveNameTaken(name, area) {
+			ac.archiveNames[area] = archiveStamp{stamp, seq}
+			return filepath.Join(ac.areaTempDirectory(area), name)
+		}
+	}
+}
+
+// archiveNameTaken reports whether an archive called name exists in any of
+// the places an archive of area goes through before or after its upload.
+func (ac *AstroCam) archiveNameTaken(name, area string) bool {
+	dirs := []string{ac.areaTempDirectory(area), ac.tempDirectory, filepath.Join(ac.tempDirectory, partialDirName),
+		ac.failedDirectory, ac.reviewDirectory}
+	if ac.config.UploadedDirectory != "" {
+		dirs = append(dirs, ac.config.UploadedDirectory)
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		for _, file := range []string{path, archiveMetaPath(path)} {
+			if _, err := os.Lstat(file); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,430 @@
+package astrocam
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// zipDeflateLevel is the compression level of archive/zip's own Deflate
+// compressor, kept when the frames are deflated in parallel.
+const zipDeflateLevel = 5
+
+// zip64Limit is the largest archive, and frame in it, that fits a ZIP file
+// without zip64 extensions. archive/zip adds them as needed, and for more
+// than 65535 frames, but a FAT32 drive cannot hold a file this large at all.
+const zip64Limit = 1<<32 - 1
+
+// Archiver packs frames into an archive file and verifies the result.
+type Archiver interface {
+	// Create writes an archive at path containing files, each stored under
+	// its base name.
+	Create(path string, files []string) error
+	// Test checks the integrity of an archive written by Create.
+	Test(path string) error
+	// Extension is the archive file name extension, including the dot.
+	Extension() string
+	// String describes the format for log messages.
+	String() string
+}
+
+// StreamArchiver is implemented by archivers that can write an archive to a
+// stream, for SAI_STREAM_UPLOAD. Stream writes the archive of files to w;
+// tempDir is for any scratch files it needs.
+type StreamArchiver interface {
+	Archiver
+	Stream(w io.Writer, tempDir string, files []string) error
+}
+
+// ZipArchiver writes ZIP archives with Go's built-in zip library.
+type ZipArchiver struct {
+	Compress            bool // Deflate the frames; false stores them uncompressed
+	Threads             int  // Frames deflated at the same time (0 = one per CPU)
+	StoreIncompressible bool // Store the frames deflating would not shrink, such as fpacked ones
+}
+
+func (z *ZipArchiver) Extension() string { return ".zip" }
+
+func (z *ZipArchiver) String() string {
+	if z.Compress {
+		return "ZIP compressed (built-in)"
+	}
+	return "ZIP uncompressed (built-in)"
+}
+
+// Create creates ZIP archive using Go's built-in zip library
+func (z *ZipArchiver) Create(archiveFileName string, files []string) error {
+	outFile, err := os.Create(archiveFileName)
+	if err != nil {
+		return fmt.Errorf("failed to create archive file: %w", err)
+	}
+	defer outFile.Close()
+
+	if err := z.Stream(outFile, filepath.Dir(archiveFileName), files); err != nil {
+		return largeArchiveError(err, outFile)
+	}
+	return outFile.Close()
+}
+
+// Stream writes the ZIP archive of files to w. Frames deflated in parallel
+// wait in tempDir until their turn.
+func (z *ZipArchiver) Stream(w io.Writer, tempDir string, files []string) error {
+	zipWriter := zip.NewWriter(w)
+	err := z.addFiles(zipWriter, tempDir, files)
+	if err == nil {
+		// Writes the central directory, with the zip64 records of a large archive
+		err = zipWriter.Close()
+	}
+	return err
+}
+
+// addFiles adds files to the zip archive, deflating them in parallel when
+// more than one thread is allowed.
+func (z *ZipArchiver) addFiles(zipWriter *zip.Writer, dir string, files []string) error {
+	if threads := z.threads(len(files)); z.Compress && threads > 1 {
+		return z.addFilesParallel(zipWriter, dir, files, threads)
+	}
+	for _, filename := range files {
+		if err := z.addFile(zipWriter, filename, z.method(filename)); err != nil {
+			return fmt.Errorf("failed to add file %s to archive: %w", filename, err)
+		}
+	}
+	return nil
+}
+
+// largeArchiveError explains a write that failed as the archive reached
+// 4 GB, which the file system of the temp directory cannot hold.
+func largeArchiveError(err error, outFile *os.File) error {
+	written, seekErr := outFile.Seek(0, io.SeekCurrent)
+	if seekErr != nil || written < zip64Limit-1<<20 {
+		return err
+	}
+	return fmt.Errorf("%w: the archive reached 4 GB, more than the file system of the temp directory allows (FAT32?); "+
+		"run astrocam-go from an NTFS, exFAT or ext4 drive or lower SAI_COUNT", err)
+}
+
+// method returns the compression method of a frame.
+func (z *ZipArchiver) method(filename string) uint16 {
+	if !z.Compress {
+		return zip.Store
+	}
+	if z.StoreIncompressible && incompressible(filename) {
+		slog.Debug("Storing incompressible frame", "frame", filepath.Base(filename))
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// addFile adds a single file to the zip archive
+func (z *ZipArchiver) addFile(zipWriter *zip.Writer, filename string, method uint16) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+
+	header.Name = filepath.Base(filename)
+	header.Method = method
+
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// threads returns how many of files are deflated at the same time.
+func (z *ZipArchiver) threads(files int) int {
+	threads := z.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	return min(threads, files)
+}
+
+// deflatedFile is a frame compressed ahead of its turn in the archive.
+type deflatedFile struct {
+	header *zip.FileHeader
+	data   string // temporary file holding the Deflate stream
+	store  bool   // the frame is incompressible and stored as it is instead
+	err    error
+	done   chan struct{}
+}
+
+// addFilesParallel deflates files on up to threads CPUs into temporary files
+// in dir, and adds each one to the archive, in order, as soon as it and the
+// files before it are ready. A frame is only deflated once fewer than threads
+// frames wait to be added, which bounds the temporary space. The archive is
+// the same as addFile would write.
+func (z *ZipArchiver) addFilesParallel(zipWriter *zip.Writer, dir string, files []string, threads int) error {
+	deflated := make([]*deflatedFile, len(files))
+	for i := range deflated {
+		deflated[i] = &deflatedFile{done: make(chan struct{})}
+	}
+	slots := make(chan struct{}, threads)
+	go func() {
+		for i, filename := range files {
+			slots <- struct{}{}
+			go func(d *deflatedFile, filename string) {
+				if d.store = z.method(filename) == zip.Store; !d.store {
+					d.header, d.data, d.err = deflateFile(filename, dir)
+				}
+				close(d.done)
+			}(deflated[i], filename)
+		}
+	}()
+
+	next := 0
+	// After an error the remaining frames are still deflated; their
+	// temporary files are removed as they finish
+	defer func() {
+		for _, d := range deflated[next:] {
+			<-d.done
+			if d.data != "" {
+				os.Remove(d.data)
+			}
+			<-slots
+		}
+	}()
+
+	for next < len(deflated) {
+		d, filename := deflated[next], files[next]
+		next++
+		<-d.done
+		err := d.err
+		if d.store {
+			err = z.addFile(zipWriter, filename, zip.Store)
+		} else if err == nil {
+			err = addRaw(zipWriter, d.header, d.data)
+			os.Remove(d.data)
+		}
+		<-slots
+		if err != nil {
+			return fmt.Errorf("failed to add file %s to archive: %w", filename, err)
+		}
+	}
+	return nil
+}
+
+// deflateFile compresses filename into a temporary file in dir and returns
+// the archive header of the file, with its CRC and sizes, and the temporary
+// file.
+func deflateFile(filename, dir string) (*zip.FileHeader, string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, "", err
+	}
+	header.Name = filepath.Base(filename)
+	header.Method = zip.Deflate
+
+	tmp, err := os.CreateTemp(dir, "."+header.Name+"-*.deflate")
+	if err != nil {
+		return nil, "", err
+	}
+	crc := crc32.NewIEEE()
+	compressor, err := flate.NewWriter(tmp, zipDeflateLevel)
+	var size int64
+	if err == nil {
+		size, err = io.Copy(io.MultiWriter(compressor, crc), file)
+	}
+	if err == nil {
+		err = compressor.Close()
+	}
+	var compressed int64
+	if err == nil {
+		compressed, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, "", err
+	}
+	header.CRC32 = crc.Sum32()
+	header.UncompressedSize64 = uint64(size)
+	header.CompressedSize64 = uint64(compressed)
+	return header, tmp.Name(), nil
+}
+
+// addRaw adds a file deflated by deflateFile to the archive.
+func addRaw(zipWriter *zip.Writer, header *zip.FileHeader, data string) error {
+	file, err := os.Open(data)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// Test tests ZIP archive integrity
+func (z *ZipArchiver) Test(archiveFileName string) error {
+	reader, err := zip.OpenReader(archiveFileName)
+	if err != nil {
+		return fmt.Errorf("failed to open ZIP file for testing: %w", err)
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open file %s in archive: %w", file.Name, err)
+		}
+
+		// Reading to the end checks the CRC and the size, which for frames
+		// and archives over 4 GB come from the zip64 records
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+
+		if err != nil {
+			return fmt.Errorf("failed to read file %s in archive: %w", file.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// DEFAULT_RAR_TIMEOUT is the SAI_RAR_TIMEOUT default.
+const DEFAULT_RAR_TIMEOUT = 15 * time.Minute
+
+// RARArchiver writes RAR archives with the external rar command.
+type RARArchiver struct {
+	Path            string        // rar executable
+	StoreCompressed bool          // Store the files compressedExtensions lists instead of compressing them again
+	Timeout         time.Duration // rar still running after this long is killed (0 = no limit)
+}
+
+func (r *RARArchiver) Extension() string { return ".rar" }
+
+func (r *RARArchiver) String() string { return fmt.Sprintf("RAR (using %s)", r.Path) }
+
+// Create creates RAR archive using external rar command. -ep stores the files
+// without their directories, like the ZIP entries.
+func (r *RARArchiver) Create(archiveFileName string, files []string) error {
+	args := []string{"a", "-ep"}
+	if r.StoreCompressed {
+		args = append(args, rarStoreTypes())
+	}
+	args = append(args, archiveFileName)
+	args = append(args, files...)
+
+	output, err := r.run(args...)
+	if err != nil {
+		return fmt.Errorf("rar creation failed: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// Test tests RAR archive integrity
+func (r *RARArchiver) Test(archiveFileName string) error {
+	output, err := r.run("t", archiveFileName)
+	if err != nil {
+		return fmt.Errorf("rar test failed: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// run runs rar with args and returns its combined output. A rar waiting for
+// an answer, such as WinRAR on a damaged file, reads end-of-file from its
+// input, and is killed after Timeout.
+func (r *RARArchiver) run(args ...string) ([]byte, error) {
+	ctx := context.Background()
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	output, err := runChild(exec.CommandContext(ctx, r.Path, args...))
+	if ctx.Err() != nil {
+		return output, fmt.Errorf("killed after %s", r.Timeout)
+	}
+	return output, err
+}
+
+// findRARExecutable checks for rar command in PATH and Windows default locations
+func findRARExecutable() (string, bool) {
+	// First try PATH (works on Linux and Windows if rar is in PATH)
+	if rarPath, err := exec.LookPath("rar"); err == nil {
+		return rarPath, true
+	}
+
+	// On Windows, also check common WinRAR installation locations
+	if runtime.GOOS == "windows" {
+		commonPaths := []string{
+			`C:\Program Files\WinRAR\rar.exe`,
+			`C:\Program Files (x86)\WinRAR\rar.exe`,
+		}
+
+		for _, path := range commonPaths {
+			if _, err := os.Stat(path); err == nil {
+				return path, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// newArchiver picks the archive format from SAI_ARCHIVE_MODE and the
+// availability of the rar command. "auto" prefers RAR, falling back to
+// compressed ZIP, and stores the frames that are compressed already.
+func newArchiver(config *Config) Archiver {
+	rarPath, rarAvailable := findRARExecutable()
+
+	switch config.ArchiveMode {
+	case "rar":
+		if rarAvailable {
+			return &RARArchiver{Path: rarPath, Timeout: config.RARTimeout}
+		}
+		slog.Warn("RAR mode requested but rar command not found, falling back to compressed ZIP")
+	case "zip":
+	case "zip-uncompressed":
+		return &ZipArchiver{Compress: false}
+	default:
+		// Auto mode: prefer RAR if available, otherwise compressed ZIP
+		if rarAvailable {
+			return &RARArchiver{Path: rarPath, StoreCompressed: true, Timeout: config.RARTimeout}
+		}
+		return &ZipArchiver{Compress: true, Threads: config.ArchiveThreads, StoreIncompressible: true}
+	}
+	return &ZipArchiver{Compress: true, Threads: config.ArchiveThreads}
+}
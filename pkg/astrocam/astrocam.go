@@ -0,0 +1,3101 @@
+// Package astrocam watches a camera directory for new FITS frames, packs them
+// into per-area archives and uploads those to the processing server. The
+// astrocam-go command (cmd/astrocam) is a thin wrapper around it; other
+// programs can embed the uploader with New or NewWithConfig and AstroCam.Run.
+package astrocam
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Constants matching Python version
+const (
+	ERROR = "ERROR"
+	EMPTY = "EMPTY"
+
+	// Interval configuration constants
+	MIN_INTERVAL     = 15    // Minimum allowed interval in seconds
+	DEFAULT_INTERVAL = 15    // Default interval if not specified/invalid
+	MAX_INTERVAL     = 86400 // Maximum allowed interval in seconds (24 hours)
+
+	// No new frames for this long raises the scan interval towards SAI_IDLE_INTERVAL
+	IDLE_BACKOFF_AFTER = 30 * time.Minute
+
+	// Upload pacing defaults, see SAI_UPLOAD_THROTTLE and SAI_UPLOAD_TIMEOUT
+	DEFAULT_UPLOAD_THROTTLE = 120 * time.Second // time between upload attempts
+	DEFAULT_UPLOAD_TIMEOUT  = 300 * time.Second // limit for a single upload request
+	DEFAULT_BATCH_WAIT      = 30 * time.Minute  // SAI_UPLOAD_INTERVAL when only SAI_UPLOAD_BATCH is set
+	MAX_JITTER              = 50                // largest SAI_JITTER in percent
+	DEFAULT_COMMAND_POLL    = 5 * time.Minute   // time between requests to SAI_COMMAND_URL
+
+	// How long to pause uploads after a server-side rejection, by cause.
+	HIGH_LOAD_PAUSE  = 10 * time.Minute // server reported high system load
+	DISK_SPACE_PAUSE = 1 * time.Hour    // server reported out of disk space
+	RATE_LIMIT_PAUSE = 5 * time.Minute  // HTTP 429 without Retry-After
+	MAX_RETRY_AFTER  = 24 * time.Hour   // longest Retry-After honored
+)
+
+// retryAfterPattern finds the wait requested by the server in a rejection
+// message, as written by UploadRejectedError and Preflight.
+var retryAfterPattern = regexp.MustCompile(`retry after (\d+) seconds`)
+
+// Version is reported in /healthz and the {version} FITS keyword placeholder.
+// The command sets it from its build version.
+var Version string
+
+// softwareVersion returns Version, or "development" for builds without one.
+func softwareVersion() string {
+	if Version == "" {
+		return "development"
+	}
+	return Version
+}
+
+// Config holds the settings read from config.env.
+type Config struct {
+	Server              string
+	Username            string
+	Password            string
+	PasswordFile        string            // File holding SAI_PASSWORD, instead of config.env
+	PasswordKeyring     bool              // Read SAI_PASSWORD from the OS credential store
+	AuthMethod          string            // "basic" (default), "digest" or "ntlm"
+	CAFile              string            // PEM certificates trusted for the upload server, e.g. an institute CA
+	TLSInsecure         bool              // Do not verify the upload server's certificate (emergencies only)
+	StationID           string            // Identifies the station to the server in the User-Agent and "station" form field
+	UploadFields        map[string]string // Extra form fields sent with every upload, from SAI_UPLOAD_FIELD_<NAME>
+	ChecksumSidecar     bool              // Also upload ARCHIVE.sha256 in the "checksum" form field
+	SignMethod          string            // Detached signature uploaded in the "signature" form field: "minisign", "gpg" or "" (unsigned)
+	SignKey             string            // minisign secret key file or GPG key ID
+	SignPassphraseFile  string            // File holding the passphrase of the signing key
+	StreamUpload        bool              // Upload archives while packing them instead of writing them to the temp directory first
+	UploadPolicies      []destinationPolicy
+	CameraDirectory     string
+	ProcessedDirectory  string
+	TempDirectory       string        // Where archives wait for upload (empty = temp next to the executable)
+	CameraReadOnly      bool          // Copy frames instead of moving them; the camera directory is never modified
+	CameraMount         bool          // The camera directory is a mount point; an unmounted one is unreachable, not empty
+	CameraReconnect     string        // Command run while the camera directory is unreachable, e.g. to mount the share again
+	Interval            int           // Scan interval in seconds
+	RequestedInterval   int           // Store the original requested interval
+	IdleInterval        time.Duration // Longest scan interval while no new frames appear (0 = no back-off)
+	UploadThrottle      time.Duration // Minimum time between upload attempts
+	UploadTimeout       time.Duration // Limit for a single upload request
+	Jitter              int           // Random variation of the scan interval and upload throttle, in percent
+	UploadInterval      time.Duration // Time between upload batches (0 = upload each archive when created)
+	UploadBatch         int           // Start a batch early once this many archives wait (0 = no limit)
+	MaxUploadAttempts   int           // Failed attempts before an archive goes to the failed directory (0 = retry forever)
+	UploadBandwidth     int64         // Upload rate limit in bytes per second (0 = unlimited)
+	DailyUploadLimit    int64         // Bytes uploaded per local day before uploads wait for the next day (0 = unlimited)
+	StrictUpload        bool          // Delete archives only after an explicit confirmation; unconfirmed 2xx answers move them to needs-review
+	SuccessToken        string        // Text of the upload answer that confirms an upload (empty = the markers of upload.py)
+	ProbeURL            string        // Fetched before uploading to detect captive portals (empty = HEAD request to the upload server)
+	ProbeExpect         string        // Text the answer of ProbeURL must contain (empty = expect 204 or a page that is not HTML)
+	AckURL              string        // Ingestion status endpoint with {id} and {name}; archives are kept until it confirms (empty = delete after upload)
+	AckTimeout          time.Duration // Upload again when the ingestion is not confirmed within this time
+	ResultsURL          string        // Processing results endpoint with {name} and {area} (empty = no download)
+	ResultsDirectory    string        // Where downloaded processing results are stored
+	ResultsInterval     time.Duration // Time between two downloads of processing results
+	UploadHours         schedule      // Daily windows in which archives are uploaded (empty = always)
+	ArchiveHours        schedule      // Daily windows in which frames are packed (empty = always)
+	FlushAfter          time.Duration // Pack groups with fewer than Count frames after this long without new frames (0 = never)
+	FlushAt             int           // Local time, in minutes since midnight, after which earlier partial groups are packed (-1 = never)
+	Count               int
+	PriorityAreas       []string // Areas packed and uploaded before the others
+	Prefix              string
+	Postfix             string
+	ArchiveMode         string        // "auto", "rar", "zip", "zip-uncompressed"
+	ArchiveThreads      int           // Frames deflated at the same time in compressed ZIP archives (0 = one per CPU)
+	RARTimeout          time.Duration // rar still creating or testing an archive after this long is killed
+	FilenamePattern     string        // Regex template of frame names with {area} and {ext} placeholders
+	SplitSF             bool          // Pack the AREA-SF_ frames of an area into separate AREA-SF archives
+	SequencePattern     string        // Its first group is the number of a frame in its sequence (empty = no sequences)
+	SequenceKeyword     string        // FITS keyword holding the number of a frame in its sequence
+	ProcessOrder        string        // Order areas are packed and archives uploaded: "list" (default), "oldest" or "newest"
+	MaxArchivesPerScan  int           // Archives packed, and archives queued for upload, per scan (0 = no limit)
+	QuarantineDirectory string        // Where invalid frames are moved (empty = quarantine disabled)
+	QuarantineNotify    bool          // Send a notification for every quarantined frame
+	NotifyURL           string        // Plain-text POST endpoint for operator notifications
+	ReportDirectory     string        // Where nightly reports are written (empty = disabled)
+	ReportNotify        bool          // Also send nightly reports to NotifyURL
+	CrashDirectory      string        // Where crash reports of recovered panics are written (default: "crashes" next to the executable)
+	CrashURL            string        // Endpoint receiving crash reports as plain-text POST (empty = not uploaded)
+	WatchdogTimeout     time.Duration // Report a scan, pack or upload job running this long and kill its child processes (0 = off)
+	StaleAreaAfter      time.Duration // Alarm when an area produces no frames for this long during the night (0 = off)
+	StaleAreaHours      schedule      // Hours in which stale areas are watched (empty = while other areas produce frames)
+	StaleFrameAfter     time.Duration // Warn when a frame waits in the camera directory for this long (0 = off)
+	StaleFrameFlush     bool          // Also pack such frames as an incomplete group
+	UploadedDirectory   string        // Uploaded archives are moved here instead of being deleted (empty = delete)
+	UploadedMaxAge      time.Duration // Kept uploaded archives older than this are deleted (0 = no age limit)
+	UploadedMaxSize     int64         // Oldest kept uploaded archives are deleted beyond this many bytes (0 = no size limit)
+	TempMaxAge          time.Duration // Archives in the temp directory older than this at startup are cleaned up (0 = never)
+	TempCleanup         string        // What happens to them: "quarantine" (failed directory) or "remove"
+	StateDatabase       bool          // Record frames, uploads and file operations in the state database
+	StateMaxAge         time.Duration // State records older than this are dropped (0 = kept forever)
+	SkipDuplicates      bool          // Do not archive frames whose contents the state database shows were archived before
+	GroupBy             string        // "filename" (default), "object" or "object-filter"
+	PreviewMode         string        // "" (disabled), "archive" or "upload"
+	PreviewFormat       string        // "png" or "jpeg"
+	PreviewStretch      string        // "asinh" or "zscale"
+	PreviewSize         int           // Longest preview side in pixels
+	PreviewURL          string        // Endpoint receiving previews in "upload" mode
+	QualityMetrics      bool          // Compute background/FWHM/star count for every frame
+	QualityMinStars     int           // Frames with fewer detected stars are not uploaded (0 = keep all)
+	WeatherSource       string        // Cloud sensor: file, HTTP(S) URL or mqtt:// topic (empty = none)
+	WeatherGate         bool          // Do not upload frames taken while the cloud sensor reported an overcast sky
+	WeatherOvercast     int           // Cloud cover, in percent, from which the sky counts as overcast
+	RoofSource          string        // Roof or dome state: file, HTTP(S) URL or mqtt:// topic (empty = none)
+	SafetyMonitor       string        // Pause packing and uploads while this monitor reports unsafe: alpaca://, file, URL or mqtt:// (empty = none)
+	ExposureEvents      string        // Camera driver announcing the end of exposures: indi:// or alpaca:// (empty = none)
+	Calibration         bool          // Route dark/bias/flat frames into separate archives
+	CalibrationPattern  string        // Filename regex identifying calibration frames
+	CalibrationCount    int           // Frames per calibration archive
+	CalibrationServer   string        // Upload URL for calibration archives (default: SAI_SERVER)
+	FITSKeywords        []fitsKeyword // Header keywords written into frames before archiving
+	LogLevel            slog.Level    // Minimum level of printed log messages
+	LogFormat           string        // "text" (default) or "json"
+	Language            string        // Language of the text log messages, e.g. "ru" (empty = system language)
+	LanguageFile        string        // File of "message = translation" lines added to the shipped translations
+	StatusListen        string        // Address of the HTTP status server, e.g. "127.0.0.1:8080" (empty = disabled)
+	StatusToken         string        // Token the control requests of the status server must carry (empty = none)
+	StatusFile          string        // JSON status file rewritten after every scan and upload (empty = disabled)
+	StatusPprof         bool          // Serve the Go profiler under /debug/pprof/ on the status server
+	HeartbeatURL        string        // URL pinged after every completed program loop (dead-man switch)
+	PreArchiveHook      string        // Command run before archiving; can veto frames or stop the archive
+	HookTimeout         time.Duration // Hooks still running after this long are killed
+	PreUploadHook       string        // Command run before each upload; a non-zero exit postpones it
+	UploadHook          string        // Command run after every upload attempt (empty = none)
+	UploadWebhook       string        // URL receiving a JSON POST after every upload attempt (empty = none)
+	CommandURL          string        // Server endpoint polled for remote commands (empty = disabled)
+	CommandInterval     time.Duration // Time between polls of CommandURL
+	UpdateCheck         bool          // Check daily for a newer release and log and notify it
+	ReleasesURL         string        // GitHub "latest release" API endpoint (empty = the AstroCam-GO repository)
+	UpdatePublicKey     string        // minisign public key, or its file, that updates must be signed with (empty = checksums only)
+}
+
+// AstroCam is the uploader: it scans the camera directory, packs frames into
+// archives and uploads them.
+type AstroCam struct {
+	config              *Config
+	areas               []string
+	profile             string // Station profile whose files are used ("" = the default files)
+	tempDirectory       string
+	failedDirectory     string // Archives no longer retried (SAI_MAX_UPLOAD_ATTEMPTS, permanent rejections)
+	reviewDirectory     string // Archives whose upload was neither confirmed nor rejected (SAI_STRICT_UPLOAD)
+	currentDir          string
+	throttle            *uploadThrottle // Spaces the uploads to each destination SAI_UPLOAD_THROTTLE apart
+	turns               *uploadTurns    // Upload turns shared with the other stations of the process (nil = one station)
+	weather             *weatherSensor  // Cloud sensor readings, taken by the scanner and looked up by the packer
+	roof                roofSensor      // Roof state, followed by the scanner
+	safety              sensorPoller    // SAI_SAFETY_MONITOR, read by the scanner
+	archiver            Archiver
+	archiverFixed       bool // set by SetArchiver, kept across reloads
+	testMode            bool // Whether running in test mode
+	testStartTime       time.Time
+	fitsExtPattern      string                    // Regex pattern matching all FITS file extensions (.fts, .fits, .fit)
+	uploadPauseUntil    time.Time                 // Skip uploads until this time after a server-side rejection (high load or out of disk space)
+	uploadLimitDay      string                    // Local day on which SAI_DAILY_UPLOAD_LIMIT held back an upload
+	uploadReserved      int64                     // Bytes of the uploads in progress, not yet counted by the state database
+	headerCache         map[string]cachedHeader   // FITS headers by path, for header-based grouping
+	headerCacheMu       sync.Mutex                // Guards headerCache
+	status              *runtimeStatus            // Pipeline state reported by the status server
+	statusVolumes       map[string]string         // Absolute directories whose free space is reported
+	operatorPaused      atomic.Bool               // Packing and uploads paused by the operator (Pause, the status server) until resumed
+	pauseFile           atomic.Bool               // Packing and uploads paused while the PAUSE file exists
+	unsafe              atomic.Bool               // SAI_SAFETY_MONITOR marked the observatory unsafe
+	offline             atomic.Bool               // The last connection test to the upload server failed
+	captivePortal       atomic.Bool               // The last connection test was answered by a captive portal
+	cameraUnreachable   atomic.Bool               // The last scan could not read the camera directory
+	lastCameraReconnect time.Time                 // When the scanner last ran SAI_CAMERA_RECONNECT
+	uploadFailures      map[string]*uploadFailure // Retry state of archives whose upload failed, by path
+	archiveNames        map[string]archiveStamp   // Last archive name issued per area, against collisions within a second
+	archiveNamesMu      sync.Mutex                // Guards archiveNames
+	failuresMu          sync.Mutex                // Guards uploadFailures
+	scanRequests        chan struct{}             // Immediate scan requests from the status server
+	reloadRequests      chan chan error           // Config reload requests from the status server, answered with the result
+	restart             chan struct{}             // Closed when Run should return for a restart (see RestartRequested)
+	restartOnce         sync.Once                 // Guards closing restart
+	configMu            sync.RWMutex              // Guards config, areas and archive settings against reloads while the status server reads them
+	uploader            Uploader                  // Overrides the uploader chosen by destination URL scheme (tests, embedding)
+	cameraLock          *FileLock                 // Lock held in the camera directory
+	state               *stateDB                  // Processed frames and upload attempts
+	lastReport          time.Time                 // Night of the last nightly report, written by the main loop
+	lastCompaction      time.Time                 // Time the state database was last compacted, written by the main loop
+	scanPeriod          time.Duration             // Current scan interval, raised while idle
+	lastActivity        time.Time                 // Last scan that found new frames or work in progress
+	waitingFrames       int                       // Frames found in the camera directory by the last scan
+	lastUploadBatch     time.Time                 // When the scanner last queued the waiting archives for upload
+	uploadHoursShut     bool                      // The scanner found SAI_UPLOAD_HOURS closed
+	archiveHoursShut    bool                      // The scanner found SAI_ARCHIVE_HOURS closed
+	lastCommandPoll     time.Time                 // When the scanner last asked SAI_COMMAND_URL for commands
+	lastCycle           cycleCounts               // Totals at the last cycle summary
+	intervalOverride    time.Duration             // Scan interval set by the server (0 = SAI_INTERVAL)
+	sequenceRegexp      *regexp.Regexp            // Compiled SAI_SEQUENCE_PATTERN, used by the scanner
+	staleFrames         map[string]bool           // Areas whose waiting frames the scanner reported as stale
+	cameraLockPath      string
+	pipeline            *pipeline    // Queues between the scanner, packer and uploader
+	watchdog            *watchdog    // Start of the current job of the scanner, packer and uploader
+	jobsMu              sync.RWMutex // Held for reading by each pack and upload job, for writing by reload
+	pauseMu             sync.Mutex   // Guards uploadPauseUntil, uploadLimitDay and uploadReserved
+	areaFilter          []string     // Areas a run is restricted to (nil = all of areas.txt)
+	fs                  FS           // File system used for grouping, moving frames and finding archives
+	clock               Clock        // Time source for throttling, pauses and file ages
+}
+
+// cachedHeader remembers the FITS header of a frame so it is not re-read on
+// every scan; the entry is valid while the file size and mtime are unchanged.
+type cachedHeader struct {
+	size    int64
+	modTime time.Time
+	header  map[string]string
+}
+
+// FileGroup is a set of frames packed into one archive. FilesToArchive holds
+// their base names, as stored in the archive, and FilesToDelete their
+// absolute paths, which are archived and then moved to the processed directory.
+type FileGroup struct {
+	FilesToArchive []string
+	FilesToDelete  []string
+}
+
+// remove drops frames, given by path, from the group.
+func (g *FileGroup) remove(files []string) {
+	drop := make(map[string]bool, len(files))
+	for _, file := range files {
+		drop[file] = true
+	}
+	keep := g.FilesToDelete[:0]
+	g.FilesToArchive = g.FilesToArchive[:0]
+	for _, file := range g.FilesToDelete {
+		if !drop[file] {
+			keep = append(keep, file)
+			g.FilesToArchive = append(g.FilesToArchive, filepath.Base(file))
+		}
+	}
+	g.FilesToDelete = keep
+}
+
+// findConfigFile looks for a config file in multiple locations:
+// 1. Next to the executable (preferred)
+// 2. Current working directory (fallback)
+func findConfigFile(filename string) (string, error) {
+	// Get executable directory
+	execPath, err := os.Executable()
+	if err == nil {
+		execDir := filepath.Dir(execPath)
+		configPath := filepath.Join(execDir, filename)
+		if _, err := os.Stat(configPath); err == nil {
+			return configPath, nil
+		}
+	}
+
+	// Fall back to current directory
+	if _, err := os.Stat(filename); err == nil {
+		return filename, nil
+	}
+
+	return "", fmt.Errorf("config file %s not found in executable directory or current directory", filename)
+}
+
+// DefaultConfig returns the settings used for keys missing from config.env.
+func DefaultConfig() *Config {
+	return &Config{
+		Interval:           DEFAULT_INTERVAL, // Use default instead of hardcoded 180
+		RequestedInterval:  DEFAULT_INTERVAL, // Initialize both to default
+		AuthMethod:         authBasic,
+		UploadThrottle:     DEFAULT_UPLOAD_THROTTLE,
+		UploadTimeout:      DEFAULT_UPLOAD_TIMEOUT,
+		AckTimeout:         DEFAULT_ACK_TIMEOUT,
+		ResultsInterval:    DEFAULT_RESULTS_INTERVAL,
+		CommandInterval:    DEFAULT_COMMAND_POLL,
+		WatchdogTimeout:    DEFAULT_WATCHDOG_TIMEOUT,
+		RARTimeout:         DEFAULT_RAR_TIMEOUT,
+		HookTimeout:        DEFAULT_HOOK_TIMEOUT,
+		UpdateCheck:        true,
+		FilenamePattern:    DEFAULT_FILENAME_PATTERN,
+		FlushAt:            -1,
+		TempCleanup:        tempCleanupQuarantine,
+		StateDatabase:      true,
+		StateMaxAge:        DEFAULT_STATE_MAX_AGE,
+		Count:              3,          // default
+		ProcessOrder:       orderList,  // default
+		ArchiveMode:        "auto",     // default
+		GroupBy:            "filename", // default
+		LogFormat:          "text",     // default
+		PreviewFormat:      "png",      // default
+		PreviewStretch:     "asinh",    // default
+		PreviewSize:        DEFAULT_PREVIEW_SIZE,
+		CalibrationPattern: DEFAULT_CALIBRATION_PATTERN,
+		CalibrationCount:   DEFAULT_CALIBRATION_COUNT,
+		WeatherOvercast:    DEFAULT_WEATHER_OVERCAST,
+	}
+}
+
+// configKeys are the config.env settings, except the SAI_FITS_KEY_<KEYWORD>
+// family. Each can be overridden by an environment variable of the same name.
+var configKeys = []string{
+	"SAI_SERVER", "SAI_USERNAME", "SAI_PASSWORD", "SAI_PASSWORD_FILE", "SAI_PASSWORD_KEYRING", "SAI_STATION_ID", "SAI_CHECKSUM_SIDECAR", "SAI_SIGN_METHOD", "SAI_SIGN_KEY", "SAI_SIGN_PASSPHRASE_FILE", "SAI_STREAM_UPLOAD",
+	"SAI_AUTH_METHOD", "SAI_CA_FILE", "SAI_TLS_INSECURE",
+	"SAI_CAMERA_DIRECTORY", "SAI_PROCESSED_DIRECTORY", "SAI_TEMP_DIRECTORY", "SAI_CAMERA_READ_ONLY", "SAI_CAMERA_MOUNT", "SAI_CAMERA_RECONNECT",
+	"SAI_INTERVAL", "SAI_IDLE_INTERVAL", "SAI_UPLOAD_THROTTLE", "SAI_UPLOAD_TIMEOUT", "SAI_JITTER", "SAI_UPLOAD_INTERVAL", "SAI_UPLOAD_BATCH", "SAI_MAX_UPLOAD_ATTEMPTS", "SAI_UPLOAD_BANDWIDTH", "SAI_DAILY_UPLOAD_LIMIT", "SAI_STRICT_UPLOAD", "SAI_SUCCESS_TOKEN", "SAI_PROBE_URL", "SAI_PROBE_EXPECT", "SAI_ACK_URL", "SAI_ACK_TIMEOUT", "SAI_RESULTS_URL", "SAI_RESULTS_DIRECTORY", "SAI_RESULTS_INTERVAL", "SAI_UPLOAD_HOURS", "SAI_ARCHIVE_HOURS", "SAI_FLUSH_AFTER", "SAI_FLUSH_AT", "SAI_COUNT", "SAI_PROCESS_ORDER", "SAI_MAX_ARCHIVES_PER_SCAN", "SAI_PRIORITY_AREAS", "SAI_PREFIX", "SAI_POSTFIX", "SAI_ARCHIVE_MODE", "SAI_ARCHIVE_THREADS", "SAI_RAR_TIMEOUT", "SAI_FILENAME_PATTERN", "SAI_SPLIT_SF", "SAI_SEQUENCE_PATTERN", "SAI_SEQUENCE_KEYWORD",
+	"SAI_QUARANTINE_DIRECTORY", "SAI_QUARANTINE_NOTIFY", "SAI_NOTIFY_URL",
+	"SAI_REPORT_DIRECTORY", "SAI_REPORT_NOTIFY", "SAI_CRASH_DIRECTORY", "SAI_CRASH_URL", "SAI_WATCHDOG_TIMEOUT", "SAI_STALE_AREA_AFTER", "SAI_STALE_AREA_HOURS", "SAI_STALE_FRAME_AFTER", "SAI_STALE_FRAME_FLUSH", "SAI_UPLOADED_DIRECTORY", "SAI_UPLOADED_MAX_AGE", "SAI_UPLOADED_MAX_SIZE", "SAI_TEMP_MAX_AGE", "SAI_TEMP_CLEANUP", "SAI_STATE_DATABASE", "SAI_STATE_MAX_AGE", "SAI_SKIP_DUPLICATES",
+	"SAI_GROUP_BY",
+	"SAI_PREVIEW", "SAI_PREVIEW_FORMAT", "SAI_PREVIEW_STRETCH", "SAI_PREVIEW_SIZE", "SAI_PREVIEW_URL",
+	"SAI_QUALITY", "SAI_QUALITY_MIN_STARS",
+	"SAI_WEATHER_SOURCE", "SAI_WEATHER_GATE", "SAI_WEATHER_OVERCAST", "SAI_ROOF_SOURCE", "SAI_SAFETY_MONITOR", "SAI_EXPOSURE_EVENTS",
+	"SAI_CALIBRATION", "SAI_CALIBRATION_PATTERN", "SAI_CALIBRATION_COUNT", "SAI_CALIBRATION_SERVER",
+	"SAI_LOG_LEVEL", "SAI_LOG_FORMAT", "SAI_LANGUAGE", "SAI_LANGUAGE_FILE",
+	"SAI_STATUS_LISTEN", "SAI_STATUS_TOKEN", "SAI_STATUS_FILE", "SAI_STATUS_PPROF", "SAI_HEARTBEAT_URL",
+	"SAI_COMMAND_URL", "SAI_COMMAND_INTERVAL",
+	"SAI_UPDATE_CHECK", "SAI_RELEASES_URL", "SAI_UPDATE_PUBKEY",
+	"SAI_PRE_ARCHIVE_HOOK", "SAI_PRE_UPLOAD_HOOK", "SAI_UPLOAD_HOOK", "SAI_UPLOAD_WEBHOOK", "SAI_HOOK_TIMEOUT",
+}
+
+// fitsKeyPrefix starts the config.env keys that set FITS header keywords.
+const fitsKeyPrefix = "SAI_FITS_KEY_"
+
+// uploadFieldPrefix starts the config.env keys that add upload form fields.
+const uploadFieldPrefix = "SAI_UPLOAD_FIELD_"
+
+// ConfigKeys returns the names of the config.env settings. Settings named
+// SAI_FITS_KEY_<KEYWORD>, SAI_UPLOAD_FIELD_<NAME> and SAI_UPLOAD_POLICY_<NAME>
+// are accepted in addition to these.
+func ConfigKeys() []string {
+	return append([]string(nil), configKeys...)
+}
+
+var (
+	configOverridesMu sync.Mutex
+	configOverrides   map[string]string
+)
+
+// SetConfigOverrides sets config.env settings (e.g. from command-line flags)
+// that take precedence over both config.env and the environment in every
+// later LoadConfig, including reloads.
+func SetConfigOverrides(overrides map[string]string) {
+	configOverridesMu.Lock()
+	defer configOverridesMu.Unlock()
+	configOverrides = make(map[string]string, len(overrides))
+	for key, value := range overrides {
+		configOverrides[key] = value
+	}
+}
+
+// LoadConfig reads config.env from the executable directory or, failing
+// that, the current directory. Missing or invalid settings keep their
+// defaults. SAI_* environment variables override the file, and settings
+// passed to SetConfigOverrides override both.
+func LoadConfig() *Config {
+	return loadConfig(currentProfile())
+}
+
+// loadConfig reads the config.env of the named profile, like LoadConfig.
+func loadConfig(profile string) *Config {
+	config := DefaultConfig()
+
+	// Look for config.env in executable directory first, then current directory
+	configPath, err := findConfigFile(StationFileName(profile, "config.env"))
+	if err != nil {
+		slog.Warn("Could not find config.env", "error", err)
+	} else if file, err := os.Open(configPath); err != nil {
+		slog.Warn("Could not read config.env", "error", err)
+	} else {
+		slog.Info("Using config file", "path", configPath)
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+			// Remove inline comments (everything after # character)
+			if commentPos := strings.Index(value, "#"); commentPos != -1 {
+				value = strings.TrimSpace(value[:commentPos])
+			}
+
+			config.set(key, value)
+		}
+		file.Close()
+	}
+
+	// Environment variables, then overrides; values are taken verbatim since
+	// they do not go through the comment handling of the file
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if isConfigKey(key) {
+			slog.Debug("Config setting from environment", "key", key)
+			config.set(key, strings.TrimSpace(value))
+		}
+	}
+	configOverridesMu.Lock()
+	for key, value := range configOverrides {
+		slog.Debug("Config setting from command line", "key", key)
+		config.set(key, strings.TrimSpace(value))
+	}
+	configOverridesMu.Unlock()
+
+	// A star-count threshold needs the metrics to be computed
+	if config.QualityMinStars > 0 {
+		config.QualityMetrics = true
+	}
+
+	config.resolvePassword()
+
+	return config
+}
+
+// isConfigKey reports whether key names a config.env setting.
+func isConfigKey(key string) bool {
+	if strings.HasPrefix(key, fitsKeyPrefix) || strings.HasPrefix(key, uploadFieldPrefix) || strings.HasPrefix(key, uploadPolicyPrefix) {
+		return true
+	}
+	for _, k := range configKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// set applies one config.env setting. Invalid values are logged and leave
+// the current value unchanged.
+func (config *Config) set(key, value string) {
+	switch key {
+	case "SAI_SERVER":
+		config.Server = value
+	case "SAI_USERNAME":
+		config.Username = strings.TrimSpace(value)
+	case "SAI_PASSWORD":
+		config.Password = strings.TrimSpace(value)
+	case "SAI_PASSWORD_FILE":
+		config.PasswordFile = value
+	case "SAI_PASSWORD_KEYRING":
+		config.PasswordKeyring = parseYesNo(value)
+	case "SAI_AUTH_METHOD":
+		method := strings.ToLower(value)
+		switch method {
+		case authBasic, authDigest, authNTLM:
+			config.AuthMethod = method
+		default:
+			slog.Warn("Invalid SAI_AUTH_METHOD (basic, digest or ntlm), using basic", "value", value)
+		}
+	case "SAI_CA_FILE":
+		config.CAFile = value
+	case "SAI_TLS_INSECURE":
+		config.TLSInsecure = parseYesNo(value)
+	case "SAI_STATION_ID":
+		config.StationID = value
+	case "SAI_CHECKSUM_SIDECAR":
+		config.ChecksumSidecar = parseYesNo(value)
+	case "SAI_STREAM_UPLOAD":
+		config.StreamUpload = parseYesNo(value)
+	case "SAI_SIGN_METHOD":
+		method := strings.TrimSpace(strings.ToLower(value))
+		switch method {
+		case "", signMinisign, signGPG:
+			config.SignMethod = method
+		default:
+			slog.Warn("Invalid SAI_SIGN_METHOD (minisign or gpg), archives are not signed", "value", value)
+		}
+	case "SAI_SIGN_KEY":
+		config.SignKey = value
+	case "SAI_SIGN_PASSPHRASE_FILE":
+		config.SignPassphraseFile = value
+	case "SAI_CAMERA_DIRECTORY":
+		config.CameraDirectory = value
+	case "SAI_PROCESSED_DIRECTORY":
+		config.ProcessedDirectory = value
+	case "SAI_TEMP_DIRECTORY":
+		config.TempDirectory = value
+	case "SAI_CAMERA_READ_ONLY":
+		config.CameraReadOnly = parseYesNo(value)
+	case "SAI_CAMERA_MOUNT":
+		config.CameraMount = parseYesNo(value)
+	case "SAI_CAMERA_RECONNECT":
+		config.CameraReconnect = value
+	case "SAI_INTERVAL":
+		// Handle interval with validation and fallback
+		if value == "" {
+			// Empty value - use default
+			config.RequestedInterval = DEFAULT_INTERVAL
+			config.Interval = DEFAULT_INTERVAL
+		} else if d, err := parseDuration(value); err != nil {
+			// Invalid value - use default
+			slog.Warn("Invalid SAI_INTERVAL, using default", "value", value, "default_seconds", DEFAULT_INTERVAL)
+			config.RequestedInterval = DEFAULT_INTERVAL
+			config.Interval = DEFAULT_INTERVAL
+		} else if val := int(d / time.Second); val > MAX_INTERVAL {
+			// Too large - use default
+			slog.Warn("SAI_INTERVAL exceeds maximum, using default",
+				"value", val, "max_seconds", MAX_INTERVAL, "default_seconds", DEFAULT_INTERVAL)
+			config.RequestedInterval = val // Store what was requested
+			config.Interval = DEFAULT_INTERVAL
+		} else {
+			// Valid value - store it (will be enforced to minimum later)
+			config.RequestedInterval = val
+			config.Interval = val
+		}
+	case "SAI_IDLE_INTERVAL":
+		if d, err := parseDuration(value); err == nil && d >= 0 && d <= MAX_INTERVAL*time.Second {
+			config.IdleInterval = d
+		} else {
+			slog.Warn("Invalid SAI_IDLE_INTERVAL, not backing off when idle", "value", value)
+			config.IdleInterval = 0
+		}
+	case "SAI_UPLOAD_THROTTLE":
+		if d, err := parseDuration(value); err == nil && d >= 0 {
+			config.UploadThrottle = d
+		} else {
+			slog.Warn("Invalid SAI_UPLOAD_THROTTLE, using default", "value", value, "default", DEFAULT_UPLOAD_THROTTLE)
+		}
+	case "SAI_UPLOAD_TIMEOUT":
+		if d, err := parseDuration(value); err == nil && d > 0 {
+			config.UploadTimeout = d
+		} else {
+			slog.Warn("Invalid SAI_UPLOAD_TIMEOUT, using default", "value", value, "default", DEFAULT_UPLOAD_TIMEOUT)
+		}
+	case "SAI_STRICT_UPLOAD":
+		config.StrictUpload = parseYesNo(value)
+	case "SAI_SUCCESS_TOKEN":
+		config.SuccessToken = value
+	case "SAI_PROBE_URL":
+		config.ProbeURL = value
+	case "SAI_PROBE_EXPECT":
+		config.ProbeExpect = value
+	case "SAI_ACK_URL":
+		config.AckURL = value
+	case "SAI_ACK_TIMEOUT":
+		if d, err := parseDuration(value); err == nil && d > 0 {
+			config.AckTimeout = d
+		} else {
+			slog.Warn("Invalid SAI_ACK_TIMEOUT, using default", "value", value, "default", DEFAULT_ACK_TIMEOUT)
+		}
+	case "SAI_RESULTS_URL":
+		config.ResultsURL = value
+	case "SAI_RESULTS_DIRECTORY":
+		config.ResultsDirectory = value
+	case "SAI_RESULTS_INTERVAL":
+		if d, err := parseDuration(value); err == nil && d >= time.Minute {
+			config.ResultsInterval = d
+		} else {
+			slog.Warn("Invalid SAI_RESULTS_INTERVAL, using default", "value", value, "default", DEFAULT_RESULTS_INTERVAL)
+		}
+	case "SAI_UPLOAD_INTERVAL":
+		if d, err := parseDuration(value); err == nil && d >= 0 {
+			config.UploadInterval = d
+		} else {
+			slog.Warn("Invalid SAI_UPLOAD_INTERVAL, uploading each archive when created", "value", value)
+		}
+	case "SAI_UPLOAD_BATCH":
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n >= 0 {
+			config.UploadBatch = n
+		} else {
+			slog.Warn("Invalid SAI_UPLOAD_BATCH, ignoring it", "value", value)
+		}
+	case "SAI_MAX_UPLOAD_ATTEMPTS":
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n >= 0 {
+			config.MaxUploadAttempts = n
+		} else {
+			slog.Warn("Invalid SAI_MAX_UPLOAD_ATTEMPTS, retrying failed uploads forever", "value", value)
+		}
+	case "SAI_UPLOAD_BANDWIDTH":
+		if kb, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && kb >= 0 {
+			config.UploadBandwidth = int64(kb) << 10
+		} else {
+			slog.Warn("Invalid SAI_UPLOAD_BANDWIDTH (KB per second), not limiting the upload rate", "value", value)
+		}
+	case "SAI_DAILY_UPLOAD_LIMIT":
+		if limit, err := parseDataSize(value); err == nil {
+			config.DailyUploadLimit = limit
+		} else {
+			slog.Warn("Invalid SAI_DAILY_UPLOAD_LIMIT, not limiting the daily upload volume", "value", value)
+		}
+	case "SAI_JITTER":
+		percent, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+		if err == nil && percent >= 0 && percent <= MAX_JITTER {
+			config.Jitter = percent
+		} else {
+			slog.Warn("Invalid SAI_JITTER, expected a percentage from 0 to 50", "value", value)
+		}
+	case "SAI_UPLOAD_HOURS", "SAI_ARCHIVE_HOURS":
+		sched, err := parseSchedule(value)
+		if err != nil {
+			slog.Warn("Invalid "+key+", not limiting the hours", "value", value, "error", err)
+			sched = nil
+		}
+		if key == "SAI_UPLOAD_HOURS" {
+			config.UploadHours = sched
+		} else {
+			config.ArchiveHours = sched
+		}
+	case "SAI_FLUSH_AFTER":
+		if d, err := parseDuration(value); err == nil && d >= 0 {
+			config.FlushAfter = d
+		} else {
+			slog.Warn("Invalid SAI_FLUSH_AFTER, incomplete groups are not flushed", "value", value)
+		}
+	case "SAI_FLUSH_AT":
+		config.FlushAt = -1
+		if strings.TrimSpace(value) == "" {
+			break
+		}
+		if minutes, err := parseClockTime(value); err == nil && minutes < 24*60 {
+			config.FlushAt = minutes
+		} else {
+			slog.Warn("Invalid SAI_FLUSH_AT, expected HH:MM", "value", value)
+		}
+	case "SAI_COUNT":
+		if val, err := strconv.Atoi(value); err == nil {
+			config.Count = val
+		}
+	case "SAI_PROCESS_ORDER":
+		order := strings.TrimSpace(strings.ToLower(value))
+		switch order {
+		case "":
+		case orderList, orderOldest, orderNewest:
+			config.ProcessOrder = order
+		default:
+			slog.Warn("Invalid SAI_PROCESS_ORDER, using the order of areas.txt", "value", value)
+		}
+	case "SAI_MAX_ARCHIVES_PER_SCAN":
+		if val, err := strconv.Atoi(value); err == nil && val >= 0 {
+			config.MaxArchivesPerScan = val
+		} else {
+			slog.Warn("Invalid SAI_MAX_ARCHIVES_PER_SCAN, not limiting archives per scan", "value", value)
+		}
+	case "SAI_PRIORITY_AREAS":
+		config.PriorityAreas = nil
+		for _, area := range strings.Split(value, ",") {
+			if area = strings.TrimSpace(area); area != "" {
+				config.PriorityAreas = append(config.PriorityAreas, area)
+			}
+		}
+	case "SAI_FILENAME_PATTERN":
+		pattern := strings.TrimSpace(value)
+		if pattern == "" {
+			config.FilenamePattern = DEFAULT_FILENAME_PATTERN
+		} else if !strings.Contains(pattern, "{area}") {
+			slog.Warn("SAI_FILENAME_PATTERN lacks {area}, using the default pattern", "value", value)
+		} else if _, err := filenameRegexp(pattern, "AREA", fitsExtensionPattern); err != nil {
+			slog.Warn("Invalid SAI_FILENAME_PATTERN, using the default pattern", "value", value, "error", err)
+		} else {
+			config.FilenamePattern = pattern
+		}
+	case "SAI_SPLIT_SF":
+		config.SplitSF = parseYesNo(value)
+	case "SAI_SEQUENCE_PATTERN":
+		config.SequencePattern = ""
+		if strings.TrimSpace(value) == "" {
+			break
+		}
+		if re, err := regexp.Compile(value); err != nil || re.NumSubexp() < 1 {
+			slog.Warn("Invalid SAI_SEQUENCE_PATTERN, it needs a group capturing the frame number", "value", value, "error", err)
+		} else {
+			config.SequencePattern = value
+		}
+	case "SAI_SEQUENCE_KEYWORD":
+		config.SequenceKeyword = strings.ToUpper(strings.TrimSpace(value))
+	case "SAI_PREFIX":
+		config.Prefix = value
+	case "SAI_POSTFIX":
+		config.Postfix = value
+	case "SAI_ARCHIVE_MODE":
+		mode := strings.TrimSpace(strings.ToLower(value))
+		if mode != "" {
+			config.ArchiveMode = mode
+		}
+	case "SAI_ARCHIVE_THREADS":
+		if val, err := strconv.Atoi(value); err == nil && val >= 0 {
+			config.ArchiveThreads = val
+		} else {
+			slog.Warn("Invalid SAI_ARCHIVE_THREADS, using one thread per CPU", "value", value)
+		}
+	case "SAI_RAR_TIMEOUT":
+		if d, err := parseDuration(value); err == nil && d > 0 {
+			config.RARTimeout = d
+		} else {
+			slog.Warn("Invalid SAI_RAR_TIMEOUT, using default", "value", value, "default", DEFAULT_RAR_TIMEOUT)
+		}
+	case "SAI_QUARANTINE_DIRECTORY":
+		config.QuarantineDirectory = value
+	case "SAI_QUARANTINE_NOTIFY":
+		config.QuarantineNotify = parseYesNo(value)
+	case "SAI_NOTIFY_URL":
+		config.NotifyURL = value
+	case "SAI_REPORT_DIRECTORY":
+		config.ReportDirectory = value
+	case "SAI_REPORT_NOTIFY":
+		config.ReportNotify = parseYesNo(value)
+	case "SAI_CRASH_DIRECTORY":
+		config.CrashDirectory = value
+	case "SAI_CRASH_URL":
+		config.CrashURL = value
+	case "SAI_WATCHDOG_TIMEOUT":
+		if d, err := parseDuration(value); err == nil && d >= 0 {
+			config.WatchdogTimeout = d
+		} else {
+			slog.Warn("Invalid SAI_WATCHDOG_TIMEOUT, using default", "value", value, "default", DEFAULT_WATCHDOG_TIMEOUT)
+		}
+	case "SAI_STALE_AREA_AFTER":
+		if d, err := parseDuration(value); err == nil && d >= 0 {
+			config.StaleAreaAfter = d
+		} else {
+			slog.Warn("Invalid SAI_STALE_AREA_AFTER, stale-area alarm disabled", "value", value)
+		}
+	case "SAI_STALE_AREA_HOURS":
+		sched, err := parseSchedule(value)
+		if err != nil {
+			slog.Warn("Invalid SAI_STALE_AREA_HOURS, watching while other areas produce frames", "value", value, "error", err)
+			sched = nil
+		}
+		config.StaleAreaHours = sched
+	case "SAI_STALE_FRAME_AFTER":
+		if d, err := parseDuration(value); err == nil && d >= 0 {
+			config.StaleFrameAfter = d
+		} else {
+			slog.Warn("Invalid SAI_STALE_FRAME_AFTER, stale frames are not reported", "value", value)
+		}
+	case "SAI_STALE_FRAME_FLUSH":
+		config.StaleFrameFlush = parseYesNo(value)
+	case "SAI_UPLOADED_DIRECTORY":
+		config.UploadedDirectory = value
+	case "SAI_UPLOADED_MAX_AGE":
+		if d, err := parseDuration(value); err == nil && d >= 0 {
+			config.UploadedMaxAge = d
+		} else {
+			slog.Warn("Invalid SAI_UPLOADED_MAX_AGE, kept archives are not deleted by age", "value", value)
+		}
+	case "SAI_UPLOADED_MAX_SIZE":
+		if size, err := parseDataSize(value); err == nil {
+			config.UploadedMaxSize = size
+		} else {
+			slog.Warn("Invalid SAI_UPLOADED_MAX_SIZE, kept archives are not deleted by size", "value", value)
+		}
+	case "SAI_TEMP_MAX_AGE":
+		if d, err := parseDuration(value); err == nil && d >= 0 {
+			config.TempMaxAge = d
+		} else {
+			slog.Warn("Invalid SAI_TEMP_MAX_AGE, old archives are kept", "value", value)
+		}
+	case "SAI_STATE_DATABASE":
+		config.StateDatabase = parseYesNo(value)
+	case "SAI_SKIP_DUPLICATES":
+		config.SkipDuplicates = parseYesNo(value)
+	case "SAI_STATE_MAX_AGE":
+		if d, err := parseDuration(value); err == nil && (d == 0 || d >= stateMinAge) {
+			config.StateMaxAge = d
+		} else {
+			slog.Warn("Invalid SAI_STATE_MAX_AGE (0, or at least 48h), using default", "value", value, "default", DEFAULT_STATE_MAX_AGE)
+		}
+	case "SAI_TEMP_CLEANUP":
+		mode := strings.TrimSpace(strings.ToLower(value))
+		switch mode {
+		case "":
+		case tempCleanupQuarantine, tempCleanupRemove:
+			config.TempCleanup = mode
+		default:
+			slog.Warn("Invalid SAI_TEMP_CLEANUP, moving archives to the failed directory", "value", value)
+		}
+	case "SAI_GROUP_BY":
+		mode := strings.TrimSpace(strings.ToLower(value))
+		switch mode {
+		case "":
+		case "filename", "object", "object-filter":
+			config.GroupBy = mode
+		default:
+			slog.Warn("Invalid SAI_GROUP_BY, using filename grouping", "value", value)
+		}
+	case "SAI_PREVIEW":
+		mode := strings.TrimSpace(strings.ToLower(value))
+		switch mode {
+		case "", "no", "off":
+			config.PreviewMode = ""
+		case "archive", "upload":
+			config.PreviewMode = mode
+		default:
+			slog.Warn("Invalid SAI_PREVIEW, previews disabled", "value", value)
+		}
+	case "SAI_PREVIEW_FORMAT":
+		switch strings.ToLower(value) {
+		case "png":
+			config.PreviewFormat = "png"
+		case "jpeg", "jpg":
+			config.PreviewFormat = "jpeg"
+		default:
+			slog.Warn("Invalid SAI_PREVIEW_FORMAT, using png", "value", value)
+		}
+	case "SAI_PREVIEW_STRETCH":
+		switch strings.ToLower(value) {
+		case "asinh", "zscale":
+			config.PreviewStretch = strings.ToLower(value)
+		default:
+			slog.Warn("Invalid SAI_PREVIEW_STRETCH, using asinh", "value", value)
+		}
+	case "SAI_PREVIEW_SIZE":
+		if val, err := strconv.Atoi(value); err == nil && val > 0 {
+			config.PreviewSize = val
+		}
+	case "SAI_PREVIEW_URL":
+		config.PreviewURL = value
+	case "SAI_QUALITY":
+		config.QualityMetrics = parseYesNo(value)
+	case "SAI_QUALITY_MIN_STARS":
+		if val, err := strconv.Atoi(value); err == nil && val >= 0 {
+			config.QualityMinStars = val
+		}
+	case "SAI_WEATHER_SOURCE":
+		config.WeatherSource = value
+	case "SAI_ROOF_SOURCE":
+		config.RoofSource = value
+	case "SAI_SAFETY_MONITOR":
+		if _, err := safetyMonitorSource(value); err != nil {
+			slog.Warn("Invalid SAI_SAFETY_MONITOR, ignoring it", "error", err)
+		} else {
+			config.SafetyMonitor = value
+		}
+	case "SAI_EXPOSURE_EVENTS":
+		config.ExposureEvents = value
+	case "SAI_WEATHER_GATE":
+		config.WeatherGate = parseYesNo(value)
+	case "SAI_WEATHER_OVERCAST":
+		if percent, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "%")); err == nil && percent > 0 && percent <= 100 {
+			config.WeatherOvercast = percent
+		} else {
+			slog.Warn("Invalid SAI_WEATHER_OVERCAST (1 to 100 percent), using default", "value", value, "default", DEFAULT_WEATHER_OVERCAST)
+		}
+	case "SAI_CALIBRATION":
+		config.Calibration = parseYesNo(value)
+	case "SAI_CALIBRATION_PATTERN":
+		if _, err := regexp.Compile(value); err != nil {
+			slog.Warn("Invalid SAI_CALIBRATION_PATTERN", "value", value, "error", err)
+		} else if value != "" {
+			config.CalibrationPattern = value
+		}
+	case "SAI_CALIBRATION_COUNT":
+		if val, err := strconv.Atoi(value); err == nil && val > 0 {
+			config.CalibrationCount = val
+		}
+	case "SAI_CALIBRATION_SERVER":
+		config.CalibrationServer = value
+	case "SAI_LOG_LEVEL":
+		level, err := parseLogLevel(value)
+		if err != nil {
+			slog.Warn("Invalid SAI_LOG_LEVEL, using info", "value", value)
+		}
+		config.LogLevel = level
+	case "SAI_STATUS_LISTEN":
+		config.StatusListen = value
+	case "SAI_STATUS_TOKEN":
+		config.StatusToken = value
+	case "SAI_STATUS_FILE":
+		config.StatusFile = value
+	case "SAI_STATUS_PPROF":
+		config.StatusPprof = parseYesNo(value)
+	case "SAI_HEARTBEAT_URL":
+		config.HeartbeatURL = value
+	case "SAI_PRE_ARCHIVE_HOOK":
+		config.PreArchiveHook = value
+	case "SAI_PRE_UPLOAD_HOOK":
+		config.PreUploadHook = value
+	case "SAI_UPLOAD_HOOK":
+		config.UploadHook = value
+	case "SAI_UPLOAD_WEBHOOK":
+		config.UploadWebhook = value
+	case "SAI_HOOK_TIMEOUT":
+		if d, err := parseDuration(value); err == nil && d > 0 {
+			config.HookTimeout = d
+		} else {
+			slog.Warn("Invalid SAI_HOOK_TIMEOUT, using default", "value", value, "default", DEFAULT_HOOK_TIMEOUT)
+		}
+	case "SAI_COMMAND_URL":
+		config.CommandURL = value
+	case "SAI_COMMAND_INTERVAL":
+		if d, err := parseDuration(value); err == nil && d >= MIN_INTERVAL*time.Second {
+			config.CommandInterval = d
+		} else {
+			slog.Warn("Invalid SAI_COMMAND_INTERVAL, using default", "value", value, "default", DEFAULT_COMMAND_POLL)
+		}
+	case "SAI_UPDATE_CHECK":
+		config.UpdateCheck = parseYesNo(value)
+	case "SAI_RELEASES_URL":
+		config.ReleasesURL = value
+	case "SAI_UPDATE_PUBKEY":
+		config.UpdatePublicKey = value
+	case "SAI_LOG_FORMAT":
+		format := strings.ToLower(value)
+		if format == "text" || format == "json" {
+			config.LogFormat = format
+		} else {
+			slog.Warn("Invalid SAI_LOG_FORMAT, using text", "value", value)
+		}
+	case "SAI_LANGUAGE":
+		config.Language = value
+	case "SAI_LANGUAGE_FILE":
+		config.LanguageFile = value
+	default:
+		// SAI_FITS_KEY_<KEYWORD>=value adds <KEYWORD> to every frame header
+		if strings.HasPrefix(key, fitsKeyPrefix) {
+			name := strings.ToUpper(strings.TrimPrefix(key, fitsKeyPrefix))
+			if name == "" || len(name) > 8 {
+				slog.Warn("Invalid FITS keyword (1-8 characters required)", "key", key)
+				return
+			}
+			config.FITSKeywords = append(config.FITSKeywords, fitsKeyword{Key: name, Value: value})
+		}
+		// SAI_UPLOAD_FIELD_<NAME>=value sends the form field <name> with every upload
+		if strings.HasPrefix(key, uploadFieldPrefix) {
+			name := strings.ToLower(strings.TrimPrefix(key, uploadFieldPrefix))
+			if name == "" || reservedFormFields[name] {
+				slog.Warn("Invalid upload form field name", "key", key)
+				return
+			}
+			if config.UploadFields == nil {
+				config.UploadFields = make(map[string]string)
+			}
+			config.UploadFields[name] = value
+		}
+		// SAI_UPLOAD_POLICY_<NAME>=DESTINATION setting=value... overrides the
+		// upload settings for one destination
+		if strings.HasPrefix(key, uploadPolicyPrefix) {
+			name := strings.ToLower(strings.TrimPrefix(key, uploadPolicyPrefix))
+			policy, err := parseDestinationPolicy(name, value)
+			if name == "" || err != nil {
+				slog.Warn("Invalid upload policy, ignoring it", "key", key, "value", value, "error", err)
+				return
+			}
+			config.UploadPolicies = slices.DeleteFunc(config.UploadPolicies, func(p destinationPolicy) bool { return p.Name == name })
+			config.UploadPolicies = append(config.UploadPolicies, policy)
+			sort.Slice(config.UploadPolicies, func(i, j int) bool { return config.UploadPolicies[i].Name < config.UploadPolicies[j].Name })
+		}
+	}
+}
+
+// parseDuration interprets a time setting: a Go duration such as "90s", "5m"
+// or "1h30m", or a bare number of seconds as in older config files.
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// parseYesNo interprets a boolean config value ("yes", "true", "1", "on").
+func parseYesNo(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "y", "true", "1", "on":
+		return true
+	}
+	return false
+}
+
+// LoadAreas reads the list of sky areas from areas.txt. A line may mark its
+// area as high priority with the word "priority" after the name.
+func LoadAreas() ([]string, error) {
+	areas, _, err := loadAreas(currentProfile())
+	return areas, err
+}
+
+// loadAreas reads the areas.txt of the named profile and returns the areas
+// and those of them marked as high priority.
+func loadAreas(profile string) (areas, priority []string, err error) {
+	// Look for areas.txt in executable directory first, then current directory
+	areasPath, err := findConfigFile(StationFileName(profile, "areas.txt"))
+	if err != nil && profile != "" {
+		// Profiles observing the same fields can share areas.txt
+		areasPath, err = findConfigFile("areas.txt")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not find areas.txt: %w", err)
+	}
+
+	file, err := os.Open(areasPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open areas.txt: %w", err)
+	}
+	defer file.Close()
+
+	slog.Info("Using areas file", "path", areasPath)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) > 1 && strings.EqualFold(fields[len(fields)-1], "priority") {
+			line = strings.Join(fields[:len(fields)-1], " ")
+			priority = append(priority, line)
+		}
+		areas = append(areas, line)
+	}
+	return areas, priority, scanner.Err()
+}
+
+// priorityArea reports whether area is packed and uploaded before the
+// others, as listed in SAI_PRIORITY_AREAS or marked in areas.txt.
+func (config *Config) priorityArea(area string) bool {
+	if config.SplitSF {
+		area = strings.TrimSuffix(area, sfSuffix)
+	}
+	return slices.Contains(config.PriorityAreas, area)
+}
+
+// fitsExtensionPattern returns a regex fragment matching all supported FITS file extensions.
+const fitsExtensionPattern = `\.(fts|fits|fit)`
+
+// DEFAULT_FILENAME_PATTERN matches frames named AREA_... and AREA-SF_...
+const DEFAULT_FILENAME_PATTERN = `{area}(_|-SF_).*{ext}`
+
+// filenameRegexp expands a SAI_FILENAME_PATTERN template for area: {area} is
+// replaced by the area name and {ext} by the FITS extension pattern, and the
+// result must match the whole file name.
+func filenameRegexp(template, area, extPattern string) (*regexp.Regexp, error) {
+	pattern := strings.NewReplacer("{area}", area, "{ext}", extPattern).Replace(template)
+	return regexp.Compile("^" + pattern + "$")
+}
+
+// prepareDirectories fills in the default camera, processed, results and
+// crash directories (next to the executable) and creates the directories the
+// program writes to. The crash directory is created by the first crash.
+// Relative directories are made absolute, which on Windows lets the os
+// package reach paths longer than MAX_PATH (260 characters).
+func prepareDirectories(config *Config, baseDir string) error {
+	// Set default directories if not specified
+	if config.CameraDirectory == "" {
+		config.CameraDirectory = filepath.Join(baseDir, "data")
+	}
+	if config.ProcessedDirectory == "" {
+		config.ProcessedDirectory = filepath.Join(baseDir, "processed")
+	}
+	if config.ResultsURL != "" && config.ResultsDirectory == "" {
+		config.ResultsDirectory = filepath.Join(baseDir, "results")
+	}
+	if config.CrashDirectory == "" {
+		config.CrashDirectory = filepath.Join(baseDir, "crashes")
+	}
+	for _, dir := range []*string{&config.CameraDirectory, &config.ProcessedDirectory, &config.TempDirectory, &config.ResultsDirectory,
+		&config.UploadedDirectory, &config.QuarantineDirectory, &config.ReportDirectory, &config.CrashDirectory} {
+		if *dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			return fmt.Errorf("could not resolve directory %s: %w", *dir, err)
+		}
+		*dir = abs
+	}
+
+	// Create processed directory if it doesn't exist
+	if err := os.MkdirAll(config.ProcessedDirectory, 0755); err != nil {
+		return fmt.Errorf("could not create processed directory: %w", err)
+	}
+
+	if config.ResultsDirectory != "" {
+		if err := os.MkdirAll(config.ResultsDirectory, 0755); err != nil {
+			return fmt.Errorf("could not create results directory: %w", err)
+		}
+	}
+
+	// Create quarantine directory if quarantine is enabled
+	if config.QuarantineDirectory != "" {
+		if err := os.MkdirAll(config.QuarantineDirectory, 0755); err != nil {
+			return fmt.Errorf("could not create quarantine directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// New creates an uploader configured from config.env and areas.txt. In test
+// mode every error is fatal and the program exits after two minutes without
+// new frames.
+func New(testMode bool) (*AstroCam, error) {
+	return NewStation(currentProfile(), testMode)
+}
+
+// NewStation creates an uploader configured from the files of the named
+// profile (see SetProfile), independent of the profile selected for the
+// process, so that several stations can run in one process.
+func NewStation(profile string, testMode bool) (*AstroCam, error) {
+	if profile != "" && !profileNamePattern.MatchString(profile) {
+		return nil, fmt.Errorf("invalid profile name %q (letters, digits, - and _ only)", profile)
+	}
+	config := loadConfig(profile)
+	areas, priority, err := loadAreas(profile)
+	if err != nil {
+		return nil, err
+	}
+	config.PriorityAreas = append(config.PriorityAreas, priority...)
+	execPath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("could not get executable path: %w", err)
+	}
+	return newAstroCam(config, areas, testMode, filepath.Dir(execPath), profile)
+}
+
+// NewWithConfig creates an uploader from an explicit configuration and area
+// list, for programs that embed astrocam and manage its settings themselves.
+func NewWithConfig(config *Config, areas []string, testMode bool) (*AstroCam, error) {
+	// Determine executable directory (matching Python logic)
+	execPath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("could not get executable path: %w", err)
+	}
+	return newAstroCam(config, areas, testMode, filepath.Dir(execPath), currentProfile())
+}
+
+// newAstroCam creates an uploader keeping its temp directory, and the default
+// camera and processed directories, in baseDir. The temp directory, state
+// database and failed directory carry the name of profile.
+func newAstroCam(config *Config, areas []string, testMode bool, baseDir, profile string) (*AstroCam, error) {
+	logLevel.Set(config.LogLevel)
+	setLanguage(config)
+	SetupLogging(config.LogFormat)
+
+	// Determine archive settings based on config
+	archiver := newArchiver(config)
+
+	// Display mode and archive type information
+	modeStr := "NORMAL OPERATION"
+	if testMode {
+		modeStr = "TEST"
+	}
+
+	slog.Info("ASTROCAM STARTING", "mode", modeStr, "archive_mode", config.ArchiveMode, "archive_format", archiver.String())
+
+	if err := prepareDirectories(config, baseDir); err != nil {
+		return nil, err
+	}
+
+	tempDir := config.TempDirectory
+	if tempDir == "" {
+		tempDir = filepath.Join(baseDir, StationFileName(profile, "temp"))
+	}
+
+	failedDir := filepath.Join(baseDir, StationFileName(profile, "failed"))
+	reviewDir := filepath.Join(baseDir, StationFileName(profile, "needs-review"))
+	if err := prepareUploadedDirectory(config, tempDir, failedDir, reviewDir); err != nil {
+		return nil, err
+	}
+
+	// Create temp directory if it doesn't exist
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create temp directory: %w", err)
+	}
+
+	var state *stateDB
+	if needed := stateDatabaseNeeded(config); config.StateDatabase || needed != "" {
+		if !config.StateDatabase {
+			slog.Warn("SAI_STATE_DATABASE=no is ignored, a setting needs the state database", "setting", needed)
+		}
+		db, err := openStateDB(filepath.Join(baseDir, StationFileName(profile, stateFileName)))
+		if err != nil {
+			return nil, err
+		}
+		state = db
+	}
+
+	currentDir, _ := os.Getwd()
+
+	ac := &AstroCam{
+		config:          config,
+		areas:           areas,
+		profile:         profile,
+		tempDirectory:   tempDir,
+		failedDirectory: failedDir,
+		reviewDirectory: reviewDir,
+		currentDir:      currentDir,
+		throttle:        newUploadThrottle(),
+		weather:         &weatherSensor{},
+		archiver:        archiver,
+		testMode:        testMode,
+		testStartTime:   time.Now(),
+		fs:              OSFS{},
+		clock:           SystemClock{},
+		headerCache:     make(map[string]cachedHeader),
+		uploadFailures:  make(map[string]*uploadFailure),
+		archiveNames:    make(map[string]archiveStamp),
+		staleFrames:     make(map[string]bool),
+		status:          newRuntimeStatus(),
+		scanRequests:    make(chan struct{}, 1),
+		reloadRequests:  make(chan chan error, 1),
+		restart:         make(chan struct{}),
+		pipeline:        newPipeline(),
+		watchdog:        newWatchdog(),
+		state:           state,
+	}
+
+	ac.fitsExtPattern = fitsExtensionPattern
+	ac.resolveStatusVolumes()
+
+	// Archives interrupted while being written are incomplete; their frames
+	// are still in the camera directory and get packed again. The journal is
+	// replayed first, as it may refer to copies of read-only mode there.
+	ac.replayJournal()
+	partialDir := filepath.Join(tempDir, partialDirName)
+	os.RemoveAll(partialDir)
+	if err := os.MkdirAll(partialDir, 0755); err != nil {
+		if ac.state != nil {
+			ac.state.Close()
+		}
+		return nil, fmt.Errorf("could not create temp directory: %w", err)
+	}
+	ac.cleanTempDirectory()
+	ac.pruneUploaded()
+	ac.compactState()
+
+	return ac, nil
+}
+
+// cameraLockFile is kept locked in the camera directory so that two
+// installations (e.g. copies of the executable in different folders) never
+// process the same frames.
+const cameraLockFile = ".astrocam.lock"
+
+// LockCameraDirectory takes the lock in the camera directory, so that a second
+// installation cannot process the same frames. The returned error wraps
+// fs.ErrNotExist if the directory does not exist yet. After a config reload
+// that changed the directory, the lock is moved to the new one.
+func (ac *AstroCam) LockCameraDirectory() error {
+	if ac.config.CameraReadOnly {
+		// Nothing may be written there, not even the lock file
+		return nil
+	}
+	path := filepath.Join(ac.config.CameraDirectory, cameraLockFile)
+	if ac.cameraLock != nil && ac.cameraLockPath == path {
+		return nil
+	}
+	lock, err := AcquireFileLock(path)
+	if err != nil {
+		return err
+	}
+	if ac.cameraLock != nil {
+		ac.cameraLock.Release()
+	}
+	ac.cameraLock, ac.cameraLockPath = lock, path
+	return nil
+}
+
+// Close releases the camera directory lock and closes the state file.
+func (ac *AstroCam) Close() {
+	if ac.cameraLock != nil {
+		ac.cameraLock.Release()
+		ac.cameraLock = nil
+	}
+	if ac.state != nil {
+		ac.state.Close()
+		ac.state = nil
+	}
+}
+
+// fileBrowser matches Python _filebrowser method
+func (ac *AstroCam) fileBrowser(constellation, dir, extPattern string) ([]string, error) {
+	regex, err := filenameRegexp(ac.config.FilenamePattern, constellation, extPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex pattern: %w", err)
+	}
+
+	entries, err := ac.fs.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory %s: %w", dir, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && regex.MatchString(entry.Name()) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return files, nil
+}
+
+// frameHeader returns the (cached) FITS header of a frame.
+func (ac *AstroCam) frameHeader(path string, info os.FileInfo) (map[string]string, error) {
+	ac.headerCacheMu.Lock()
+	cached, ok := ac.headerCache[path]
+	ac.headerCacheMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.header, nil
+	}
+	f, err := ac.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	header, err := readFITSHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	ac.headerCacheMu.Lock()
+	ac.headerCache[path] = cachedHeader{size: info.Size(), modTime: info.ModTime(), header: header}
+	ac.headerCacheMu.Unlock()
+	return header, nil
+}
+
+// forgetHeader drops the cached header of a frame that has left its
+// directory, whatever the grouping mode that cached it.
+func (ac *AstroCam) forgetHeader(path string) {
+	ac.headerCacheMu.Lock()
+	delete(ac.headerCache, path)
+	ac.headerCacheMu.Unlock()
+}
+
+// headerAreaName returns the area a frame belongs to according to its FITS
+// header: the OBJECT keyword, or OBJECT_FILTER in "object-filter" mode.
+func (ac *AstroCam) headerAreaName(header map[string]string) string {
+	name := header["OBJECT"]
+	if name == "" {
+		return ""
+	}
+	if ac.config.GroupBy == "object-filter" && header["FILTER"] != "" {
+		name += "_" + header["FILTER"]
+	}
+	return name
+}
+
+// headerBrowser lists the frames in dir whose FITS header assigns them to the
+// given area. Frames with unreadable headers (e.g. still being written) are
+// skipped and picked up on a later scan.
+func (ac *AstroCam) headerBrowser(area, dir, extPattern string) ([]string, error) {
+	regex, err := regexp.Compile(fmt.Sprintf("(?i)^.*%s$", extPattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex pattern: %w", err)
+	}
+
+	entries, err := ac.fs.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory %s: %w", dir, err)
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || !regex.MatchString(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		seen[path] = true
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		header, err := ac.frameHeader(path, info)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(ac.headerAreaName(header), area) {
+			files = append(files, path)
+		}
+	}
+
+	// Forget headers of frames that have left the directory
+	ac.headerCacheMu.Lock()
+	for path := range ac.headerCache {
+		if filepath.Dir(path) == dir && !seen[path] {
+			delete(ac.headerCache, path)
+		}
+	}
+	ac.headerCacheMu.Unlock()
+
+	return files, nil
+}
+
+// sfSuffix tags the second focus series of an area, frames named AREA-SF_...,
+// which SAI_SPLIT_SF packs into archives of their own named like AREA-SF.
+const sfSuffix = "-SF"
+
+// scanAreas returns the areas the camera directory is grouped into: those of
+// areas.txt and, with SAI_SPLIT_SF, the -SF series of each of them.
+func (ac *AstroCam) scanAreas() []string {
+	if !ac.config.SplitSF || ac.config.GroupBy != "filename" {
+		return ac.areas
+	}
+	areas := make([]string, 0, 2*len(ac.areas))
+	for _, area := range ac.areas {
+		areas = append(areas, area, area+sfSuffix)
+	}
+	return areas
+}
+
+// splitSeries returns the area of areas.txt a scanned area belongs to and
+// whether it is the -SF series of it.
+func (ac *AstroCam) splitSeries(area string) (string, bool) {
+	if !ac.config.SplitSF {
+		return area, false
+	}
+	base, sf := strings.CutSuffix(area, sfSuffix)
+	return base, sf
+}
+
+// areaFiles lists the frames belonging to an area using the configured
+// grouping mode (filename pattern or FITS header keywords).
+func (ac *AstroCam) areaFiles(area string) ([]string, error) {
+	var files []string
+	var err error
+	if ac.config.GroupBy == "object" || ac.config.GroupBy == "object-filter" {
+		files, err = ac.headerBrowser(area, ac.config.CameraDirectory, ac.fitsExtPattern)
+	} else if base, sf := ac.splitSeries(area); ac.config.SplitSF {
+		files, err = ac.fileBrowser(base, ac.config.CameraDirectory, ac.fitsExtPattern)
+		// The main series and the -SF_ series of base go into separate archives
+		series := files[:0]
+		for _, file := range files {
+			if strings.HasPrefix(filepath.Base(file), base+sfSuffix+"_") == sf {
+				series = append(series, file)
+			}
+		}
+		files = series
+	} else {
+		files, err = ac.fileBrowser(area, ac.config.CameraDirectory, ac.fitsExtPattern)
+	}
+	if err != nil {
+		return nil, err
+	}
+	files = ac.newFrames(files)
+	if !ac.config.Calibration {
+		return files, nil
+	}
+
+	// Calibration frames never go into science archives
+	science := files[:0]
+	for _, file := range files {
+		if ac.calibrationType(file) == "" {
+			science = append(science, file)
+		}
+	}
+	return science, nil
+}
+
+// frameSortKey returns the key frames of an area are ordered by: the name part
+// after the area prefix, or DATE-OBS when grouping by FITS header. Headers
+// cached in filename mode, e.g. to tell calibration frames, do not change
+// the order.
+func (ac *AstroCam) frameSortKey(file string) string {
+	if ac.config.GroupBy == "object" || ac.config.GroupBy == "object-filter" {
+		ac.headerCacheMu.Lock()
+		cached, ok := ac.headerCache[file]
+		ac.headerCacheMu.Unlock()
+		if ok && cached.header["DATE-OBS"] != "" {
+			return cached.header["DATE-OBS"] + filepath.Base(file)
+		}
+	}
+	return sortByNamePart(file)
+}
+
+// sortFrames sorts frames by name part (matching Python logic), or by
+// DATE-OBS when frames are grouped by their FITS header.
+func (ac *AstroCam) sortFrames(files []string) {
+	sort.Slice(files, func(i, j int) bool {
+		return ac.frameSortKey(files[i]) < ac.frameSortKey(files[j])
+	})
+}
+
+// sortByNamePart matches Python _sortByNamePart method
+func sortByNamePart(inputFileName string) string {
+	filename := filepath.Base(inputFileName)
+	pos := strings.Index(filename, "_")
+	if pos == -1 {
+		return filename
+	}
+	// Return everything after first underscore, removing extension
+	// Find the last dot and remove from there
+	lastDot := strings.LastIndex(filename, ".")
+	if lastDot == -1 {
+		return filename[pos+1:]
+	}
+	return filename[pos+1 : lastDot]
+}
+
+// sortByArchiveName matches Python _sortByArchiveName method
+func (ac *AstroCam) sortByArchiveName(archiveFileName string) string {
+	filename := filepath.Base(archiveFileName)
+
+	// Remove archive extension (.rar or .zip)
+	pos := strings.LastIndex(filename, ac.archiver.Extension())
+	if pos != -1 {
+		filename = filename[:pos]
+	}
+
+	// Remove postfix if present
+	if ac.config.Postfix != "" {
+		pos = strings.LastIndex(filename, ac.config.Postfix)
+		if pos != -1 {
+			filename = filename[:pos]
+		}
+	}
+
+	// Extract date and time parts
+	pos = strings.Index(filename, "_")
+	if pos == -1 {
+		return filename
+	}
+	strDate := filename[:pos]
+
+	pos = strings.LastIndex(filename, "_")
+	if pos == -1 {
+		return strDate
+	}
+	strTime := filename[pos:]
+
+	// Create sort criteria
+	criteria := strings.ReplaceAll(strings.ReplaceAll(strDate+strTime, "-", ""), "_", "")
+	return criteria
+}
+
+// areaTempDirectory is the subdirectory of the temp directory holding the
+// archives of area.
+func (ac *AstroCam) areaTempDirectory(area string) string {
+	return filepath.Join(ac.tempDirectory, area)
+}
+
+// tempAreaDirectories lists the per-area subdirectories of the temp
+// directory, leaving out those of the program itself.
+func (ac *AstroCam) tempAreaDirectories() ([]string, error) {
+	entries, err := ac.fs.ReadDir(ac.tempDirectory)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != partialDirName && entry.Name() != journalDirName {
+			dirs = append(dirs, filepath.Join(ac.tempDirectory, entry.Name()))
+		}
+	}
+	return dirs, nil
+}
+
+// getArchiveFiles matches Python getArchiveFiles method. It lists the
+// archives in the per-area subdirectories of the temp directory, and those
+// an earlier version left in the temp directory itself, oldest first by name
+// whatever their area.
+func (ac *AstroCam) getArchiveFiles() ([]string, error) {
+	dirs, err := ac.tempAreaDirectories()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error scanning for archive files: %w", err)
+	}
+	var files []string
+	for _, dir := range append([]string{ac.tempDirectory}, dirs...) {
+		entries, err := ac.fs.ReadDir(dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("error scanning for archive files: %w", err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ac.archiver.Extension()) {
+				files = append(files, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
+	// Sort files using the same logic as Python
+	sort.Slice(files, func(i, j int) bool {
+		return ac.sortByArchiveName(files[i]) < ac.sortByArchiveName(files[j])
+	})
+
+	return files, nil
+}
+
+// getImageFiles matches Python _getImageFiles method
+func (ac *AstroCam) getImageFiles(area string) (*FileGroup, error) {
+	files, err := ac.areaFiles(area)
+	if err != nil {
+		return nil, err
+	}
+
+	ac.sortFrames(files)
+
+	// Take up to 'count' files, or the next sequence
+	files, _ = ac.nextGroup(files)
+	maxFiles := len(files)
+
+	if maxFiles == 0 {
+		return &FileGroup{}, nil
+	}
+
+	filesToArchive := make([]string, maxFiles)
+	filesToDelete := make([]string, maxFiles)
+
+	for i := 0; i < maxFiles; i++ {
+		slog.Debug("Processing file", "area", area, "file", files[i])
+		filesToArchive[i] = filepath.Base(files[i]) // ONLY basename for archive!
+
+		// Convert to absolute path for reliable deletion/moving
+		absPath, err := filepath.Abs(files[i])
+		if err != nil {
+			absPath = files[i] // fallback to original if abs fails
+		}
+		filesToDelete[i] = absPath // Absolute path for deletion
+	}
+
+	return &FileGroup{
+		FilesToArchive: filesToArchive,
+		FilesToDelete:  filesToDelete,
+	}, nil
+}
+
+// moveImages matches Python _moveImages method with retry logic
+func (ac *AstroCam) moveImages(files []string) error {
+	const maxRetries = 2
+	const retryDelay = 3 * time.Second
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		allSuccess := true
+		var failedFiles []string
+
+		for _, file := range files {
+			basename := filepath.Base(file)
+			targetPath := filepath.Join(ac.config.ProcessedDirectory, basename)
+
+			// Check if target file already exists
+			if _, err := ac.fs.Stat(targetPath); err == nil {
+				// Target exists, delete source file
+				if err := ac.removeRecorded(file); err != nil {
+					slog.Error("Cannot delete file", "file", filepath.Base(file),
+						"attempt", fmt.Sprintf("%d/%d", attempt, maxRetries), "error", err)
+					failedFiles = append(failedFiles, file)
+					allSuccess = false
+				}
+			} else {
+				// Target doesn't exist, move file
+				if err := ac.moveRecorded(file, targetPath); err != nil {
+					slog.Error("Cannot move file", "file", filepath.Base(file),
+						"attempt", fmt.Sprintf("%d/%d", attempt, maxRetries), "error", err)
+					failedFiles = append(failedFiles, file)
+					allSuccess = false
+				}
+			}
+		}
+
+		if allSuccess {
+			return nil // All files moved successfully
+		}
+
+		// If this was the last attempt, handle failure
+		if attempt == maxRetries {
+			if ac.testMode {
+				// In test mode, exit with error
+				ac.testFatal("Failed to move files", "count", len(failedFiles),
+					"attempts", maxRetries, "files", baseNames(failedFiles))
+			} else {
+				// In normal mode, log error but continue
+				slog.Warn("Failed to move files, files remain in camera directory", "count", len(failedFiles),
+					"attempts", maxRetries, "files", baseNames(failedFiles))
+				slog.Warn("Archive was uploaded successfully. New files with different names will be processed normally.")
+				return nil // Return success to avoid re-uploading archive
+			}
+		}
+
+		// Wait before retry
+		slog.Info("Waiting before retry", "delay", retryDelay)
+		ac.sleep(retryDelay)
+		files = failedFiles // Only retry the files that failed
+	}
+
+	return nil // This should never be reached due to the logic above
+}
+
+// injectKeywords writes SAI_FITS_KEY_* keywords into each frame header.
+// "{version}" in a value expands to the AstroCam-GO version. Failures are
+// reported but do not stop archiving; the frames whose header is not a FITS
+// header are returned with the reason, to be quarantined.
+func (ac *AstroCam) injectKeywords(files []string) map[string]error {
+	if len(ac.config.FITSKeywords) == 0 {
+		return nil
+	}
+
+	keywords := make([]fitsKeyword, len(ac.config.FITSKeywords))
+	for i, kw := range ac.config.FITSKeywords {
+		keywords[i] = fitsKeyword{Key: kw.Key, Value: strings.ReplaceAll(kw.Value, "{version}", softwareVersion())}
+	}
+
+	invalid := make(map[string]error)
+	for _, file := range files {
+		if err := injectFITSKeywords(file, keywords); err != nil {
+			slog.Warn("Cannot write FITS keywords", "file", filepath.Base(file), "error", err)
+			if errors.Is(err, errInvalidHeader) {
+				invalid[file] = err
+			}
+		}
+	}
+	return invalid
+}
+
+// quarantineMinAge protects frames that may still be written by the camera
+// software: a file modified more recently than this is never quarantined.
+const quarantineMinAge = 30 * time.Second
+
+// quarantineFile moves a rejected frame out of the camera directory into the
+// quarantine directory, logging the reason and optionally notifying.
+func (ac *AstroCam) quarantineFile(file, reason string) error {
+	basename := filepath.Base(file)
+	targetPath := filepath.Join(ac.config.QuarantineDirectory, basename)
+
+	// Never overwrite an earlier quarantined frame with the same name
+	if _, err := ac.fs.Stat(targetPath); err == nil {
+		targetPath = filepath.Join(ac.config.QuarantineDirectory,
+			fmt.Sprintf("%s.%s", basename, ac.clock.Now().Format("20060102-150405")))
+	}
+
+	if err := ac.moveRecorded(file, targetPath); err != nil {
+		return fmt.Errorf("cannot quarantine %s: %w", basename, err)
+	}
+
+	slog.Warn("File quarantined", "file", basename, "directory", ac.config.QuarantineDirectory, "reason", reason)
+	if ac.config.QuarantineNotify {
+		ac.notify("frame quarantined",
+			fmt.Sprintf("Frame %s was moved to quarantine directory %s: %s", basename, ac.config.QuarantineDirectory, reason))
+	}
+	return nil
+}
+
+// quarantineInvalidFiles checks every frame of a group and quarantines the
+// ones that are not valid FITS files. It returns the frames quarantined.
+// Frames that are still being written are left alone.
+func (ac *AstroCam) quarantineInvalidFiles(files []string) []string {
+	return ac.quarantineFailing(files, validateFITSFile)
+}
+
+// quarantineFailing quarantines the frames for which check returns an error,
+// the reason, and returns them. Frames that are still being written are left
+// alone.
+func (ac *AstroCam) quarantineFailing(files []string, check func(string) error) []string {
+	if ac.config.QuarantineDirectory == "" {
+		return nil
+	}
+
+	var quarantined []string
+	for _, file := range files {
+		err := check(file)
+		if err == nil {
+			continue
+		}
+		if info, statErr := ac.fs.Stat(file); statErr == nil && ac.since(info.ModTime()) < quarantineMinAge {
+			slog.Warn("File looks invalid but was modified recently, will check again later",
+				"file", filepath.Base(file), "error", err)
+			continue
+		}
+		if qErr := ac.quarantineFile(file, err.Error()); qErr != nil {
+			slog.Error("Quarantine failed", "error", qErr)
+			continue
+		}
+		quarantined = append(quarantined, file)
+	}
+	return quarantined
+}
+
+// PackArea archives the waiting frames of one area (up to SAI_COUNT, fewer
+// if that is all there is) and returns the archive path in the temp
+// directory, where the next run uploads it. It returns "" if the area has no
+// frames.
+func (ac *AstroCam) PackArea(area string) (string, error) {
+	if err := ac.LockCameraDirectory(); err != nil {
+		return "", err
+	}
+	fileGroup, err := ac.getImageFiles(area)
+	if err != nil {
+		return "", err
+	}
+	archiveFile, err := ac.packGroup(area, "", fileGroup, false)
+	if err != nil {
+		return "", err
+	}
+	switch archiveFile {
+	case ERROR:
+		return "", fmt.Errorf("archive creation failed for area %s", area)
+	case EMPTY:
+		return "", nil
+	}
+	return archiveFile, nil
+}
+
+// packGroup archives a group of frames under the given area name, moves the
+// frames to the processed directory and returns the archive path. kind is ""
+// for science frames or "calibration" for dark/bias/flat groups, which skip
+// the science-only quality checks and previews. With stream set the archive
+// is uploaded as it is written if possible, and STREAMED returned.
+func (ac *AstroCam) packGroup(area, kind string, fileGroup *FileGroup, stream bool) (string, error) {
+	if len(fileGroup.FilesToArchive) == 0 {
+		return EMPTY, nil
+	}
+
+	// Wait for files to complete writing (just in case)
+	slog.Info("Found files, waiting 5 seconds for writes to complete",
+		"area", area, "count", len(fileGroup.FilesToArchive))
+	ac.sleep(5 * time.Second)
+
+	// Note the frames for the state database; in read-only mode the rest
+	// works on copies
+	frames, err := ac.collectFrames(area, fileGroup)
+	if err != nil {
+		return ERROR, err
+	}
+	defer frames.cleanup()
+
+	// Move corrupt or truncated frames out of the way. The group is rebuilt
+	// from the remaining frames on the next cycle so archives stay full.
+	if quarantined := ac.quarantineInvalidFiles(fileGroup.FilesToDelete); len(quarantined) > 0 {
+		ac.record(frames, quarantined, outcomeQuarantined, "")
+		slog.Warn("Quarantined invalid files, regrouping on next cycle", "area", area, "count", len(quarantined))
+		return EMPTY, nil
+	}
+
+	// With SAI_SKIP_DUPLICATES, frames whose contents were archived before
+	// (e.g. re-saved by the camera driver after a reconnect) go to the
+	// processed directory unarchived
+	if duplicates := ac.duplicateFrames(frames, fileGroup.FilesToDelete); len(duplicates) > 0 {
+		if err := ac.moveImages(duplicates); err != nil {
+			return ERROR, fmt.Errorf("failed to move duplicate images: %w", err)
+		}
+		ac.record(frames, duplicates, outcomeDuplicate, "")
+		fileGroup.remove(duplicates)
+		if len(fileGroup.FilesToDelete) == 0 {
+			slog.Warn("All frames were duplicates, no archive created", "area", area, "count", len(duplicates))
+			return EMPTY, nil
+		}
+	}
+
+	// Stamp the configured keywords into the frame headers, so they are in the
+	// archived copy as well as in the processed directory
+	if invalid := ac.injectKeywords(fileGroup.FilesToDelete); len(invalid) > 0 {
+		quarantined := ac.quarantineFailing(fileGroup.FilesToDelete, func(file string) error { return invalid[file] })
+		if len(quarantined) > 0 {
+			ac.record(frames, quarantined, outcomeQuarantined, "")
+			slog.Warn("Quarantined invalid files, regrouping on next cycle", "area", area, "count", len(quarantined))
+			return EMPTY, nil
+		}
+	}
+
+	// Science frames taken under an overcast sky, as the cloud sensor
+	// reported it, are not worth their upload
+	weather := ac.frameWeather(fileGroup.FilesToDelete)
+	var overcast []string
+	if kind == "" {
+		overcast = ac.overcastFrames(fileGroup.FilesToDelete, weather)
+	}
+	if len(overcast) > 0 {
+		if err := ac.moveImages(overcast); err != nil {
+			return ERROR, fmt.Errorf("failed to move overcast images: %w", err)
+		}
+		ac.record(frames, overcast, outcomeRejected, "")
+		fileGroup.remove(overcast)
+		if len(fileGroup.FilesToDelete) == 0 {
+			slog.Warn("All frames were taken under an overcast sky, no archive created", "area", area, "count", len(overcast))
+			return EMPTY, nil
+		}
+	}
+
+	var quality map[string]*frameQuality
+	var previews []string
+	// Measure frame quality and render previews while the frames are still
+	// in the camera directory
+	if kind == "" {
+		quality, previews = ac.inspectFrames(fileGroup.FilesToDelete)
+		defer removePreviews(previews)
+	}
+
+	// Frames that look clouded out are moved to the processed directory
+	// without being archived or uploaded
+	keep, rejected := ac.rejectCloudedFrames(fileGroup.FilesToDelete, quality)
+	if len(rejected) > 0 {
+		if err := ac.moveImages(rejected); err != nil {
+			return ERROR, fmt.Errorf("failed to move rejected images: %w", err)
+		}
+		ac.record(frames, rejected, outcomeRejected, "")
+		if len(keep) == 0 {
+			slog.Warn("All frames were rejected, no archive created", "area", area, "count", len(rejected))
+			return EMPTY, nil
+		}
+		fileGroup.remove(rejected)
+	}
+
+	// Site policies: the pre-archive hook may veto frames or stop the archive
+	vetoed, proceed := ac.preArchiveHook(area, fileGroup.FilesToDelete)
+	if !proceed {
+		return EMPTY, nil
+	}
+	if len(vetoed) > 0 {
+		if err := ac.moveImages(vetoed); err != nil {
+			return ERROR, fmt.Errorf("failed to move vetoed images: %w", err)
+		}
+		ac.record(frames, vetoed, outcomeRejected, "")
+		fileGroup.remove(vetoed)
+		if len(fileGroup.FilesToDelete) == 0 {
+			slog.Warn("All frames were vetoed, no archive created", "area", area, "count", len(vetoed))
+			return EMPTY, nil
+		}
+	}
+
+	filesToArchive := fileGroup.FilesToDelete
+	if ac.config.PreviewMode == "archive" {
+		filesToArchive = append(append([]string{}, filesToArchive...), previews...)
+	}
+
+	now := ac.clock.Now()
+	if err := os.MkdirAll(ac.areaTempDirectory(area), 0755); err != nil {
+		return ERROR, fmt.Errorf("could not create temp directory: %w", err)
+	}
+	// YYYY-MM-DD_[PREFIX]AREA_HHMMSS[POSTFIX].ext, numbered if the name is taken
+	archiveFileName := ac.newArchiveName(area, now)
+
+	// Describe the archive contents in a metadata sidecar sent along with it
+	meta := &archiveMeta{Area: area, Kind: kind, Created: now, Frames: fileGroup.FilesToArchive}
+	if len(quality) > 0 {
+		meta.Quality = make(map[string]*frameQuality)
+		for frame, q := range quality {
+			meta.Quality[filepath.Base(frame)] = q
+		}
+	}
+	for _, frame := range fileGroup.FilesToDelete {
+		if reading := weather[frame]; reading != nil {
+			if meta.Weather == nil {
+				meta.Weather = make(map[string]*weatherReading)
+			}
+			meta.Weather[filepath.Base(frame)] = reading
+		}
+	}
+	if err := writeArchiveMeta(archiveFileName, meta); err != nil {
+		slog.Warn("Cannot write archive metadata", "archive", filepath.Base(archiveFileName), "error", err)
+	}
+	records := frames.records(fileGroup.FilesToDelete, outcomeArchived, filepath.Base(archiveFileName), ac.clock.Now())
+
+	var streamErr error
+	if stream {
+		var streamed bool
+		if streamed, streamErr = ac.streamArchive(area, archiveFileName, filesToArchive); streamed {
+			removeArchiveMeta(archiveFileName)
+			return STREAMED, ac.completeGroup(archiveFileName, fileGroup.FilesToDelete, records, previews, true)
+		}
+	}
+
+	// Build the archive in the partial directory and move it next to the
+	// other archives only when complete, so a scan never queues it half-written
+	partialFileName := filepath.Join(ac.tempDirectory, partialDirName, filepath.Base(archiveFileName))
+	if err := ac.createArchive(area, partialFileName, filesToArchive); err != nil {
+		os.Remove(partialFileName)
+		removeArchiveMeta(archiveFileName)
+		return ERROR, err
+	}
+
+	// Journal the rest, so that a crash before the frames have left the
+	// camera directory does not get them archived a second time
+	intent := &packIntent{Archive: archiveFileName, Frames: fileGroup.FilesToDelete, Records: records}
+	if err := ac.beginIntent(intent); err != nil {
+		os.Remove(partialFileName)
+		removeArchiveMeta(archiveFileName)
+		return ERROR, fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := os.Rename(partialFileName, archiveFileName); err != nil {
+		ac.endIntent(archiveFileName)
+		os.Remove(partialFileName)
+		removeArchiveMeta(archiveFileName)
+		return ERROR, fmt.Errorf("failed to move archive to temp directory: %w", err)
+	}
+	// The archive a stream failed to deliver counts that attempt
+	if streamErr != nil {
+		ac.recordUploadFailure(archiveFileName, streamErr)
+	}
+	if err := ac.completeGroup(archiveFileName, fileGroup.FilesToDelete, records, previews, false); err != nil {
+		return ERROR, err
+	}
+	return archiveFileName, nil
+}
+
+// completeGroup finishes a group whose archive is in the temp directory or,
+// if uploaded is set, was streamed to the server: it records the frames,
+// uploads the previews and moves the frames to the processed directory. The
+// journal entry of an archive in the temp directory is written already; that
+// of a streamed archive is written here.
+func (ac *AstroCam) completeGroup(archiveFileName string, frames []string, records []*stateRecord, previews []string, uploaded bool) error {
+	if uploaded {
+		intent := &packIntent{Archive: archiveFileName, Frames: frames, Records: records, Uploaded: true}
+		if err := ac.beginIntent(intent); err != nil {
+			slog.Warn("Cannot write journal", "archive", filepath.Base(archiveFileName), "error", err)
+		}
+	}
+	defer ac.endIntent(archiveFileName)
+	ac.appendFrames(records)
+
+	if ac.config.PreviewMode == "upload" {
+		ac.uploadPreviews(previews)
+	}
+
+	// Move processed images
+	if err := ac.moveImages(frames); err != nil {
+		return fmt.Errorf("failed to move images: %w", err)
+	}
+	return nil
+}
+
+// createArchive creates an archive of the given files, stored under their
+// base names, and tests it.
+func (ac *AstroCam) createArchive(area, archiveFileName string, files []string) error {
+	// Create archive
+	slog.Info("Creating archive", "area", area, "archive", filepath.Base(archiveFileName), "format", ac.archiver)
+
+	if err := ac.archiver.Create(archiveFileName, files); err != nil {
+		if ac.testMode {
+			ac.testFatal("Archive creation failed", "archive", filepath.Base(archiveFileName), "error", err)
+		}
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	// Test archive integrity
+	if err := ac.archiver.Test(archiveFileName); err != nil {
+		slog.Warn("Archive integrity test failed", "archive", filepath.Base(archiveFileName), "error", err)
+		if ac.testMode {
+			ac.testFatal("Archive integrity test failed", "archive", filepath.Base(archiveFileName))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// hasCredentials checks if username and password are provided
+func (ac *AstroCam) hasCredentials() bool {
+	return ac.config.Username != "" && ac.config.Password != ""
+}
+
+// uploaderFor returns the uploader for a destination URL: the one set with
+// SetUploader, or the backend registered for the URL scheme.
+func (ac *AstroCam) uploaderFor(server string) (Uploader, error) {
+	if ac.uploader != nil {
+		return ac.uploader, nil
+	}
+	return NewUploader(server, ac.config)
+}
+
+// SetUploader replaces the uploader chosen from the destination URLs, e.g.
+// with a fake in tests or a custom backend in an embedding program.
+func (ac *AstroCam) SetUploader(uploader Uploader) {
+	ac.uploader = uploader
+}
+
+// RestrictAreas limits processing to the given areas instead of those in
+// areas.txt, also across reloads. Calibration frames are left alone too.
+// Names missing from areas.txt are processed all the same, with a warning.
+func (ac *AstroCam) RestrictAreas(areas []string) {
+	known := make(map[string]bool)
+	for _, area := range ac.areas {
+		known[area] = true
+	}
+	for _, area := range areas {
+		if !known[area] {
+			slog.Warn("Area is not listed in areas.txt", "area", area)
+		}
+	}
+	slog.Info("Processing only the selected areas", "areas", strings.Join(areas, ", "))
+
+	ac.configMu.Lock()
+	defer ac.configMu.Unlock()
+	ac.areaFilter = append([]string{}, areas...)
+	ac.areas = ac.areaFilter
+}
+
+// SetFS replaces the file system used to group frames, move them and find
+// archives, e.g. with an in-memory one in tests. Call it before Run.
+func (ac *AstroCam) SetFS(fsys FS) {
+	ac.fs = fsys
+}
+
+// SetClock replaces the time source used for upload throttling and pauses,
+// file ages and the test mode timeout, e.g. with a fake clock in tests. Call
+// it before Run.
+func (ac *AstroCam) SetClock(clock Clock) {
+	ac.clock = clock
+}
+
+// SetArchiver replaces the archive format chosen from SAI_ARCHIVE_MODE.
+func (ac *AstroCam) SetArchiver(archiver Archiver) {
+	ac.jobsMu.Lock()
+	defer ac.jobsMu.Unlock()
+	ac.configMu.Lock()
+	defer ac.configMu.Unlock()
+	ac.archiver = archiver
+	ac.archiverFixed = true
+}
+
+// uploadFile matches FileUploader functionality with proper resource management
+func (ac *AstroCam) uploadFile(uploader Uploader, filePath, server string) error {
+	slog.Info("Uploading to server", "archive", filepath.Base(filePath), "server", server)
+
+	size, hash := ac.fileDigest(filePath)
+
+	start := ac.clock.Now()
+	err := uploader.Upload(context.Background(), filePath, readArchiveMetaRaw(filePath))
+	ac.recordUploadAttempt(filepath.Base(filePath), server, size, hash, start, err)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Successfully uploaded", "archive", filepath.Base(filePath))
+	return nil
+}
+
+// recordUploadAttempt notes an upload started at start in the state
+// database, and the size of a successful one for the cycle summary. In test
+// mode any failure is fatal, except a server rejection for disk space or
+// load, which pauseUploads reports.
+func (ac *AstroCam) recordUploadAttempt(name, server string, size int64, hash string, start time.Time, err error) {
+	if err == nil {
+		ac.status.uploadedSize(size)
+	}
+	if ac.state != nil {
+		now := ac.clock.Now()
+		if recErr := ac.state.recordUpload(name, server, size, hash, now.Sub(start), err, now); recErr != nil {
+			slog.Warn("Cannot record upload", "archive", name, "error", recErr)
+		}
+	}
+	var rejected *UploadRejectedError
+	if err != nil && ac.testMode && !(errors.As(err, &rejected) && (rejected.StatusCode < 300 || rejected.StatusCode == 507)) {
+		ac.testFatal("Upload failed", "archive", name, "error", err)
+	}
+}
+
+// deleteFile matches Python deleteFile function
+func (ac *AstroCam) deleteFile(filePath string) error {
+	if err := ac.removeRecorded(filePath); err != nil {
+		slog.Error("Cannot delete file", "file", filepath.Base(filePath), "error", err)
+		return fmt.Errorf(ERROR)
+	}
+	return nil
+}
+
+// formatPauseDuration renders a pause length in friendly units
+// ("10 minutes", "1 hour") for log messages.
+func formatPauseDuration(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		h := int(d / time.Hour)
+		if h == 1 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", h)
+	}
+	if d >= time.Minute && d%time.Minute == 0 {
+		m := int(d / time.Minute)
+		if m == 1 {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", m)
+	}
+	return d.String()
+}
+
+// classifyServerError inspects a server UNMW_STATUS:ERROR body (or an upload
+// error string) and returns a human-readable reason and the pause duration to
+// apply: out of disk space pauses for DISK_SPACE_PAUSE, high system load for
+// HIGH_LOAD_PAUSE. Anything else is treated as a transient error and uses the
+// shorter high-load pause so we retry reasonably soon.
+func classifyServerError(body string) (string, time.Duration) {
+	lower := strings.ToLower(body)
+	// An explicit Retry-After wins over the guesses below
+	if m := retryAfterPattern.FindStringSubmatch(lower); m != nil {
+		seconds, _ := strconv.Atoi(m[1])
+		return "Server asked to retry later", min(time.Duration(seconds)*time.Second, MAX_RETRY_AFTER)
+	}
+	if strings.Contains(lower, "status 429") || strings.Contains(lower, "too many requests") {
+		return "Server rate limit reached", RATE_LIMIT_PAUSE
+	}
+	if strings.Contains(lower, "out of disk space") ||
+		strings.Contains(lower, "insufficient storage") ||
+		strings.Contains(lower, "507") {
+		return "Server is out of disk space", DISK_SPACE_PAUSE
+	}
+	if strings.Contains(lower, "load too high") ||
+		strings.Contains(lower, "system load") {
+		return "Server is busy: system load too high", HIGH_LOAD_PAUSE
+	}
+	return "Server reported a temporary error", HIGH_LOAD_PAUSE
+}
+
+// pauseUploads records that uploads must be skipped until a future time and
+// prints an informative message naming the cause and how long the pause lasts.
+// In test mode, a server-side rejection is fatal (exit immediately).
+func (ac *AstroCam) pauseUploads(reason string, duration time.Duration, detail string) {
+	if ac.testMode {
+		ac.testFatal(reason, "response", strings.TrimSpace(detail))
+	}
+	until := ac.clock.Now().Add(duration)
+	ac.pauseMu.Lock()
+	ac.uploadPauseUntil = until
+	ac.pauseMu.Unlock()
+	ac.status.setPausedUntil(until)
+	slog.Warn(fmt.Sprintf("%s. Pausing uploads for %s", reason, formatPauseDuration(duration)),
+		"retry_after", until.Format("15:04:05"), "response", strings.TrimSpace(detail))
+}
+
+// isUploadPaused returns true if the pipeline was paused by the operator or
+// the PAUSE file, uploads by the safety monitor, or we are still within a pause window set after a server-side rejection (high load or
+// out of disk space).
+func (ac *AstroCam) isUploadPaused() bool {
+	if ac.operatorPaused.Load() || ac.pauseFile.Load() || ac.unsafe.Load() {
+		return true
+	}
+	ac.pauseMu.Lock()
+	defer ac.pauseMu.Unlock()
+	if ac.uploadPauseUntil.IsZero() {
+		return false
+	}
+	if ac.clock.Now().After(ac.uploadPauseUntil) {
+		ac.uploadPauseUntil = time.Time{} // Reset
+		return false
+	}
+	return true
+}
+
+// makeJobForArchive matches Python makeJobForArchive function
+func (ac *AstroCam) makeJobForArchive(archiveFile string) {
+	// Skip if we're in a pause period set by an earlier server rejection, or
+	// the upload hours ended while waiting for the throttle
+	if ac.isUploadPaused() || !ac.config.UploadHours.active(ac.clock.Now()) {
+		return
+	}
+	info, err := os.Stat(archiveFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Uploaded and deleted by an earlier job after the scanner listed it
+		slog.Debug("Archive is gone, nothing to upload", "archive", filepath.Base(archiveFile))
+		return
+	}
+	if err == nil {
+		if ac.exceedsUploadLimit(archiveFile, info.Size()) || !ac.reserveUpload(filepath.Base(archiveFile), info.Size()) {
+			return
+		}
+		defer ac.releaseUpload(info.Size())
+	}
+
+	server := ac.archiveServer(archiveFile)
+	if !ac.preUploadHook(archiveFile, server) {
+		return
+	}
+	if !ac.serverReachable(server) {
+		return // Archive stays in temp/ until the network is back
+	}
+	uploader, err := ac.uploaderFor(server)
+	if err != nil {
+		slog.Error("Cannot upload archive", "archive", filepath.Base(archiveFile), "error", err)
+		if ac.testMode {
+			ac.testFatal("No uploader for destination", "server", server, "error", err)
+		}
+		return
+	}
+
+	if !ac.preflight(uploader) {
+		return // Archive stays in temp/ for retry
+	}
+
+	event := ac.newUploadEvent(archiveFile, server)
+	if err := ac.uploadFile(uploader, archiveFile, server); err != nil {
+		slog.Error("Upload error", "archive", filepath.Base(archiveFile), "error", err)
+		defer ac.uploadFinished(event, err)
+		ac.status.uploadFailed(filepath.Base(archiveFile), err)
+		ac.writeStatusFile()
+		// The local archive is kept for retry (uploadFile returns nil only on a
+		// confirmed-successful upload, so it was NOT deleted)
+		ac.pauseForRejection(err)
+		ac.recordUploadFailure(archiveFile, err)
+		return
+	}
+
+	ac.clearUploadFailure(archiveFile)
+	ac.status.uploadSucceeded(filepath.Base(archiveFile))
+	defer ac.uploadFinished(event, nil)
+	defer ac.writeStatusFile()
+	if ac.awaitIngestion(uploader, archiveFile, server) {
+		return // Deleted by pollIngestion once the server confirms it
+	}
+	os.Remove(archiveFile + ackSuffix) // left by an earlier SAI_ACK_URL
+	if ac.keepUploaded(archiveFile) {
+		return
+	}
+	if err := ac.deleteFile(archiveFile); err != nil {
+		slog.Warn("Error deleting file after upload", "archive", filepath.Base(archiveFile), "error", err)
+	}
+	removeArchiveMeta(archiveFile)
+}
+
+// preflight asks the server, if the uploader can, whether it accepts uploads
+// (disk space and system load). It returns false, and pauses the uploads, if
+// it does not.
+func (ac *AstroCam) preflight(uploader Uploader) bool {
+	status, msg := "unknown", ""
+	if preflighter, ok := uploader.(Preflighter); ok {
+		status, msg = preflighter.Preflight(context.Background())
+	}
+	switch status {
+	case "error":
+		reason, pause := classifyServerError(msg)
+		ac.pauseUploads(reason, pause, msg)
+		return false
+	case "warning":
+		slog.Warn("Server disk space warning", "response", msg)
+		// Proceed with upload despite warning
+	case "unknown":
+		// Old server or network issue — proceed with upload normally
+	}
+	return true
+}
+
+// pauseForRejection pauses the uploads if a failed upload says the server is
+// out of disk space, overloaded or rate limiting -- including the POST path
+// where upload.py reports these in an HTTP 200 body -- so we back off
+// instead of hammering the server.
+func (ac *AstroCam) pauseForRejection(err error) {
+	lowerErr := strings.ToLower(err.Error())
+	if strings.Contains(lowerErr, "507") ||
+		strings.Contains(lowerErr, "status 429") ||
+		strings.Contains(lowerErr, "retry after") ||
+		strings.Contains(lowerErr, "out of disk space") ||
+		strings.Contains(lowerErr, "system load") ||
+		strings.Contains(lowerErr, "load too high") {
+		reason, pause := classifyServerError(err.Error())
+		ac.pauseUploads(reason, pause, err.Error())
+	}
+}
+
+// makeJobForArchives queues the archives waiting in the temp directory for
+// upload, such as those kept after a failed upload
+func (ac *AstroCam) makeJobForArchives() {
+	archiveFiles, err := ac.getArchiveFiles()
+	if err != nil {
+		slog.Error("Error scanning archive files", "error", err)
+		return
+	}
+
+	if ac.isUploadPaused() {
+		return
+	}
+	if !ac.withinHours("SAI_UPLOAD_HOURS", ac.config.UploadHours, &ac.uploadHoursShut, "archives wait in the temp directory") {
+		return
+	}
+	ac.checkUploadLimitReset()
+	// Archives whose last upload failed wait for their retry time, and
+	// uploaded ones for the server to confirm their ingestion
+	due := archiveFiles[:0]
+	for _, archiveFile := range archiveFiles {
+		if ac.uploadRetryDue(archiveFile) && !(ac.config.AckURL != "" && awaitingIngestion(archiveFile)) {
+			due = append(due, archiveFile)
+		}
+	}
+	archiveFiles = due
+	if len(archiveFiles) == 0 || !ac.uploadBatchDue(len(archiveFiles)) {
+		return
+	}
+	if !ac.serverReachable(ac.archiveServer(archiveFiles[0])) {
+		return
+	}
+	ac.lastUploadBatch = ac.clock.Now()
+	if ac.config.ProcessOrder == orderNewest {
+		slices.Reverse(archiveFiles)
+	}
+	// Archives of priority areas first
+	priority := make(map[string]bool)
+	if len(ac.config.PriorityAreas) > 0 {
+		for _, archiveFile := range archiveFiles {
+			if meta, err := readArchiveMeta(archiveFile); err == nil && ac.config.priorityArea(meta.Area) {
+				priority[archiveFile] = true
+			}
+		}
+		sort.SliceStable(archiveFiles, func(i, j int) bool { return priority[archiveFiles[i]] && !priority[archiveFiles[j]] })
+	}
+	queued := 0
+	for _, archiveFile := range archiveFiles {
+		if ac.config.MaxArchivesPerScan > 0 && queued >= ac.config.MaxArchivesPerScan {
+			break
+		}
+		if ac.pipeline.enqueueUpload(archiveFile, priority[archiveFile]) {
+			slog.Info("Found existing archive", "archive", filepath.Base(archiveFile))
+			queued++
+		}
+	}
+}
+
+// makeJobForArea groups the frames of an area and queues them for packing.
+// It reports whether a pack job was queued.
+func (ac *AstroCam) makeJobForArea(area string) bool {
+	// Skip if we're in a pause period — don't pack new archives
+	if ac.isUploadPaused() {
+		return false
+	}
+
+	fileGroup, err := ac.getImageFiles(area)
+	if err != nil {
+		slog.Error("Error processing area", "area", area, "error", err)
+		ac.status.recordError(err)
+		return false
+	}
+	if len(fileGroup.FilesToArchive) == 0 {
+		return false
+	}
+	return ac.pipeline.enqueuePack(packJob{area: area, group: fileGroup, priority: ac.config.priorityArea(area)})
+}
+
+// Processing orders of SAI_PROCESS_ORDER.
+const (
+	orderList   = "list"
+	orderOldest = "oldest"
+	orderNewest = "newest"
+)
+
+// areaCandidate is an area with frames to pack at this scan.
+type areaCandidate struct {
+	area           string
+	oldest, newest time.Time // modification times of its waiting frames
+	partial        bool      // fewer than SAI_COUNT frames, flushed
+}
+
+// sortAreaCandidates orders the areas to pack: priority areas first, and
+// within each class by SAI_PROCESS_ORDER, as listed in areas.txt or by their
+// oldest or newest waiting frame.
+func (ac *AstroCam) sortAreaCandidates(candidates []areaCandidate) {
+	switch ac.config.ProcessOrder {
+	case orderOldest:
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].oldest.Before(candidates[j].oldest) })
+	case orderNewest:
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].newest.After(candidates[j].newest) })
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return ac.config.priorityArea(candidates[i].area) && !ac.config.priorityArea(candidates[j].area)
+	})
+}
+
+// makeJobForAreas matches Python makeJobForAreas function
+func (ac *AstroCam) makeJobForAreas() {
+	if !ac.cameraReachable() {
+		// A share that went away is not an idle camera: the counts of the
+		// last scan stay and the scan interval does not back off
+		ac.lastActivity = ac.clock.Now()
+		return
+	}
+
+	hasNewFiles := false
+	areaCounts := make(map[string]int)
+	defer func() {
+		ac.status.setAreaCounts(areaCounts)
+		total := 0
+		for _, n := range areaCounts {
+			total += n
+		}
+		ac.noteActivity(total)
+	}()
+	if err := ac.LockCameraDirectory(); err != nil {
+		slog.Error("Camera directory is in use, not processing it", "error", err)
+		return
+	}
+	// Outside the archive hours the frames are counted but not packed
+	archiving := ac.withinHours("SAI_ARCHIVE_HOURS", ac.config.ArchiveHours, &ac.archiveHoursShut, "frames wait in the camera directory")
+
+	var candidates []areaCandidate
+	for _, area := range ac.scanAreas() {
+		// Check if area has files without processing them
+		files, err := ac.areaFiles(area)
+		if err != nil {
+			// The share may have gone away during the scan
+			if !ac.cameraReachable() {
+				return
+			}
+			continue
+		}
+		areaCounts[area] = len(files)
+		if len(files) == 0 {
+			delete(ac.staleFrames, area)
+		}
+		oldest, newest := ac.frameTimes(files)
+		if !newest.IsZero() {
+			ac.status.frameSeen(area, newest)
+		}
+
+		// Debug output to help troubleshooting
+		if len(files) > 0 {
+			slog.Debug("Area has files", "area", area, "count", len(files), "need", ac.config.Count)
+		}
+
+		ac.sortFrames(files)
+		if group, complete := ac.nextGroup(files); complete {
+			if len(group) < ac.config.Count {
+				slog.Info("Sequence restarted, packing the incomplete sequence", "area", area, "frames", len(group), "need", ac.config.Count)
+			}
+			candidates = append(candidates, areaCandidate{area: area, oldest: oldest, newest: newest})
+		} else if len(files) > 0 && archiving && (ac.checkStaleFrames(area, len(files), oldest) || ac.flushDue(newest)) {
+			candidates = append(candidates, areaCandidate{area: area, oldest: oldest, newest: newest, partial: true})
+		}
+	}
+	if !archiving {
+		return
+	}
+
+	// At most SAI_MAX_ARCHIVES_PER_SCAN groups, in SAI_PROCESS_ORDER
+	ac.sortAreaCandidates(candidates)
+	limit := ac.config.MaxArchivesPerScan
+	queued := 0
+	for _, c := range candidates {
+		if limit > 0 && queued >= limit {
+			slog.Info("Archive limit reached, remaining areas wait for the next scan", "limit", limit)
+			break
+		}
+		if c.partial {
+			slog.Info("Packing incomplete group", "area", c.area, "count", areaCounts[c.area], "need", ac.config.Count)
+		}
+		hasNewFiles = true
+		if ac.makeJobForArea(c.area) {
+			queued++
+		}
+	}
+
+	if (limit == 0 || queued < limit) && ac.config.Calibration && ac.areaFilter == nil && ac.makeJobForCalibration() {
+		hasNewFiles = true
+	}
+
+	// In test mode, track if we've found files yet
+	if ac.testMode && hasNewFiles {
+		ac.testStartTime = ac.clock.Now() // Reset timeout when we find files
+	}
+}
+
+// frameTimes returns the modification times of the oldest and the newest of
+// files, or zero times if none can be read.
+func (ac *AstroCam) frameTimes(files []string) (oldest, newest time.Time) {
+	for _, file := range files {
+		info, err := ac.fs.Stat(file)
+		if err != nil {
+			continue
+		}
+		if oldest.IsZero() || info.ModTime().Before(oldest) {
+			oldest = info.ModTime()
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return oldest, newest
+}
+
+// flushDue reports whether a group with fewer frames than needed, whose newest
+// frame was written at newest, is packed anyway: once no frame has arrived
+// for SAI_FLUSH_AFTER, or once SAI_FLUSH_AT has passed today for frames
+// written before it. Otherwise the last frames of a sequence would wait in
+// the camera directory until the next night completes their group.
+func (ac *AstroCam) flushDue(newest time.Time) bool {
+	if newest.IsZero() {
+		return false
+	}
+	if ac.config.FlushAfter > 0 && ac.since(newest) >= ac.config.FlushAfter {
+		return true
+	}
+	if ac.config.FlushAt >= 0 {
+		now := ac.clock.Now()
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		flushAt := midnight.Add(time.Duration(ac.config.FlushAt) * time.Minute)
+		return !now.Before(flushAt) && newest.Before(flushAt)
+	}
+	return false
+}
+
+// checkTestTimeout checks if test mode should timeout
+func (ac *AstroCam) checkTestTimeout() {
+	if !ac.testMode {
+		return
+	}
+
+	const testTimeout = 2 * time.Minute
+	if ac.since(ac.testStartTime) > testTimeout && ac.pipeline.idle() {
+		slog.Info("Test timeout: no new images found, exiting", "timeout", testTimeout)
+		os.Exit(0) // Success exit - timeout is expected behavior in test mode
+	}
+}
+
+// scanInterval returns the configured scan interval, raised to MIN_INTERVAL.
+func (ac *AstroCam) scanInterval() time.Duration {
+	if ac.intervalOverride > 0 {
+		return ac.intervalOverride
+	}
+	return time.Duration(max(ac.config.Interval, MIN_INTERVAL)) * time.Second
+}
+
+// noteActivity records whether a scan found new frames or work in progress.
+// A change in the number of waiting frames counts as new frames.
+func (ac *AstroCam) noteActivity(frames int) {
+	if frames != ac.waitingFrames || !ac.pipeline.idle() || ac.lastActivity.IsZero() {
+		ac.lastActivity = ac.clock.Now()
+	}
+	ac.waitingFrames = frames
+}
+
+// cycleSummary logs one line on the scan started at start: the areas
+// scanned and the frames waiting in them, and the archives created, uploads
+// and bytes uploaded since the previous summary, which the packer and
+// uploader did meanwhile. A cycle without frames or work is only logged at
+// debug level.
+func (ac *AstroCam) cycleSummary(start time.Time) {
+	totals := ac.status.counts()
+	last := ac.lastCycle
+	ac.lastCycle = totals
+	ac.status.mu.Lock()
+	areas := len(ac.status.areaCounts)
+	ac.status.mu.Unlock()
+
+	archives := totals.archives - last.archives
+	uploads := totals.uploads - last.uploads
+	failed := totals.uploadErrors - last.uploadErrors
+	args := []any{"areas", areas, "frames", ac.waitingFrames, "archives", archives, "uploads", uploads, "failed", failed,
+		"bytes", totals.bytes - last.bytes, "duration", time.Since(start).Round(time.Millisecond)}
+	if ac.waitingFrames == 0 && archives == 0 && uploads == 0 && failed == 0 {
+		slog.Debug("Cycle summary", args...)
+		return
+	}
+	slog.Info("Cycle summary", args...)
+}
+
+// nextScanInterval returns the time until the next scan: the scan interval
+// while frames keep arriving, and once none have appeared for
+// IDLE_BACKOFF_AFTER, twice the current interval up to SAI_IDLE_INTERVAL.
+func (ac *AstroCam) nextScanInterval() time.Duration {
+	base := ac.scanInterval()
+	if ac.config.IdleInterval <= base || ac.since(ac.lastActivity) < IDLE_BACKOFF_AFTER {
+		if ac.scanPeriod > base {
+			slog.Info("New frames, scanning at the normal interval again", "interval", base)
+		}
+		return base
+	}
+	next := min(2*max(ac.scanPeriod, base), ac.config.IdleInterval)
+	if next != ac.scanPeriod {
+		slog.Info("No new frames, scanning less often",
+			"idle_for", ac.since(ac.lastActivity).Round(time.Second), "interval", next)
+	}
+	return next
+}
+
+// adjustScanInterval applies nextScanInterval to the main loop's ticker. With
+// SAI_JITTER every period is drawn anew.
+func (ac *AstroCam) adjustScanInterval(ticker *time.Ticker) {
+	next := ac.nextScanInterval()
+	if next == ac.scanPeriod && ac.config.Jitter == 0 {
+		return
+	}
+	ac.scanPeriod = next
+	ticker.Reset(ac.jitteredScanPeriod())
+}
+
+// jitteredScanPeriod returns the current scan interval varied by SAI_JITTER,
+// never below MIN_INTERVAL.
+func (ac *AstroCam) jitteredScanPeriod() time.Duration {
+	return max(jitter(ac.scanPeriod, ac.config.Jitter), MIN_INTERVAL*time.Second)
+}
+
+// jitter varies d randomly by up to percent either way, so that stations
+// configured alike drift apart instead of scanning and uploading at the same
+// instants.
+func jitter(d time.Duration, percent int) time.Duration {
+	if percent <= 0 || d <= 0 {
+		return d
+	}
+	spread := int64(d) * int64(percent) / 100
+	return d + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
+
+// reload re-reads config.env and areas.txt and applies them. It runs on the
+// main loop between scans. The status server address is only read at
+// startup, so a changed SAI_STATUS_LISTEN is ignored until restart.
+func (ac *AstroCam) reload() error {
+	config := loadConfig(ac.profile)
+	areas, priority, err := loadAreas(ac.profile)
+	if err != nil {
+		return err
+	}
+	config.PriorityAreas = append(config.PriorityAreas, priority...)
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not get executable path: %w", err)
+	}
+	if err := prepareDirectories(config, filepath.Dir(execPath)); err != nil {
+		return err
+	}
+	if err := prepareUploadedDirectory(config, ac.tempDirectory, ac.failedDirectory, ac.reviewDirectory); err != nil {
+		return err
+	}
+	if needed := stateDatabaseNeeded(config); ac.state == nil && needed != "" {
+		return fmt.Errorf("%s needs the state database, which is only opened at startup; restart to use it", needed)
+	}
+	archiver := newArchiver(config)
+	if config.StatusListen != ac.config.StatusListen {
+		slog.Warn("SAI_STATUS_LISTEN changes take effect after a restart")
+		config.StatusListen = ac.config.StatusListen
+	}
+	if config.ExposureEvents != ac.config.ExposureEvents {
+		slog.Warn("SAI_EXPOSURE_EVENTS changes take effect after a restart")
+		config.ExposureEvents = ac.config.ExposureEvents
+	}
+	if config.StatusPprof != ac.config.StatusPprof {
+		slog.Warn("SAI_STATUS_PPROF changes take effect after a restart")
+		config.StatusPprof = ac.config.StatusPprof
+	}
+	if config.TempDirectory != ac.config.TempDirectory {
+		slog.Warn("SAI_TEMP_DIRECTORY changes take effect after a restart")
+		config.TempDirectory = ac.config.TempDirectory
+	}
+
+	// Wait for the pack and upload jobs in progress, which use the config
+	ac.jobsMu.Lock()
+	defer ac.jobsMu.Unlock()
+	if config.Username != ac.config.Username || config.Password != ac.config.Password {
+		// New credentials end a pause after the old ones were rejected
+		ac.pauseMu.Lock()
+		ac.uploadPauseUntil = time.Time{}
+		ac.pauseMu.Unlock()
+		ac.status.setPausedUntil(time.Time{})
+	}
+	ac.configMu.Lock()
+	ac.config = config
+	if ac.areaFilter != nil {
+		areas = ac.areaFilter
+	}
+	ac.areas = areas
+	if ac.archiverFixed {
+		archiver = ac.archiver
+	} else {
+		ac.archiver = archiver
+	}
+	ac.resolveStatusVolumes()
+	ac.configMu.Unlock()
+
+	logLevel.Set(config.LogLevel)
+	setLanguage(config)
+	SetupLogging(config.LogFormat)
+	slog.Info("Configuration reloaded", "areas", len(areas), "archive_format", archiver)
+	return nil
+}
+
+// programLoop matches Python programLoop function
+func (ac *AstroCam) programLoop() {
+	// A crashed scan is reported and the next one starts on time
+	defer ac.recoverPanic("scanner")
+	defer ac.watchdog.begin("scanner")()
+	ac.status.scanStarted()
+	defer ac.writeStatusFile()
+	defer ac.status.scanFinished()
+	defer ac.cycleSummary(time.Now())
+
+	ac.checkPauseFile()
+	ac.pollSafetyMonitor()
+
+	slog.Debug("Scanning temp directory", "path", ac.tempDirectory)
+	ac.pollIngestion()
+	ac.makeJobForArchives()
+
+	ac.pollWeather()
+	ac.pollRoof()
+
+	slog.Debug("Scanning camera directory", "path", ac.config.CameraDirectory)
+	ac.makeJobForAreas()
+
+	ac.checkStaleAreas()
+
+	ac.nightlyReport()
+	ac.compactState()
+
+	ac.pollServerCommands()
+
+	// Tell the external dead-man switch that the loop completed
+	ac.heartbeat()
+
+	// Check test timeout
+	ac.checkTestTimeout()
+}
+
+// Run scans and uploads until stop is closed. The caller decides what stops
+// the uploader: the command closes stop on SIGINT/SIGTERM or a Windows
+// service stop request. Run also returns when a restart is requested (see
+// RestartRequested).
+func (ac *AstroCam) Run(stop <-chan struct{}) {
+	stop = ac.untilRestart(stop)
+	if ac.testMode {
+		slog.Info("ASTROCAM TEST MODE - AUTOMATED TESTING", "test_timeout", "2m")
+	} else {
+		slog.Info("ASTROCAM NORMAL OPERATION - CONTINUOUS MONITORING")
+	}
+
+	// Determine actual interval with minimum enforcement
+	actualInterval := int(ac.scanInterval() / time.Second)
+
+	// Display interval information
+	if ac.config.RequestedInterval != actualInterval {
+		slog.Info("Configuration", "scan_interval_seconds", actualInterval,
+			"requested_seconds", ac.config.RequestedInterval, "minimum_seconds", MIN_INTERVAL)
+	} else {
+		slog.Info("Configuration", "scan_interval_seconds", actualInterval, "minimum_seconds", MIN_INTERVAL)
+	}
+
+	if ac.config.IdleInterval > ac.scanInterval() {
+		slog.Info("Configuration", "idle_scan_interval", ac.config.IdleInterval, "idle_after", IDLE_BACKOFF_AFTER)
+	}
+	if ac.config.Jitter > 0 {
+		slog.Info("Configuration", "jitter_percent", ac.config.Jitter)
+	}
+	if ac.config.UploadInterval > 0 || ac.config.UploadBatch > 0 {
+		slog.Info("Configuration", "upload_interval", ac.uploadBatchInterval(), "upload_batch", ac.config.UploadBatch)
+	}
+	slog.Info("Configuration", "files_per_archive", ac.config.Count)
+	if ac.config.SplitSF {
+		slog.Info("Configuration", "split_sf_series", true)
+	}
+	if ac.config.SequenceKeyword != "" {
+		slog.Info("Configuration", "sequence_keyword", ac.config.SequenceKeyword)
+	} else if ac.config.SequencePattern != "" {
+		slog.Info("Configuration", "sequence_pattern", ac.config.SequencePattern)
+	}
+	if len(ac.config.PriorityAreas) > 0 {
+		slog.Info("Configuration", "priority_areas", strings.Join(ac.config.PriorityAreas, ", "))
+	}
+	if ac.config.ProcessOrder != orderList || ac.config.MaxArchivesPerScan > 0 {
+		slog.Info("Configuration", "process_order", ac.config.ProcessOrder, "max_archives_per_scan", ac.config.MaxArchivesPerScan)
+	}
+	if ac.config.FlushAfter > 0 {
+		slog.Info("Configuration", "flush_incomplete_after", ac.config.FlushAfter)
+	}
+	if ac.config.FlushAt >= 0 {
+		slog.Info("Configuration", "flush_incomplete_at", fmt.Sprintf("%02d:%02d", ac.config.FlushAt/60, ac.config.FlushAt%60))
+	}
+	if len(ac.config.UploadHours) > 0 {
+		slog.Info("Configuration", "upload_hours", ac.config.UploadHours)
+	}
+	if len(ac.config.ArchiveHours) > 0 {
+		slog.Info("Configuration", "archive_hours", ac.config.ArchiveHours)
+	}
+	if ac.config.StaleFrameAfter > 0 {
+		slog.Info("Configuration", "stale_frame_after", ac.config.StaleFrameAfter, "stale_frame_flush", ac.config.StaleFrameFlush)
+	}
+	if ac.state == nil {
+		slog.Info("Configuration", "state_database", "off")
+	} else if ac.config.StateMaxAge != DEFAULT_STATE_MAX_AGE {
+		slog.Info("Configuration", "state_max_age", ac.config.StateMaxAge)
+	}
+	if ac.config.UploadedDirectory != "" {
+		slog.Info("Configuration", "uploaded_directory", ac.config.UploadedDirectory, "uploaded_max_age", ac.config.UploadedMaxAge, "uploaded_max_size", formatDataSize(ac.config.UploadedMaxSize))
+	}
+	if ac.config.TempMaxAge > 0 {
+		slog.Info("Configuration", "temp_max_age", ac.config.TempMaxAge, "temp_cleanup", ac.config.TempCleanup)
+	}
+	if ac.config.StaleAreaAfter > 0 {
+		slog.Info("Configuration", "stale_area_after", ac.config.StaleAreaAfter, "stale_area_hours", ac.config.StaleAreaHours)
+	}
+	slog.Info("Configuration", "camera_directory", ac.config.CameraDirectory)
+	slog.Info("Configuration", "processed_directory", ac.config.ProcessedDirectory)
+	slog.Info("Configuration", "temp_directory", ac.tempDirectory)
+	slog.Info("Configuration", "archive_mode", ac.config.ArchiveMode)
+	if ac.config.ArchiveThreads > 0 {
+		slog.Info("Configuration", "archive_threads", ac.config.ArchiveThreads)
+	}
+
+	slog.Info("Configuration", "archive_format", ac.archiver)
+	slog.Info("Configuration", "fits_extensions", ".fts, .fits, .fit")
+	if ac.config.QualityMinStars > 0 {
+		slog.Info("Configuration", "quality_metrics", "enabled", "min_stars", ac.config.QualityMinStars)
+	} else if ac.config.QualityMetrics {
+		slog.Info("Configuration", "quality_metrics", "enabled")
+	}
+	if ac.config.WeatherSource != "" {
+		slog.Info("Configuration", "weather_source", redactedSource(ac.config.WeatherSource), "weather_gate", ac.config.WeatherGate, "overcast_percent", ac.config.WeatherOvercast)
+	}
+	if ac.config.RoofSource != "" {
+		slog.Info("Configuration", "roof_source", redactedSource(ac.config.RoofSource))
+	}
+	if ac.config.SafetyMonitor != "" {
+		slog.Info("Configuration", "safety_monitor", redactedSource(ac.config.SafetyMonitor))
+	}
+	if ac.config.ExposureEvents != "" {
+		slog.Info("Configuration", "exposure_events", ac.config.ExposureEvents)
+	}
+	if len(ac.config.FITSKeywords) > 0 {
+		var names []string
+		for _, kw := range ac.config.FITSKeywords {
+			names = append(names, kw.Key)
+		}
+		slog.Info("Configuration", "fits_keywords", strings.Join(names, ", "))
+	}
+	if ac.config.CAFile != "" {
+		slog.Info("Configuration", "ca_file", ac.config.CAFile)
+	}
+	if ac.config.TLSInsecure {
+		slog.Warn("SAI_TLS_INSECURE is set: the upload server's certificate is NOT verified, so anyone on the network path can read the password and the uploads. Use it only in an emergency and set SAI_CA_FILE instead")
+	}
+	if ac.config.StationID != "" {
+		slog.Info("Configuration", "station_id", ac.config.StationID)
+	}
+	if len(ac.config.UploadFields) > 0 {
+		var names []string
+		for name := range ac.config.UploadFields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		slog.Info("Configuration", "upload_fields", strings.Join(names, ", "))
+	}
+	if ac.config.UploadBandwidth > 0 {
+		slog.Info("Configuration", "upload_bandwidth_kb", ac.config.UploadBandwidth>>10)
+	}
+	if ac.config.DailyUploadLimit > 0 {
+		slog.Info("Configuration", "daily_upload_limit", formatDataSize(ac.config.DailyUploadLimit), "uploaded_today", formatDataSize(ac.uploadedToday()))
+	}
+	if ac.config.StrictUpload {
+		slog.Info("Configuration", "strict_upload", true, "success_token", ac.config.SuccessToken)
+	}
+	if ac.config.ProbeURL != "" {
+		slog.Info("Configuration", "probe_url", ac.config.ProbeURL, "probe_expect", ac.config.ProbeExpect)
+	}
+	for _, p := range ac.config.UploadPolicies {
+		slog.Info("Configuration", "upload_policy", p.Name, "settings", p.String())
+	}
+	if ac.config.ChecksumSidecar {
+		slog.Info("Configuration", "checksum_sidecar", true)
+	}
+	if ac.config.AckURL != "" {
+		slog.Info("Configuration", "ack_url", ac.config.AckURL, "ack_timeout", ac.config.AckTimeout)
+	}
+	if ac.config.ResultsURL != "" {
+		slog.Info("Configuration", "results_url", ac.config.ResultsURL, "results_directory", ac.config.ResultsDirectory, "results_interval", ac.config.ResultsInterval)
+	}
+	if ac.config.SignMethod != "" {
+		slog.Info("Configuration", "sign_method", ac.config.SignMethod, "sign_key", ac.config.SignKey)
+	}
+	if ac.config.StreamUpload {
+		if reason := streamUnsupported(ac.config, ac.archiver); reason != "" {
+			slog.Warn("SAI_STREAM_UPLOAD is set but archives are written to the temp directory", "reason", reason)
+		} else {
+			slog.Info("Configuration", "stream_upload", true)
+		}
+	}
+	if ac.config.Calibration {
+		calibrationServer := ac.config.CalibrationServer
+		if calibrationServer == "" {
+			calibrationServer = ac.config.Server
+		}
+		slog.Info("Configuration", "calibration_count", ac.config.CalibrationCount, "calibration_server", calibrationServer)
+	}
+	switch ac.config.PreviewMode {
+	case "archive":
+		slog.Info("Configuration", "previews", "archive", "preview_format", ac.config.PreviewFormat, "preview_stretch", ac.config.PreviewStretch)
+	case "upload":
+		slog.Info("Configuration", "previews", "upload", "preview_format", ac.config.PreviewFormat,
+			"preview_stretch", ac.config.PreviewStretch, "preview_url", ac.config.PreviewURL)
+	}
+	switch ac.config.GroupBy {
+	case "object":
+		slog.Info("Configuration", "frame_grouping", "FITS OBJECT keyword")
+	case "object-filter":
+		slog.Info("Configuration", "frame_grouping", "FITS OBJECT and FILTER keywords (AREA_FILTER)")
+	default:
+		slog.Info("Configuration", "frame_grouping", "filename prefix")
+	}
+
+	if ac.hasCredentials() {
+		slog.Info("Configuration", "authentication", ac.config.AuthMethod, "username", ac.config.Username)
+	} else {
+		slog.Info("Configuration", "authentication", "disabled (no credentials provided)")
+	}
+
+	if ac.config.StatusListen != "" {
+		server, err := ac.startStatusServer()
+		if err != nil {
+			slog.Error("Cannot start status server", "address", ac.config.StatusListen, "error", err)
+		} else {
+			defer server.Close()
+		}
+	}
+
+	// Pack and upload in the background; on stop, wait for the jobs in hand
+	pipelineDone := ac.startPipeline(stop)
+	defer pipelineDone.Wait()
+	// A crash of the main loop outside a scan stops the pipeline, before it
+	// is waited for, and restarts the program
+	defer func() {
+		if v := recover(); v != nil {
+			ac.reportCrash("main loop", v, debug.Stack())
+			ac.requestRestart()
+		}
+	}()
+
+	// The background tasks stop when the main loop returns, also to restart,
+	// and are waited for, so that none outlives Close
+	tasksStop := make(chan struct{})
+	var tasks sync.WaitGroup
+	defer tasks.Wait()
+	defer close(tasksStop)
+	startTask := func(task string, run func(stop <-chan struct{})) {
+		tasks.Add(1)
+		go func() {
+			defer tasks.Done()
+			ac.supervise(task, tasksStop, func() { run(tasksStop) })
+		}()
+	}
+
+	// Scan as soon as the camera driver reports the end of an exposure
+	if ac.config.ExposureEvents != "" {
+		if watcher, err := newExposureWatcher(ac.config.ExposureEvents); err != nil {
+			slog.Error("Cannot follow exposures, scanning every SAI_INTERVAL only", "error", err)
+		} else {
+			startTask("exposure watcher", func(stop <-chan struct{}) { ac.watchExposures(stop, watcher) })
+		}
+	}
+
+	// Download the server's processing results of the uploaded archives
+	if ac.state != nil {
+		statePath := ac.state.path
+		startTask("results download", func(stop <-chan struct{}) { ac.syncResults(stop, statePath) })
+	}
+	startTask("update check", ac.checkForUpdates)
+	startTask("watchdog", ac.watchJobs)
+
+	// Use the actual interval (with minimum enforcement)
+	ac.scanPeriod = ac.scanInterval()
+	ticker := time.NewTicker(ac.jitteredScanPeriod())
+	defer ticker.Stop()
+
+	// Run once immediately
+	ac.programLoop()
+
+	// Main loop
+	for {
+		select {
+		case <-ticker.C:
+			ac.programLoop()
+			ac.adjustScanInterval(ticker)
+		case <-ac.scanRequests:
+			slog.Info("Immediate scan requested")
+			ac.programLoop()
+			ac.adjustScanInterval(ticker)
+		case reply := <-ac.reloadRequests:
+			err := ac.reload()
+			if err == nil {
+				ac.scanPeriod = ac.scanInterval()
+				ticker.Reset(ac.jitteredScanPeriod())
+			}
+			reply <- err
+		case <-ac.restart:
+			slog.Info("Stopping to restart")
+			return
+		case <-stop:
+			return
+		}
+	}
+}
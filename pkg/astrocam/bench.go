@@ -0,0 +1,177 @@
+package astrocam
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DEFAULT_BENCH_UPLINK is the upload speed, in bytes per second, assumed by
+// the bench command when no uploads are recorded and none is given.
+const DEFAULT_BENCH_UPLINK = 1e6
+
+// benchResult is the measurement of one archive format.
+type benchResult struct {
+	mode     string // SAI_ARCHIVE_MODE selecting the format
+	external bool   // written by another program, whose CPU time may not be measurable
+	size     int64
+	elapsed  time.Duration // creating and testing the archive, as the packer does
+	cpu      time.Duration
+	err      error
+}
+
+// BenchArchivers packs up to frames FITS files from dir with every archive
+// format available on this machine, measuring size, time and CPU, and writes
+// a table and a recommended SAI_ARCHIVE_MODE to w. uplink is the upload
+// speed in bytes per second; 0 takes the average of the uploads recorded in
+// the state database.
+func BenchArchivers(config *Config, dir string, frames int, uplink float64, w io.Writer) error {
+	files, err := benchFrames(dir, frames)
+	if err != nil {
+		return err
+	}
+	var input int64
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			input += info.Size()
+		}
+	}
+
+	uplinkSource := "given"
+	if uplink <= 0 {
+		uplink, uplinkSource = recordedUplink()
+	}
+
+	tmp, err := os.MkdirTemp("", "astrocam-bench-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	archivers := map[string]Archiver{
+		"zip":              &ZipArchiver{Compress: true, Threads: config.ArchiveThreads},
+		"zip-uncompressed": &ZipArchiver{Compress: false},
+	}
+	modes := []string{"zip", "zip-uncompressed"}
+	if rarPath, ok := findRARExecutable(); ok {
+		archivers["rar"] = &RARArchiver{Path: rarPath, Timeout: config.RARTimeout}
+		modes = append([]string{"rar"}, modes...)
+	}
+
+	fmt.Fprintf(w, "Packing %d frames (%.1f MB) from %s\n\n", len(files), float64(input)/1e6, dir)
+	var results []benchResult
+	for _, mode := range modes {
+		results = append(results, benchArchiver(mode, archivers[mode], files, tmp))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "SAI_ARCHIVE_MODE\tSize MB\tRatio\tPack s\tCPU s\tUpload s\t")
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(tw, "%s\tfailed: %v\t\t\t\t\t\n", r.mode, r.err)
+			continue
+		}
+		cpu := fmt.Sprintf("%.2f", r.cpu.Seconds())
+		if r.external && !childCPUCounted {
+			cpu = "n/a"
+		}
+		fmt.Fprintf(tw, "%s\t%.1f\t%.2f\t%.2f\t%s\t%.1f\t\n", r.mode, float64(r.size)/1e6,
+			float64(r.size)/float64(max(input, 1)), r.elapsed.Seconds(), cpu, float64(r.size)/uplink)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nUpload speed: %.2f MB/s (%s)\n", uplink/1e6, uplinkSource)
+	best := recommendArchiveMode(results, uplink)
+	if best == nil {
+		return errors.New("no archive format worked")
+	}
+	fmt.Fprintf(w, "Recommended:  SAI_ARCHIVE_MODE=%s (packing overlaps uploading, so the slower of the two sets the pace)\n", best.mode)
+	fmt.Fprintf(w, "Currently:    SAI_ARCHIVE_MODE=%s (%s)\n", config.ArchiveMode, newArchiver(config))
+	return nil
+}
+
+// benchFrames returns up to count FITS files of dir, by name.
+func benchFrames(dir string, count int) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	fits := regexp.MustCompile(`(?i)` + fitsExtensionPattern + `$`)
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && fits.MatchString(entry.Name()) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no FITS files in %s", dir)
+	}
+	sort.Strings(files)
+	if count > 0 && len(files) > count {
+		files = files[:count]
+	}
+	return files, nil
+}
+
+// benchArchiver creates and tests one archive of files in dir.
+func benchArchiver(mode string, archiver Archiver, files []string, dir string) benchResult {
+	_, external := archiver.(*RARArchiver)
+	r := benchResult{mode: mode, external: external}
+	path := filepath.Join(dir, "bench-"+strings.ReplaceAll(mode, "-", "")+archiver.Extension())
+	cpuStart := processCPUTime()
+	start := time.Now()
+	r.err = archiver.Create(path, files)
+	if r.err == nil {
+		r.err = archiver.Test(path)
+	}
+	r.elapsed = time.Since(start)
+	r.cpu = processCPUTime() - cpuStart
+	if info, err := os.Stat(path); err == nil {
+		r.size = info.Size()
+	}
+	os.Remove(path)
+	return r
+}
+
+// recommendArchiveMode returns the result whose group is done soonest, given
+// that the next group is packed while this one uploads.
+func recommendArchiveMode(results []benchResult, uplink float64) *benchResult {
+	var best *benchResult
+	var bestTime float64
+	for i := range results {
+		r := &results[i]
+		if r.err != nil {
+			continue
+		}
+		t := max(r.elapsed.Seconds(), float64(r.size)/uplink)
+		// Within 5% counts as a tie, won by the smaller archive
+		if best == nil || t < bestTime*0.95 || (t <= bestTime*1.05 && r.size < best.size) {
+			best, bestTime = r, t
+		}
+	}
+	return best
+}
+
+// recordedUplink returns the average speed of the successful uploads in the
+// state database, or DEFAULT_BENCH_UPLINK if there are none.
+func recordedUplink() (float64, string) {
+	var bytes int64
+	var seconds float64
+	readStateRecords(StateFilePath(), func(rec *stateRecord) {
+		if rec.Type == recordUpload && rec.Outcome == outcomeUploaded && rec.Duration > 0 {
+			bytes += rec.Size
+			seconds += rec.Duration
+		}
+	})
+	if bytes == 0 || seconds == 0 {
+		return DEFAULT_BENCH_UPLINK, "assumed, no uploads recorded; set it with -uplink"
+	}
+	return float64(bytes) / seconds, "average of the recorded uploads"
+}
@@ -0,0 +1,154 @@
+package astrocam
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Calibration frame defaults
+const (
+	DEFAULT_CALIBRATION_PATTERN = `(?i)^(dark|bias|zero|flat)`
+	DEFAULT_CALIBRATION_COUNT   = 10
+)
+
+// calibrationKinds lists the calibration frame types in processing order.
+var calibrationKinds = []string{"bias", "dark", "flat"}
+
+// classifyCalibration maps an IMAGETYP value or a filename to a calibration
+// frame type ("bias", "dark" or "flat"), or "" for anything else.
+func classifyCalibration(s string) string {
+	lower := strings.ToLower(s)
+	switch {
+	case strings.Contains(lower, "bias"), strings.Contains(lower, "zero"):
+		return "bias"
+	case strings.Contains(lower, "dark"):
+		return "dark"
+	case strings.Contains(lower, "flat"):
+		return "flat"
+	}
+	return ""
+}
+
+// calibrationType returns the calibration type of a frame, judged by its FITS
+// IMAGETYP keyword when readable, otherwise by SAI_CALIBRATION_PATTERN
+// matching the filename. Science frames return "".
+func (ac *AstroCam) calibrationType(path string) string {
+	if info, err := ac.fs.Stat(path); err == nil {
+		if header, err := ac.frameHeader(path, info); err == nil {
+			if imageType, ok := header["IMAGETYP"]; ok {
+				return classifyCalibration(imageType)
+			}
+		}
+	}
+
+	name := filepath.Base(path)
+	if regex, err := regexp.Compile(ac.config.CalibrationPattern); err == nil && regex.MatchString(name) {
+		if kind := classifyCalibration(regex.FindString(name)); kind != "" {
+			return kind
+		}
+		return classifyCalibration(name)
+	}
+	return ""
+}
+
+// calibrationFiles lists the calibration frames in the camera directory by type.
+func (ac *AstroCam) calibrationFiles() (map[string][]string, error) {
+	regex, err := regexp.Compile(fmt.Sprintf("(?i)^.*%s$", ac.fitsExtPattern))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ac.fs.ReadDir(ac.config.CameraDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	byKind := make(map[string][]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !regex.MatchString(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(ac.config.CameraDirectory, entry.Name())
+		if kind := ac.calibrationType(path); kind != "" {
+			byKind[kind] = append(byKind[kind], path)
+		}
+	}
+	for kind, files := range byKind {
+		byKind[kind] = ac.newFrames(files)
+	}
+	return byKind, nil
+}
+
+// makeJobForCalibration queues calibration frames for packing, one archive
+// per type once SAI_CALIBRATION_COUNT frames of that type have accumulated,
+// or fewer when SAI_FLUSH_AFTER or SAI_FLUSH_AT make the group due.
+// Archives are named like science archives with CALIB-<TYPE> as the area.
+// Returns true if any calibration group was found.
+func (ac *AstroCam) makeJobForCalibration() bool {
+	if ac.isUploadPaused() {
+		return false
+	}
+
+	byKind, err := ac.calibrationFiles()
+	if err != nil {
+		slog.Error("Error scanning calibration frames", "error", err)
+		return false
+	}
+
+	found := false
+	for _, kind := range calibrationKinds {
+		files := byKind[kind]
+		if len(files) == 0 {
+			continue
+		}
+		slog.Info("Calibration frames found", "type", kind, "count", len(files), "need", ac.config.CalibrationCount)
+		if len(files) < ac.config.CalibrationCount {
+			if _, newest := ac.frameTimes(files); !ac.flushDue(newest) {
+				continue
+			}
+		}
+
+		sort.Strings(files)
+		fileGroup := &FileGroup{}
+		for _, file := range files[:min(len(files), ac.config.CalibrationCount)] {
+			absPath, err := filepath.Abs(file)
+			if err != nil {
+				absPath = file
+			}
+			fileGroup.FilesToArchive = append(fileGroup.FilesToArchive, filepath.Base(file))
+			fileGroup.FilesToDelete = append(fileGroup.FilesToDelete, absPath)
+		}
+
+		name := "CALIB-" + strings.ToUpper(kind)
+		ac.pipeline.enqueuePack(packJob{area: name, kind: "calibration", group: fileGroup})
+		found = true
+	}
+	return found
+}
+
+// archiveServer returns the upload URL for an archive: calibration archives go
+// to SAI_CALIBRATION_SERVER when set, everything else to SAI_SERVER.
+func (ac *AstroCam) archiveServer(archiveFile string) string {
+	return archiveDestination(ac.config, archiveFile)
+}
+
+func archiveDestination(config *Config, archiveFile string) string {
+	if config.CalibrationServer != "" {
+		if meta, err := readArchiveMeta(archiveFile); err == nil {
+			return kindDestination(config, meta.Kind)
+		}
+	}
+	return config.Server
+}
+
+// kindDestination returns the upload URL for archives of a kind, "" for
+// science frames or "calibration".
+func kindDestination(config *Config, kind string) string {
+	if kind == "calibration" && config.CalibrationServer != "" {
+		return config.CalibrationServer
+	}
+	return config.Server
+}
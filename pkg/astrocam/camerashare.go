@@ -0,0 +1,83 @@
+package astrocam
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"time"
+)
+
+// cameraReconnectInterval is the shortest time between two runs of
+// SAI_CAMERA_RECONNECT.
+const cameraReconnectInterval = 5 * time.Minute
+
+// cameraReachable checks, before the camera directory is scanned, that it
+// can be listed and, with SAI_CAMERA_MOUNT, that it is a mount point, so that
+// a network share that went away (or a mount point left empty by it) is not
+// taken for an idle camera. While it is unreachable SAI_CAMERA_RECONNECT is
+// run every cameraReconnectInterval. The change is logged and sent to
+// SAI_NOTIFY_URL when the directory is lost and when it is back.
+func (ac *AstroCam) cameraReachable() bool {
+	err := ac.checkCameraDirectory()
+	if err != nil && ac.config.CameraReconnect != "" &&
+		(ac.lastCameraReconnect.IsZero() || ac.since(ac.lastCameraReconnect) >= cameraReconnectInterval) {
+		ac.lastCameraReconnect = ac.clock.Now()
+		slog.Info("Reconnecting the camera directory", "command", ac.config.CameraReconnect, "error", err)
+		env := []string{"ASTROCAM_HOOK=camera-reconnect"}
+		if _, hookErr := runHook(ac.config.CameraReconnect, []string{ac.config.CameraDirectory}, env, ac.config.HookTimeout); hookErr != nil {
+			slog.Warn("Camera reconnect command failed", "command", ac.config.CameraReconnect, "error", hookErr)
+		} else {
+			err = ac.checkCameraDirectory()
+		}
+	}
+
+	reachable := err == nil
+	if ac.cameraUnreachable.Swap(!reachable) == reachable {
+		if reachable {
+			slog.Info("Camera directory reachable again", "path", ac.config.CameraDirectory)
+			ac.notify("camera directory back", fmt.Sprintf("The camera directory %s can be read again.", ac.config.CameraDirectory))
+		} else {
+			slog.Error("Camera directory unreachable, frames wait until it is back", "path", ac.config.CameraDirectory, "error", err)
+			ac.status.recordError(err)
+			ac.notify("camera directory unreachable", fmt.Sprintf("The camera directory %s cannot be read: %v\nNo frames are archived until it is back.", ac.config.CameraDirectory, err))
+		}
+	} else if !reachable {
+		slog.Warn("Camera directory still unreachable", "path", ac.config.CameraDirectory, "error", err)
+	}
+	return reachable
+}
+
+// checkCameraDirectory returns why the camera directory cannot be scanned,
+// or nil. Reading one entry tells a dropped share, whose directory can still
+// be stat'ed from a cache, from a reachable one.
+func (ac *AstroCam) checkCameraDirectory() error {
+	dir := ac.config.CameraDirectory
+	info, err := ac.fs.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if ac.config.CameraMount {
+		mounted, err := isMountPoint(dir)
+		if err != nil {
+			return err
+		}
+		if !mounted {
+			return fmt.Errorf("%s is not mounted (SAI_CAMERA_MOUNT)", dir)
+		}
+	}
+	f, err := ac.fs.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if d, ok := f.(fs.ReadDirFile); ok {
+		if _, err := d.ReadDir(1); err != nil && err != io.EOF {
+			return fmt.Errorf("could not read directory %s: %w", dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,693 @@
+package astrocam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ConfigFinding is one result of CheckConfig.
+type ConfigFinding struct {
+	Level   string // "ok", "warning" or "error"
+	Setting string // config key or file the finding is about
+	Message string
+}
+
+// configCheck collects the findings of CheckConfig.
+type configCheck struct {
+	findings []ConfigFinding
+}
+
+func (c *configCheck) ok(setting, format string, args ...any) {
+	c.findings = append(c.findings, ConfigFinding{"ok", setting, fmt.Sprintf(format, args...)})
+}
+
+func (c *configCheck) warn(setting, format string, args ...any) {
+	c.findings = append(c.findings, ConfigFinding{"warning", setting, fmt.Sprintf(format, args...)})
+}
+
+func (c *configCheck) fail(setting, format string, args ...any) {
+	c.findings = append(c.findings, ConfigFinding{"error", setting, fmt.Sprintf(format, args...)})
+}
+
+// CheckConfig validates a configuration as loaded from config.env and
+// areas.txt: upload URLs, directories, the archive tool, the area list and
+// settings that contradict each other. With contactServer set the upload
+// servers are also asked whether they accept uploads. Problems a run would
+// only hit at the first upload or scan are reported as errors.
+func CheckConfig(ctx context.Context, config *Config, areas []string, contactServer bool) []ConfigFinding {
+	c := &configCheck{}
+
+	if path, err := findConfigFile(ProfileFileName("config.env")); err != nil {
+		c.warn("config.env", "%v; only environment variables and command-line settings are used", err)
+	} else {
+		c.ok("config.env", "using %s", path)
+	}
+
+	// Upload destinations
+	if config.Server == "" {
+		c.fail("SAI_SERVER", "not set; archives cannot be uploaded")
+	} else {
+		c.checkUploadURL(ctx, "SAI_SERVER", config.Server, config, contactServer)
+	}
+	if config.CalibrationServer != "" {
+		if !config.Calibration {
+			c.warn("SAI_CALIBRATION_SERVER", "set but SAI_CALIBRATION is off, so it is not used")
+		} else if config.CalibrationServer != config.Server {
+			c.checkUploadURL(ctx, "SAI_CALIBRATION_SERVER", config.CalibrationServer, config, contactServer)
+		}
+	}
+	for _, p := range config.UploadPolicies {
+		setting := uploadPolicyPrefix + strings.ToUpper(p.Name)
+		switch {
+		case config.Server != "" && p.matches(config.Server):
+			c.ok(setting, "applies to SAI_SERVER: %s", p)
+		case config.CalibrationServer != "" && p.matches(config.CalibrationServer):
+			c.ok(setting, "applies to SAI_CALIBRATION_SERVER: %s", p)
+		default:
+			c.warn(setting, "%s matches neither SAI_SERVER nor SAI_CALIBRATION_SERVER, so it is not used", p.Destination)
+		}
+	}
+	if config.PasswordFile != "" || config.PasswordKeyring {
+		setting := "SAI_PASSWORD_FILE"
+		if config.PasswordFile == "" {
+			setting = "SAI_PASSWORD_KEYRING"
+		}
+		if _, err := config.readPassword(); err != nil {
+			c.fail(setting, "cannot read the password: %v", err)
+		} else {
+			c.ok(setting, "password found")
+		}
+	}
+	if _, err := config.tlsConfig(); err != nil {
+		c.fail("SAI_CA_FILE", "%v", err)
+	} else if config.CAFile != "" {
+		c.ok("SAI_CA_FILE", "certificates of %s trusted for the upload server", config.CAFile)
+	}
+	if signer := config.archiveSigner(); signer != nil {
+		c.checkSigner(ctx, signer)
+	}
+	if config.LanguageFile != "" {
+		if n, err := checkLanguageFile(config.LanguageFile); err != nil {
+			c.fail("SAI_LANGUAGE_FILE", "%v", err)
+		} else {
+			c.ok("SAI_LANGUAGE_FILE", "%d translations", n)
+		}
+	}
+	if config.TLSInsecure {
+		c.warn("SAI_TLS_INSECURE", "the upload server's certificate is not verified; the password and uploads can be intercepted")
+	}
+	if config.WatchdogTimeout > 0 {
+		longest := config.UploadTimeout
+		for _, p := range config.UploadPolicies {
+			longest = max(longest, p.Timeout)
+		}
+		if config.WatchdogTimeout <= longest {
+			c.warn("SAI_WATCHDOG_TIMEOUT", "%s is not longer than the upload timeout %s, so slow uploads are reported as hung", config.WatchdogTimeout, longest)
+		}
+	}
+	if (config.Username == "") != (config.Password == "") {
+		c.warn("SAI_USERNAME", "only one of SAI_USERNAME and SAI_PASSWORD is set; uploads are sent without authentication")
+	}
+	for _, setting := range []struct{ key, value string }{
+		{"SAI_PREVIEW_URL", config.PreviewURL},
+		{"SAI_NOTIFY_URL", config.NotifyURL},
+		{"SAI_HEARTBEAT_URL", config.HeartbeatURL},
+		{"SAI_COMMAND_URL", config.CommandURL},
+		{"SAI_UPLOAD_WEBHOOK", config.UploadWebhook},
+		{"SAI_PROBE_URL", config.ProbeURL},
+		{"SAI_ACK_URL", config.AckURL},
+		{"SAI_RESULTS_URL", config.ResultsURL},
+		{"SAI_RELEASES_URL", config.ReleasesURL},
+		{"SAI_CRASH_URL", config.CrashURL},
+	} {
+		if setting.value != "" {
+			c.checkHTTPURL(setting.key, setting.value)
+		}
+	}
+	if config.StrictUpload {
+		c.ok("SAI_STRICT_UPLOAD", "archives are deleted only after the server confirms the upload; unconfirmed ones go to %s", ReviewDirectory())
+	}
+	if config.ProbeExpect != "" && config.ProbeURL == "" {
+		c.warn("SAI_PROBE_EXPECT", "has no effect without SAI_PROBE_URL")
+	}
+	if config.ProbeURL == "" && strings.HasPrefix(strings.ToLower(config.Server), "http://") {
+		c.warn("SAI_PROBE_URL", "not set, so a captive portal answering the plain http upload server with a page of its own is taken for the server; set it to a URL with a known answer")
+	}
+	if contactServer && config.Server != "" {
+		var captive *captivePortalError
+		switch err := probeServer(config, config.Server); {
+		case errors.As(err, &captive):
+			c.fail("SAI_PROBE_URL", "%v; uploads wait until the network reaches the server", err)
+		case err != nil && config.ProbeURL != "":
+			c.fail("SAI_PROBE_URL", "%v", err)
+		case err == nil && config.ProbeURL != "":
+			c.ok("SAI_PROBE_URL", "%s answers as expected, so captive portals are detected before uploading", config.ProbeURL)
+		}
+	}
+	if config.AckURL != "" {
+		if !strings.Contains(config.AckURL, "{id}") && !strings.Contains(config.AckURL, "{name}") {
+			c.warn("SAI_ACK_URL", "has neither {id} nor {name}, so every archive is asked about at the same URL")
+		} else {
+			c.ok("SAI_ACK_URL", "archives are kept until the server confirms their ingestion, at most %s", config.AckTimeout)
+		}
+	}
+	if config.ResultsURL != "" {
+		if !strings.Contains(config.ResultsURL, "{name}") {
+			c.warn("SAI_RESULTS_URL", "has no {name}, so the results of every archive are asked for at the same URL")
+		} else {
+			resultsDir := config.ResultsDirectory
+			if resultsDir == "" {
+				resultsDir = "results"
+			}
+			c.ok("SAI_RESULTS_URL", "results of the uploaded archives go to %s every %s", resultsDir, config.ResultsInterval)
+		}
+	}
+	if key, err := updatePublicKey(config); err != nil {
+		c.fail("SAI_UPDATE_PUBKEY", "%v", err)
+	} else if key == nil {
+		c.warn("SAI_UPDATE_PUBKEY", "this build has no release signing key, so updates are checked against checksums.txt only; set it to require a signature")
+	} else if config.UpdatePublicKey != "" {
+		c.ok("SAI_UPDATE_PUBKEY", "updates must be signed with key %s", key.ID())
+	}
+	if config.WeatherSource != "" {
+		c.checkSensor(ctx, "SAI_WEATHER_SOURCE", config.WeatherSource, config, contactServer, func(data []byte, at time.Time) (string, error) {
+			reading, err := parseWeather(data, at, config.WeatherOvercast)
+			return reading.String(), err
+		})
+	} else if config.WeatherGate {
+		c.warn("SAI_WEATHER_GATE", "set but SAI_WEATHER_SOURCE is not, so no frames are skipped")
+	}
+	if config.RoofSource != "" {
+		c.checkSensor(ctx, "SAI_ROOF_SOURCE", config.RoofSource, config, contactServer, func(data []byte, _ time.Time) (string, error) {
+			state, err := parseRoofState(data)
+			return "the roof " + state, err
+		})
+	}
+	if config.SafetyMonitor != "" {
+		source, _ := safetyMonitorSource(config.SafetyMonitor)
+		c.checkSensor(ctx, "SAI_SAFETY_MONITOR", source, config, contactServer, func(data []byte, _ time.Time) (string, error) {
+			safe, err := parseSafety(data)
+			if !safe {
+				return "the observatory unsafe, packing and uploads would pause", err
+			}
+			return "the observatory safe", err
+		})
+	}
+	if config.ExposureEvents != "" {
+		c.checkExposureEvents(config.ExposureEvents, contactServer)
+	}
+	for _, hook := range []struct{ key, command, when string }{
+		{"SAI_PRE_ARCHIVE_HOOK", config.PreArchiveHook, "before archiving"},
+		{"SAI_PRE_UPLOAD_HOOK", config.PreUploadHook, "before every upload"},
+		{"SAI_UPLOAD_HOOK", config.UploadHook, "after every upload"},
+	} {
+		if hook.command == "" {
+			continue
+		}
+		if path, err := exec.LookPath(hook.command); err != nil {
+			c.fail(hook.key, "%v", err)
+		} else {
+			c.ok(hook.key, "runs %s %s", path, hook.when)
+		}
+	}
+
+	// Directories
+	baseDir := "."
+	if execPath, err := os.Executable(); err == nil {
+		baseDir = filepath.Dir(execPath)
+	}
+	cameraDir := config.CameraDirectory
+	if cameraDir == "" {
+		cameraDir = filepath.Join(baseDir, "data")
+	}
+	processedDir := config.ProcessedDirectory
+	if processedDir == "" {
+		processedDir = filepath.Join(baseDir, "processed")
+	}
+	if info, err := os.Stat(cameraDir); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			c.warn("SAI_CAMERA_DIRECTORY", "%s does not exist (yet); nothing is uploaded until it appears", cameraDir)
+		} else {
+			c.fail("SAI_CAMERA_DIRECTORY", "%v", err)
+		}
+	} else if !info.IsDir() {
+		c.fail("SAI_CAMERA_DIRECTORY", "%s is not a directory", cameraDir)
+	} else if config.CameraReadOnly {
+		c.ok("SAI_CAMERA_DIRECTORY", "%s is used read-only", cameraDir)
+	} else {
+		// Frames are moved out of the camera directory after packing
+		c.checkWritable("SAI_CAMERA_DIRECTORY", cameraDir)
+	}
+	if config.CameraMount {
+		if mounted, err := isMountPoint(cameraDir); err != nil {
+			c.fail("SAI_CAMERA_MOUNT", "%v", err)
+		} else if !mounted {
+			c.fail("SAI_CAMERA_MOUNT", "%s is not mounted; nothing is archived until it is", cameraDir)
+		} else {
+			c.ok("SAI_CAMERA_MOUNT", "%s is mounted", cameraDir)
+		}
+	}
+	c.checkWritable("SAI_PROCESSED_DIRECTORY", processedDir)
+	if config.TempDirectory != "" {
+		c.checkWritable("SAI_TEMP_DIRECTORY", config.TempDirectory)
+	} else {
+		c.checkWritable("temp", filepath.Join(baseDir, ProfileFileName("temp")))
+	}
+	c.checkWritable("failed", filepath.Join(baseDir, ProfileFileName("failed")))
+	if config.UploadedDirectory != "" {
+		tempDir := config.TempDirectory
+		if tempDir == "" {
+			tempDir = filepath.Join(baseDir, ProfileFileName("temp"))
+		}
+		if dir := uploadedDirectoryOverlap(config.UploadedDirectory, cameraDir, processedDir, tempDir,
+			filepath.Join(baseDir, ProfileFileName("failed")), filepath.Join(baseDir, ProfileFileName("needs-review"))); dir != "" {
+			c.fail("SAI_UPLOADED_DIRECTORY", "overlaps %s, whose files would be deleted as old uploads; the program does not start", dir)
+		} else {
+			c.checkWritable("SAI_UPLOADED_DIRECTORY", config.UploadedDirectory)
+		}
+	}
+	if config.QuarantineDirectory != "" {
+		c.checkWritable("SAI_QUARANTINE_DIRECTORY", config.QuarantineDirectory)
+	}
+	if config.ReportDirectory != "" {
+		c.checkWritable("SAI_REPORT_DIRECTORY", config.ReportDirectory)
+	}
+	if config.CrashDirectory != "" {
+		c.checkWritable("SAI_CRASH_DIRECTORY", config.CrashDirectory)
+	}
+	if config.StatusFile != "" {
+		c.checkWritable("SAI_STATUS_FILE", filepath.Dir(config.StatusFile))
+	}
+	if sameDirectory(cameraDir, processedDir) {
+		c.fail("SAI_PROCESSED_DIRECTORY", "is the camera directory; processed frames would be packed again")
+	}
+	if config.QuarantineDirectory != "" && sameDirectory(cameraDir, config.QuarantineDirectory) {
+		c.fail("SAI_QUARANTINE_DIRECTORY", "is the camera directory; quarantined frames would be checked again")
+	}
+
+	// Archive tool
+	rarPath, rarAvailable := findRARExecutable()
+	switch config.ArchiveMode {
+	case "rar":
+		if rarAvailable {
+			c.ok("SAI_ARCHIVE_MODE", "rar found at %s", rarPath)
+		} else {
+			c.warn("SAI_ARCHIVE_MODE", "rar requested but the rar command was not found; compressed ZIP is used instead")
+		}
+	case "auto":
+		c.ok("SAI_ARCHIVE_MODE", "auto uses %s", newArchiver(config))
+	case "zip", "zip-uncompressed":
+		c.ok("SAI_ARCHIVE_MODE", "%s", newArchiver(config))
+	default:
+		c.warn("SAI_ARCHIVE_MODE", "unknown mode %q (auto, rar, zip or zip-uncompressed); %s is used", config.ArchiveMode, newArchiver(config))
+	}
+	if config.StreamUpload {
+		if reason := streamUnsupported(config, newArchiver(config)); reason != "" {
+			c.warn("SAI_STREAM_UPLOAD", "archives are written to the temp directory: %s", reason)
+		} else {
+			c.ok("SAI_STREAM_UPLOAD", "archives are uploaded while they are packed")
+		}
+	}
+
+	// Numeric settings
+	if config.Count < 1 {
+		c.fail("SAI_COUNT", "must be at least 1 (is %d)", config.Count)
+	}
+	if config.RequestedInterval < MIN_INTERVAL {
+		c.warn("SAI_INTERVAL", "%d seconds is below the minimum; %d seconds is used (bare numbers are seconds, write e.g. 5m for minutes)", config.RequestedInterval, MIN_INTERVAL)
+	} else if config.RequestedInterval > MAX_INTERVAL {
+		c.warn("SAI_INTERVAL", "%d seconds exceeds the maximum of %d; %d seconds is used", config.RequestedInterval, MAX_INTERVAL, DEFAULT_INTERVAL)
+	}
+
+	if config.IdleInterval > 0 && config.IdleInterval <= time.Duration(max(config.Interval, MIN_INTERVAL))*time.Second {
+		c.warn("SAI_IDLE_INTERVAL", "%s is not longer than SAI_INTERVAL, so the scan interval never backs off", config.IdleInterval)
+	}
+	if config.DailyUploadLimit > 0 {
+		c.ok("SAI_DAILY_UPLOAD_LIMIT", "at most %s uploaded per day, local time", formatDataSize(config.DailyUploadLimit))
+	}
+	if len(config.UploadHours) > 0 {
+		c.ok("SAI_UPLOAD_HOURS", "uploads only %s local time", config.UploadHours)
+	}
+	if len(config.ArchiveHours) > 0 {
+		c.ok("SAI_ARCHIVE_HOURS", "frames packed only %s local time", config.ArchiveHours)
+	}
+	if config.ProcessOrder != orderList {
+		c.ok("SAI_PROCESS_ORDER", "areas with the %s frames are packed and uploaded first", config.ProcessOrder)
+	}
+	if config.MaxArchivesPerScan > 0 {
+		c.ok("SAI_MAX_ARCHIVES_PER_SCAN", "at most %d archives packed and %d queued for upload per scan", config.MaxArchivesPerScan, config.MaxArchivesPerScan)
+	}
+	if config.FlushAfter > 0 {
+		c.ok("SAI_FLUSH_AFTER", "incomplete groups packed after %s without new frames", config.FlushAfter)
+	}
+	if config.FlushAt >= 0 {
+		c.ok("SAI_FLUSH_AT", "incomplete groups packed after %02d:%02d local time", config.FlushAt/60, config.FlushAt%60)
+	}
+
+	// Contradicting settings
+	if config.PreviewMode == "upload" && config.PreviewURL == "" {
+		c.fail("SAI_PREVIEW_URL", "SAI_PREVIEW=upload needs SAI_PREVIEW_URL")
+	}
+	if config.PreviewMode != "upload" && config.PreviewURL != "" {
+		c.warn("SAI_PREVIEW_URL", "set but SAI_PREVIEW is not \"upload\", so it is not used")
+	}
+	if config.QuarantineNotify && config.NotifyURL == "" {
+		c.warn("SAI_QUARANTINE_NOTIFY", "enabled but SAI_NOTIFY_URL is not set, so no notification is sent")
+	}
+	if config.QuarantineNotify && config.QuarantineDirectory == "" {
+		c.warn("SAI_QUARANTINE_NOTIFY", "enabled but SAI_QUARANTINE_DIRECTORY is not set, so frames are never quarantined")
+	}
+	if config.StaleAreaAfter > 0 {
+		when := "while other areas produce frames"
+		if len(config.StaleAreaHours) > 0 {
+			when = config.StaleAreaHours.String() + " local time"
+		}
+		if config.NotifyURL == "" {
+			c.warn("SAI_STALE_AREA_AFTER", "alarm after %s without frames is only logged: SAI_NOTIFY_URL is not set", config.StaleAreaAfter)
+		} else {
+			c.ok("SAI_STALE_AREA_AFTER", "alarm after %s without frames, %s", config.StaleAreaAfter, when)
+		}
+	}
+	if config.StaleFrameAfter > 0 {
+		c.ok("SAI_STALE_FRAME_AFTER", "frames waiting longer than %s are reported", config.StaleFrameAfter)
+	} else if config.StaleFrameFlush {
+		c.warn("SAI_STALE_FRAME_FLUSH", "has no effect without SAI_STALE_FRAME_AFTER")
+	}
+	switch {
+	case config.UploadedDirectory == "" && (config.UploadedMaxAge > 0 || config.UploadedMaxSize > 0):
+		c.warn("SAI_UPLOADED_DIRECTORY", "not set, so SAI_UPLOADED_MAX_AGE and SAI_UPLOADED_MAX_SIZE have no effect")
+	case config.UploadedDirectory != "" && config.UploadedMaxAge <= 0 && config.UploadedMaxSize <= 0:
+		c.warn("SAI_UPLOADED_DIRECTORY", "uploaded archives are kept forever; set SAI_UPLOADED_MAX_AGE or SAI_UPLOADED_MAX_SIZE before the disk fills")
+	case config.UploadedDirectory != "":
+		var limits []string
+		if config.UploadedMaxAge > 0 {
+			limits = append(limits, fmt.Sprintf("deleted %s after the upload", config.UploadedMaxAge))
+		}
+		if config.UploadedMaxSize > 0 {
+			limits = append(limits, fmt.Sprintf("the oldest deleted beyond %s", formatDataSize(config.UploadedMaxSize)))
+		}
+		c.ok("SAI_UPLOADED_DIRECTORY", "uploaded archives are kept, %s", strings.Join(limits, " and "))
+	}
+	if config.TempMaxAge > 0 {
+		if config.TempCleanup == tempCleanupRemove {
+			c.ok("SAI_TEMP_MAX_AGE", "archives older than %s are removed at startup", config.TempMaxAge)
+		} else {
+			c.ok("SAI_TEMP_MAX_AGE", "archives older than %s are moved to the failed directory at startup", config.TempMaxAge)
+		}
+	}
+	switch needed := stateDatabaseNeeded(config); {
+	case !config.StateDatabase && needed != "":
+		c.warn("SAI_STATE_DATABASE", "no is ignored, %s needs the state database", needed)
+	case !config.StateDatabase:
+		c.ok("SAI_STATE_DATABASE", "off, frames, uploads and file operations are not recorded")
+	case config.StateMaxAge <= 0:
+		c.warn("SAI_STATE_MAX_AGE", "0 keeps every record, so the state database grows without limit")
+	case config.StateMaxAge != DEFAULT_STATE_MAX_AGE:
+		c.ok("SAI_STATE_MAX_AGE", "records older than %s are dropped once a day", config.StateMaxAge)
+	}
+	if config.SkipDuplicates {
+		c.ok("SAI_SKIP_DUPLICATES", "frames whose contents were archived before are not archived again")
+	}
+	if config.ReportNotify && (config.ReportDirectory == "" || config.NotifyURL == "") {
+		c.warn("SAI_REPORT_NOTIFY", "needs SAI_REPORT_DIRECTORY and SAI_NOTIFY_URL, so no report is sent")
+	}
+	if config.StatusListen != "" {
+		if host, _, err := net.SplitHostPort(config.StatusListen); err != nil {
+			c.fail("SAI_STATUS_LISTEN", "invalid address %q: %v", config.StatusListen, err)
+		} else if ip := net.ParseIP(host); (host == "" || (ip != nil && !ip.IsLoopback())) && config.StatusToken == "" {
+			c.warn("SAI_STATUS_LISTEN", "%s is reachable from other machines and SAI_STATUS_TOKEN is not set, so anyone there can pause, reload or restart the station", config.StatusListen)
+		}
+	}
+	if config.StatusToken != "" {
+		if config.StatusListen == "" {
+			c.warn("SAI_STATUS_TOKEN", "has no effect without SAI_STATUS_LISTEN")
+		} else {
+			c.ok("SAI_STATUS_TOKEN", "the dashboard buttons and the POST endpoints of the API need the token")
+		}
+	}
+	if config.StatusPprof && config.StatusListen == "" {
+		c.warn("SAI_STATUS_PPROF", "has no effect without SAI_STATUS_LISTEN")
+	}
+
+	// Areas
+	if len(areas) == 0 {
+		c.fail("areas.txt", "no areas listed; no frames are ever packed")
+	} else {
+		c.ok("areas.txt", "%d area(s)", len(areas))
+	}
+	seen := make(map[string]bool)
+	for _, area := range areas {
+		if seen[area] {
+			c.warn("areas.txt", "area %q is listed twice", area)
+		}
+		seen[area] = true
+		if strings.ContainsAny(area, `/\`) {
+			c.fail("areas.txt", "area %q contains a path separator", area)
+		} else if regexp.QuoteMeta(area) != area && config.GroupBy == "filename" {
+			c.warn("areas.txt", "area %q contains regular expression characters and may match other frames", area)
+		}
+	}
+	for _, area := range config.PriorityAreas {
+		if !seen[area] {
+			c.warn("SAI_PRIORITY_AREAS", "area %q is not listed in areas.txt", area)
+		}
+	}
+	if config.GroupBy == "filename" {
+		c.checkFilenamePattern(config, cameraDir, areas)
+	}
+	if config.SequenceKeyword != "" {
+		c.ok("SAI_SEQUENCE_KEYWORD", "archives hold one sequence, numbered by the %s keyword", config.SequenceKeyword)
+	} else if config.SequencePattern != "" {
+		c.ok("SAI_SEQUENCE_PATTERN", "archives hold one sequence, numbered by %s", config.SequencePattern)
+	}
+	if config.SplitSF && config.GroupBy != "filename" {
+		c.warn("SAI_SPLIT_SF", "has no effect with SAI_GROUP_BY=%s", config.GroupBy)
+	} else if config.SplitSF {
+		c.ok("SAI_SPLIT_SF", "AREA-SF_ frames are packed into separate AREA-SF archives")
+	}
+	if len(config.PriorityAreas) > 0 {
+		c.ok("SAI_PRIORITY_AREAS", "%s packed and uploaded first", strings.Join(config.PriorityAreas, ", "))
+	}
+
+	return c.findings
+}
+
+// checkUploadURL checks an upload destination and, with contactServer set,
+// asks it whether it accepts uploads.
+func (c *configCheck) checkUploadURL(ctx context.Context, setting, destination string, config *Config, contactServer bool) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		c.fail(setting, "invalid URL: %v", err)
+		return
+	}
+	if u.Host == "" && u.Scheme != "file" {
+		c.fail(setting, "%q has no host; expected a URL like https://host/upload.py", destination)
+		return
+	}
+	uploader, err := NewUploader(destination, config)
+	if err != nil {
+		c.fail(setting, "%v", err)
+		return
+	}
+	if u.Scheme == "http" && config.Password != "" {
+		c.warn(setting, "the password is sent unencrypted over http")
+	}
+	if !contactServer {
+		c.ok(setting, "%s", destination)
+		return
+	}
+	preflighter, ok := uploader.(Preflighter)
+	if !ok {
+		c.ok(setting, "%s (the %s backend cannot be checked without uploading)", destination, u.Scheme)
+		return
+	}
+	switch status, msg := preflighter.Preflight(ctx); status {
+	case "ok":
+		c.ok(setting, "%s accepts uploads", destination)
+	case "warning":
+		c.warn(setting, "%s: %s", destination, strings.TrimSpace(msg))
+	case "error":
+		c.fail(setting, "%s does not accept uploads: %s", destination, strings.TrimSpace(msg))
+	default:
+		if msg != "" {
+			c.fail(setting, "%s is not reachable: %s", destination, msg)
+		} else {
+			// Older upload.py versions answer without a status marker
+			c.ok(setting, "%s is reachable (no upload status reported)", destination)
+		}
+	}
+}
+
+// checkSigner signs a test file, which finds a missing tool, a wrong key and a
+// wrong passphrase alike.
+func (c *configCheck) checkSigner(ctx context.Context, signer *archiveSigner) {
+	if _, err := exec.LookPath(signer.Method); err != nil {
+		c.fail("SAI_SIGN_METHOD", "%s not found, so nothing can be uploaded: %v", signer.Method, err)
+		return
+	}
+	test, err := os.CreateTemp("", "astrocam-sign-test-")
+	if err != nil {
+		c.warn("SAI_SIGN_METHOD", "cannot create a test file to sign: %v", err)
+		return
+	}
+	test.WriteString("AstroCam-GO signing test\n")
+	test.Close()
+	defer os.Remove(test.Name())
+	if _, _, err := signer.Sign(ctx, test.Name()); err != nil {
+		c.fail("SAI_SIGN_KEY", "cannot sign, so nothing can be uploaded: %v", err)
+		return
+	}
+	c.ok("SAI_SIGN_METHOD", "archives are signed with %s", signer.Method)
+}
+
+// checkSensor reads an observatory sensor once and reports what parse makes
+// of its message. Without contactServer only sensor files are read.
+func (c *configCheck) checkSensor(ctx context.Context, setting, source string, config *Config, contactServer bool, parse func([]byte, time.Time) (string, error)) {
+	sensor, err := newSensorSource(source, config, "check")
+	if err != nil {
+		c.fail(setting, "%v", err)
+		return
+	}
+	defer sensor.close()
+	if _, isFile := sensor.(fileSensor); !isFile && !contactServer {
+		c.ok(setting, "reads %s", sensor)
+		return
+	}
+	data, at, err := sensor.read(ctx)
+	if err != nil {
+		c.warn(setting, "cannot read %s: %v", sensor, err)
+		return
+	}
+	description, err := parse(data, at)
+	if err != nil {
+		c.warn(setting, "%s: %v", sensor, err)
+		return
+	}
+	c.ok(setting, "%s reports %s", sensor, description)
+}
+
+// checkExposureEvents checks SAI_EXPOSURE_EVENTS and, with contactServer,
+// that the camera driver answers.
+func (c *configCheck) checkExposureEvents(source string, contactServer bool) {
+	watcher, err := newExposureWatcher(source)
+	if err != nil {
+		c.fail("SAI_EXPOSURE_EVENTS", "%v", err)
+		return
+	}
+	if contactServer {
+		switch w := watcher.(type) {
+		case *indiWatcher:
+			conn, err := net.DialTimeout("tcp", w.host, 10*time.Second)
+			if err != nil {
+				c.warn("SAI_EXPOSURE_EVENTS", "cannot reach the INDI server: %v; scanning every SAI_INTERVAL only", err)
+				return
+			}
+			conn.Close()
+		case *alpacaWatcher:
+			if _, err := w.imageReady(); err != nil {
+				c.warn("SAI_EXPOSURE_EVENTS", "cannot ask the camera: %v; scanning every SAI_INTERVAL only", err)
+				return
+			}
+		}
+	}
+	c.ok("SAI_EXPOSURE_EVENTS", "scans when %s reports the end of an exposure", watcher)
+}
+
+// checkHTTPURL checks the syntax of an http(s) endpoint.
+func (c *configCheck) checkHTTPURL(setting, value string) {
+	u, err := url.Parse(value)
+	switch {
+	case err != nil:
+		c.fail(setting, "invalid URL: %v", err)
+	case u.Scheme != "http" && u.Scheme != "https":
+		c.fail(setting, "%q is not an http or https URL", value)
+	case u.Host == "":
+		c.fail(setting, "%q has no host", value)
+	}
+}
+
+// checkWritable reports whether files can be created in dir. A directory that
+// does not exist yet is fine if it can be created.
+func (c *configCheck) checkWritable(setting, dir string) {
+	existing := dir
+	for {
+		if _, err := os.Stat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			c.fail(setting, "%s cannot be created", dir)
+			return
+		}
+		existing = parent
+	}
+	f, err := os.CreateTemp(existing, ".astrocam-check-*")
+	if err != nil {
+		c.fail(setting, "%s is not writable: %v", existing, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	if existing != dir {
+		c.ok(setting, "%s will be created at startup", dir)
+		return
+	}
+	c.ok(setting, "%s is writable", dir)
+}
+
+// sameDirectory reports whether two paths name the same directory.
+func sameDirectory(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	if absA == absB {
+		return true
+	}
+	infoA, errA := os.Stat(absA)
+	infoB, errB := os.Stat(absB)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// checkFilenamePattern reports frames in the camera directory that
+// SAI_FILENAME_PATTERN assigns to no area, which are never uploaded.
+func (c *configCheck) checkFilenamePattern(config *Config, cameraDir string, areas []string) {
+	if config.FilenamePattern != DEFAULT_FILENAME_PATTERN {
+		c.ok("SAI_FILENAME_PATTERN", "frames named like %s", config.FilenamePattern)
+	}
+	entries, err := os.ReadDir(cameraDir)
+	if err != nil || len(areas) == 0 {
+		return
+	}
+	var matchers []*regexp.Regexp
+	for _, area := range areas {
+		if re, err := filenameRegexp(config.FilenamePattern, area, fitsExtensionPattern); err == nil {
+			matchers = append(matchers, re)
+		}
+	}
+	fits := regexp.MustCompile(fitsExtensionPattern + "$")
+	var unmatched []string
+	for _, entry := range entries {
+		if entry.IsDir() || !fits.MatchString(entry.Name()) {
+			continue
+		}
+		if !slices.ContainsFunc(matchers, func(re *regexp.Regexp) bool { return re.MatchString(entry.Name()) }) {
+			unmatched = append(unmatched, entry.Name())
+		}
+	}
+	if len(unmatched) > 0 {
+		c.warn("SAI_FILENAME_PATTERN", "%d frame(s) in the camera directory match no area, e.g. %s", len(unmatched), unmatched[0])
+	}
+}
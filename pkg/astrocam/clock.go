@@ -0,0 +1,27 @@
+package astrocam
+
+import "time"
+
+// Clock is the time source for upload throttling, upload pauses, the
+// file-age checks and the test mode timeout. SystemClock is the real clock;
+// tests can substitute a fake one with SetClock to avoid real waits.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock implements Clock with the time package.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time                         { return time.Now() }
+func (SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// since returns the time elapsed since t according to ac's clock.
+func (ac *AstroCam) since(t time.Time) time.Duration {
+	return ac.clock.Now().Sub(t)
+}
+
+// sleep waits for d according to ac's clock.
+func (ac *AstroCam) sleep(d time.Duration) {
+	<-ac.clock.After(d)
+}
@@ -0,0 +1,183 @@
+package astrocam
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// reachabilityTimeout bounds the connection test made before uploading.
+const reachabilityTimeout = 5 * time.Second
+
+// probeAddress returns the host:port an upload to destination connects to
+// first: the server, or the proxy from HTTP_PROXY/HTTPS_PROXY. It returns ""
+// for destinations that cannot be probed this way, such as backends with
+// schemes of their own and no port in the URL.
+func probeAddress(destination string) string {
+	u, err := url.Parse(destination)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	var defaultPort string
+	switch u.Scheme {
+	case "http":
+		defaultPort = "80"
+	case "https":
+		defaultPort = "443"
+	}
+	if defaultPort != "" {
+		if proxy, err := http.ProxyFromEnvironment(&http.Request{URL: u}); err == nil && proxy != nil {
+			u = proxy
+			defaultPort = "80"
+			if u.Scheme == "https" {
+				defaultPort = "443"
+			}
+		}
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	if port == "" {
+		return ""
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// captivePortalError reports that the probe made before uploading was
+// answered by something other than the internet, typically the login page of
+// a hotel, campus or LTE router network.
+type captivePortalError struct {
+	reason string
+}
+
+func (e *captivePortalError) Error() string {
+	return "captive portal: " + e.reason
+}
+
+// certificateError reports whether err is a failed TLS certificate check,
+// which is what a portal intercepting an https connection causes.
+func certificateError(err error) bool {
+	var verification *tls.CertificateVerificationError
+	var authority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &verification) || errors.As(err, &authority) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
+
+// probeServer checks, after the connection test, that HTTP requests reach
+// the upload server rather than a captive portal. Without SAI_PROBE_URL it
+// sends a HEAD request to an http or https destination and only a redirect to
+// another host, a 511 answer or a certificate that is not the server's count
+// as a portal: the upload script may answer HEAD with anything. SAI_PROBE_URL
+// is fetched instead and must answer 204, a 2xx page that is not HTML, or the
+// text of SAI_PROBE_EXPECT when it is set. Redirects are never followed.
+func probeServer(config *Config, destination string) error {
+	target, method := config.ProbeURL, http.MethodGet
+	if target == "" {
+		u, err := url.Parse(destination)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil
+		}
+		target, method = destination, http.MethodHead
+	}
+	tlsConf, err := config.tlsConfig()
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+	transport.DisableKeepAlives = true
+	client := &http.Client{
+		Timeout:   2 * reachabilityTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "AstroCam-GO/"+softwareVersion())
+	resp, err := client.Do(req)
+	if err != nil {
+		if certificateError(err) {
+			return &captivePortalError{fmt.Sprintf("the certificate of %s is not the server's: %v", req.URL.Host, err)}
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode == http.StatusNetworkAuthenticationRequired {
+		return &captivePortalError{resp.Status}
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		if location, err := resp.Location(); err == nil && location.Hostname() != req.URL.Hostname() {
+			return &captivePortalError{"redirected to " + location.String()}
+		}
+	}
+	if config.ProbeURL == "" {
+		return nil
+	}
+	if expect := config.ProbeExpect; expect != "" {
+		if resp.StatusCode/100 != 2 || !strings.Contains(string(body), expect) {
+			return &captivePortalError{fmt.Sprintf("SAI_PROBE_URL answered %s without the text of SAI_PROBE_EXPECT", resp.Status)}
+		}
+		return nil
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/html" {
+		return &captivePortalError{fmt.Sprintf("SAI_PROBE_URL answered %s with an HTML page", resp.Status)}
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("SAI_PROBE_URL answered %s", resp.Status)
+	}
+	return nil
+}
+
+// serverReachable reports whether a TCP connection to the upload server (or
+// its proxy) can be opened and the probe of probeServer passes, and logs when
+// the answer changes. While the server cannot be reached no upload is
+// attempted, so a dead link costs a few seconds per scan instead of
+// SAI_UPLOAD_TIMEOUT per archive, and an upload is never "answered" by a
+// captive portal; archiving goes on, and the first scan that reaches the
+// server again queues every waiting archive.
+func (ac *AstroCam) serverReachable(destination string) bool {
+	addr := probeAddress(destination)
+	if addr == "" {
+		return true
+	}
+	conn, err := net.DialTimeout("tcp", addr, reachabilityTimeout)
+	if err == nil {
+		conn.Close()
+		err = probeServer(ac.config, destination)
+	}
+	reachable := err == nil
+	var captive *captivePortalError
+	portal := errors.As(err, &captive)
+	wasPortal := ac.captivePortal.Swap(portal)
+	wasOffline := ac.offline.Swap(!reachable)
+	switch {
+	case portal && !wasPortal:
+		slog.Warn("Captive portal detected, archives wait until the network is back", "server", destination, "reason", captive.reason)
+		ac.notify("captive portal detected", fmt.Sprintf("Requests to %s are answered by a captive portal (%s). Archives wait in the temp directory until the network reaches the server again.", destination, captive.reason))
+	case !reachable && !portal && !wasOffline:
+		slog.Warn("Upload server unreachable, archives wait until the network is back", "address", addr, "error", err)
+	case reachable && wasOffline:
+		slog.Info("Upload server reachable again, uploading the waiting archives", "server", destination)
+	}
+	return reachable
+}
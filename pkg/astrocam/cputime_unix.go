@@ -0,0 +1,25 @@
+//go:build !windows
+
+package astrocam
+
+import (
+	"syscall"
+	"time"
+)
+
+// childCPUCounted tells whether processCPUTime includes the CPU time of
+// child processes such as rar.
+const childCPUCounted = true
+
+// processCPUTime returns the user and system CPU time used so far by this
+// process and its finished children.
+func processCPUTime() time.Duration {
+	var total time.Duration
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		var ru syscall.Rusage
+		if err := syscall.Getrusage(who, &ru); err == nil {
+			total += time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+		}
+	}
+	return total
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package astrocam
+
+import (
+	"syscall"
+	"time"
+)
+
+// childCPUCounted tells whether processCPUTime includes the CPU time of
+// child processes such as rar; Windows only reports the process itself.
+const childCPUCounted = false
+
+// processCPUTime returns the user and kernel CPU time used so far by this
+// process.
+func processCPUTime() time.Duration {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0
+	}
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(process, &creation, &exit, &kernel, &user); err != nil {
+		return 0
+	}
+	// Filetime durations count 100-nanosecond intervals
+	ticks := func(ft syscall.Filetime) int64 { return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime) }
+	return time.Duration((ticks(kernel) + ticks(user)) * 100)
+}
@@ -0,0 +1,204 @@
+package astrocam
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A panic in the scanner, packer, uploader or a background task is recovered
+// instead of ending the process: a crash report is written to
+// SAI_CRASH_DIRECTORY, sent to SAI_NOTIFY_URL and, with SAI_CRASH_URL,
+// uploaded, and the task goes on with its next job. A panic elsewhere in the
+// main loop makes Run return for a restart.
+
+// crashReportLimit is the number of crash reports kept; older ones are
+// removed, so that a panic repeated at every scan cannot fill the disk.
+const crashReportLimit = 20
+
+// crashLogLines is the number of recent log lines in a crash report.
+const crashLogLines = 100
+
+// crashRetry is the pause before a background task that panicked is started
+// again.
+const crashRetry = time.Minute
+
+// recoverPanic, deferred by a task, turns a panic of the task into a crash
+// report. The deferring function then returns normally with its named
+// results as they were when it panicked.
+func (ac *AstroCam) recoverPanic(task string) {
+	if v := recover(); v != nil {
+		ac.reportCrash(task, v, debug.Stack())
+	}
+}
+
+// supervise runs a background task until stop is closed, starting it again
+// crashRetry after a panic.
+func (ac *AstroCam) supervise(task string, stop <-chan struct{}, run func()) {
+	for {
+		panicked := true
+		func() {
+			defer ac.recoverPanic(task)
+			run()
+			panicked = false
+		}()
+		if !panicked {
+			return
+		}
+		select {
+		case <-time.After(crashRetry):
+			slog.Info("Restarting after a crash", "task", task)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reportCrash logs a recovered panic and writes, notifies and uploads its
+// crash report.
+func (ac *AstroCam) reportCrash(task string, value any, stack []byte) {
+	now := time.Now()
+	slog.Error("Recovered from a crash", "task", task, "panic", fmt.Sprint(value))
+	ac.status.recordError(fmt.Errorf("crash in %s: %v", task, value))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "AstroCam-GO %s crashed in the %s at %s\n", softwareVersion(), task, now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "%s/%s, %s\n\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	fmt.Fprintf(&b, "panic: %v\n\n%s", value, stack)
+	ac.sendCrashReport("crash in "+task, b.String(), now)
+}
+
+// sendCrashReport completes the description of a problem with the settings
+// and the latest log lines, and writes, notifies and uploads it as a crash
+// report. Failures to do so are logged and otherwise ignored.
+func (ac *AstroCam) sendCrashReport(subject, problem string, now time.Time) {
+	ac.configMu.RLock()
+	config := ac.config
+	ac.configMu.RUnlock()
+
+	var b strings.Builder
+	b.WriteString(problem)
+	formatReportContext(&b, config)
+	report := b.String()
+
+	if path, err := ac.saveCrashReport(config.CrashDirectory, report, now); err != nil {
+		slog.Warn("Cannot write crash report", "error", err)
+	} else {
+		slog.Error("Crash report written", "file", path)
+	}
+	ac.notify(subject, report)
+	if config.CrashURL != "" {
+		if err := ac.uploadCrashReport(config, report); err != nil {
+			slog.Warn("Cannot send crash report", "error", err)
+		} else {
+			slog.Info("Crash report sent", "url", config.CrashURL)
+		}
+	}
+}
+
+// formatReportContext writes the part of a crash report that follows the
+// problem: the settings without secrets and the latest log lines, oldest
+// first.
+func formatReportContext(w io.Writer, config *Config) {
+	fmt.Fprintln(w, "\nSettings:")
+	writeConfigSummary(w, config)
+
+	fmt.Fprintln(w, "\nRecent log:")
+	entries := recentLog.list()
+	if len(entries) > crashLogLines {
+		entries = entries[:crashLogLines]
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		fmt.Fprintf(w, "%s %-5s %s\n", e.Time.Format("2006-01-02 15:04:05"), e.Level, e.Message)
+	}
+}
+
+// writeConfigSummary writes the settings of config, one per line. The
+// password is only reported as set, URLs lose their passwords and only the
+// names of the upload form fields are shown, which may hold tokens.
+func writeConfigSummary(w io.Writer, config *Config) {
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		field := v.Field(i)
+		var value string
+		switch {
+		case name == "Password":
+			if field.String() != "" {
+				value = "(set)"
+			}
+		case name == "UploadFields":
+			var names []string
+			for _, key := range field.MapKeys() {
+				names = append(names, key.String())
+			}
+			sort.Strings(names)
+			value = strings.Join(names, ", ")
+		case field.Kind() == reflect.String:
+			value = redactedSource(field.String())
+		default:
+			value = fmt.Sprint(field.Interface())
+		}
+		fmt.Fprintf(w, "  %s: %s\n", name, value)
+	}
+}
+
+// saveCrashReport saves a crash report in dir and removes the oldest ones
+// beyond crashReportLimit. It returns the path of the report.
+func (ac *AstroCam) saveCrashReport(dir, report string, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := StationFileName(ac.profile, "astrocam-crash-"+now.Format("20060102-150405.000")+".txt")
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(report), 0644); err != nil {
+		return "", err
+	}
+	if old, err := filepath.Glob(filepath.Join(dir, StationFileName(ac.profile, "astrocam-crash-*.txt"))); err == nil && len(old) > crashReportLimit {
+		// The time stamps sort by name
+		sort.Strings(old)
+		for _, file := range old[:len(old)-crashReportLimit] {
+			os.Remove(file)
+		}
+	}
+	return path, nil
+}
+
+// uploadCrashReport POSTs a crash report as plain text to SAI_CRASH_URL with
+// the station and software version in the query, using the upload
+// credentials and TLS settings.
+func (ac *AstroCam) uploadCrashReport(config *Config, report string) error {
+	target, err := url.Parse(config.CrashURL)
+	if err != nil {
+		return fmt.Errorf("invalid SAI_CRASH_URL: %w", err)
+	}
+	query := target.Query()
+	if config.StationID != "" {
+		query.Set("station", config.StationID)
+	}
+	query.Set("version", softwareVersion())
+	target.RawQuery = query.Encode()
+
+	resp, err := authorizedRequest(config, http.MethodPost, target, strings.NewReader(report))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	return nil
+}
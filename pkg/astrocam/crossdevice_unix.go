@@ -0,0 +1,23 @@
+//go:build !windows
+
+package astrocam
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// isCrossDevice reports whether a rename failed because source and target
+// are on different file systems.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// copyFileTimes gives dst the modification time of the file described by
+// info, as a rename would have kept it. Unix file systems have no portable
+// creation time to set.
+func copyFileTimes(dst string, info fs.FileInfo) error {
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,40 @@
+//go:build windows
+
+package astrocam
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// errorNotSameDevice is ERROR_NOT_SAME_DEVICE, returned by MoveFileEx for a
+// move to another volume.
+const errorNotSameDevice syscall.Errno = 17
+
+// isCrossDevice reports whether a rename failed because source and target
+// are on different volumes.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
+
+// copyFileTimes gives dst the creation, access and modification times of the
+// file described by info, as a rename would have kept them.
+func copyFileTimes(dst string, info fs.FileInfo) error {
+	attrs, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+	path, err := syscall.UTF16PtrFromString(longPath(dst))
+	if err != nil {
+		return err
+	}
+	h, err := syscall.CreateFile(path, syscall.FILE_WRITE_ATTRIBUTES,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil, syscall.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(h)
+	return syscall.SetFileTime(h, &attrs.CreationTime, &attrs.LastAccessTime, &attrs.LastWriteTime)
+}
@@ -0,0 +1,265 @@
+package astrocam
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dashboardTemplate is the self-contained status page served on "/". It
+// reloads itself every 30 seconds and needs no external assets, so it works
+// from a phone on a slow link.
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"ago":  ago,
+	"gb":   formatGB,
+	"data": formatDataSize,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="30">
+<title>AstroCam status</title>
+<style>
+body { font-family: sans-serif; margin: 1em; max-width: 60em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+.ok { color: #080; } .bad { color: #c00; }
+form { display: inline; }
+button, input { font-size: 1em; padding: 0.4em 1em; margin: 0 0.5em 1em 0; }
+</style>
+</head>
+<body>
+<h1>AstroCam {{if .Health.Version}}{{.Health.Version}}{{end}}</h1>
+<p>Status: {{if eq .Health.Status "ok"}}<b class="ok">OK</b>{{else}}<b class="bad">{{.Health.Status}}</b>{{end}}
+{{if and .Health.CameraUnreachable (ne .Health.Status "camera unreachable")}} &middot; <b class="bad">camera unreachable</b>{{end}}
+{{if .OperatorPaused}} &middot; <b class="bad">pipeline paused by operator</b>{{else if .PauseFile}} &middot; <b class="bad">pipeline paused by the PAUSE file</b>{{else if .Unsafe}} &middot; <b class="bad">uploads paused: observatory unsafe</b>{{else if .Health.PausedUntil}} &middot; <b class="bad">uploads paused until {{.Health.PausedUntil.Format "15:04:05"}}</b>{{else if .Health.UploadLimitHit}} &middot; <b class="bad">daily upload limit reached, uploads wait until midnight</b>{{end}}</p>
+
+<form method="post">{{if .TokenRequired}}<input type="password" name="token" placeholder="SAI_STATUS_TOKEN" autocomplete="current-password">
+{{end}}<button formaction="/trigger">Scan now</button>
+{{if .OperatorPaused}}<button formaction="/resume">Resume pipeline</button>
+{{else}}<button formaction="/pause">Pause pipeline</button>{{end}}</form>
+
+<h2>Pipeline</h2>
+<table>
+<tr><th>Last scan</th><td>{{ago .LastScan}}</td></tr>
+<tr><th>Last upload</th><td>{{ago .LastUpload}}{{if .Health.LastUploadArchive}} ({{.Health.LastUploadArchive}}){{end}}</td></tr>
+<tr><th>Uploads / errors</th><td>{{.Health.Uploads}} / {{.Health.UploadErrors}}</td></tr>
+<tr><th>Pending archives</th><td>{{.Health.PendingArchives}}</td></tr>
+<tr><th>Uploaded today</th><td>{{data .Health.UploadedToday}}{{if .Health.DailyUploadLimit}} of {{data .Health.DailyUploadLimit}} (SAI_DAILY_UPLOAD_LIMIT){{end}}</td></tr>
+{{range $name, $free := .Health.DiskFreeBytes}}<tr><th>Free on {{$name}}</th><td>{{gb $free}}</td></tr>
+{{end}}</table>
+
+<h2>Areas</h2>
+{{if .Areas}}<table>
+<tr><th>Area</th><th>Frames waiting</th></tr>
+{{range .Areas}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{else}}<p>No frames waiting.</p>{{end}}
+
+<h2>Upload history</h2>
+{{if .History}}<table>
+<tr><th>Time</th><th>Archive</th><th>Result</th></tr>
+{{range .History}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Archive}}</td><td>{{if .Error}}<span class="bad">{{.Error}}</span>{{else}}<span class="ok">uploaded</span>{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p>No uploads yet.</p>{{end}}
+
+<h2>Recent errors</h2>
+{{if .Problems}}<table>
+<tr><th>Time</th><th>Level</th><th>Message</th></tr>
+{{range .Problems}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Level}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>
+{{else}}<p>None.</p>{{end}}
+
+<h2>Configuration</h2>
+<table>
+{{range .Config}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// dashboardArea is one row of the per-area table.
+type dashboardArea struct {
+	Name  string
+	Count int
+}
+
+// dashboardSetting is one row of the configuration table.
+type dashboardSetting struct {
+	Name  string
+	Value any
+}
+
+// dashboardData is everything the dashboard template renders.
+type dashboardData struct {
+	Health         healthReport
+	OperatorPaused bool
+	PauseFile      bool
+	Unsafe         bool
+	LastScan       time.Time
+	LastUpload     time.Time
+	Areas          []dashboardArea
+	History        []uploadRecord // newest first
+	Problems       []logEntry
+	Config         []dashboardSetting
+	TokenRequired  bool // the buttons need SAI_STATUS_TOKEN
+}
+
+// ago renders the time since t, or "never" for the zero time.
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+// formatGB renders a byte count in gigabytes.
+func formatGB(bytes uint64) string {
+	return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+}
+
+// dashboardData collects the state shown on the dashboard. The caller holds
+// ac.configMu for reading.
+func (ac *AstroCam) dashboardData() dashboardData {
+	data := dashboardData{
+		Health:         ac.healthReport(),
+		OperatorPaused: ac.operatorPaused.Load(),
+		PauseFile:      ac.pauseFile.Load(),
+		Unsafe:         ac.unsafe.Load(),
+		Problems:       recentProblems.list(),
+		TokenRequired:  ac.config.StatusToken != "",
+	}
+	for i := range data.Problems {
+		data.Problems[i].Message = translate(data.Problems[i].Message)
+	}
+
+	s := ac.status
+	s.mu.Lock()
+	data.LastScan = s.lastScan
+	data.LastUpload = s.lastUpload
+	for name, count := range s.areaCounts {
+		if count > 0 {
+			data.Areas = append(data.Areas, dashboardArea{Name: name, Count: count})
+		}
+	}
+	for i := len(s.history) - 1; i >= 0; i-- {
+		data.History = append(data.History, s.history[i])
+	}
+	s.mu.Unlock()
+	sort.Slice(data.Areas, func(i, j int) bool { return data.Areas[i].Name < data.Areas[j].Name })
+
+	data.Config = []dashboardSetting{
+		{"Server", ac.config.Server},
+		{"Camera directory", ac.config.CameraDirectory},
+		{"Processed directory", ac.config.ProcessedDirectory},
+		{"Temp directory", ac.tempDirectory},
+		{"Scan interval (s)", max(ac.config.Interval, MIN_INTERVAL)},
+		{"Frames per archive", ac.config.Count},
+		{"Archive format", ac.archiver.String()},
+		{"Frame grouping", ac.config.GroupBy},
+		{"Areas", len(ac.areas)},
+	}
+	return data
+}
+
+// handleDashboard serves the HTML status page.
+func (ac *AstroCam) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	ac.configMu.RLock()
+	data := ac.dashboardData()
+	ac.configMu.RUnlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		slog.Warn("Cannot render dashboard", "error", err)
+	}
+}
+
+// handleDashboardAction handles the dashboard buttons (POST /trigger, /pause,
+// /resume) and redirects back to the dashboard.
+func (ac *AstroCam) handleDashboardAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if code, reason := ac.refuseControl(r); code != 0 {
+		http.Error(w, reason, code)
+		return
+	}
+	switch r.URL.Path {
+	case "/trigger":
+		ac.requestScan()
+	case "/pause":
+		ac.setOperatorPause(true)
+	case "/resume":
+		ac.setOperatorPause(false)
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// refuseControl returns the HTTP status and the reason for refusing a
+// control request of the status server, or 0 to accept it. Browsers name the
+// site a request comes from in Sec-Fetch-Site and Origin, and a request from
+// another site is refused, so that a web page cannot press the buttons
+// through the operator's browser. With SAI_STATUS_TOKEN the request must
+// also carry the token, as "Authorization: Bearer TOKEN" or in the token
+// field of the dashboard.
+func (ac *AstroCam) refuseControl(r *http.Request) (int, string) {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return http.StatusForbidden, "cross-site request refused"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			return http.StatusForbidden, "cross-site request refused"
+		}
+	}
+
+	ac.configMu.RLock()
+	token := ac.config.StatusToken
+	ac.configMu.RUnlock()
+	if token == "" {
+		return 0, ""
+	}
+	given, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !bearer {
+		given = r.PostFormValue("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return http.StatusUnauthorized, "missing or wrong SAI_STATUS_TOKEN"
+	}
+	return 0, ""
+}
+
+// requestScan asks the main loop for an immediate scan. A request made while
+// another one is still pending is merged with it.
+func (ac *AstroCam) requestScan() {
+	select {
+	case ac.scanRequests <- struct{}{}:
+	default:
+	}
+}
+
+// setOperatorPause pauses or resumes the pipeline, packing and uploading, on
+// operator request.
+func (ac *AstroCam) setOperatorPause(paused bool) {
+	if ac.operatorPaused.Swap(paused) == paused {
+		return
+	}
+	if paused {
+		slog.Info("Pipeline paused by operator")
+	} else {
+		slog.Info("Pipeline resumed by operator")
+	}
+}
@@ -0,0 +1,36 @@
+//go:build windows
+
+package astrocam
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// diskFree returns the number of bytes available to the current user on the
+// volume holding path.
+func diskFree(path string) (uint64, error) {
+	kernel32, err := syscall.LoadDLL("kernel32.dll")
+	if err != nil {
+		return 0, err
+	}
+	defer kernel32.Release()
+
+	getDiskFreeSpaceEx, err := kernel32.FindProc("GetDiskFreeSpaceExW")
+	if err != nil {
+		return 0, err
+	}
+
+	pathPtr, err := syscall.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return 0, err
+	}
+
+	var freeBytesAvailable uint64
+	ret, _, callErr := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPtr)),
+		uintptr(unsafe.Pointer(&freeBytesAvailable)), 0, 0)
+	if ret == 0 {
+		return 0, callErr
+	}
+	return freeBytesAvailable, nil
+}
@@ -0,0 +1,243 @@
+package astrocam
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// exposureScanDelay gives the capture software time to save the frame
+	// after the camera driver reported the end of an exposure.
+	exposureScanDelay = 2 * time.Second
+	// exposureRetry is the wait before reconnecting to the camera driver.
+	exposureRetry = 30 * time.Second
+	// alpacaPollInterval is how often an ASCOM Alpaca camera is asked
+	// whether an image is ready.
+	alpacaPollInterval = time.Second
+)
+
+// exposureWatcher reports the ends of exposures announced by a camera
+// driver, so that the camera directory is scanned right away instead of at
+// the next SAI_INTERVAL.
+type exposureWatcher interface {
+	// watch calls ended for every exposure the driver finishes until stop is
+	// closed or the connection fails.
+	watch(stop <-chan struct{}, ended func()) error
+	String() string
+}
+
+// newExposureWatcher returns the watcher for SAI_EXPOSURE_EVENTS:
+// indi://host[:port][/DEVICE] for an INDI server, or
+// alpaca://host:port[/NUMBER] for an ASCOM Alpaca camera.
+func newExposureWatcher(source string) (exposureWatcher, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAI_EXPOSURE_EVENTS: %w", err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("SAI_EXPOSURE_EVENTS %s has no host", source)
+	}
+	device := strings.Trim(u.Path, "/")
+	switch strings.ToLower(u.Scheme) {
+	case "indi":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "7624")
+		}
+		return &indiWatcher{host: host, device: device}, nil
+	case "alpaca":
+		if device == "" {
+			device = "0"
+		}
+		if _, err := strconv.Atoi(device); err != nil {
+			return nil, fmt.Errorf("SAI_EXPOSURE_EVENTS %s: the camera number must be a number", source)
+		}
+		return &alpacaWatcher{base: "http://" + u.Host + "/api/v1/camera/" + device}, nil
+	}
+	return nil, fmt.Errorf("SAI_EXPOSURE_EVENTS %s: use indi://host[:port][/DEVICE] or alpaca://host:port[/NUMBER]", source)
+}
+
+// watchExposures follows SAI_EXPOSURE_EVENTS until stop is closed and asks
+// for a scan after every exposure. Scanning every SAI_INTERVAL goes on, so
+// frames are still found while the driver cannot be reached.
+func (ac *AstroCam) watchExposures(stop <-chan struct{}, watcher exposureWatcher) {
+	ended := func() {
+		slog.Debug("Exposure finished, scanning the camera directory", "driver", watcher)
+		go func() {
+			select {
+			case <-time.After(exposureScanDelay):
+				ac.requestScan()
+			case <-stop:
+			}
+		}()
+	}
+	for {
+		err := watcher.watch(stop, ended)
+		if stopped(stop) {
+			return
+		}
+		slog.Warn("Lost the camera driver's exposure events, scanning every SAI_INTERVAL meanwhile",
+			"driver", watcher, "error", err, "retry_in", exposureRetry)
+		select {
+		case <-time.After(exposureRetry):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// indiWatcher follows the CCD_EXPOSURE property of the cameras on an INDI
+// server: an exposure has ended when the property turns from Busy to Ok.
+type indiWatcher struct {
+	host   string
+	device string // camera device name ("" = every device)
+}
+
+func (w *indiWatcher) String() string {
+	if w.device != "" {
+		return "indi://" + w.host + "/" + w.device
+	}
+	return "indi://" + w.host
+}
+
+func (w *indiWatcher) watch(stop <-chan struct{}, ended func()) error {
+	conn, err := net.DialTimeout("tcp", w.host, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	request := `<getProperties version="1.7"/>`
+	if w.device != "" {
+		var device strings.Builder
+		xml.EscapeText(&device, []byte(w.device))
+		request = `<getProperties version="1.7" device="` + device.String() + `"/>`
+	}
+	if _, err := io.WriteString(conn, request+"\n"); err != nil {
+		return err
+	}
+	slog.Info("Following exposures of the INDI server", "driver", w)
+
+	states := make(map[string]string) // CCD_EXPOSURE state by device
+	decoder := xml.NewDecoder(conn)
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok || (element.Name.Local != "setNumberVector" && element.Name.Local != "defNumberVector") {
+			continue
+		}
+		var device, name, state string
+		for _, attr := range element.Attr {
+			switch attr.Name.Local {
+			case "device":
+				device = attr.Value
+			case "name":
+				name = attr.Value
+			case "state":
+				state = attr.Value
+			}
+		}
+		if name != "CCD_EXPOSURE" || (w.device != "" && device != w.device) {
+			continue
+		}
+		if states[device] == "Busy" && state == "Ok" {
+			ended()
+		}
+		states[device] = state
+	}
+}
+
+// alpacaWatcher asks an ASCOM Alpaca camera every second whether an image is
+// ready: an exposure has ended when ImageReady turns true.
+type alpacaWatcher struct {
+	base        string // camera device URL
+	transaction atomic.Uint32
+}
+
+func (w *alpacaWatcher) String() string {
+	return w.base
+}
+
+func (w *alpacaWatcher) watch(stop <-chan struct{}, ended func()) error {
+	ready, err := w.imageReady()
+	if err != nil {
+		return err
+	}
+	slog.Info("Following exposures of the ASCOM Alpaca camera", "driver", w)
+	ticker := time.NewTicker(alpacaPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		now, err := w.imageReady()
+		if err != nil {
+			return err
+		}
+		if now && !ready {
+			ended()
+		}
+		ready = now
+	}
+}
+
+// imageReady asks the camera whether an image is ready for download.
+func (w *alpacaWatcher) imageReady() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	query := fmt.Sprintf("?ClientID=%d&ClientTransactionID=%d", alpacaClientID, w.transaction.Add(1))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.base+"/imageready"+query, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("camera returned status %s", resp.Status)
+	}
+	var answer struct {
+		Value        bool
+		ErrorNumber  int
+		ErrorMessage string
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&answer); err != nil {
+		return false, fmt.Errorf("invalid answer from the camera: %w", err)
+	}
+	if answer.ErrorNumber != 0 {
+		return false, errors.New("camera error: " + answer.ErrorMessage)
+	}
+	return answer.Value, nil
+}
+
+// alpacaClientID identifies AstroCam-GO to Alpaca devices.
+const alpacaClientID = 7624
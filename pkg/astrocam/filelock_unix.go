@@ -1,6 +1,6 @@
 //go:build !windows
 
-package main
+package astrocam
 
 import (
 	"fmt"
@@ -8,16 +8,16 @@ import (
 	"syscall"
 )
 
-// fileLock holds an OS-level exclusive lock on a file.
+// FileLock holds an OS-level exclusive lock on a file.
 // The lock is automatically released by the OS when the process exits,
 // regardless of how it terminates (graceful shutdown, SIGKILL, crash, reboot).
-type fileLock struct {
+type FileLock struct {
 	file *os.File
 }
 
-// acquireFileLock attempts to take an exclusive lock on the given path.
+// AcquireFileLock attempts to take an exclusive lock on the given path.
 // Returns an error if another instance already holds the lock.
-func acquireFileLock(path string) (*fileLock, error) {
+func AcquireFileLock(path string) (*FileLock, error) {
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
 		return nil, fmt.Errorf("could not open lock file %s: %w", path, err)
@@ -29,11 +29,11 @@ func acquireFileLock(path string) (*fileLock, error) {
 		return nil, fmt.Errorf("another instance of astrocam-go is already running (lock file: %s)", path)
 	}
 
-	return &fileLock{file: f}, nil
+	return &FileLock{file: f}, nil
 }
 
-// release explicitly releases the file lock.
-func (l *fileLock) release() {
+// Release explicitly releases the file lock.
+func (l *FileLock) Release() {
 	if l.file != nil {
 		syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
 		l.file.Close()
@@ -0,0 +1,121 @@
+package astrocam
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// FS is the file system access used to group frames, move them to the
+// processed directory and find the archives waiting for upload. OSFS is the
+// real file system; tests can substitute an in-memory one with SetFS.
+type FS interface {
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Open(name string) (fs.File, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+}
+
+// OSFS implements FS with the os package.
+type OSFS struct{}
+
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (OSFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (OSFS) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (OSFS) Rename(oldpath, newpath string) error       { return os.Rename(oldpath, newpath) }
+func (OSFS) Remove(name string) error                   { return os.Remove(name) }
+
+// moveFile renames src to dst. When they are on different file systems (e.g.
+// camera SSD and archive HDD) it copies the file instead, verifies the copy
+// against the original's SHA-256 and only then deletes the original.
+func moveFile(fsys FS, src, dst string) error {
+	err := fsys.Rename(src, dst)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+	slog.Debug("Rename across file systems, copying instead", "file", filepath.Base(src), "target", filepath.Dir(dst))
+	return copyVerifyDelete(src, dst)
+}
+
+// copyVerifyDelete copies src to dst with copyVerified, gives the copy the
+// permissions of src, as a rename would, and removes src.
+func copyVerifyDelete(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := copyVerified(src, dst); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		slog.Warn("Cannot copy file permissions", "file", filepath.Base(dst), "error", err)
+	}
+	return os.Remove(src)
+}
+
+// copyVerified copies src to dst, preserving its timestamps (on Windows
+// including the creation time), and checks that the copy reads back with the
+// same checksum. A partial or mismatching copy is removed.
+func copyVerified(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Copy to a temporary name, so that dst never holds a partial file
+	tmp := dst + ".partial"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	srcHash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, srcHash), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyChecksum(tmp, srcHash.Sum(nil))
+	}
+	if err == nil {
+		err = copyFileTimes(tmp, info)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("copy to %s failed: %w", filepath.Dir(dst), err)
+	}
+	return nil
+}
+
+// verifyChecksum reads path back and compares its SHA-256 with want.
+func verifyChecksum(path string, want []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), want) {
+		return fmt.Errorf("checksum mismatch after copying %s", filepath.Base(path))
+	}
+	return nil
+}
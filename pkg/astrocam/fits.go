@@ -0,0 +1,378 @@
+package astrocam
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FITS files are organized in 2880-byte blocks made of 80-character header cards.
+const (
+	fitsBlockSize = 2880
+	fitsCardSize  = 80
+)
+
+// fitsMaxImagePixels bounds the image readFITSImage loads, well above the
+// largest astronomical camera sensors, so that a corrupt NAXIS1/NAXIS2 cannot
+// make it allocate gigabytes.
+const fitsMaxImagePixels = 1 << 28
+
+// errInvalidHeader marks a frame whose header cannot be rewritten because it
+// is not a FITS header; such frames are quarantined.
+var errInvalidHeader = errors.New("invalid FITS header")
+
+// validateFITSFile performs a cheap sanity check of a FITS frame: the file must
+// be non-empty, start with a SIMPLE card, contain an END card in its header and
+// have a size that is a whole number of FITS blocks. A frame failing any of
+// these checks is truncated or not a FITS file at all.
+func validateFITSFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("empty file")
+	}
+	if info.Size() < fitsBlockSize {
+		return fmt.Errorf("file too short for a FITS header (%d bytes)", info.Size())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	block := make([]byte, fitsBlockSize)
+	if _, err := io.ReadFull(f, block); err != nil {
+		return fmt.Errorf("cannot read FITS header: %w", err)
+	}
+	if !bytes.HasPrefix(block, []byte("SIMPLE  =")) {
+		return fmt.Errorf("missing SIMPLE keyword, not a FITS file")
+	}
+
+	// Look for the END card, reading further header blocks as needed
+	for {
+		for off := 0; off < fitsBlockSize; off += fitsCardSize {
+			if strings.TrimRight(string(block[off:off+fitsCardSize]), " ") == "END" {
+				if info.Size()%fitsBlockSize != 0 {
+					return fmt.Errorf("truncated file (%d bytes is not a multiple of %d)", info.Size(), fitsBlockSize)
+				}
+				return nil
+			}
+		}
+		if _, err := io.ReadFull(f, block); err != nil {
+			return fmt.Errorf("FITS header has no END card")
+		}
+	}
+}
+
+// readFITSHeader parses the primary header of a FITS file and returns its
+// keywords with string values unquoted and trimmed. COMMENT, HISTORY and
+// blank cards are skipped.
+func readFITSHeader(f io.Reader) (map[string]string, error) {
+	header := make(map[string]string)
+	block := make([]byte, fitsBlockSize)
+	for {
+		if _, err := io.ReadFull(f, block); err != nil {
+			return nil, fmt.Errorf("incomplete FITS header: %w", err)
+		}
+		for off := 0; off < fitsBlockSize; off += fitsCardSize {
+			card := string(block[off : off+fitsCardSize])
+			key := strings.TrimSpace(card[:8])
+			if key == "END" {
+				return header, nil
+			}
+			if key == "" || key == "COMMENT" || key == "HISTORY" || card[8:10] != "= " {
+				continue
+			}
+			header[key] = parseFITSValue(card[10:])
+		}
+	}
+}
+
+// parseFITSValue extracts the value part of a header card, removing quotes
+// from string values and any trailing "/ comment".
+func parseFITSValue(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "'") {
+		// String value: '' is an escaped quote, a single ' ends the string
+		var sb strings.Builder
+		for i := 1; i < len(raw); i++ {
+			if raw[i] == '\'' {
+				if i+1 < len(raw) && raw[i+1] == '\'' {
+					sb.WriteByte('\'')
+					i++
+					continue
+				}
+				break
+			}
+			sb.WriteByte(raw[i])
+		}
+		return strings.TrimSpace(sb.String())
+	}
+	if pos := strings.Index(raw, "/"); pos != -1 {
+		raw = raw[:pos]
+	}
+	return strings.TrimSpace(raw)
+}
+
+// fitsImage holds the pixel values of the first 2D image plane of a frame,
+// scaled by BSCALE/BZERO. Row 0 is the first row stored in the file (the
+// bottom of the image in the usual FITS orientation).
+type fitsImage struct {
+	Width, Height int
+	Pixels        []float32
+	Saturation    float64 // SATURATE keyword, or the largest value the data type can hold
+}
+
+// readFITSImage reads the primary image of a FITS file.
+func readFITSImage(path string) (*fitsImage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Read header blocks until END, counting how many blocks they take
+	header := make(map[string]string)
+	block := make([]byte, fitsBlockSize)
+	headerBlocks := 0
+	for done := false; !done; {
+		if _, err := io.ReadFull(f, block); err != nil {
+			return nil, fmt.Errorf("incomplete FITS header: %w", err)
+		}
+		headerBlocks++
+		for off := 0; off < fitsBlockSize; off += fitsCardSize {
+			card := string(block[off : off+fitsCardSize])
+			key := strings.TrimSpace(card[:8])
+			if key == "END" {
+				done = true
+				break
+			}
+			if key != "" && card[8:10] == "= " {
+				header[key] = parseFITSValue(card[10:])
+			}
+		}
+	}
+
+	bitpix, _ := strconv.Atoi(header["BITPIX"])
+	naxis, _ := strconv.Atoi(header["NAXIS"])
+	width, _ := strconv.Atoi(header["NAXIS1"])
+	height, _ := strconv.Atoi(header["NAXIS2"])
+	if naxis < 2 || width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("no 2D image in primary HDU (NAXIS=%d)", naxis)
+	}
+	bytesPerPixel := bitpix / 8
+	if bytesPerPixel < 0 {
+		bytesPerPixel = -bytesPerPixel
+	}
+	switch bitpix {
+	case 8, 16, 32, 64, -32, -64:
+	default:
+		return nil, fmt.Errorf("unsupported BITPIX %d", bitpix)
+	}
+	dataSize := int64(width) * int64(height) * int64(bytesPerPixel)
+	if width > fitsMaxImagePixels/height || dataSize > math.MaxInt {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", width, height)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if dataSize > info.Size()-int64(headerBlocks)*fitsBlockSize {
+		return nil, fmt.Errorf("image of %dx%d pixels does not fit in the file (%d bytes)", width, height, info.Size())
+	}
+
+	bscale, bzero := 1.0, 0.0
+	if v, err := strconv.ParseFloat(header["BSCALE"], 64); err == nil {
+		bscale = v
+	}
+	if v, err := strconv.ParseFloat(header["BZERO"], 64); err == nil {
+		bzero = v
+	}
+
+	raw := make([]byte, dataSize)
+	if _, err := io.ReadFull(bufio.NewReaderSize(f, 1<<20), raw); err != nil {
+		return nil, fmt.Errorf("truncated image data: %w", err)
+	}
+
+	pixels := make([]float32, width*height)
+	for i := range pixels {
+		b := raw[i*bytesPerPixel : (i+1)*bytesPerPixel]
+		var v float64
+		switch bitpix {
+		case 8:
+			v = float64(b[0])
+		case 16:
+			v = float64(int16(binary.BigEndian.Uint16(b)))
+		case 32:
+			v = float64(int32(binary.BigEndian.Uint32(b)))
+		case 64:
+			v = float64(int64(binary.BigEndian.Uint64(b)))
+		case -32:
+			v = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		case -64:
+			v = math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+		pixels[i] = float32(v*bscale + bzero)
+	}
+
+	saturation := math.Inf(1)
+	switch bitpix {
+	case 8:
+		saturation = 255*bscale + bzero
+	case 16:
+		saturation = math.MaxInt16*bscale + bzero
+	case 32:
+		saturation = math.MaxInt32*bscale + bzero
+	}
+	if v, err := strconv.ParseFloat(header["SATURATE"], 64); err == nil && v > 0 {
+		saturation = v
+	}
+
+	return &fitsImage{Width: width, Height: height, Pixels: pixels, Saturation: saturation}, nil
+}
+
+// fitsKeyword is a header keyword written into frames before archiving.
+type fitsKeyword struct {
+	Key   string
+	Value string
+}
+
+// formatFITSCard renders an 80-character header card. Numbers and the logical
+// values T/F are written as fixed-format numeric/logical values, anything
+// else as a quoted string.
+func formatFITSCard(key, value string) string {
+	var card string
+	key = strings.ToUpper(key)
+	if _, err := strconv.ParseFloat(value, 64); err == nil || value == "T" || value == "F" {
+		card = fmt.Sprintf("%-8s= %20s", key, value)
+	} else {
+		quoted := "'" + fmt.Sprintf("%-8s", strings.ReplaceAll(value, "'", "''")) + "'"
+		card = fmt.Sprintf("%-8s= %-20s", key, quoted)
+	}
+	if len(card) > fitsCardSize {
+		card = card[:fitsCardSize-1] + "'"
+	}
+	return fmt.Sprintf("%-80s", card)
+}
+
+// injectFITSKeywords writes the given keywords into the primary header of a
+// FITS file, replacing existing cards with the same keyword and adding the
+// others just before END. When the header still has room in its last block
+// it is rewritten in place; otherwise the file is rewritten with an extra
+// header block. The file modification time is preserved either way.
+func injectFITSKeywords(path string, keywords []fitsKeyword) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Collect header cards up to (not including) END
+	var cards []string
+	headerSize := 0
+	block := make([]byte, fitsBlockSize)
+	for done := false; !done; {
+		if _, err := io.ReadFull(f, block); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: no END card before the end of the file", errInvalidHeader)
+		} else if err != nil {
+			return fmt.Errorf("incomplete FITS header: %w", err)
+		}
+		headerSize += fitsBlockSize
+		for off := 0; off < fitsBlockSize; off += fitsCardSize {
+			card := string(block[off : off+fitsCardSize])
+			if strings.TrimRight(card, " ") == "END" {
+				done = true
+				break
+			}
+			cards = append(cards, card)
+		}
+	}
+	if len(cards) == 0 {
+		return fmt.Errorf("%w: END card before any other", errInvalidHeader)
+	}
+	if !strings.HasPrefix(cards[0], "SIMPLE  =") {
+		return fmt.Errorf("%w: missing SIMPLE keyword, not a FITS file", errInvalidHeader)
+	}
+
+	changed := false
+	for _, kw := range keywords {
+		card := formatFITSCard(kw.Key, kw.Value)
+		replaced := false
+		for i, existing := range cards {
+			if existing[:8] == card[:8] {
+				if existing != card {
+					cards[i] = card
+					changed = true
+				}
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			cards = append(cards, card)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	// Strip blank padding cards, then pad the header to whole blocks
+	for len(cards) > 0 && strings.TrimSpace(cards[len(cards)-1]) == "" {
+		cards = cards[:len(cards)-1]
+	}
+	newHeader := strings.Join(cards, "") + fmt.Sprintf("%-80s", "END")
+	if pad := len(newHeader) % fitsBlockSize; pad != 0 {
+		newHeader += strings.Repeat(" ", fitsBlockSize-pad)
+	}
+
+	if len(newHeader) == headerSize {
+		if _, err := f.WriteAt([]byte(newHeader), 0); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return os.Chtimes(path, info.ModTime(), info.ModTime())
+	}
+
+	// The header grew by a block: write a new file next to the original and
+	// replace it, so an interruption never leaves a half-written frame
+	tmpPath := path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = out.WriteString(newHeader)
+	if err == nil {
+		_, err = io.Copy(out, f)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,33 @@
+package astrocam
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// heartbeat pings SAI_HEARTBEAT_URL after a completed program loop. An
+// external dead-man switch (healthchecks.io, Uptime Kuma push monitors, ...)
+// raises an alert when the pings stop, which also catches hangs and crashes
+// that the process cannot report itself. Failures are logged and ignored.
+func (ac *AstroCam) heartbeat() {
+	if ac.config.HeartbeatURL == "" {
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(ac.config.HeartbeatURL)
+	if err != nil {
+		slog.Warn("Heartbeat ping failed", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.Warn("Heartbeat ping rejected", "url", ac.config.HeartbeatURL, "status", resp.Status)
+		return
+	}
+	slog.Debug("Heartbeat ping sent")
+}
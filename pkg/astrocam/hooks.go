@@ -0,0 +1,184 @@
+package astrocam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_HOOK_TIMEOUT is the SAI_HOOK_TIMEOUT default.
+const DEFAULT_HOOK_TIMEOUT = 2 * time.Minute
+
+// uploadEvent describes an upload attempt for SAI_UPLOAD_HOOK and
+// SAI_UPLOAD_WEBHOOK.
+type uploadEvent struct {
+	Outcome string    `json:"outcome"` // outcomeUploaded or outcomeFailed
+	Archive string    `json:"archive"`
+	Area    string    `json:"area"`
+	Size    int64     `json:"size_bytes"`
+	Server  string    `json:"server"`
+	Station string    `json:"station,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// newUploadEvent describes the upload of archive, read before the archive is
+// deleted after a successful upload.
+func (ac *AstroCam) newUploadEvent(archive, server string) uploadEvent {
+	event := uploadEvent{Archive: filepath.Base(archive), Server: server, Station: ac.config.StationID}
+	if info, err := ac.fs.Stat(archive); err == nil {
+		event.Size = info.Size()
+	}
+	if meta, err := readArchiveMeta(archive); err == nil {
+		event.Area = meta.Area
+	}
+	return event
+}
+
+// uploadFinished runs SAI_UPLOAD_HOOK and notifies SAI_UPLOAD_WEBHOOK after
+// an upload attempt, so that sites can update their own bookkeeping. Both are
+// best effort: failures are logged and do not affect the archive.
+func (ac *AstroCam) uploadFinished(event uploadEvent, err error) {
+	if ac.config.UploadHook == "" && ac.config.UploadWebhook == "" {
+		return
+	}
+	event.Outcome = outcomeUploaded
+	if err != nil {
+		event.Outcome = outcomeFailed
+		event.Error = err.Error()
+	}
+	event.Time = ac.clock.Now()
+
+	if ac.config.UploadHook != "" {
+		args := []string{event.Outcome, event.Archive, event.Area, strconv.FormatInt(event.Size, 10)}
+		env := []string{
+			"ASTROCAM_OUTCOME=" + event.Outcome,
+			"ASTROCAM_ARCHIVE=" + event.Archive,
+			"ASTROCAM_AREA=" + event.Area,
+			"ASTROCAM_SIZE=" + strconv.FormatInt(event.Size, 10),
+			"ASTROCAM_SERVER=" + event.Server,
+			"ASTROCAM_STATION=" + event.Station,
+			"ASTROCAM_ERROR=" + event.Error,
+		}
+		if _, err := runHook(ac.config.UploadHook, args, env, ac.config.HookTimeout); err != nil {
+			slog.Warn("Upload hook failed", "hook", ac.config.UploadHook, "archive", event.Archive, "error", err)
+		}
+	}
+	if ac.config.UploadWebhook != "" {
+		ac.postUploadWebhook(event)
+	}
+}
+
+// postUploadWebhook POSTs an upload event as JSON to SAI_UPLOAD_WEBHOOK.
+func (ac *AstroCam) postUploadWebhook(event uploadEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Warn("Cannot encode upload webhook", "error", err)
+		return
+	}
+	req, err := http.NewRequest("POST", ac.config.UploadWebhook, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("Cannot create upload webhook request", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("Upload webhook failed", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.Warn("Upload webhook rejected", "url", ac.config.UploadWebhook, "status", resp.Status)
+	}
+}
+
+// runHook runs a hook command with args, adding env to the environment, and
+// returns its combined output. It is killed after timeout. A non-zero exit
+// status is returned as an *exec.ExitError wrapped with the output.
+func runHook(command string, args, env []string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := runChild(cmd)
+	out := strings.TrimSpace(string(output))
+	if ctx.Err() != nil {
+		return out, fmt.Errorf("killed after %s", timeout)
+	}
+	if err != nil && out != "" {
+		return out, fmt.Errorf("%w: %s", err, out)
+	}
+	return out, err
+}
+
+// preArchiveHook runs SAI_PRE_ARCHIVE_HOOK as HOOK AREA FRAME... before the
+// frames of an area are archived. Frames whose path or name the hook prints,
+// one per line, are vetoed: they are moved to the processed directory without
+// being archived. A non-zero exit status, or a hook that cannot run, stops
+// the archive; the frames stay and are offered again at the next scan.
+func (ac *AstroCam) preArchiveHook(area string, frames []string) (veto []string, ok bool) {
+	if ac.config.PreArchiveHook == "" {
+		return nil, true
+	}
+	env := []string{"ASTROCAM_HOOK=pre-archive", "ASTROCAM_AREA=" + area}
+	output, err := runHook(ac.config.PreArchiveHook, append([]string{area}, frames...), env, ac.config.HookTimeout)
+	if err != nil {
+		slog.Warn("Pre-archive hook stopped archiving, trying again at the next scan",
+			"hook", ac.config.PreArchiveHook, "area", area, "error", err)
+		return nil, false
+	}
+	named := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			named[line] = true
+		}
+	}
+	for _, frame := range frames {
+		if named[frame] || named[filepath.Base(frame)] {
+			slog.Warn("Frame vetoed by the pre-archive hook, it will not be uploaded", "file", filepath.Base(frame))
+			veto = append(veto, frame)
+		}
+	}
+	return veto, true
+}
+
+// preUploadHook runs SAI_PRE_UPLOAD_HOOK as HOOK ARCHIVE AREA before an
+// upload, e.g. to check that a VPN is up. It reports whether the upload may
+// go ahead: a non-zero exit status, or a hook that cannot run, keeps the
+// archive in the temp directory until the next scan.
+func (ac *AstroCam) preUploadHook(archive, server string) bool {
+	if ac.config.PreUploadHook == "" {
+		return true
+	}
+	var area string
+	if meta, err := readArchiveMeta(archive); err == nil {
+		area = meta.Area
+	}
+	env := []string{
+		"ASTROCAM_HOOK=pre-upload",
+		"ASTROCAM_ARCHIVE=" + filepath.Base(archive),
+		"ASTROCAM_AREA=" + area,
+		"ASTROCAM_SERVER=" + server,
+	}
+	if _, err := runHook(ac.config.PreUploadHook, []string{archive, area}, env, ac.config.HookTimeout); err != nil {
+		slog.Warn("Pre-upload hook stopped the upload, trying again at the next scan",
+			"hook", ac.config.PreUploadHook, "archive", filepath.Base(archive), "error", err)
+		return false
+	}
+	return true
+}
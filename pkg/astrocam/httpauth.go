@@ -0,0 +1,199 @@
+package astrocam
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Authentication methods of SAI_AUTH_METHOD.
+const (
+	authBasic  = "basic"
+	authDigest = "digest"
+	authNTLM   = "ntlm"
+)
+
+// authenticate adds the credentials to req with the configured method. Basic
+// credentials are sent right away; Digest and NTLM first ask the server for a
+// challenge with an empty request of the same method, so that the archive is
+// sent only once. A server that does not ask for credentials gets none.
+func (u *HTTPUploader) authenticate(client *http.Client, req *http.Request) error {
+	if !u.hasCredentials() {
+		return nil
+	}
+	switch u.AuthMethod {
+	case authDigest:
+		challenge, err := u.challenge(client, req, "Digest", "")
+		if err != nil || challenge == "" {
+			return err
+		}
+		authorization, err := digestAuthorization(challenge, u.Username, u.Password, req.Method, req.URL.RequestURI())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", authorization)
+	case authNTLM:
+		challenge, err := u.challenge(client, req, "NTLM", "NTLM "+base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()))
+		if err != nil || challenge == "" {
+			return err
+		}
+		message, err := base64.StdEncoding.DecodeString(challenge)
+		if err != nil {
+			return fmt.Errorf("invalid NTLM challenge: %w", err)
+		}
+		authenticate, err := ntlmAuthenticateMessage(message, u.Username, u.Password)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "NTLM "+base64.StdEncoding.EncodeToString(authenticate))
+	default:
+		req.SetBasicAuth(u.Username, u.Password)
+	}
+	return nil
+}
+
+// challenge sends an empty request like req with the given Authorization
+// header and returns the parameters of the server's WWW-Authenticate
+// challenge for scheme, or "" if the server accepted the request without
+// credentials. NTLM authenticates the connection, so the response is read to
+// the end to let the request that follows reuse it.
+func (u *HTTPUploader) challenge(client *http.Client, req *http.Request, scheme, authorization string) (string, error) {
+	probe, err := http.NewRequestWithContext(req.Context(), req.Method, req.URL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	probe.Header.Set("User-Agent", req.Header.Get("User-Agent"))
+	if authorization != "" {
+		probe.Header.Set("Authorization", authorization)
+	}
+	resp, err := client.Do(probe)
+	if err != nil {
+		return "", fmt.Errorf("%s authentication failed: %w", scheme, err)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return "", nil
+	}
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		name, params, _ := strings.Cut(strings.TrimSpace(header), " ")
+		if strings.EqualFold(name, scheme) && params != "" {
+			return strings.TrimSpace(params), nil
+		}
+	}
+	return "", &UploadRejectedError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       fmt.Sprintf("server does not offer %s authentication (offers %s)", scheme, strings.Join(resp.Header.Values("WWW-Authenticate"), "; ")),
+	}
+}
+
+// digestAuthorization answers a Digest challenge (RFC 7616) for a request
+// without integrity protection of the body (qop "auth").
+func digestAuthorization(challenge, username, password, method, uri string) (string, error) {
+	params := parseAuthParams(challenge)
+	realm, nonce := params["realm"], params["nonce"]
+	if nonce == "" {
+		return "", fmt.Errorf("invalid Digest challenge %q", challenge)
+	}
+
+	algorithm := params["algorithm"]
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("unsupported Digest algorithm %q", algorithm)
+	}
+	h := func(s string) string {
+		d := newHash()
+		io.WriteString(d, s)
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	cnonceBytes := make([]byte, 16)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	ha1 := h(username + ":" + realm + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	qop := ""
+	for _, q := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+	if params["qop"] != "" && qop == "" {
+		return "", fmt.Errorf("unsupported Digest qop %q", params["qop"])
+	}
+
+	const nc = "00000001"
+	var response string
+	if qop != "" {
+		response = h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q`, username, realm, nonce, uri, response)
+	if algorithm != "" {
+		fmt.Fprintf(&b, ", algorithm=%s", algorithm)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		fmt.Fprintf(&b, ", opaque=%q", opaque)
+	}
+	if qop != "" {
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce=%q`, qop, nc, cnonce)
+	}
+	return b.String(), nil
+}
+
+// parseAuthParams splits the key=value and key="quoted value" parameters of
+// a WWW-Authenticate challenge. Keys are lower-cased.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " \t")
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value.WriteString(strings.TrimSpace(rest[:end]))
+			s = rest[end:]
+		}
+		params[key] = value.String()
+	}
+}
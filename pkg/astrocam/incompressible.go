@@ -0,0 +1,76 @@
+package astrocam
+
+import (
+	"compress/flate"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// compressedExtensions are the extensions of files that are compressed
+// already, such as fpacked (.fz) and gzipped frames.
+var compressedExtensions = map[string]bool{
+	".fz": true, ".gz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".zip": true, ".rar": true, ".7z": true, ".jpg": true, ".jpeg": true, ".png": true,
+}
+
+const (
+	// compressSampleSize is how much of a frame is deflated on trial.
+	compressSampleSize = 256 << 10
+	// incompressibleRatio is the compressed size, as a fraction of the
+	// sample, above which deflating is not worth its CPU time.
+	incompressibleRatio = 0.95
+)
+
+// incompressible reports whether deflating filename would hardly make it
+// smaller: its extension says it is compressed already, or a sample from
+// the middle of the file, past the FITS header, barely shrinks when
+// deflated as the archive would. Frames that cannot be read are deflated;
+// the archiver reports the error.
+func incompressible(filename string) bool {
+	if compressedExtensions[strings.ToLower(filepath.Ext(filename))] {
+		return true
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.Size() < compressSampleSize {
+		return false
+	}
+
+	var compressed byteCounter
+	compressor, err := flate.NewWriter(&compressed, zipDeflateLevel)
+	if err != nil {
+		return false
+	}
+	sample := io.NewSectionReader(file, (info.Size()-compressSampleSize)/2, compressSampleSize)
+	n, err := io.Copy(compressor, sample)
+	if err != nil || compressor.Close() != nil || n == 0 {
+		return false
+	}
+	return float64(compressed) > float64(n)*incompressibleRatio
+}
+
+// byteCounter is an io.Writer counting what is written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// rarStoreTypes is the -ms switch making rar store the files compressedExtensions
+// lists instead of compressing them again.
+func rarStoreTypes() string {
+	var types []string
+	for ext := range compressedExtensions {
+		types = append(types, strings.TrimPrefix(ext, "."))
+	}
+	sort.Strings(types)
+	return "-ms" + strings.Join(types, ";")
+}
@@ -0,0 +1,129 @@
+package astrocam
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// journalDirName is the temp subdirectory holding the intents of archives
+// being completed.
+const journalDirName = "journal"
+
+// packIntent is written before a finished archive is moved into the temp
+// directory and removed once its frames have left the camera directory. If
+// the program dies in between, the archive is queued for upload but its
+// frames are still there and would be archived and uploaded again under a
+// new name; replayJournal completes the job at the next start instead. An
+// archive streamed to the server (SAI_STREAM_UPLOAD) is journaled once the
+// server confirmed it.
+type packIntent struct {
+	Archive  string         `json:"archive"`            // path in the temp directory
+	Frames   []string       `json:"frames"`             // frames to move to the processed directory
+	Records  []*stateRecord `json:"records"`            // state records of the archived frames
+	Uploaded bool           `json:"uploaded,omitempty"` // streamed to the server, never in the temp directory
+}
+
+// intentPath returns the journal file of an archive.
+func (ac *AstroCam) intentPath(archive string) string {
+	return filepath.Join(ac.tempDirectory, journalDirName, filepath.Base(archive)+".json")
+}
+
+// beginIntent writes the intent of an archive to the journal and syncs it
+// to disk, so that it survives a power failure.
+func (ac *AstroCam) beginIntent(intent *packIntent) error {
+	data, err := json.Marshal(intent)
+	if err != nil {
+		return err
+	}
+	path := ac.intentPath(intent.Archive)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path + partSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(path+partSuffix, path)
+	}
+	if err != nil {
+		os.Remove(path + partSuffix)
+	}
+	return err
+}
+
+// endIntent removes the intent of a completed archive from the journal.
+func (ac *AstroCam) endIntent(archive string) {
+	if err := os.Remove(ac.intentPath(archive)); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Cannot remove journal entry", "archive", filepath.Base(archive), "error", err)
+	}
+}
+
+// replayJournal completes the archives interrupted after they were moved into
+// the temp directory or streamed to the server: their frames are recorded and
+// moved to the processed directory. An intent whose archive never reached the
+// temp directory is dropped; its frames are still in the camera directory and
+// are packed again.
+func (ac *AstroCam) replayJournal() {
+	dir := filepath.Join(ac.tempDirectory, journalDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			os.Remove(path)
+			continue
+		}
+		data, err := os.ReadFile(path)
+		intent := &packIntent{}
+		if err == nil {
+			err = json.Unmarshal(data, intent)
+		}
+		if err != nil {
+			slog.Warn("Ignoring damaged journal entry", "file", entry.Name(), "error", err)
+			os.Remove(path)
+			continue
+		}
+
+		if _, err := os.Stat(intent.Archive); err != nil && !intent.Uploaded {
+			slog.Info("Archive interrupted before completion, its frames are packed again", "archive", filepath.Base(intent.Archive))
+			os.Remove(path)
+			continue
+		}
+		if intent.Uploaded {
+			slog.Warn("Completing archive interrupted after it was uploaded", "archive", filepath.Base(intent.Archive))
+		} else {
+			slog.Warn("Completing archive interrupted after it was created", "archive", filepath.Base(intent.Archive))
+		}
+		var missing []*stateRecord
+		for _, rec := range intent.Records {
+			if ac.state != nil && rec.ModTime != nil && !ac.state.hasFrame(rec) {
+				missing = append(missing, rec)
+			}
+		}
+		ac.appendFrames(missing)
+		var remaining []string
+		for _, frame := range intent.Frames {
+			if _, err := ac.fs.Stat(frame); err == nil {
+				remaining = append(remaining, frame)
+			}
+		}
+		if err := ac.moveImages(remaining); err != nil {
+			slog.Warn("Cannot move the frames of an interrupted archive", "archive", filepath.Base(intent.Archive), "error", err)
+			continue
+		}
+		os.Remove(path)
+	}
+}
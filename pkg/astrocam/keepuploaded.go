@@ -0,0 +1,152 @@
+package astrocam
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// keepUploaded moves an uploaded archive, with its metadata, into
+// SAI_UPLOADED_DIRECTORY instead of deleting it, and then prunes the
+// directory. It returns false when no copy is kept, so that the caller
+// deletes the archive as usual.
+func (ac *AstroCam) keepUploaded(archiveFile string) bool {
+	dir := ac.config.UploadedDirectory
+	if dir == "" {
+		return false
+	}
+	name := filepath.Base(archiveFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		slog.Warn("Cannot keep a copy of the uploaded archive, deleting it", "archive", name, "error", err)
+		return false
+	}
+	dst := filepath.Join(dir, name)
+	if err := ac.moveRecorded(archiveFile, dst); err != nil {
+		slog.Warn("Cannot keep a copy of the uploaded archive, deleting it", "archive", name, "error", err)
+		return false
+	}
+	// The age limit counts from the upload, not from the packing
+	now := ac.clock.Now()
+	os.Chtimes(dst, now, now)
+	if _, err := os.Stat(archiveMetaPath(archiveFile)); err == nil {
+		if err := moveFile(ac.fs, archiveMetaPath(archiveFile), archiveMetaPath(dst)); err != nil {
+			slog.Warn("Cannot move archive metadata along with the archive", "archive", name, "path", dst, "error", err)
+		}
+	}
+	slog.Debug("Kept a copy of the uploaded archive", "archive", name, "path", dst)
+	ac.pruneUploaded()
+	return true
+}
+
+// keptArchive is an archive in SAI_UPLOADED_DIRECTORY.
+type keptArchive struct {
+	path     string
+	size     int64
+	uploaded time.Time
+}
+
+// pruneUploaded deletes the archives of SAI_UPLOADED_DIRECTORY uploaded more
+// than SAI_UPLOADED_MAX_AGE ago, then the oldest ones until the rest fit in
+// SAI_UPLOADED_MAX_SIZE. It runs at startup and after every kept upload.
+// Only files with the extension of the archive format count, so that a
+// directory shared with other files never loses them.
+func (ac *AstroCam) pruneUploaded() {
+	dir := ac.config.UploadedDirectory
+	if dir == "" || (ac.config.UploadedMaxAge <= 0 && ac.config.UploadedMaxSize <= 0) {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var kept []keptArchive
+	var total int64
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), ac.archiver.Extension()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		kept = append(kept, keptArchive{filepath.Join(dir, entry.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].uploaded.Before(kept[j].uploaded) })
+
+	now := ac.clock.Now()
+	var removed int
+	var freed int64
+	for _, archive := range kept {
+		expired := ac.config.UploadedMaxAge > 0 && now.Sub(archive.uploaded) > ac.config.UploadedMaxAge
+		oversize := ac.config.UploadedMaxSize > 0 && total > ac.config.UploadedMaxSize
+		if !expired && !oversize {
+			break
+		}
+		if err := ac.removeRecorded(archive.path); err != nil {
+			slog.Warn("Cannot delete a kept copy of an uploaded archive", "archive", filepath.Base(archive.path), "error", err)
+			continue
+		}
+		removeArchiveMeta(archive.path)
+		total -= archive.size
+		freed += archive.size
+		removed++
+	}
+	if removed > 0 {
+		slog.Info("Deleted old copies of uploaded archives", "archives", removed, "freed", formatDataSize(freed),
+			"kept", len(kept)-removed, "kept_size", formatDataSize(total))
+	}
+}
+
+// prepareUploadedDirectory creates SAI_UPLOADED_DIRECTORY after making sure
+// that it does not overlap a directory of frames or queued archives.
+func prepareUploadedDirectory(config *Config, tempDir, failedDir, reviewDir string) error {
+	if config.UploadedDirectory == "" {
+		return nil
+	}
+	if dir := uploadedDirectoryOverlap(config.UploadedDirectory, config.CameraDirectory, config.ProcessedDirectory,
+		tempDir, failedDir, reviewDir); dir != "" {
+		return fmt.Errorf("SAI_UPLOADED_DIRECTORY %s overlaps %s, whose files would be deleted as old uploads", config.UploadedDirectory, dir)
+	}
+	if err := os.MkdirAll(config.UploadedDirectory, 0755); err != nil {
+		return fmt.Errorf("could not create uploaded directory: %w", err)
+	}
+	return nil
+}
+
+// uploadedDirectoryOverlap returns the first of dirs that SAI_UPLOADED_DIRECTORY
+// is, lies inside or contains, or "" if none. Kept archives are pruned, and
+// frames and queued archives must not be mistaken for them, nor kept copies
+// uploaded again.
+func uploadedDirectoryOverlap(uploaded string, dirs ...string) string {
+	for _, dir := range dirs {
+		if dir != "" && directoriesOverlap(uploaded, dir) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// directoriesOverlap reports whether two directories are the same or one
+// lies inside the other.
+func directoriesOverlap(a, b string) bool {
+	if sameDirectory(a, b) {
+		return true
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return pathWithin(absA, absB) || pathWithin(absB, absA)
+}
+
+// pathWithin reports whether path is dir or lies inside it.
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
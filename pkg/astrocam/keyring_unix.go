@@ -0,0 +1,55 @@
+//go:build !windows
+
+package astrocam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// keyringTimeout limits the keyring command, which may wait for the keyring
+// to be unlocked.
+const keyringTimeout = time.Minute
+
+// keyringPassword looks up a password in the login keyring: with the
+// security command on macOS, and through libsecret's secret-tool elsewhere.
+func keyringPassword(service, user string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyringTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		args := []string{"find-generic-password", "-s", service, "-w"}
+		if user != "" {
+			args = append(args, "-a", user)
+		}
+		cmd = exec.CommandContext(ctx, "security", args...)
+	} else {
+		args := []string{"lookup", "service", service}
+		if user != "" {
+			args = append(args, "username", user)
+		}
+		cmd = exec.CommandContext(ctx, "secret-tool", args...)
+	}
+
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("%s killed after %s", cmd.Path, keyringTimeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", fmt.Errorf("not found (%s: %s)", cmd.Path, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return "", err
+	}
+	password := strings.TrimRight(string(output), "\r\n")
+	if password == "" {
+		return "", errors.New("not found")
+	}
+	return password, nil
+}
@@ -0,0 +1,76 @@
+//go:build windows
+
+package astrocam
+
+import (
+	"errors"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// credential mirrors the Win32 CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+const credTypeGeneric = 1
+
+// keyringPassword reads a generic credential from the Windows Credential
+// Manager, as stored by "cmdkey /generic:astrocam-go /user:NAME /pass".
+// The user name is not checked: the target name identifies the entry.
+func keyringPassword(service, user string) (string, error) {
+	advapi32, err := syscall.LoadDLL("advapi32.dll")
+	if err != nil {
+		return "", err
+	}
+	defer advapi32.Release()
+
+	credRead, err := advapi32.FindProc("CredReadW")
+	if err != nil {
+		return "", err
+	}
+	credFree, err := advapi32.FindProc("CredFree")
+	if err != nil {
+		return "", err
+	}
+
+	target, err := syscall.UTF16PtrFromString(service)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+	ret, _, callErr := credRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0,
+		uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		return "", callErr
+	}
+	defer credFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.CredentialBlobSize == 0 || cred.CredentialBlob == nil {
+		return "", errors.New("credential has no password")
+	}
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+
+	// cmdkey and the Credential Manager store the password as UTF-16
+	if len(blob)%2 == 0 {
+		chars := make([]uint16, len(blob)/2)
+		for i := range chars {
+			chars[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+		}
+		return string(utf16.Decode(chars)), nil
+	}
+	return string(blob), nil
+}
@@ -0,0 +1,142 @@
+package astrocam
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// localeFiles holds the translations of the log messages shipped with the
+// program, one file per language named after its code, e.g. locales/ru.txt.
+//
+//go:embed locales/*.txt
+var localeFiles embed.FS
+
+// messageCatalog maps English log messages to the language of the operator.
+// It is empty for English, and replaced as a whole when the configuration is
+// (re)loaded, before SetupLogging.
+var messageCatalog atomic.Pointer[map[string]string]
+
+// translate returns message in the language of the operator, or unchanged
+// when it has no translation. The log kept in memory is in English, so that
+// what is sent to the server reads the same from every station; the
+// dashboards translate it when showing it.
+func translate(message string) string {
+	if catalog := messageCatalog.Load(); catalog != nil {
+		if translation, ok := (*catalog)[message]; ok {
+			return translation
+		}
+	}
+	return message
+}
+
+// setLanguage loads the translations of SAI_LANGUAGE, or of the language of
+// the system when it is not set, and of SAI_LANGUAGE_FILE.
+func setLanguage(config *Config) {
+	lang := normalizeLanguage(config.Language)
+	if lang == "" {
+		lang = normalizeLanguage(systemLanguage())
+	}
+	catalog, err := loadCatalog(lang, config.LanguageFile)
+	if err != nil {
+		slog.Warn("Cannot read SAI_LANGUAGE_FILE", "path", config.LanguageFile, "error", err)
+	}
+	if len(catalog) == 0 && config.Language != "" && lang != "en" && config.LanguageFile == "" {
+		slog.Warn("No translations for SAI_LANGUAGE, using English", "value", config.Language)
+	}
+	messageCatalog.Store(&catalog)
+}
+
+// normalizeLanguage reduces a locale such as "ru_RU.UTF-8" or "es-ES" to its
+// language code. "C" and "POSIX" are English.
+func normalizeLanguage(locale string) string {
+	lang := strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(lang, "_-.@"); i >= 0 {
+		lang = lang[:i]
+	}
+	if lang == "c" || lang == "posix" {
+		return "en"
+	}
+	return lang
+}
+
+// loadCatalog returns the shipped translations of lang with the lines of
+// file, if any, added on top.
+func loadCatalog(lang, file string) (map[string]string, error) {
+	catalog := make(map[string]string)
+	if data, err := localeFiles.ReadFile("locales/" + lang + ".txt"); err == nil {
+		parseCatalog(data, catalog)
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return catalog, err
+		}
+		parseCatalog(data, catalog)
+	}
+	return catalog, nil
+}
+
+// parseCatalog adds the "English message = translation" lines of data to
+// catalog, skipping blank lines and # comments.
+func parseCatalog(data []byte, catalog map[string]string) {
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		message, translation, ok := strings.Cut(line, " = ")
+		if !ok {
+			continue
+		}
+		message, translation = strings.TrimSpace(message), strings.TrimSpace(translation)
+		if message != "" && translation != "" {
+			catalog[message] = translation
+		}
+	}
+}
+
+// checkLanguageFile reports whether file can be read and how many
+// translations it holds, for check-config.
+func checkLanguageFile(file string) (int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	catalog := make(map[string]string)
+	parseCatalog(data, catalog)
+	if len(catalog) == 0 {
+		return 0, fmt.Errorf("no \"message = translation\" lines in %s", file)
+	}
+	return len(catalog), nil
+}
+
+// translatingHandler prints log messages in the language of catalog. The
+// keys and values of the attributes stay as they are, so that the lines can
+// still be searched for with grep.
+type translatingHandler struct {
+	slog.Handler
+	catalog map[string]string
+}
+
+func (h translatingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if translation, ok := h.catalog[record.Message]; ok {
+		record.Message = translation
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h translatingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return translatingHandler{h.Handler.WithAttrs(attrs), h.catalog}
+}
+
+func (h translatingHandler) WithGroup(name string) slog.Handler {
+	return translatingHandler{h.Handler.WithGroup(name), h.catalog}
+}
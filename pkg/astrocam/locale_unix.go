@@ -0,0 +1,15 @@
+//go:build !windows
+
+package astrocam
+
+import "os"
+
+// systemLanguage returns the locale of the messages set in the environment.
+func systemLanguage() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
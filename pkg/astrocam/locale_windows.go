@@ -0,0 +1,15 @@
+//go:build windows
+
+package astrocam
+
+import "golang.org/x/sys/windows"
+
+// systemLanguage returns the display language chosen by the user, e.g.
+// "ru-RU".
+func systemLanguage() string {
+	languages, err := windows.GetUserPreferredUILanguages(windows.MUI_LANGUAGE_NAME)
+	if err != nil || len(languages) == 0 {
+		return ""
+	}
+	return languages[0]
+}
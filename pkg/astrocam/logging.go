@@ -0,0 +1,223 @@
+package astrocam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the minimum level of messages that are printed. It starts at
+// info and is adjusted once SAI_LOG_LEVEL has been read from config.env.
+var logLevel = new(slog.LevelVar)
+
+// SetLogLevel sets the minimum level of printed messages until config.env
+// is read, e.g. for -quiet, which also passes it on as SAI_LOG_LEVEL.
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
+// logOutput is where log messages are written: stdout, or a log file when
+// running as a Windows service without a console.
+var logOutput io.Writer = os.Stdout
+
+// SetLogOutput redirects log messages, e.g. to a file when there is no
+// console. SetupLogging must be called again for it to take effect.
+func SetLogOutput(w io.Writer) {
+	logOutput = w
+}
+
+// logColor makes the text log on stdout print warnings in yellow and errors
+// in red.
+var logColor bool
+
+// SetLogColor turns the colors of warnings and errors on stdout on or off,
+// for a terminal that understands ANSI escape sequences. SetupLogging must be
+// called again for it to take effect.
+func SetLogColor(on bool) {
+	logColor = on
+}
+
+// SetupLogging installs the default structured logger writing to logOutput.
+// The format is "text" (key=value lines) or "json" (one object per line,
+// for shipping logs to a central store). It is called once at startup with
+// the text format and again after SAI_LOG_FORMAT has been read.
+func SetupLogging(format string) {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(logOutput, opts)
+	} else if logColor && logOutput == io.Writer(os.Stdout) {
+		handler = levelColorHandler{
+			plain: slog.NewTextHandler(logOutput, opts),
+			warn:  slog.NewTextHandler(colorWriter{logOutput, "33"}, opts),
+			error: slog.NewTextHandler(colorWriter{logOutput, "31"}, opts),
+		}
+	} else {
+		handler = slog.NewTextHandler(logOutput, opts)
+	}
+	if catalog := messageCatalog.Load(); format != "json" && catalog != nil && len(*catalog) > 0 {
+		handler = translatingHandler{handler, *catalog}
+	}
+	slog.SetDefault(slog.New(ringHandler{Handler: handler, ring: recentProblems, log: recentLog}))
+}
+
+// levelColorHandler prints warnings and errors through handlers that color
+// them.
+type levelColorHandler struct {
+	plain, warn, error slog.Handler
+}
+
+func (h levelColorHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.plain.Enabled(ctx, level)
+}
+
+func (h levelColorHandler) Handle(ctx context.Context, record slog.Record) error {
+	switch {
+	case record.Level >= slog.LevelError:
+		return h.error.Handle(ctx, record)
+	case record.Level >= slog.LevelWarn:
+		return h.warn.Handle(ctx, record)
+	}
+	return h.plain.Handle(ctx, record)
+}
+
+func (h levelColorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelColorHandler{h.plain.WithAttrs(attrs), h.warn.WithAttrs(attrs), h.error.WithAttrs(attrs)}
+}
+
+func (h levelColorHandler) WithGroup(name string) slog.Handler {
+	return levelColorHandler{h.plain.WithGroup(name), h.warn.WithGroup(name), h.error.WithGroup(name)}
+}
+
+// colorWriter writes each log line in an ANSI color. The slog handlers
+// write one whole line per call.
+type colorWriter struct {
+	w     io.Writer
+	color string
+}
+
+func (c colorWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	if _, err := fmt.Fprintf(c.w, "\x1b[%sm%s\x1b[0m\n", c.color, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// recentProblems keeps the latest warnings and errors for the status dashboard.
+var recentProblems = &logRing{limit: 20}
+
+// recentLog keeps the latest printed messages of any level, for the
+// upload-log server command.
+var recentLog = &logRing{limit: 1000}
+
+// logEntry is a warning or error message with its attributes flattened.
+type logEntry struct {
+	Time    time.Time
+	Level   string
+	Message string
+}
+
+// logRing is a bounded, concurrency-safe list of recent log entries.
+type logRing struct {
+	mu      sync.Mutex
+	limit   int
+	entries []logEntry
+}
+
+func (r *logRing) add(entry logEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+	if len(r.entries) > r.limit {
+		r.entries = r.entries[len(r.entries)-r.limit:]
+	}
+}
+
+// list returns the entries, newest first.
+func (r *logRing) list() []logEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := make([]logEntry, len(r.entries))
+	for i, entry := range r.entries {
+		entries[len(entries)-1-i] = entry
+	}
+	return entries
+}
+
+// ringHandler passes records on to the wrapped handler and copies warnings
+// and errors into ring, and every record into log.
+type ringHandler struct {
+	slog.Handler
+	ring  *logRing
+	log   *logRing
+	attrs []slog.Attr
+}
+
+func (h ringHandler) Handle(ctx context.Context, record slog.Record) error {
+	var sb strings.Builder
+	sb.WriteString(record.Message)
+	for _, attr := range h.attrs {
+		sb.WriteString(" " + attr.String())
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		sb.WriteString(" " + attr.String())
+		return true
+	})
+	entry := logEntry{Time: record.Time, Level: record.Level.String(), Message: sb.String()}
+	if record.Level >= slog.LevelWarn {
+		h.ring.add(entry)
+	}
+	h.log.add(entry)
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h ringHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return ringHandler{
+		Handler: h.Handler.WithAttrs(attrs),
+		ring:    h.ring,
+		log:     h.log,
+		attrs:   append(append([]slog.Attr(nil), h.attrs...), attrs...),
+	}
+}
+
+func (h ringHandler) WithGroup(name string) slog.Handler {
+	return ringHandler{Handler: h.Handler.WithGroup(name), ring: h.ring, log: h.log, attrs: h.attrs}
+}
+
+// parseLogLevel converts a SAI_LOG_LEVEL value (debug, info, warn, error).
+func parseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", value)
+}
+
+// testFatal logs a fatal error and exits with status 1. It is only used in
+// test mode, where any failure must stop the run.
+func (ac *AstroCam) testFatal(msg string, args ...any) {
+	slog.Error("FATAL ERROR (Test Mode): "+msg, args...)
+	os.Exit(1)
+}
+
+// baseNames returns the basenames of a list of paths, for compact log fields.
+func baseNames(paths []string) []string {
+	names := make([]string, len(paths))
+	for i, path := range paths {
+		names[i] = filepath.Base(path)
+	}
+	return names
+}
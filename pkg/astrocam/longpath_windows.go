@@ -0,0 +1,29 @@
+//go:build windows
+
+package astrocam
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxShortPath is the longest directory path the Windows API accepts without
+// the \\?\ prefix: MAX_PATH (260) less room for an 8.3 file name.
+const maxShortPath = 248
+
+// longPath returns path in the \\?\ form for direct Windows API calls, which
+// otherwise fail beyond MAX_PATH. The os package does this by itself for
+// absolute paths.
+func longPath(path string) string {
+	if len(path) < maxShortPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,71 @@
+package astrocam
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// archiveMeta describes the contents of an archive. It is stored next to the
+// archive in the temp directory as "<archive>.json" so it survives restarts,
+// and is sent to the server as the "metadata" form field on upload.
+type archiveMeta struct {
+	Area    string                     `json:"area"`
+	Kind    string                     `json:"kind,omitempty"` // "" for science, "calibration"
+	Frames  []string                   `json:"frames"`
+	Created time.Time                  `json:"created"`
+	Quality map[string]*frameQuality   `json:"quality,omitempty"` // by frame basename
+	Weather map[string]*weatherReading `json:"weather,omitempty"` // cloud sensor reading nearest to each frame, by frame basename
+}
+
+// archiveMetaPath returns the path of the metadata sidecar of an archive.
+func archiveMetaPath(archiveFile string) string {
+	return archiveFile + ".json"
+}
+
+// writeArchiveMeta stores the metadata sidecar of an archive. It is written
+// as "<sidecar>.part" and renamed when complete, so a crash never leaves a
+// truncated sidecar to be sent with the archive.
+func writeArchiveMeta(archiveFile string, meta *archiveMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := archiveMetaPath(archiveFile)
+	if err := os.WriteFile(path+partSuffix, data, 0644); err != nil {
+		os.Remove(path + partSuffix)
+		return err
+	}
+	return os.Rename(path+partSuffix, path)
+}
+
+// partSuffix marks a file in the temp directory that is still being written.
+const partSuffix = ".part"
+
+// readArchiveMetaRaw returns the metadata sidecar of an archive as stored, or
+// nil if the archive has none (e.g. created by an older version).
+func readArchiveMetaRaw(archiveFile string) []byte {
+	data, err := os.ReadFile(archiveMetaPath(archiveFile))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// readArchiveMeta parses the metadata sidecar of an archive.
+func readArchiveMeta(archiveFile string) (*archiveMeta, error) {
+	data, err := os.ReadFile(archiveMetaPath(archiveFile))
+	if err != nil {
+		return nil, err
+	}
+	meta := &archiveMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// removeArchiveMeta deletes the metadata sidecar of an archive, if any.
+func removeArchiveMeta(archiveFile string) {
+	os.Remove(archiveMetaPath(archiveFile))
+}
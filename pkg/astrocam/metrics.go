@@ -0,0 +1,254 @@
+package astrocam
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// areaActivity is the frame and archive history of one area, kept by the
+// runtime status for /metrics, /api/status and the stale-area alarm.
+type areaActivity struct {
+	LastFrame   time.Time // modification time of the newest frame seen
+	LastArchive time.Time // when the last archive of the area was created
+	Stale       bool      // the stale-area alarm is raised
+}
+
+// frameSeen records the modification time of the newest waiting frame of area.
+func (s *runtimeStatus) frameSeen(area string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.activity(area)
+	if t.After(a.LastFrame) {
+		a.LastFrame = t
+	}
+}
+
+// areaArchived records that an archive of area was created at t.
+func (s *runtimeStatus) areaArchived(area string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activity(area).LastArchive = t
+	s.archives++
+}
+
+// activity returns the entry of area, creating it; the caller holds s.mu.
+func (s *runtimeStatus) activity(area string) *areaActivity {
+	a := s.areas[area]
+	if a == nil {
+		a = &areaActivity{}
+		s.areas[area] = a
+	}
+	return a
+}
+
+// areaReport is the activity of an area as served on /api/status.
+type areaReport struct {
+	LastFrame        *time.Time `json:"last_frame,omitempty"`
+	LastArchive      *time.Time `json:"last_archive,omitempty"`
+	SecondsSinceLast int64      `json:"seconds_since_last_frame,omitempty"`
+	Stale            bool       `json:"stale"`
+}
+
+// areaReports returns the activity of all areas seen since the start.
+func (ac *AstroCam) areaReports() map[string]areaReport {
+	ac.status.mu.Lock()
+	defer ac.status.mu.Unlock()
+	reports := make(map[string]areaReport, len(ac.status.areas))
+	for area, a := range ac.status.areas {
+		r := areaReport{LastFrame: timePtr(a.LastFrame), LastArchive: timePtr(a.LastArchive), Stale: a.Stale}
+		if !a.LastFrame.IsZero() {
+			r.SecondsSinceLast = int64(ac.since(a.LastFrame).Seconds())
+		}
+		reports[area] = r
+	}
+	return reports
+}
+
+// checkStaleAreas raises an alarm for an area that produced frames earlier in
+// the night but none for SAI_STALE_AREA_AFTER, e.g. because a stuck filter
+// wheel ruins one field while the others go on. It only watches within
+// SAI_STALE_AREA_HOURS or, without them, while some other area still produces
+// frames, so that the end of the night does not raise alarms. While
+// SAI_ROOF_SOURCE reports the roof closed no alarms are raised, and after it
+// opens the areas are given SAI_STALE_AREA_AFTER to produce frames again. The
+// alarm is logged and sent to SAI_NOTIFY_URL once, and cleared when frames
+// return.
+func (ac *AstroCam) checkStaleAreas() {
+	limit := ac.config.StaleAreaAfter
+	if limit <= 0 {
+		return
+	}
+	now := ac.clock.Now()
+	watching := ac.config.StaleAreaHours.active(now)
+	night := nightStart(now.Add(-nightStartHour * time.Hour))
+	roofShut, roofOpened := ac.roofShut()
+
+	var raised, cleared []string
+	ac.status.mu.Lock()
+	var newest time.Time
+	for _, a := range ac.status.areas {
+		if a.LastFrame.After(newest) {
+			newest = a.LastFrame
+		}
+	}
+	if len(ac.config.StaleAreaHours) == 0 {
+		watching = now.Sub(newest) <= limit
+	}
+	// An unreachable camera directory was reported once; its areas are not
+	watching = watching && !roofShut && !ac.cameraUnreachable.Load()
+	for area, a := range ac.status.areas {
+		silent := !a.LastFrame.Before(night) && now.Sub(a.LastFrame) > limit && now.Sub(roofOpened) > limit
+		switch {
+		case silent && watching && !a.Stale:
+			a.Stale = true
+			raised = append(raised, area)
+		case !silent && a.Stale:
+			a.Stale = false
+			if now.Sub(a.LastFrame) <= limit {
+				cleared = append(cleared, area)
+			}
+		}
+	}
+	lastFrames := make(map[string]time.Time, len(raised))
+	for _, area := range raised {
+		lastFrames[area] = ac.status.areas[area].LastFrame
+	}
+	ac.status.mu.Unlock()
+
+	sort.Strings(raised)
+	for _, area := range raised {
+		last := lastFrames[area]
+		slog.Warn("Area has stopped producing frames", "area", area, "last_frame", last.Format("15:04:05"), "silent_for", now.Sub(last).Round(time.Minute))
+		ac.notify("no frames for area "+area, fmt.Sprintf("Area %s has produced no frames since %s (%s). Other areas are still observing; check the camera, filter wheel and scheduler.",
+			area, last.Format("2006-01-02 15:04"), now.Sub(last).Round(time.Minute)))
+	}
+	sort.Strings(cleared)
+	for _, area := range cleared {
+		slog.Info("Area is producing frames again", "area", area)
+	}
+}
+
+// handleMetrics serves the pipeline state in the Prometheus text format.
+// Times are Unix timestamps, so that the time since the last frame of an area
+// is time() - astrocam_area_last_frame_timestamp_seconds.
+func (ac *AstroCam) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	ac.configMu.RLock()
+	report := ac.healthReport()
+	ac.configMu.RUnlock()
+
+	var b strings.Builder
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	timestamp := func(t *time.Time) float64 {
+		if t == nil {
+			return 0
+		}
+		return float64(t.Unix())
+	}
+
+	metric("astrocam_uptime_seconds", "gauge", "Time since the uploader started.")
+	fmt.Fprintf(&b, "astrocam_uptime_seconds %d\n", report.UptimeSeconds)
+	metric("astrocam_uploads_total", "counter", "Archives uploaded since the start.")
+	fmt.Fprintf(&b, "astrocam_uploads_total %d\n", report.Uploads)
+	metric("astrocam_upload_errors_total", "counter", "Failed upload attempts since the start.")
+	fmt.Fprintf(&b, "astrocam_upload_errors_total %d\n", report.UploadErrors)
+	metric("astrocam_pending_archives", "gauge", "Archives waiting in the temp directory.")
+	fmt.Fprintf(&b, "astrocam_pending_archives %d\n", report.PendingArchives)
+	metric("astrocam_last_upload_timestamp_seconds", "gauge", "Time of the last successful upload (0 = none).")
+	fmt.Fprintf(&b, "astrocam_last_upload_timestamp_seconds %.0f\n", timestamp(report.LastUpload))
+	metric("astrocam_stuck", "gauge", "1 when the pipeline has made no progress for too long.")
+	fmt.Fprintf(&b, "astrocam_stuck %d\n", boolMetric(report.Status == "stuck"))
+	metric("astrocam_camera_unreachable", "gauge", "1 while the camera directory cannot be read.")
+	fmt.Fprintf(&b, "astrocam_camera_unreachable %d\n", boolMetric(report.CameraUnreachable))
+	metric("astrocam_uploaded_today_bytes", "gauge", "Bytes of the upload attempts made today, local time.")
+	fmt.Fprintf(&b, "astrocam_uploaded_today_bytes %d\n", report.UploadedToday)
+	if report.DailyUploadLimit > 0 {
+		metric("astrocam_daily_upload_limit_bytes", "gauge", "SAI_DAILY_UPLOAD_LIMIT in bytes.")
+		fmt.Fprintf(&b, "astrocam_daily_upload_limit_bytes %d\n", report.DailyUploadLimit)
+		metric("astrocam_daily_upload_limit_reached", "gauge", "1 while uploads wait for the daily upload limit to reset.")
+		fmt.Fprintf(&b, "astrocam_daily_upload_limit_reached %d\n", boolMetric(report.UploadLimitHit))
+	}
+	metric("astrocam_disk_free_bytes", "gauge", "Free space on the volume of a directory.")
+	for _, volume := range sortedKeys(report.DiskFreeBytes) {
+		fmt.Fprintf(&b, "astrocam_disk_free_bytes{volume=%q} %d\n", volume, report.DiskFreeBytes[volume])
+	}
+
+	ac.status.mu.Lock()
+	counts := make(map[string]int, len(ac.status.areaCounts))
+	for area, n := range ac.status.areaCounts {
+		counts[area] = n
+	}
+	ac.status.mu.Unlock()
+	areas := ac.areaReports()
+
+	metric("astrocam_area_frames_waiting", "gauge", "Frames of an area waiting in the camera directory at the last scan.")
+	for _, area := range sortedKeys(counts) {
+		fmt.Fprintf(&b, "astrocam_area_frames_waiting{area=%q} %d\n", area, counts[area])
+	}
+	names := sortedKeys(areas)
+	metric("astrocam_area_last_frame_timestamp_seconds", "gauge", "Modification time of the newest frame seen for an area.")
+	for _, area := range names {
+		fmt.Fprintf(&b, "astrocam_area_last_frame_timestamp_seconds{area=%q} %.0f\n", area, timestamp(areas[area].LastFrame))
+	}
+	metric("astrocam_area_last_archive_timestamp_seconds", "gauge", "Time the last archive of an area was created (0 = none).")
+	for _, area := range names {
+		fmt.Fprintf(&b, "astrocam_area_last_archive_timestamp_seconds{area=%q} %.0f\n", area, timestamp(areas[area].LastArchive))
+	}
+	metric("astrocam_area_stale", "gauge", "1 while the stale-area alarm of an area is raised.")
+	for _, area := range names {
+		fmt.Fprintf(&b, "astrocam_area_stale{area=%q} %d\n", area, boolMetric(areas[area].Stale))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkStaleFrames warns when the oldest waiting frame of an area has been in
+// the camera directory for longer than SAI_STALE_FRAME_AFTER: a group that
+// never fills up, or a frame whose name sorts it out of its sequence. The
+// warning is also sent to SAI_NOTIFY_URL, once until the frames are gone. It
+// reports whether the frames are to be packed anyway (SAI_STALE_FRAME_FLUSH).
+func (ac *AstroCam) checkStaleFrames(area string, count int, oldest time.Time) bool {
+	limit := ac.config.StaleFrameAfter
+	if limit <= 0 {
+		return false
+	}
+	stale := count > 0 && !oldest.IsZero() && ac.since(oldest) > limit
+	if !stale {
+		delete(ac.staleFrames, area)
+		return false
+	}
+	if !ac.staleFrames[area] {
+		ac.staleFrames[area] = true
+		age := ac.since(oldest).Round(time.Minute)
+		slog.Warn("Frames are waiting in the camera directory for too long", "area", area, "count", count, "oldest_age", age)
+		action := "Check for an incomplete group or misnamed frames."
+		if ac.config.StaleFrameFlush {
+			action = "They are packed as an incomplete group."
+		}
+		ac.notify("frames of area "+area+" left behind", fmt.Sprintf("%d frame(s) of area %s have been waiting in the camera directory for up to %s. %s",
+			count, area, age, action))
+	}
+	return ac.config.StaleFrameFlush
+}
@@ -0,0 +1,127 @@
+package astrocam
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// releasePublicKey is the minisign public key the official releases sign
+// their checksums.txt with, in the format of a minisign .pub file. The
+// release workflow refuses to publish a release that it does not verify.
+//
+//go:embed release.pub
+var releasePublicKey string
+
+const (
+	minisignAlgorithm       = "Ed" // signature of the file itself (minisign -l)
+	minisignPrehashed       = "ED" // signature of the BLAKE2b-512 of the file, the default
+	minisignUntrustedPrefix = "untrusted comment:"
+	minisignTrustedPrefix   = "trusted comment: "
+)
+
+// minisignKey is a minisign public key.
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+// updatePublicKey returns the key that updates must be signed with:
+// SAI_UPDATE_PUBKEY, for releases from another SAI_RELEASES_URL, or else the
+// key of the official releases built into the program. It returns nil while
+// release.pub holds no key and SAI_UPDATE_PUBKEY is not set; updates are then
+// checked against checksums.txt only.
+func updatePublicKey(config *Config) (*minisignKey, error) {
+	if config.UpdatePublicKey != "" {
+		key, err := parseMinisignKey(config.UpdatePublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SAI_UPDATE_PUBKEY: %w", err)
+		}
+		return key, nil
+	}
+	if minisignKeyLine(releasePublicKey) == "" {
+		return nil, nil
+	}
+	return parseMinisignKey(releasePublicKey)
+}
+
+// minisignKeyLine returns the base64 line of a minisign key or signature
+// file, skipping its untrusted comment.
+func minisignKeyLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, minisignUntrustedPrefix) {
+			return line
+		}
+	}
+	return ""
+}
+
+// parseMinisignKey reads a minisign public key: the key itself, the
+// contents of its .pub file, or the name of that file.
+func parseMinisignKey(text string) (*minisignKey, error) {
+	if data, err := os.ReadFile(text); err == nil {
+		text = string(data)
+	}
+	raw, err := base64.StdEncoding.DecodeString(minisignKeyLine(text))
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != minisignAlgorithm {
+		return nil, errors.New("not a minisign public key")
+	}
+	key := &minisignKey{key: ed25519.PublicKey(raw[10:])}
+	copy(key.id[:], raw[2:10])
+	return key, nil
+}
+
+// ID returns the key ID as minisign prints it.
+func (k *minisignKey) ID() string {
+	id := k.id
+	for i, j := 0, len(id)-1; i < j; i, j = i+1, j-1 {
+		id[i], id[j] = id[j], id[i]
+	}
+	return strings.ToUpper(hex.EncodeToString(id[:]))
+}
+
+// Verify checks the minisign signature, the contents of a .minisig file, of
+// message, including its trusted comment, as minisign -V does.
+func (k *minisignKey) Verify(message, signature []byte) error {
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], minisignUntrustedPrefix) || !strings.HasPrefix(lines[2], minisignTrustedPrefix) {
+		return errors.New("not a minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return errors.New("not a minisign signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errors.New("not a minisign signature")
+	}
+	if !bytes.Equal(raw[2:10], k.id[:]) {
+		return fmt.Errorf("signed with another key than %s", k.ID())
+	}
+	switch string(raw[:2]) {
+	case minisignAlgorithm:
+	case minisignPrehashed:
+		sum := blake2b.Sum512(message)
+		message = sum[:]
+	default:
+		return fmt.Errorf("unknown minisign signature algorithm %q", raw[:2])
+	}
+	sig := raw[10:]
+	if !ed25519.Verify(k.key, message, sig) {
+		return errors.New("signature does not verify")
+	}
+	trusted := append(append([]byte{}, sig...), strings.TrimPrefix(lines[2], minisignTrustedPrefix)...)
+	if !ed25519.Verify(k.key, trusted, global) {
+		return errors.New("trusted comment does not verify")
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package astrocam
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// isMountPoint reports whether dir is the root of a mounted file system: its
+// device differs from that of its parent directory.
+func isMountPoint(dir string) (bool, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return false, err
+	}
+	parent, err := os.Stat(filepath.Dir(dir))
+	if err != nil {
+		return false, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	pst, pok := parent.Sys().(*syscall.Stat_t)
+	if !ok || !pok {
+		return false, fmt.Errorf("cannot tell whether %s is mounted", dir)
+	}
+	return st.Dev != pst.Dev || st.Ino == pst.Ino, nil
+}
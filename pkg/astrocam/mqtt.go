@@ -0,0 +1,357 @@
+package astrocam
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 packet types, shifted into the first byte of a packet
+const (
+	mqttConnect   = 1 << 4
+	mqttConnack   = 2 << 4
+	mqttPublish   = 3 << 4
+	mqttPuback    = 4 << 4
+	mqttSubscribe = 8<<4 | 2
+	mqttSuback    = 9 << 4
+	mqttPingreq   = 12 << 4
+)
+
+const (
+	// mqttKeepAlive is the keep-alive interval announced to the broker;
+	// a ping is sent at half of it.
+	mqttKeepAlive = 60 * time.Second
+	// mqttRetry is the wait before reconnecting to a broker.
+	mqttRetry = 30 * time.Second
+	// mqttFirstMessage is how long a new subscriber waits for the retained
+	// message when it is read.
+	mqttFirstMessage = 5 * time.Second
+)
+
+// mqttSubscriber keeps the latest message published on one MQTT topic, given
+// as mqtt://[user:password@]host[:port]/topic (mqtts:// for TLS). It is a
+// minimal MQTT 3.1.1 client subscribing with QoS 0: the broker's retained
+// message arrives right after connecting, so a sensor publishing with the
+// retain flag is known at once. Lost connections are re-established in the
+// background until close is called.
+type mqttSubscriber struct {
+	broker   *url.URL
+	topic    string
+	clientID string
+	started  time.Time
+	first    chan struct{} // closed when the first message arrives
+
+	mu       sync.Mutex
+	payload  []byte    // latest message (nil = none yet)
+	received time.Time // when it arrived
+	err      error     // why the last connection ended
+	conn     net.Conn
+	closed   bool
+
+	writeMu sync.Mutex // serializes pings and acknowledgements
+}
+
+// newMQTTSubscriber starts following the topic of broker. clientID
+// identifies the connection to the broker.
+func newMQTTSubscriber(broker *url.URL, clientID string) (*mqttSubscriber, error) {
+	topic := strings.TrimPrefix(broker.Path, "/")
+	if topic == "" || broker.Host == "" {
+		return nil, fmt.Errorf("MQTT source %s needs a broker and a topic, e.g. mqtt://broker:1883/observatory/weather", broker.Redacted())
+	}
+	s := &mqttSubscriber{broker: broker, topic: topic, clientID: clientID, started: time.Now(), first: make(chan struct{})}
+	go s.run()
+	return s, nil
+}
+
+// read returns the latest message on the topic and when it arrived. Right
+// after subscribing it waits up to mqttFirstMessage for the retained message.
+func (s *mqttSubscriber) read(ctx context.Context) ([]byte, time.Time, error) {
+	select {
+	case <-s.first:
+	case <-ctx.Done():
+	case <-time.After(time.Until(s.started.Add(mqttFirstMessage))):
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.payload == nil {
+		if s.err != nil {
+			return nil, time.Time{}, fmt.Errorf("no message on MQTT topic %s yet: %w", s.topic, s.err)
+		}
+		return nil, time.Time{}, fmt.Errorf("no message on MQTT topic %s yet", s.topic)
+	}
+	return s.payload, s.received, nil
+}
+
+// close ends the subscription.
+func (s *mqttSubscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+func (s *mqttSubscriber) String() string {
+	return s.broker.Redacted()
+}
+
+// run keeps a session with the broker open until close is called.
+func (s *mqttSubscriber) run() {
+	for {
+		err := s.session()
+		s.mu.Lock()
+		closed := s.closed
+		s.err = err
+		s.mu.Unlock()
+		if closed {
+			return
+		}
+		slog.Warn("MQTT connection lost, reconnecting", "broker", s.broker.Host, "topic", s.topic, "error", err, "retry_in", mqttRetry)
+		time.Sleep(mqttRetry)
+	}
+}
+
+// session connects, subscribes and receives messages until the connection
+// fails.
+func (s *mqttSubscriber) session() error {
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return errors.New("subscription closed")
+	}
+	s.conn = conn
+	s.mu.Unlock()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err := s.write(conn, mqttConnect, s.connectPacket()); err != nil {
+		return err
+	}
+	kind, body, err := readMQTTPacket(r)
+	if err != nil {
+		return err
+	}
+	if kind != mqttConnack || len(body) < 2 {
+		return fmt.Errorf("unexpected answer to MQTT connect (packet type %d)", kind>>4)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("MQTT broker refused the connection (return code %d%s)", body[1], mqttRefusal(body[1]))
+	}
+	subscribe := binary.BigEndian.AppendUint16(nil, 1)
+	subscribe = appendMQTTString(subscribe, s.topic)
+	subscribe = append(subscribe, 0) // QoS 0
+	if err := s.write(conn, mqttSubscribe, subscribe); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.ping(conn, done)
+	for {
+		conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+		kind, body, err := readMQTTPacket(r)
+		if err != nil {
+			return err
+		}
+		switch kind & 0xF0 {
+		case mqttSuback:
+			if len(body) >= 3 && body[2] == 0x80 {
+				return fmt.Errorf("MQTT broker refused the subscription to %s", s.topic)
+			}
+			slog.Debug("Subscribed to MQTT topic", "broker", s.broker.Host, "topic", s.topic)
+		case mqttPublish:
+			if err := s.handlePublish(conn, kind, body); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handlePublish stores the message of a PUBLISH packet, acknowledging it if
+// the broker sent it with QoS 1.
+func (s *mqttSubscriber) handlePublish(conn net.Conn, kind byte, body []byte) error {
+	if len(body) < 2 {
+		return errors.New("malformed MQTT message")
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+n {
+		return errors.New("malformed MQTT message")
+	}
+	payload := body[2+n:]
+	if qos := kind >> 1 & 3; qos > 0 {
+		if len(payload) < 2 {
+			return errors.New("malformed MQTT message")
+		}
+		id := payload[:2]
+		payload = payload[2:]
+		if qos == 1 {
+			if err := s.write(conn, mqttPuback, id); err != nil {
+				return err
+			}
+		}
+	}
+	s.mu.Lock()
+	if s.payload == nil {
+		close(s.first)
+	}
+	s.payload = append([]byte{}, payload...)
+	s.received = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// ping keeps the connection alive until done is closed.
+func (s *mqttSubscriber) ping(conn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(mqttKeepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if s.write(conn, mqttPingreq, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *mqttSubscriber) dial() (net.Conn, error) {
+	host := s.broker.Host
+	secure := strings.EqualFold(s.broker.Scheme, "mqtts")
+	if s.broker.Port() == "" {
+		port := "1883"
+		if secure {
+			port = "8883"
+		}
+		host = net.JoinHostPort(s.broker.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if secure {
+		return tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: s.broker.Hostname()})
+	}
+	return dialer.Dial("tcp", host)
+}
+
+// connectPacket returns the CONNECT packet without its fixed header: a
+// clean session, with the credentials of the broker URL if it has any.
+func (s *mqttSubscriber) connectPacket() []byte {
+	flags := byte(0x02)
+	user := s.broker.User
+	password, hasPassword := "", false
+	if user != nil {
+		flags |= 0x80
+		if password, hasPassword = user.Password(); hasPassword {
+			flags |= 0x40
+		}
+	}
+	p := appendMQTTString(nil, "MQTT")
+	p = append(p, 4, flags)
+	p = binary.BigEndian.AppendUint16(p, uint16(mqttKeepAlive/time.Second))
+	p = appendMQTTString(p, s.clientID)
+	if user != nil {
+		p = appendMQTTString(p, user.Username())
+	}
+	if hasPassword {
+		p = appendMQTTString(p, password)
+	}
+	return p
+}
+
+// write sends one packet.
+func (s *mqttSubscriber) write(conn net.Conn, kind byte, body []byte) error {
+	packet := []byte{kind}
+	for n := len(body); ; {
+		b := byte(n & 0x7F)
+		n >>= 7
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := conn.Write(append(packet, body...))
+	return err
+}
+
+// mqttMaxPacket limits the messages accepted from the broker; sensor
+// readings are far smaller.
+const mqttMaxPacket = 1 << 20
+
+// readMQTTPacket reads one packet and returns its first byte and body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := 0
+	for shift := 0; ; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if shift > 21 {
+			return 0, nil, errors.New("malformed MQTT packet length")
+		}
+		length |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > mqttMaxPacket {
+		return 0, nil, fmt.Errorf("MQTT packet of %d bytes is too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return kind, body, nil
+}
+
+func appendMQTTString(p []byte, s string) []byte {
+	p = binary.BigEndian.AppendUint16(p, uint16(len(s)))
+	return append(p, s...)
+}
+
+// mqttRefusal explains the return code of a refused connection.
+func mqttRefusal(code byte) string {
+	switch code {
+	case 4:
+		return ": bad user name or password"
+	case 5:
+		return ": not authorized"
+	}
+	return ""
+}
+
+// mqttClientID identifies a connection of this station to the broker.
+func mqttClientID(config *Config, purpose string) string {
+	id := "astrocam"
+	if config.StationID != "" {
+		id += "-" + config.StationID
+	}
+	return fmt.Sprintf("%s-%s-%d", id, purpose, os.Getpid())
+}
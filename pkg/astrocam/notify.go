@@ -0,0 +1,39 @@
+package astrocam
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notify sends an operator notification as a plain-text HTTP POST to
+// SAI_NOTIFY_URL (works with ntfy.sh-style push services and simple webhooks).
+// Notifications are best effort: a failure is logged and otherwise ignored.
+func (ac *AstroCam) notify(subject, message string) {
+	if ac.config.NotifyURL == "" {
+		return
+	}
+
+	req, err := http.NewRequest("POST", ac.config.NotifyURL, strings.NewReader(message))
+	if err != nil {
+		slog.Warn("Cannot create notification request", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Title", "AstroCam: "+subject)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("Notification failed", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.Warn("Notification rejected", "url", ac.config.NotifyURL, "status", resp.Status)
+	}
+}
@@ -0,0 +1,217 @@
+package astrocam
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM (MS-NLMP) as offered by IIS "Windows Authentication" alongside
+// Negotiate. Only NTLMv2 responses are sent; the session key and message
+// signing are not needed for authenticating an HTTP request.
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+const (
+	ntlmNegotiateUnicode          = 0x00000001
+	ntlmNegotiateOEM              = 0x00000002
+	ntlmRequestTarget             = 0x00000004
+	ntlmNegotiateNTLM             = 0x00000200
+	ntlmNegotiateAlwaysSign       = 0x00008000
+	ntlmNegotiateExtendedSecurity = 0x00080000
+	ntlmNegotiateTargetInfo       = 0x00800000
+	ntlmNegotiate128              = 0x20000000
+	ntlmNegotiate56               = 0x80000000
+
+	ntlmNegotiateFlags = ntlmNegotiateUnicode | ntlmNegotiateOEM | ntlmRequestTarget | ntlmNegotiateNTLM |
+		ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSecurity | ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+
+	ntlmAvEOL       = 0
+	ntlmAvTimestamp = 7
+)
+
+// ntlmNegotiateMessage returns the first message of the handshake.
+func ntlmNegotiateMessage() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateFlags)
+	return msg // empty domain and workstation fields
+}
+
+// ntlmAuthenticateMessage answers the server's challenge message with an
+// NTLMv2 response. username may be written DOMAIN\user or user@domain, split
+// at the last @.
+func ntlmAuthenticateMessage(challenge []byte, username, password string) ([]byte, error) {
+	if len(challenge) < 32 || !bytes.Equal(challenge[:8], ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("invalid NTLM challenge message")
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:])
+	serverChallenge := challenge[24:32]
+	var targetInfo []byte
+	if len(challenge) >= 48 {
+		// Checked in uint64: an offset of 2^31 or more is negative as an
+		// int on 32-bit systems
+		length := uint64(binary.LittleEndian.Uint16(challenge[40:]))
+		offset := uint64(binary.LittleEndian.Uint32(challenge[44:]))
+		if offset+length > uint64(len(challenge)) {
+			return nil, errors.New("invalid NTLM challenge message: target info out of range")
+		}
+		targetInfo = challenge[offset : offset+length]
+	}
+
+	domain, user := "", username
+	if d, u, ok := strings.Cut(username, `\`); ok {
+		domain, user = d, u
+	} else if at := strings.LastIndex(username, "@"); at >= 0 {
+		domain, user = username[at+1:], username[:at]
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+	timestamp, serverTime := ntlmAvPair(targetInfo, ntlmAvTimestamp)
+	if !serverTime {
+		timestamp = make([]byte, 8)
+		binary.LittleEndian.PutUint64(timestamp, ntlmFileTime(time.Now()))
+	}
+
+	// NTOWFv2 and the NTLMv2 response of MS-NLMP 3.3.2
+	ntHash := md4Sum(utf16LE(password))
+	responseKey := hmacMD5(ntHash[:], utf16LE(strings.ToUpper(user)+domain))
+	var blob bytes.Buffer
+	blob.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+	blob.Write(timestamp)
+	blob.Write(clientChallenge)
+	blob.Write([]byte{0, 0, 0, 0})
+	blob.Write(targetInfo)
+	blob.Write([]byte{0, 0, 0, 0})
+	ntProof := hmacMD5(responseKey, serverChallenge, blob.Bytes())
+	ntResponse := append(ntProof, blob.Bytes()...)
+	lmResponse := make([]byte, 24) // all zero when the server sent its time
+	if !serverTime {
+		lmResponse = append(hmacMD5(responseKey, serverChallenge, clientChallenge), clientChallenge...)
+	}
+
+	encode := func(s string) []byte {
+		if flags&ntlmNegotiateUnicode != 0 {
+			return utf16LE(s)
+		}
+		return []byte(s)
+	}
+	payloads := [][]byte{lmResponse, ntResponse, encode(domain), encode(user), encode(""), nil}
+	const headerSize = 64
+	msg := make([]byte, headerSize)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	offset := headerSize
+	for i, payload := range payloads {
+		field := msg[12+8*i:]
+		binary.LittleEndian.PutUint16(field[0:], uint16(len(payload)))
+		binary.LittleEndian.PutUint16(field[2:], uint16(len(payload)))
+		binary.LittleEndian.PutUint32(field[4:], uint32(offset))
+		offset += len(payload)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags&ntlmNegotiateFlags)
+	for _, payload := range payloads {
+		msg = append(msg, payload...)
+	}
+	return msg, nil
+}
+
+// ntlmAvPair returns the value of an attribute of the target information.
+func ntlmAvPair(info []byte, id uint16) ([]byte, bool) {
+	for len(info) >= 4 {
+		avID := binary.LittleEndian.Uint16(info)
+		length := int(binary.LittleEndian.Uint16(info[2:]))
+		if avID == ntlmAvEOL || 4+length > len(info) {
+			break
+		}
+		if avID == id {
+			return info[4 : 4+length], true
+		}
+		info = info[4+length:]
+	}
+	return nil, false
+}
+
+// ntlmFileTime returns t in tenths of microseconds since 1601, as Windows
+// FILETIME.
+func ntlmFileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func utf16LE(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(b[2*i:], u)
+	}
+	return b
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// md4Sum returns the MD4 digest of data (RFC 1320), which NTLM uses for the
+// password hash and the standard library does not provide.
+func md4Sum(data []byte) [16]byte {
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+
+	msg := append([]byte(nil), data...)
+	msg = append(msg, 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(len(data))*8)
+
+	f := func(x, y, z uint32) uint32 { return x&y | ^x&z }
+	g := func(x, y, z uint32) uint32 { return x&y | x&z | y&z }
+	h := func(x, y, z uint32) uint32 { return x ^ y ^ z }
+	var x [16]uint32
+	for block := msg; len(block) >= 64; block = block[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(block[4*i:])
+		}
+		aa, bb, cc, dd := a, b, c, d
+		for _, i := range []uint{0, 4, 8, 12} {
+			a = bits.RotateLeft32(a+f(b, c, d)+x[i], 3)
+			d = bits.RotateLeft32(d+f(a, b, c)+x[i+1], 7)
+			c = bits.RotateLeft32(c+f(d, a, b)+x[i+2], 11)
+			b = bits.RotateLeft32(b+f(c, d, a)+x[i+3], 19)
+		}
+		for _, i := range []uint{0, 1, 2, 3} {
+			a = bits.RotateLeft32(a+g(b, c, d)+x[i]+0x5a827999, 3)
+			d = bits.RotateLeft32(d+g(a, b, c)+x[i+4]+0x5a827999, 5)
+			c = bits.RotateLeft32(c+g(d, a, b)+x[i+8]+0x5a827999, 9)
+			b = bits.RotateLeft32(b+g(c, d, a)+x[i+12]+0x5a827999, 13)
+		}
+		for _, i := range []uint{0, 2, 1, 3} {
+			a = bits.RotateLeft32(a+h(b, c, d)+x[i]+0x6ed9eba1, 3)
+			d = bits.RotateLeft32(d+h(a, b, c)+x[i+8]+0x6ed9eba1, 9)
+			c = bits.RotateLeft32(c+h(d, a, b)+x[i+4]+0x6ed9eba1, 11)
+			b = bits.RotateLeft32(b+h(c, d, a)+x[i+12]+0x6ed9eba1, 15)
+		}
+		a, b, c, d = a+aa, b+bb, c+cc, d+dd
+	}
+
+	var sum [16]byte
+	binary.LittleEndian.PutUint32(sum[0:], a)
+	binary.LittleEndian.PutUint32(sum[4:], b)
+	binary.LittleEndian.PutUint32(sum[8:], c)
+	binary.LittleEndian.PutUint32(sum[12:], d)
+	return sum
+}
@@ -0,0 +1,55 @@
+package astrocam
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// pauseFileName is the control file in the temp directory that pauses the
+// pipeline for as long as it exists.
+const pauseFileName = "PAUSE"
+
+// Pause stops packing and uploading until Resume, like POST /api/pause. An
+// upload in progress is finished first; frames and archives not yet handled
+// stay where they are and are picked up again after Resume.
+func (ac *AstroCam) Pause() {
+	ac.setOperatorPause(true)
+}
+
+// Resume continues after Pause.
+func (ac *AstroCam) Resume() {
+	ac.setOperatorPause(false)
+}
+
+// Paused reports whether the pipeline is paused by Pause or the status
+// server. The PAUSE file pauses it independently of that (see
+// checkPauseFile).
+func (ac *AstroCam) Paused() bool {
+	return ac.operatorPaused.Load()
+}
+
+// TriggerScan makes the running main loop scan immediately instead of
+// waiting for the next tick, like POST /api/trigger.
+func (ac *AstroCam) TriggerScan() {
+	ac.requestScan()
+}
+
+// checkPauseFile pauses the pipeline when a PAUSE file appears in the temp
+// directory and resumes it when the file is removed. It runs at the start of
+// every scan, so a change takes effect within one scan interval. The file is
+// a pause of its own: removing it does not end a pause set by the operator,
+// nor does Resume end the file's.
+func (ac *AstroCam) checkPauseFile() {
+	path := filepath.Join(ac.tempDirectory, pauseFileName)
+	_, err := os.Stat(path)
+	present := err == nil
+	if ac.pauseFile.Swap(present) == present {
+		return
+	}
+	if present {
+		slog.Info("Pause file found, pipeline paused", "file", path)
+	} else {
+		slog.Info("Pause file removed, pipeline resumed", "file", path)
+	}
+}
@@ -0,0 +1,332 @@
+package astrocam
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The work is split into three stages connected by channels so that a long
+// upload (throttled to one every SAI_UPLOAD_THROTTLE) does not hold up
+// scanning and packing:
+//
+//	scanner (Run's loop) --packQueue--> packer --uploadQueue--> uploader
+//
+// The scanner groups frames and finds archives left in the temp directory;
+// the packer archives groups and moves the frames to the processed directory;
+// the uploader sends archives and deletes them once the server confirmed
+// them. With SAI_STREAM_UPLOAD the packer sends the archives itself while
+// writing them. Both wait for the upload throttle of the destination. Each area and archive is queued at most once until its stage is done
+// with it, so a job still waiting from an earlier scan is not queued again.
+// Jobs of priority areas go through separate queues that each stage empties
+// first, so they overtake a backlog of survey fields.
+
+// pipelineQueueSize bounds the pack and upload queues. Work that does not fit
+// is found again by a later scan.
+const pipelineQueueSize = 256
+
+// partialDirName is the temp subdirectory archives are written to until
+// they are complete.
+const partialDirName = "partial"
+
+// packJob is a group of frames for the packer to archive.
+type packJob struct {
+	area     string
+	kind     string // "" for science frames, "calibration" otherwise
+	group    *FileGroup
+	priority bool // the area is in SAI_PRIORITY_AREAS
+}
+
+// pipeline holds the queues between the stages and what is in them.
+type pipeline struct {
+	packQueue      chan packJob
+	uploadQueue    chan string
+	priorityPack   chan packJob // groups of priority areas
+	priorityUpload chan string  // archives of priority areas
+
+	mu        sync.Mutex
+	packing   map[string]bool // areas queued for or being packed
+	uploading map[string]bool // archives queued for or being uploaded
+}
+
+func newPipeline() *pipeline {
+	return &pipeline{
+		packQueue:      make(chan packJob, pipelineQueueSize),
+		uploadQueue:    make(chan string, pipelineQueueSize),
+		priorityPack:   make(chan packJob, pipelineQueueSize),
+		priorityUpload: make(chan string, pipelineQueueSize),
+		packing:        make(map[string]bool),
+		uploading:      make(map[string]bool),
+	}
+}
+
+// enqueuePack hands a group to the packer. It returns false if the area is
+// already queued or the queue is full.
+func (p *pipeline) enqueuePack(job packJob) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.packing[job.area] {
+		return false
+	}
+	queue := p.packQueue
+	if job.priority {
+		queue = p.priorityPack
+	}
+	select {
+	case queue <- job:
+		p.packing[job.area] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueueUpload hands an archive to the uploader, ahead of the others if
+// priority is set. It returns false if the archive is already queued, no
+// longer exists or the queue is full. The uploader deletes an archive before
+// uploadDone, so one the scanner listed just before its upload finished is
+// not queued again.
+func (p *pipeline) enqueueUpload(archive string, priority bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.uploading[archive] {
+		return false
+	}
+	if _, err := os.Stat(archive); err != nil {
+		return false
+	}
+	queue := p.uploadQueue
+	if priority {
+		queue = p.priorityUpload
+	}
+	select {
+	case queue <- archive:
+		p.uploading[archive] = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *pipeline) packDone(area string) {
+	p.mu.Lock()
+	delete(p.packing, area)
+	p.mu.Unlock()
+}
+
+func (p *pipeline) uploadDone(archive string) {
+	p.mu.Lock()
+	delete(p.uploading, archive)
+	p.mu.Unlock()
+}
+
+// idle reports whether no group or archive is waiting or being worked on.
+func (p *pipeline) idle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.packing) == 0 && len(p.uploading) == 0
+}
+
+// startPipeline starts the packer and uploader. They return once stop is
+// closed, after finishing the job in hand; the returned WaitGroup is done
+// when both have.
+func (ac *AstroCam) startPipeline(stop <-chan struct{}) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ac.packStage(stop)
+	}()
+	go func() {
+		defer wg.Done()
+		ac.uploadStage(stop)
+	}()
+	return &wg
+}
+
+// packStage archives the groups queued by the scanner and queues the archives
+// for upload.
+func (ac *AstroCam) packStage(stop <-chan struct{}) {
+	p := ac.pipeline
+	for {
+		var job packJob
+		select {
+		case job = <-p.priorityPack:
+		default:
+			select {
+			case <-stop:
+				return
+			case job = <-p.priorityPack:
+			case job = <-p.packQueue:
+			}
+		}
+		if stopped(stop) || !ac.runPackJob(stop, job) {
+			return
+		}
+	}
+}
+
+// runPackJob archives a group taken from the pack queue and queues the
+// archive for upload. A crash is reported and the packer goes on with the
+// next job. It returns false if stop was closed while waiting to upload.
+func (ac *AstroCam) runPackJob(stop <-chan struct{}, job packJob) (ok bool) {
+	p := ac.pipeline
+	defer p.packDone(job.area)
+	defer ac.recoverPanic("packer")
+	ok = true
+
+	ac.jobsMu.RLock()
+	stream := ac.config.StreamUpload && streamUnsupported(ac.config, ac.archiver) == ""
+	destination := kindDestination(ac.config, job.kind)
+	ac.jobsMu.RUnlock()
+	// The packer uploads too, so it waits for the throttle like the uploader
+	if stream && !ac.waitForUploadThrottle(stop, destination) {
+		return false
+	}
+	var archiveFile string
+	var batched bool
+	func() {
+		defer ac.watchdog.begin("packer")()
+		ac.status.setPacking(job.area)
+		defer ac.status.setPacking("")
+		ac.jobsMu.RLock()
+		defer ac.jobsMu.RUnlock()
+		archiveFile = ac.packJob(job, stream)
+		batched = ac.config.UploadInterval > 0 || ac.config.UploadBatch > 0
+	}()
+	// Batched archives wait in the temp directory for the scanner, and
+	// one whose streaming failed for its retry
+	if archiveFile != "" && !batched && ac.uploadRetryDue(archiveFile) {
+		p.enqueueUpload(archiveFile, job.priority)
+	}
+	return true
+}
+
+// packJob archives one group and returns the archive path, or "" if no
+// archive was created or it was streamed to the server.
+func (ac *AstroCam) packJob(job packJob, stream bool) string {
+	archiveFile, err := ac.packGroup(job.area, job.kind, job.group, stream)
+	if err != nil {
+		slog.Error("Error processing area", "area", job.area, "error", err)
+		ac.status.recordError(err)
+		return ""
+	}
+	if archiveFile == ERROR {
+		slog.Error("Archive creation failed", "area", job.area)
+		return ""
+	}
+	if archiveFile == EMPTY {
+		return ""
+	}
+	if archiveFile == STREAMED {
+		ac.status.areaArchived(job.area, ac.clock.Now())
+		return ""
+	}
+	slog.Info("Archive created", "area", job.area, "archive", filepath.Base(archiveFile))
+	ac.status.areaArchived(job.area, ac.clock.Now())
+	return archiveFile
+}
+
+// uploadStage uploads the queued archives, one per upload throttle period.
+func (ac *AstroCam) uploadStage(stop <-chan struct{}) {
+	p := ac.pipeline
+	for {
+		var archiveFile string
+		select {
+		case archiveFile = <-p.priorityUpload:
+		default:
+			select {
+			case <-stop:
+				return
+			case archiveFile = <-p.priorityUpload:
+			case archiveFile = <-p.uploadQueue:
+			}
+		}
+		if stopped(stop) {
+			return
+		}
+		ac.runUploadJob(stop, archiveFile)
+	}
+}
+
+// runUploadJob uploads an archive taken from the upload queue. A crash is
+// reported and the uploader goes on with the next archive.
+func (ac *AstroCam) runUploadJob(stop <-chan struct{}, archiveFile string) {
+	defer ac.pipeline.uploadDone(archiveFile)
+	defer ac.recoverPanic("uploader")
+
+	// A paused archive, one packed outside SAI_UPLOAD_HOURS or while the
+	// server is unreachable, stays in the temp directory and is queued
+	// again by a later scan
+	ac.jobsMu.RLock()
+	server := ac.archiveServer(archiveFile)
+	ac.jobsMu.RUnlock()
+	if !ac.isUploadPaused() && !ac.offline.Load() && ac.uploadHoursActive() && ac.waitForUploadThrottle(stop, server) && ac.turns.acquire(ac.profile, stop) {
+		defer ac.turns.release()
+		defer ac.watchdog.begin("uploader")()
+		ac.status.setUploading(filepath.Base(archiveFile))
+		defer ac.status.setUploading("")
+		ac.jobsMu.RLock()
+		defer ac.jobsMu.RUnlock()
+		ac.makeJobForArchive(archiveFile)
+	}
+}
+
+// uploadBatchInterval returns the time between upload batches, or 0 if each
+// archive is uploaded when created.
+func (ac *AstroCam) uploadBatchInterval() time.Duration {
+	if ac.config.UploadInterval == 0 && ac.config.UploadBatch > 0 {
+		return DEFAULT_BATCH_WAIT
+	}
+	return ac.config.UploadInterval
+}
+
+// uploadBatchDue reports whether the scanner should queue the waiting
+// archives now: always without batching, otherwise once SAI_UPLOAD_INTERVAL
+// has passed since the last batch or SAI_UPLOAD_BATCH archives are waiting.
+// A batch uploads every waiting archive, SAI_UPLOAD_THROTTLE apart.
+func (ac *AstroCam) uploadBatchDue(waiting int) bool {
+	interval := ac.uploadBatchInterval()
+	if interval == 0 {
+		return true
+	}
+	full := ac.config.UploadBatch > 0 && waiting >= ac.config.UploadBatch
+	if !full && !ac.lastUploadBatch.IsZero() && ac.since(ac.lastUploadBatch) < interval {
+		slog.Debug("Archives wait for the next upload batch", "waiting", waiting,
+			"next_batch", ac.lastUploadBatch.Add(interval).Format("15:04:05"))
+		return false
+	}
+	return true
+}
+
+// stopped reports whether stop is closed. A stage checks it after receiving a
+// job, because select picks at random when a job and stop are both ready.
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitForUploadThrottle ensures SAI_UPLOAD_THROTTLE (120 seconds by default,
+// varied by SAI_JITTER), or the throttle of its upload policy, between upload
+// attempts to destination. It returns false if stop was closed while waiting.
+func (ac *AstroCam) waitForUploadThrottle(stop <-chan struct{}, destination string) bool {
+	ac.jobsMu.RLock()
+	uploadThrottleDelay := jitter(ac.config.uploadPolicy(destination).Throttle, ac.config.Jitter)
+	ac.jobsMu.RUnlock()
+
+	if waitTime := ac.throttle.reserve(destination, ac.clock.Now(), uploadThrottleDelay); waitTime > 0 {
+		slog.Info("Upload throttling: waiting before next upload attempt", "wait", waitTime.Round(time.Second), "server", destination)
+		select {
+		case <-ac.clock.After(waitTime):
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,260 @@
+package astrocam
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default settings for preview generation
+const (
+	DEFAULT_PREVIEW_SIZE = 1024 // longest side of the preview in pixels
+	previewSampleSize    = 100000
+)
+
+// samplePixels returns up to n finite pixel values taken evenly across the image, sorted.
+func samplePixels(pixels []float32, n int) []float32 {
+	step := len(pixels) / n
+	if step < 1 {
+		step = 1
+	}
+	sample := make([]float32, 0, len(pixels)/step+1)
+	for i := 0; i < len(pixels); i += step {
+		v := pixels[i]
+		if !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0) {
+			sample = append(sample, v)
+		}
+	}
+	sort.Slice(sample, func(i, j int) bool { return sample[i] < sample[j] })
+	return sample
+}
+
+// percentile returns the value at fraction p (0..1) of a sorted sample.
+func percentile(sorted []float32, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p * float64(len(sorted)-1))
+	return float64(sorted[idx])
+}
+
+// zscaleLimits computes display limits with the IRAF zscale algorithm: a line
+// is fitted to the sorted sample (with iterative sigma clipping) and its
+// slope, divided by the contrast, defines the range around the median.
+func zscaleLimits(sorted []float32) (float64, float64) {
+	const contrast = 0.25
+	n := len(sorted)
+	if n < 2 {
+		return percentile(sorted, 0), percentile(sorted, 1)
+	}
+	zmin, zmax := float64(sorted[0]), float64(sorted[n-1])
+	median := percentile(sorted, 0.5)
+	center := float64(n-1) / 2
+
+	keep := make([]bool, n)
+	for i := range keep {
+		keep[i] = true
+	}
+	var slope, intercept float64
+	for iter := 0; iter < 5; iter++ {
+		var sx, sy, sxx, sxy, cnt float64
+		for i, v := range sorted {
+			if !keep[i] {
+				continue
+			}
+			x := float64(i)
+			sx += x
+			sy += float64(v)
+			sxx += x * x
+			sxy += x * float64(v)
+			cnt++
+		}
+		den := cnt*sxx - sx*sx
+		if cnt < float64(n)/2 || den == 0 {
+			break
+		}
+		slope = (cnt*sxy - sx*sy) / den
+		intercept = (sy - slope*sx) / cnt
+
+		var ss float64
+		for i, v := range sorted {
+			if keep[i] {
+				r := float64(v) - (intercept + slope*float64(i))
+				ss += r * r
+			}
+		}
+		sigma := math.Sqrt(ss / cnt)
+		rejected := 0
+		for i, v := range sorted {
+			if keep[i] && math.Abs(float64(v)-(intercept+slope*float64(i))) > 2.5*sigma {
+				keep[i] = false
+				rejected++
+			}
+		}
+		if rejected == 0 {
+			break
+		}
+	}
+
+	z1 := math.Max(zmin, median-center*slope/contrast)
+	z2 := math.Min(zmax, median+center*slope/contrast)
+	return z1, z2
+}
+
+// renderPreview produces a stretched 8-bit preview of a frame, binned down so
+// that its longest side does not exceed maxSize. The stretch is "asinh"
+// (default) or "zscale".
+func renderPreview(img *fitsImage, maxSize int, stretch string) *image.Gray {
+	factor := 1
+	for (img.Width+factor-1)/factor > maxSize || (img.Height+factor-1)/factor > maxSize {
+		factor++
+	}
+	outW := (img.Width + factor - 1) / factor
+	outH := (img.Height + factor - 1) / factor
+
+	// Bin the image by averaging factor x factor blocks
+	binned := make([]float32, outW*outH)
+	for oy := 0; oy < outH; oy++ {
+		for ox := 0; ox < outW; ox++ {
+			var sum float64
+			var cnt int
+			for y := oy * factor; y < (oy+1)*factor && y < img.Height; y++ {
+				for x := ox * factor; x < (ox+1)*factor && x < img.Width; x++ {
+					v := float64(img.Pixels[y*img.Width+x])
+					if !math.IsNaN(v) {
+						sum += v
+						cnt++
+					}
+				}
+			}
+			if cnt > 0 {
+				binned[oy*outW+ox] = float32(sum / float64(cnt))
+			}
+		}
+	}
+
+	sample := samplePixels(binned, previewSampleSize)
+	var lo, hi float64
+	if stretch == "zscale" {
+		lo, hi = zscaleLimits(sample)
+	} else {
+		lo, hi = percentile(sample, 0.01), percentile(sample, 0.998)
+	}
+	if hi <= lo {
+		hi = lo + 1
+	}
+
+	const asinhSoftening = 10.0
+	out := image.NewGray(image.Rect(0, 0, outW, outH))
+	for oy := 0; oy < outH; oy++ {
+		// FITS images are stored bottom row first, flip for display
+		row := outH - 1 - oy
+		for ox := 0; ox < outW; ox++ {
+			x := (float64(binned[row*outW+ox]) - lo) / (hi - lo)
+			x = math.Max(0, math.Min(1, x))
+			if stretch != "zscale" {
+				x = math.Asinh(asinhSoftening*x) / math.Asinh(asinhSoftening)
+			}
+			out.SetGray(ox, oy, color.Gray{Y: uint8(x*255 + 0.5)})
+		}
+	}
+	return out
+}
+
+// writePreview renders the preview of a frame into dir and returns its path.
+func (ac *AstroCam) writePreview(frame string, img *fitsImage, dir string) (string, error) {
+	preview := renderPreview(img, ac.config.PreviewSize, ac.config.PreviewStretch)
+
+	base := strings.TrimSuffix(filepath.Base(frame), filepath.Ext(frame))
+	ext := ".png"
+	if ac.config.PreviewFormat == "jpeg" {
+		ext = ".jpg"
+	}
+	path := filepath.Join(dir, base+ext)
+
+	out, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if ext == ".jpg" {
+		err = jpeg.Encode(out, preview, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(out, preview)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// uploadPreview posts a preview image to SAI_PREVIEW_URL using the configured
+// credentials. There is no throttling or retry: previews are best effort.
+func (ac *AstroCam) uploadPreview(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return err
+	}
+	writer.Close()
+
+	req, err := http.NewRequest("POST", ac.config.PreviewURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if ac.hasCredentials() {
+		req.SetBasicAuth(ac.config.Username, ac.config.Password)
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("preview server returned %s", resp.Status)
+	}
+	return nil
+}
+
+// uploadPreviews sends previews to the preview endpoint and removes them.
+func (ac *AstroCam) uploadPreviews(previews []string) {
+	for _, path := range previews {
+		if err := ac.uploadPreview(path); err != nil {
+			slog.Warn("Preview upload failed", "file", filepath.Base(path), "error", err)
+		} else {
+			slog.Info("Preview uploaded", "file", filepath.Base(path))
+		}
+		os.Remove(path)
+	}
+}
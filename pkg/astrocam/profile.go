@@ -0,0 +1,57 @@
+package astrocam
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// profileNamePattern restricts profile names to characters that are safe in
+// file names on every platform.
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var (
+	profileMu sync.Mutex
+	profile   string
+)
+
+// SetProfile selects a named configuration profile: config.NAME.env and
+// areas.NAME.txt are read instead of config.env and areas.txt, and the temp
+// directory and instance lock get the same suffix, so that several profiles
+// can run side by side from one folder. An empty name selects the default
+// files.
+func SetProfile(name string) error {
+	if name != "" && !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (letters, digits, - and _ only)", name)
+	}
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	profile = name
+	return nil
+}
+
+// currentProfile returns the profile selected with SetProfile.
+func currentProfile() string {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	return profile
+}
+
+// ProfileFileName returns the name of a per-profile file: with profile
+// "east", "config.env" becomes "config.east.env" and "temp" becomes
+// "temp.east". Without a profile the name is returned unchanged.
+func ProfileFileName(name string) string {
+	return StationFileName(currentProfile(), name)
+}
+
+// StationFileName returns the name of a per-profile file of the named
+// profile, like ProfileFileName, for stations run with NewStation.
+func StationFileName(profile, name string) string {
+	if profile == "" {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + profile + ext
+}
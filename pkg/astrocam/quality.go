@@ -0,0 +1,182 @@
+package astrocam
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// frameQuality holds quick image statistics of a frame.
+type frameQuality struct {
+	Background         float64 `json:"background"`
+	Noise              float64 `json:"noise"`
+	Stars              int     `json:"stars"`
+	FWHM               float64 `json:"fwhm"` // median FWHM in pixels, 0 if no stars
+	SaturationFraction float64 `json:"saturation_fraction"`
+}
+
+// Star detection parameters
+const (
+	starDetectSigma = 5.0 // peak must exceed background by this many sigma
+	starWingSigma   = 2.0 // neighbours of a real star exceed this many sigma
+	starMaxForFWHM  = 100 // FWHM is measured on at most this many bright stars
+	starFWHMRadius  = 5   // half-size of the box used for the FWHM moments
+)
+
+// measureQuality computes the background (median), noise (MAD-based sigma),
+// saturated pixel fraction, star count and median FWHM of an image. Stars are
+// local maxima above the detection threshold with at least three neighbours
+// above the wing threshold, which rejects hot pixels and cosmic ray hits.
+func measureQuality(img *fitsImage) *frameQuality {
+	sample := samplePixels(img.Pixels, previewSampleSize)
+	q := &frameQuality{Background: percentile(sample, 0.5)}
+
+	deviations := make([]float32, len(sample))
+	for i, v := range sample {
+		deviations[i] = float32(math.Abs(float64(v) - q.Background))
+	}
+	sort.Slice(deviations, func(i, j int) bool { return deviations[i] < deviations[j] })
+	q.Noise = 1.4826 * percentile(deviations, 0.5)
+	if q.Noise <= 0 {
+		q.Noise = 1
+	}
+
+	saturated := 0
+	for _, v := range img.Pixels {
+		if float64(v) >= img.Saturation {
+			saturated++
+		}
+	}
+	q.SaturationFraction = float64(saturated) / float64(len(img.Pixels))
+
+	type star struct {
+		x, y int
+		peak float32
+	}
+	var stars []star
+	w, h := img.Width, img.Height
+	detect := float32(q.Background + starDetectSigma*q.Noise)
+	wing := float32(q.Background + starWingSigma*q.Noise)
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			v := img.Pixels[y*w+x]
+			if v < detect || float64(v) >= img.Saturation {
+				continue
+			}
+			isPeak, wings := true, 0
+			for dy := -1; dy <= 1 && isPeak; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if dx == 0 && dy == 0 {
+						continue
+					}
+					n := img.Pixels[(y+dy)*w+x+dx]
+					if n > v {
+						isPeak = false
+						break
+					}
+					if n > wing {
+						wings++
+					}
+				}
+			}
+			if isPeak && wings >= 3 {
+				stars = append(stars, star{x, y, v})
+			}
+		}
+	}
+	q.Stars = len(stars)
+
+	// FWHM from background-subtracted second moments of the brightest stars
+	sort.Slice(stars, func(i, j int) bool { return stars[i].peak > stars[j].peak })
+	var fwhms []float64
+	for _, s := range stars {
+		if len(fwhms) >= starMaxForFWHM {
+			break
+		}
+		if s.x < starFWHMRadius || s.y < starFWHMRadius || s.x >= w-starFWHMRadius || s.y >= h-starFWHMRadius {
+			continue
+		}
+		var sum, sumR2 float64
+		for dy := -starFWHMRadius; dy <= starFWHMRadius; dy++ {
+			for dx := -starFWHMRadius; dx <= starFWHMRadius; dx++ {
+				f := float64(img.Pixels[(s.y+dy)*w+s.x+dx]) - q.Background
+				if f <= 0 {
+					continue
+				}
+				sum += f
+				sumR2 += f * float64(dx*dx+dy*dy)
+			}
+		}
+		if sum > 0 {
+			sigma := math.Sqrt(sumR2 / (2 * sum))
+			fwhms = append(fwhms, 2.3548*sigma)
+		}
+	}
+	if len(fwhms) > 0 {
+		sort.Float64s(fwhms)
+		q.FWHM = fwhms[len(fwhms)/2]
+	}
+
+	return q
+}
+
+// inspectFrames reads each frame once to compute its quality metrics and/or
+// render its preview, as configured. Frames that cannot be read are reported
+// and otherwise ignored so they never block archiving.
+func (ac *AstroCam) inspectFrames(frames []string) (map[string]*frameQuality, []string) {
+	if !ac.config.QualityMetrics && ac.config.PreviewMode == "" {
+		return nil, nil
+	}
+
+	quality := make(map[string]*frameQuality)
+	var previews []string
+	for _, frame := range frames {
+		img, err := readFITSImage(frame)
+		if err != nil {
+			slog.Warn("Cannot read image data", "file", filepath.Base(frame), "error", err)
+			continue
+		}
+		if ac.config.QualityMetrics {
+			q := measureQuality(img)
+			quality[frame] = q
+			slog.Info("Frame quality", "file", filepath.Base(frame),
+				"background", fmt.Sprintf("%.1f", q.Background), "noise", fmt.Sprintf("%.1f", q.Noise),
+				"stars", q.Stars, "fwhm", fmt.Sprintf("%.2f", q.FWHM),
+				"saturated_percent", fmt.Sprintf("%.4f", 100*q.SaturationFraction))
+		}
+		if ac.config.PreviewMode != "" {
+			path, err := ac.writePreview(frame, img, ac.tempDirectory)
+			if err != nil {
+				slog.Warn("Cannot create preview", "file", filepath.Base(frame), "error", err)
+			} else {
+				previews = append(previews, path)
+			}
+		}
+	}
+	return quality, previews
+}
+
+// rejectCloudedFrames splits a group into frames passing the SAI_QUALITY_MIN_STARS
+// threshold and frames that do not. Frames without metrics are kept.
+func (ac *AstroCam) rejectCloudedFrames(frames []string, quality map[string]*frameQuality) (keep, reject []string) {
+	for _, frame := range frames {
+		if q, ok := quality[frame]; ok && ac.config.QualityMinStars > 0 && q.Stars < ac.config.QualityMinStars {
+			slog.Warn("Too few stars detected, frame will not be uploaded",
+				"file", filepath.Base(frame), "stars", q.Stars, "minimum", ac.config.QualityMinStars)
+			reject = append(reject, frame)
+			continue
+		}
+		keep = append(keep, frame)
+	}
+	return keep, reject
+}
+
+// removePreviews deletes preview files from the temp directory.
+func removePreviews(previews []string) {
+	for _, path := range previews {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,161 @@
+package astrocam
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// In read-only mode (SAI_CAMERA_READ_ONLY) the camera directory is never
+// modified: a group is copied into the partial directory, the copies are
+// archived and moved to the processed directory, and the camera frames are
+// recorded in the state database so that later scans skip them.
+
+// groupFrames describes the camera frames of a group being packed, for the
+// state database.
+type groupFrames struct {
+	area      string
+	stageDir  string                 // directory of the copies in read-only mode
+	originals map[string]string      // frame being processed -> camera frame
+	infos     map[string]fs.FileInfo // camera frame -> its state when packing started
+	hashes    map[string]string      // camera frame -> SHA-256 before keywords were written
+}
+
+// newFrames drops the frames already recorded in the state database. Outside
+// read-only mode processed frames have left the camera directory, so the
+// list is returned unchanged.
+func (ac *AstroCam) newFrames(files []string) []string {
+	if !ac.config.CameraReadOnly || ac.state == nil {
+		return files
+	}
+	fresh := files[:0]
+	for _, file := range files {
+		info, err := ac.fs.Stat(file)
+		if err != nil || !ac.state.processed(file, info) {
+			fresh = append(fresh, file)
+		}
+	}
+	return fresh
+}
+
+// collectFrames records the size, modification time and, for the state
+// database, checksum of the frames of a group before anything changes them. In read-only mode it also
+// copies the frames into a directory of their own and makes the group refer
+// to the copies; cleanup removes them.
+func (ac *AstroCam) collectFrames(area string, fileGroup *FileGroup) (*groupFrames, error) {
+	frames := &groupFrames{
+		area:      area,
+		originals: make(map[string]string),
+		infos:     make(map[string]fs.FileInfo),
+		hashes:    make(map[string]string),
+	}
+	if ac.config.CameraReadOnly {
+		dir, err := os.MkdirTemp(filepath.Join(ac.tempDirectory, partialDirName), area+"-")
+		if err != nil {
+			return nil, fmt.Errorf("cannot create staging directory: %w", err)
+		}
+		frames.stageDir = dir
+	}
+
+	processing := make([]string, 0, len(fileGroup.FilesToDelete))
+	for _, file := range fileGroup.FilesToDelete {
+		info, err := ac.fs.Stat(file)
+		if err != nil {
+			frames.cleanup()
+			return nil, err
+		}
+		frames.infos[file] = info
+		if ac.state != nil {
+			hash, err := hashFile(file)
+			if err != nil {
+				slog.Warn("Cannot compute frame checksum", "file", filepath.Base(file), "error", err)
+			}
+			frames.hashes[file] = hash
+		}
+
+		path := file
+		if frames.stageDir != "" {
+			path = filepath.Join(frames.stageDir, filepath.Base(file))
+			if err := copyVerified(file, path); err != nil {
+				frames.cleanup()
+				return nil, fmt.Errorf("cannot copy %s: %w", filepath.Base(file), err)
+			}
+		}
+		frames.originals[path] = file
+		processing = append(processing, path)
+	}
+	if frames.stageDir != "" {
+		slog.Debug("Copied frames from read-only camera directory", "area", area, "count", len(processing))
+		fileGroup.FilesToDelete = processing
+	}
+	return frames, nil
+}
+
+// record writes the outcome of the given frames (as listed in the group) to
+// the state database.
+func (ac *AstroCam) record(frames *groupFrames, files []string, outcome, archive string) {
+	ac.appendFrames(frames.records(files, outcome, archive, ac.clock.Now()))
+}
+
+// records returns the state records of the given frames (as listed in the
+// group).
+func (frames *groupFrames) records(files []string, outcome, archive string, now time.Time) []*stateRecord {
+	var recs []*stateRecord
+	for _, file := range files {
+		original := frames.originals[file]
+		if original == "" {
+			continue
+		}
+		recs = append(recs, newFrameRecord(original, frames.infos[original], frames.hashes[original],
+			frames.area, archive, outcome, now))
+	}
+	return recs
+}
+
+// appendFrames writes frame records to the state database.
+func (ac *AstroCam) appendFrames(recs []*stateRecord) {
+	if ac.state == nil {
+		return
+	}
+	for _, rec := range recs {
+		if err := ac.state.appendFrame(rec); err != nil {
+			msg := "Cannot record processed frame"
+			if ac.config.CameraReadOnly {
+				msg = "Cannot record processed frame, it will be archived again"
+			}
+			slog.Error(msg, "file", filepath.Base(rec.Path), "error", err)
+		}
+	}
+}
+
+// duplicateFrames returns the frames of a group whose contents the state
+// database shows were archived before, with SAI_SKIP_DUPLICATES. Frames of a
+// size no archived frame had cannot be duplicates and are not looked up.
+func (ac *AstroCam) duplicateFrames(frames *groupFrames, files []string) []string {
+	if !ac.config.SkipDuplicates || ac.state == nil {
+		return nil
+	}
+	var duplicates []string
+	for _, file := range files {
+		original := frames.originals[file]
+		info, hash := frames.infos[original], frames.hashes[original]
+		if hash == "" || info == nil || !ac.state.archivedSize(info.Size()) {
+			continue
+		}
+		if archive := ac.state.archivedAs(hash); archive != "" {
+			slog.Warn("Skipping duplicate frame", "file", filepath.Base(file), "archived_in", archive)
+			duplicates = append(duplicates, file)
+		}
+	}
+	return duplicates
+}
+
+// cleanup removes the copies left from read-only mode.
+func (frames *groupFrames) cleanup() {
+	if frames.stageDir != "" {
+		os.RemoveAll(frames.stageDir)
+	}
+}
@@ -0,0 +1,244 @@
+package astrocam
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// An observing night runs from local noon to the next noon and is named by
+// the date of its evening.
+const nightStartHour = 12
+
+// nightStart returns the start of the night named by the date of t.
+func nightStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), nightStartHour, 0, 0, 0, t.Location())
+}
+
+// LastNight returns the start of the last night completed at now.
+func LastNight(now time.Time) time.Time {
+	current := nightStart(now.Add(-nightStartHour * time.Hour))
+	return current.AddDate(0, 0, -1)
+}
+
+// StateFilePath returns the state database of the current profile, next to
+// the executable.
+func StateFilePath() string {
+	dir := "."
+	if execPath, err := os.Executable(); err == nil {
+		dir = filepath.Dir(execPath)
+	}
+	return filepath.Join(dir, ProfileFileName(stateFileName))
+}
+
+// nightReport summarizes the state records of one night.
+type nightReport struct {
+	night       time.Time
+	frames      map[string]map[string]int // area -> frame outcome -> count
+	uploads     int                       // archives uploaded
+	bytes       int64                     // bytes uploaded
+	seconds     float64                   // time spent on successful uploads
+	failures    []*stateRecord
+	roofAtStart string         // roof state when the night began (roofUnknown if never read)
+	roof        []*stateRecord // roof state changes during the night
+}
+
+// buildNightReport reads the state database at path and summarizes the
+// night starting at night.
+func buildNightReport(path string, night time.Time) (*nightReport, error) {
+	r := &nightReport{night: night, frames: make(map[string]map[string]int)}
+	end := night.AddDate(0, 0, 1)
+	err := readStateRecords(path, func(rec *stateRecord) {
+		if rec.Type == recordRoof && rec.Time.Before(night) {
+			r.roofAtStart = rec.Outcome
+		}
+		if rec.Time.Before(night) || !rec.Time.Before(end) {
+			return
+		}
+		switch rec.Type {
+		case recordFrame, "":
+			if r.frames[rec.Area] == nil {
+				r.frames[rec.Area] = make(map[string]int)
+			}
+			r.frames[rec.Area][rec.Outcome]++
+		case recordUpload:
+			if rec.Outcome == outcomeUploaded {
+				r.uploads++
+				r.bytes += rec.Size
+				r.seconds += rec.Duration
+			} else {
+				r.failures = append(r.failures, rec)
+			}
+		case recordRoof:
+			r.roof = append(r.roof, rec)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state file: %w", err)
+	}
+	return r, nil
+}
+
+// empty reports whether nothing happened during the night.
+func (r *nightReport) empty() bool {
+	return len(r.frames) == 0 && r.uploads == 0 && len(r.failures) == 0
+}
+
+// format writes the report as plain text.
+func (r *nightReport) format(w io.Writer) {
+	fmt.Fprintf(w, "AstroCam report for the night of %s (noon to noon, %s)\n\n",
+		r.night.Format("2006-01-02"), r.night.Format("MST"))
+
+	outcomes := []string{outcomeArchived, outcomeDuplicate, outcomeRejected, outcomeQuarantined}
+	if len(r.frames) == 0 {
+		fmt.Fprintln(w, "No frames were processed.")
+	} else {
+		var areas []string
+		for area := range r.frames {
+			areas = append(areas, area)
+		}
+		sort.Strings(areas)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(tw, "Area\t%s\t\n", strings.Join(outcomes, "\t"))
+		totals := make(map[string]int)
+		for _, area := range areas {
+			fmt.Fprint(tw, area)
+			for _, outcome := range outcomes {
+				fmt.Fprintf(tw, "\t%d", r.frames[area][outcome])
+				totals[outcome] += r.frames[area][outcome]
+			}
+			fmt.Fprintln(tw, "\t")
+		}
+		fmt.Fprint(tw, "Total")
+		for _, outcome := range outcomes {
+			fmt.Fprintf(tw, "\t%d", totals[outcome])
+		}
+		fmt.Fprintln(tw, "\t")
+		tw.Flush()
+	}
+
+	fmt.Fprintf(w, "\nArchives uploaded:   %d\n", r.uploads)
+	fmt.Fprintf(w, "Data uploaded:       %.2f GB\n", float64(r.bytes)/1e9)
+	if r.seconds > 0 {
+		fmt.Fprintf(w, "Average speed:       %.2f MB/s\n", float64(r.bytes)/1e6/r.seconds)
+	}
+	fmt.Fprintf(w, "Failed uploads:      %d\n", len(r.failures))
+	for _, rec := range r.failures {
+		fmt.Fprintf(w, "  %s %s: %s\n", rec.Time.In(r.night.Location()).Format("2006-01-02 15:04:05"), rec.Archive, rec.Error)
+	}
+	r.formatRoof(w)
+}
+
+// formatRoof writes how long the roof was open during the night and when it
+// opened and closed, if SAI_ROOF_SOURCE was followed.
+func (r *nightReport) formatRoof(w io.Writer) {
+	if r.roofAtStart == roofUnknown && len(r.roof) == 0 {
+		return
+	}
+	end := r.night.AddDate(0, 0, 1)
+	if now := time.Now(); now.Before(end) {
+		end = now
+	}
+	var open time.Duration
+	state, since := r.roofAtStart, r.night
+	for _, rec := range append(r.roof, &stateRecord{Time: end}) {
+		if state == roofOpen {
+			open += rec.Time.Sub(since)
+		}
+		state, since = rec.Outcome, rec.Time
+	}
+	fmt.Fprintf(w, "Roof open:           %s\n", open.Round(time.Minute))
+	if r.roofAtStart != roofUnknown {
+		fmt.Fprintf(w, "  %s at noon\n", r.roofAtStart)
+	}
+	previous := r.roofAtStart
+	for _, rec := range r.roof {
+		if rec.Outcome != previous {
+			fmt.Fprintf(w, "  %s %s\n", rec.Time.In(r.night.Location()).Format("2006-01-02 15:04"), rec.Outcome)
+		}
+		previous = rec.Outcome
+	}
+}
+
+// NightReport returns the report of the night starting at night, as written
+// to SAI_REPORT_DIRECTORY, from the state database of the current profile.
+func NightReport(night time.Time) (string, error) {
+	r, err := buildNightReport(StateFilePath(), nightStart(night))
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	r.format(&b)
+	return b.String(), nil
+}
+
+// ExportUploadHistory writes the upload attempts recorded between from and
+// to (zero times are open ends) as CSV, one attempt per line.
+func ExportUploadHistory(w io.Writer, from, to time.Time) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"time", "outcome", "archive", "server", "size_bytes", "duration_seconds", "error"})
+	err := readStateRecords(StateFilePath(), func(rec *stateRecord) {
+		if rec.Type != recordUpload || (!from.IsZero() && rec.Time.Before(from)) || (!to.IsZero() && !rec.Time.Before(to)) {
+			return
+		}
+		out.Write([]string{
+			rec.Time.Local().Format(time.RFC3339), rec.Outcome, rec.Archive, rec.Server,
+			strconv.FormatInt(rec.Size, 10), strconv.FormatFloat(rec.Duration, 'f', 1, 64), rec.Error,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("cannot read state file: %w", err)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// nightlyReport writes the report of the last night to SAI_REPORT_DIRECTORY
+// at the first scan after it ended, and sends it to SAI_NOTIFY_URL with
+// SAI_REPORT_NOTIFY. A report already on disk, e.g. from before a restart, is
+// not written again.
+func (ac *AstroCam) nightlyReport() {
+	if ac.config.ReportDirectory == "" {
+		return
+	}
+	night := LastNight(ac.clock.Now())
+	if !night.After(ac.lastReport) {
+		return
+	}
+	ac.lastReport = night
+
+	name := StationFileName(ac.profile, fmt.Sprintf("astrocam-report-%s.txt", night.Format("2006-01-02")))
+	path := filepath.Join(ac.config.ReportDirectory, name)
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	r, err := buildNightReport(ac.state.path, night)
+	if err != nil {
+		slog.Warn("Cannot create nightly report", "error", err)
+		return
+	}
+	var b strings.Builder
+	r.format(&b)
+
+	if err := os.MkdirAll(ac.config.ReportDirectory, 0755); err != nil {
+		slog.Warn("Cannot create report directory", "error", err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		slog.Warn("Cannot write nightly report", "error", err)
+		return
+	}
+	slog.Info("Nightly report written", "file", path)
+
+	if ac.config.ReportNotify && !r.empty() {
+		ac.notify("report for the night of "+night.Format("2006-01-02"), b.String())
+	}
+}
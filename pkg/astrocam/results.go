@@ -0,0 +1,184 @@
+package astrocam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DEFAULT_RESULTS_INTERVAL is the time between two downloads of the
+// server's processing results.
+const DEFAULT_RESULTS_INTERVAL = 10 * time.Minute
+
+const (
+	// resultsWindow is how long after its upload the results of an archive
+	// are asked for; the server is expected to have processed it by then.
+	resultsWindow = 24 * time.Hour
+	// resultsMaxSize limits a downloaded result.
+	resultsMaxSize = 100 << 20
+)
+
+// resultExtensions name the result files by the content type of the answer.
+var resultExtensions = map[string]string{
+	"application/json": ".json",
+	"application/pdf":  ".pdf",
+	"application/zip":  ".zip",
+	"image/jpeg":       ".jpg",
+	"image/png":        ".png",
+	"text/csv":         ".csv",
+	"text/html":        ".html",
+	"text/plain":       ".txt",
+}
+
+// syncResults downloads, every SAI_RESULTS_INTERVAL until stop is closed,
+// the processing results of the archives uploaded within resultsWindow that
+// have none in SAI_RESULTS_DIRECTORY yet, as the state file at statePath
+// records them.
+func (ac *AstroCam) syncResults(stop <-chan struct{}, statePath string) {
+	for {
+		ac.jobsMu.RLock()
+		config := ac.config
+		ac.jobsMu.RUnlock()
+		interval := DEFAULT_RESULTS_INTERVAL
+		if config.ResultsURL != "" {
+			ac.downloadResults(config, statePath)
+			interval = config.ResultsInterval
+		}
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// downloadResults asks SAI_RESULTS_URL for the results of every recently
+// uploaded archive without results. The server answers 404 or with an empty
+// body while it has none yet.
+func (ac *AstroCam) downloadResults(config *Config, statePath string) {
+	cutoff := ac.clock.Now().Add(-resultsWindow)
+	var uploaded []string // in order of upload
+	seen := make(map[string]bool)
+	areas := make(map[string]string) // archive name -> area
+	err := readStateRecords(statePath, func(rec *stateRecord) {
+		switch {
+		case rec.Type == recordFrame && rec.Archive != "":
+			areas[rec.Archive] = rec.Area
+		case rec.Type == recordUpload && rec.Outcome == outcomeUploaded && rec.Time.After(cutoff):
+			if !seen[rec.Archive] {
+				uploaded = append(uploaded, rec.Archive)
+				seen[rec.Archive] = true
+			}
+		}
+	})
+	if err != nil {
+		slog.Warn("Cannot read the uploads for the results download", "error", err)
+		return
+	}
+	if len(uploaded) == 0 {
+		return
+	}
+
+	have := make(map[string]bool)
+	entries, _ := os.ReadDir(config.ResultsDirectory)
+	for _, entry := range entries {
+		have[strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))] = true
+	}
+	failures := 0
+	for _, archive := range uploaded {
+		base := strings.TrimSuffix(archive, filepath.Ext(archive))
+		if have[base] {
+			continue
+		}
+		file, err := ac.downloadResult(config, archive, areas[archive], base)
+		switch {
+		case err != nil:
+			if failures == 0 {
+				slog.Warn("Cannot download processing results", "archive", archive, "error", err)
+			}
+			failures++
+		case file != "":
+			slog.Info("Processing results downloaded", "archive", archive, "file", file)
+		}
+	}
+	if failures > 1 {
+		slog.Warn("Cannot download processing results of further archives", "archives", failures-1)
+	}
+}
+
+// downloadResult fetches the results of one archive into
+// SAI_RESULTS_DIRECTORY as base plus the extension of the answer's content
+// type, and returns the file written ("" if the server has no results yet).
+func (ac *AstroCam) downloadResult(config *Config, archive, area, base string) (string, error) {
+	target := strings.NewReplacer("{name}", url.QueryEscape(archive), "{area}", url.QueryEscape(area)).Replace(config.ResultsURL)
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	getter, err := newHTTPUploader(u, config)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	resp, err := getter.(*HTTPUploader).get(ctx, target, 5*time.Minute)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent:
+		return "", nil
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return "", fmt.Errorf("server returned status %s", resp.Status)
+	}
+
+	path := filepath.Join(config.ResultsDirectory, base+resultExtension(resp))
+	out, err := os.Create(path + partSuffix)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(out, io.LimitReader(resp.Body, resultsMaxSize+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	switch {
+	case err == nil && n > resultsMaxSize:
+		err = fmt.Errorf("results larger than %d MB", resultsMaxSize>>20)
+	case err == nil && n == 0:
+		os.Remove(path + partSuffix)
+		return "", nil
+	}
+	if err == nil {
+		err = os.Rename(path+partSuffix, path)
+	}
+	if err != nil {
+		os.Remove(path + partSuffix)
+		return "", err
+	}
+	return path, nil
+}
+
+// resultExtension returns the file extension of a results answer: that of
+// the file name the server suggests, or else the one of its content type.
+func resultExtension(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if ext := filepath.Ext(filepath.Base(params["filename"])); ext != "" {
+			return ext
+		}
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		if ext, ok := resultExtensions[mediaType]; ok {
+			return ext
+		}
+	}
+	return ".dat"
+}
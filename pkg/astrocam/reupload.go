@@ -0,0 +1,141 @@
+package astrocam
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FailedDirectory returns the directory of archives given up on by the
+// current profile (see SAI_MAX_UPLOAD_ATTEMPTS), next to the executable.
+func FailedDirectory() string {
+	return filepath.Join(executableDir(), ProfileFileName("failed"))
+}
+
+// ReviewDirectory returns the directory of archives whose upload was neither
+// confirmed nor rejected by the server (see SAI_STRICT_UPLOAD).
+func ReviewDirectory() string {
+	return filepath.Join(executableDir(), ProfileFileName("needs-review"))
+}
+
+// executableDir returns the directory of the executable, or the current
+// directory if it is unknown.
+func executableDir() string {
+	if execPath, err := os.Executable(); err == nil {
+		return filepath.Dir(execPath)
+	}
+	return "."
+}
+
+// isArchiveSidecar reports whether a file in the failed directory describes an
+// archive rather than being one: its metadata or its error report.
+func isArchiveSidecar(name string) bool {
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".errors.txt")
+}
+
+// ResolveArchives expands the arguments of the reupload command into archive
+// paths. An argument is a file, a directory (all archives in it) or a glob
+// pattern, which is expanded here because the Windows shell does not. Names
+// not found as given are looked up in the failed, needs-review and processed
+// directories and in SAI_UPLOADED_DIRECTORY.
+func ResolveArchives(config *Config, args []string) ([]string, error) {
+	var archives []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] && !isArchiveSidecar(path) {
+			seen[path] = true
+			archives = append(archives, path)
+		}
+	}
+	processed := config.ProcessedDirectory
+	if processed == "" {
+		processed = filepath.Join(executableDir(), "processed")
+	}
+	lookup := []string{FailedDirectory(), ReviewDirectory(), processed}
+	if config.UploadedDirectory != "" {
+		lookup = append(lookup, config.UploadedDirectory)
+	}
+	for _, arg := range args {
+		matches, err := findArchives(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 && !strings.ContainsAny(arg, `/\`) {
+			for _, dir := range lookup {
+				if matches, err = findArchives(filepath.Join(dir, arg)); err != nil {
+					return nil, err
+				}
+				if len(matches) > 0 {
+					break
+				}
+			}
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no archive matches %q", arg)
+		}
+		for _, path := range matches {
+			add(path)
+		}
+	}
+	return archives, nil
+}
+
+// findArchives returns the files matched by one reupload argument.
+func findArchives(arg string) ([]string, error) {
+	matches, err := filepath.Glob(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+	}
+	var files []string
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			files = append(files, match)
+			continue
+		}
+		entries, err := os.ReadDir(match)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				files = append(files, filepath.Join(match, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// ReuploadArchive uploads an archive again with the configured destination and
+// credentials. An archive from the failed or needs-review directory is
+// deleted after the upload succeeded, together with its metadata and error
+// report, unless keep is set; archives elsewhere are left in place.
+func ReuploadArchive(ctx context.Context, config *Config, path string, keep bool) error {
+	if err := UploadFile(ctx, config, path); err != nil {
+		return err
+	}
+	if keep {
+		return nil
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+	failedDir, _ := filepath.Abs(FailedDirectory())
+	reviewDir, _ := filepath.Abs(ReviewDirectory())
+	if dir != failedDir && dir != reviewDir {
+		return nil
+	}
+	for _, file := range []string{path, archiveMetaPath(path), path + ".errors.txt"} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Cannot delete uploaded archive after reupload", "file", filepath.Base(file), "error", err)
+		}
+	}
+	return nil
+}
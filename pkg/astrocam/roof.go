@@ -0,0 +1,129 @@
+package astrocam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Roof states, as recorded in the state database
+const (
+	roofOpen    = "open"
+	roofClosed  = "closed"
+	roofMoving  = "moving" // opening or closing
+	roofUnknown = ""
+)
+
+// roofSensor follows SAI_ROOF_SOURCE on the scanner.
+type roofSensor struct {
+	poller  sensorPoller
+	state   string    // latest state read, roofUnknown before the first
+	changed time.Time // when the state was first read
+}
+
+// parseRoofState interprets a message of SAI_ROOF_SOURCE: a word such as
+// "open", "closed", "opening" or "closing", an ASCOM ShutterState number (0
+// open, 1 closed, 2 opening, 3 closing, 4 error), or a JSON object holding
+// one of these in "roof", "dome", "shutter", "state", "status" or "value",
+// or true or false in "open" or "closed". The ASCOM Alpaca shutterstatus
+// endpoint of a dome answers in this form.
+func parseRoofState(data []byte) (string, error) {
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, "{") {
+		return roofState(text)
+	}
+	var object map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return roofUnknown, fmt.Errorf("invalid JSON from the roof: %w", err)
+	}
+	values := make(map[string]any, len(object))
+	for key, value := range object {
+		values[strings.ToLower(key)] = value
+	}
+	for _, key := range []string{"roof", "dome", "shutter", "state", "status", "value"} {
+		if v, ok := values[key]; ok {
+			return roofState(fmt.Sprint(v))
+		}
+	}
+	if open, ok := values["open"].(bool); ok {
+		if open {
+			return roofOpen, nil
+		}
+		return roofClosed, nil
+	}
+	if closed, ok := values["closed"].(bool); ok {
+		if closed {
+			return roofClosed, nil
+		}
+		return roofOpen, nil
+	}
+	return roofUnknown, errors.New("no roof state in the message")
+}
+
+// roofState normalizes a roof state word or ASCOM ShutterState number.
+func roofState(value string) (string, error) {
+	value = strings.ToLower(value)
+	if n, err := strconv.Atoi(value); err == nil {
+		states := []string{roofOpen, roofClosed, roofMoving, roofMoving}
+		if n >= 0 && n < len(states) {
+			return states[n], nil
+		}
+		return roofUnknown, fmt.Errorf("roof reports error state %d", n)
+	}
+	switch value {
+	case "open", "opened":
+		return roofOpen, nil
+	case "closed", "close", "shut":
+		return roofClosed, nil
+	case "opening", "closing", "moving":
+		return roofMoving, nil
+	}
+	return roofUnknown, fmt.Errorf("unknown roof state %q", value)
+}
+
+// pollRoof reads the roof state, once per scan, and records every change in
+// the state database for the nightly report. A roof that cannot be read
+// keeps its last known state.
+func (ac *AstroCam) pollRoof() {
+	var state string
+	if !ac.roof.poller.poll("SAI_ROOF_SOURCE", ac.config.RoofSource, ac.config, func(data []byte, _ time.Time) (err error) {
+		state, err = parseRoofState(data)
+		return err
+	}) {
+		if ac.config.RoofSource == "" {
+			ac.roof.state = roofUnknown
+		}
+		return
+	}
+	if state == ac.roof.state {
+		return
+	}
+	now := ac.clock.Now()
+	slog.Info("Roof state changed", "roof", state)
+	ac.roof.state, ac.roof.changed = state, now
+	if ac.state != nil {
+		if err := ac.state.recordRoof(state, now); err != nil {
+			slog.Warn("Cannot record the roof state", "error", err)
+		}
+	}
+}
+
+// roofShut reports whether the roof is closed, or moving, so that no frames
+// can be expected, and otherwise since when it has been open (zero if
+// unknown).
+func (ac *AstroCam) roofShut() (bool, time.Time) {
+	switch ac.roof.state {
+	case roofClosed, roofMoving:
+		return true, time.Time{}
+	case roofOpen:
+		return false, ac.roof.changed
+	}
+	return false, time.Time{}
+}
@@ -0,0 +1,103 @@
+package astrocam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// safetyMonitorSource returns the sensor source SAI_SAFETY_MONITOR names:
+// alpaca://host:port[/NUMBER] becomes the IsSafe endpoint of that ASCOM
+// Alpaca SafetyMonitor, anything else is a file, URL or MQTT topic as for
+// the other sensors.
+func safetyMonitorSource(value string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(value), "alpaca://") {
+		return value, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid SAI_SAFETY_MONITOR: %w", err)
+	}
+	device := strings.Trim(u.Path, "/")
+	if device == "" {
+		device = "0"
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("SAI_SAFETY_MONITOR %s has no host", value)
+	}
+	if _, err := strconv.Atoi(device); err != nil {
+		return "", fmt.Errorf("SAI_SAFETY_MONITOR %s: the device number must be a number", value)
+	}
+	return fmt.Sprintf("http://%s/api/v1/safetymonitor/%s/issafe?ClientID=%d", u.Host, device, alpacaClientID), nil
+}
+
+// parseSafety interprets a message of SAI_SAFETY_MONITOR: true, false, safe,
+// unsafe, 1 or 0, or a JSON object holding one of these in "value",
+// "issafe" or "safe", which is how an ASCOM Alpaca SafetyMonitor answers.
+func parseSafety(data []byte) (bool, error) {
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, "{") {
+		return safetyValue(text)
+	}
+	var object map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return false, fmt.Errorf("invalid JSON from the safety monitor: %w", err)
+	}
+	values := make(map[string]any, len(object))
+	for key, value := range object {
+		values[strings.ToLower(key)] = value
+	}
+	if code, ok := values["errornumber"].(json.Number); ok && code.String() != "0" {
+		return false, fmt.Errorf("safety monitor error %s: %v", code, values["errormessage"])
+	}
+	for _, key := range []string{"issafe", "safe", "value"} {
+		if v, ok := values[key]; ok {
+			return safetyValue(fmt.Sprint(v))
+		}
+	}
+	return false, errors.New("no safety state in the message")
+}
+
+// safetyValue normalizes a safety state word or number.
+func safetyValue(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "safe", "yes", "1":
+		return true, nil
+	case "false", "unsafe", "no", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown safety state %q", value)
+}
+
+// pollSafetyMonitor reads SAI_SAFETY_MONITOR, once per scan, and pauses
+// packing and uploading while the observatory is marked unsafe, resuming
+// when it is safe again. A monitor that cannot be read keeps its last state.
+func (ac *AstroCam) pollSafetyMonitor() {
+	source, _ := safetyMonitorSource(ac.config.SafetyMonitor) // checked by Config.set
+	var safe bool
+	if !ac.safety.poll("SAI_SAFETY_MONITOR", source, ac.config, func(data []byte, _ time.Time) (err error) {
+		safe, err = parseSafety(data)
+		return err
+	}) {
+		if source == "" && ac.unsafe.Swap(false) {
+			slog.Info("SAI_SAFETY_MONITOR removed, resuming packing and uploads")
+		}
+		return
+	}
+	if ac.unsafe.Swap(!safe) == !safe {
+		return
+	}
+	if safe {
+		slog.Info("Observatory safe again, resuming packing and uploads")
+	} else {
+		slog.Warn("Observatory marked unsafe by the safety monitor, pausing packing and uploads", "monitor", redactedSource(ac.config.SafetyMonitor))
+	}
+}
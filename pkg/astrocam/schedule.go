@@ -0,0 +1,128 @@
+package astrocam
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// schedule is a set of daily windows of local time, written like
+// "22:00-06:00,12:00-13:00". A window whose end is not after its start runs
+// past midnight. An empty schedule is always active.
+type schedule []timeWindow
+
+// timeWindow is a window in minutes since midnight.
+type timeWindow struct {
+	start, end int
+}
+
+// parseSchedule parses the SAI_UPLOAD_HOURS and SAI_ARCHIVE_HOURS syntax.
+func parseSchedule(s string) (schedule, error) {
+	var sched schedule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a range like 22:00-06:00", part)
+		}
+		start, err := parseClockTime(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClockTime(to)
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("%q is empty", part)
+		}
+		sched = append(sched, timeWindow{start, end})
+	}
+	return sched, nil
+}
+
+// parseClockTime parses HH:MM into minutes since midnight; 24:00 is accepted
+// as the end of the day.
+func parseClockTime(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// active reports whether t falls into one of the windows.
+func (s schedule) active(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	for _, w := range s {
+		if w.start < w.end {
+			if m >= w.start && m < w.end {
+				return true
+			}
+		} else if m >= w.start || m < w.end {
+			return true
+		}
+	}
+	return false
+}
+
+// opens returns the next start of a window after t.
+func (s schedule) opens(t time.Time) time.Time {
+	var next time.Time
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, w := range s {
+		start := midnight.Add(time.Duration(w.start) * time.Minute)
+		if !start.After(t) {
+			start = start.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+func (s schedule) String() string {
+	var parts []string
+	for _, w := range s {
+		parts = append(parts, fmt.Sprintf("%02d:%02d-%02d:%02d", w.start/60, w.start%60, w.end/60, w.end%60))
+	}
+	return strings.Join(parts, ",")
+}
+
+// uploadHoursActive reports whether SAI_UPLOAD_HOURS allows uploading now.
+func (ac *AstroCam) uploadHoursActive() bool {
+	ac.jobsMu.RLock()
+	defer ac.jobsMu.RUnlock()
+	return ac.config.UploadHours.active(ac.clock.Now())
+}
+
+// withinHours reports whether the scanner may do the work limited by
+// setting now, and logs when the schedule closes or opens again. closed
+// remembers the last answer; it belongs to the scanner.
+func (ac *AstroCam) withinHours(setting string, s schedule, closed *bool, waiting string) bool {
+	now := ac.clock.Now()
+	if s.active(now) {
+		if *closed {
+			slog.Info("Within "+setting+", resuming", "hours", s)
+			*closed = false
+		}
+		return true
+	}
+	if !*closed {
+		slog.Info("Outside "+setting+", "+waiting, "hours", s, "until", s.opens(now).Format("15:04"))
+		*closed = true
+	}
+	return false
+}
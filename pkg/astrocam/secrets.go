@@ -0,0 +1,64 @@
+package astrocam
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// keyringService names the SAI_PASSWORD entry in the OS credential store.
+const keyringService = "astrocam-go"
+
+// resolvePassword fills in SAI_PASSWORD from SAI_PASSWORD_FILE or the OS
+// credential store when config.env does not contain it.
+func (config *Config) resolvePassword() {
+	if config.PasswordFile == "" && !config.PasswordKeyring {
+		return
+	}
+	if config.Password != "" {
+		slog.Warn("SAI_PASSWORD is set, ignoring SAI_PASSWORD_FILE and SAI_PASSWORD_KEYRING")
+		return
+	}
+	password, err := config.readPassword()
+	if err != nil {
+		slog.Error("Cannot read the upload password", "error", err)
+		return
+	}
+	config.Password = password
+}
+
+// readPassword reads the password from the configured secret store.
+func (config *Config) readPassword() (string, error) {
+	if config.PasswordFile != "" {
+		return readPasswordFile(config.PasswordFile)
+	}
+	password, err := keyringPassword(keyringService, config.Username)
+	if err != nil {
+		return "", fmt.Errorf("credential store entry %q: %w", keyringService, err)
+	}
+	return password, nil
+}
+
+// readPasswordFile returns the first line of a secrets file. On Unix a file
+// readable by other users is used but reported, since it defeats its purpose.
+func readPasswordFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		slog.Warn("Password file is accessible by other users, restrict it with chmod 600", "path", path, "mode", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	password, _, _ := strings.Cut(string(data), "\n")
+	password = strings.TrimSpace(password)
+	if password == "" {
+		return "", fmt.Errorf("password file %s is empty", path)
+	}
+	return password, nil
+}
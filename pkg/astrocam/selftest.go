@@ -0,0 +1,237 @@
+package astrocam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// selfTestArea names the synthetic frames created by SelfTest.
+const selfTestArea = "SELFTEST"
+
+// selfTestTimeout bounds a self-test cycle; packing alone waits 5 seconds.
+const selfTestTimeout = 60 * time.Second
+
+// selfTestUpload is an archive received by the self-test server.
+type selfTestUpload struct {
+	name     string
+	data     []byte
+	metadata string
+}
+
+// SelfTest runs a complete scan, pack and upload cycle against an embedded
+// upload server, using synthetic frames in a scratch directory and the
+// archive format this station is configured for. It checks that the server
+// received a valid archive holding every frame and that the frames were moved
+// to the processed directory. The production server and the real camera,
+// processed and temp directories are not touched.
+func SelfTest() error {
+	stationConfig := LoadConfig()
+
+	root, err := os.MkdirTemp("", "astrocam-selftest-")
+	if err != nil {
+		return fmt.Errorf("cannot create scratch directory: %w", err)
+	}
+	defer os.RemoveAll(root)
+
+	uploads := make(chan selfTestUpload, 4)
+	server, serverURL, err := startSelfTestServer(uploads)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.Server = serverURL
+	config.Username = "selftest"
+	config.Password = "selftest"
+	config.CameraDirectory = filepath.Join(root, "camera")
+	config.ProcessedDirectory = filepath.Join(root, "processed")
+	config.ArchiveMode = stationConfig.ArchiveMode
+	config.LogLevel = stationConfig.LogLevel
+	config.LogFormat = stationConfig.LogFormat
+	config.Language = stationConfig.Language
+	config.LanguageFile = stationConfig.LanguageFile
+	config.Count = 3
+	if err := os.MkdirAll(config.CameraDirectory, 0755); err != nil {
+		return fmt.Errorf("cannot create scratch directory: %w", err)
+	}
+
+	frames := make(map[string][]byte)
+	for i := 1; i <= config.Count; i++ {
+		name := fmt.Sprintf("%s_%03d.fts", selfTestArea, i)
+		data := syntheticFrame(i)
+		if err := os.WriteFile(filepath.Join(config.CameraDirectory, name), data, 0644); err != nil {
+			return fmt.Errorf("cannot write synthetic frame: %w", err)
+		}
+		frames[name] = data
+	}
+
+	ac, err := newAstroCam(config, []string{selfTestArea}, false, root, "")
+	if err != nil {
+		return err
+	}
+	defer ac.Close()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ac.Run(stop)
+		close(done)
+	}()
+
+	var upload selfTestUpload
+	select {
+	case upload = <-uploads:
+	case <-time.After(selfTestTimeout):
+	}
+	close(stop)
+	<-done
+
+	if upload.name == "" {
+		return fmt.Errorf("no archive reached the test server within %s", selfTestTimeout)
+	}
+	return verifySelfTest(ac, upload, frames)
+}
+
+// startSelfTestServer starts an upload.py-style server on a random loopback
+// port that hands every uploaded archive to uploads.
+func startSelfTestServer(uploads chan<- selfTestUpload) (*http.Server, string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot start test server: %w", err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "selftest" || pass != "selftest" {
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			fmt.Fprintln(w, "UNMW_STATUS:OK")
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "no file field: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		select {
+		case uploads <- selfTestUpload{name: header.Filename, data: data, metadata: r.FormValue("metadata")}:
+		default:
+		}
+		fmt.Fprintln(w, "Upload successful")
+		fmt.Fprintln(w, "UNMW_STATUS:OK")
+	})
+
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	return server, fmt.Sprintf("http://%s/upload.py", listener.Addr()), nil
+}
+
+// syntheticFrame returns a small 16-bit FITS frame whose pixels depend on n,
+// so that every frame of the self-test has different contents.
+func syntheticFrame(n int) []byte {
+	const width, height = 64, 64
+	var header strings.Builder
+	for _, kw := range []fitsKeyword{
+		{"SIMPLE", "T"}, {"BITPIX", "16"}, {"NAXIS", "2"},
+		{"NAXIS1", fmt.Sprint(width)}, {"NAXIS2", fmt.Sprint(height)},
+		{"OBJECT", selfTestArea}, {"IMAGETYP", "Light Frame"},
+	} {
+		header.WriteString(formatFITSCard(kw.Key, kw.Value))
+	}
+	header.WriteString(fmt.Sprintf("%-80s", "END"))
+
+	data := padFITSBlock([]byte(header.String()), ' ')
+	for i := 0; i < width*height; i++ {
+		value := uint16(1000 + (i*7+n*131)%500)
+		data = append(data, byte(value>>8), byte(value))
+	}
+	return padFITSBlock(data, 0)
+}
+
+// padFITSBlock pads data with fill to a whole number of FITS blocks.
+func padFITSBlock(data []byte, fill byte) []byte {
+	for len(data)%fitsBlockSize != 0 {
+		data = append(data, fill)
+	}
+	return data
+}
+
+// verifySelfTest checks the archive received by the test server and the state
+// left behind in the scratch directories.
+func verifySelfTest(ac *AstroCam, upload selfTestUpload, frames map[string][]byte) error {
+	var problems []string
+
+	if !strings.HasSuffix(upload.name, ac.archiver.Extension()) {
+		problems = append(problems, fmt.Sprintf("archive %s does not have the %s extension", upload.name, ac.archiver.Extension()))
+	}
+
+	// Test the received copy with the configured archiver
+	received := filepath.Join(ac.tempDirectory, "received"+ac.archiver.Extension())
+	if err := os.WriteFile(received, upload.data, 0644); err != nil {
+		return fmt.Errorf("cannot store received archive: %w", err)
+	}
+	if err := ac.archiver.Test(received); err != nil {
+		problems = append(problems, fmt.Sprintf("received archive failed the integrity test: %v", err))
+	}
+	os.Remove(received)
+
+	// The metadata must list every frame
+	var meta archiveMeta
+	if err := json.Unmarshal([]byte(upload.metadata), &meta); err != nil {
+		problems = append(problems, fmt.Sprintf("metadata missing or invalid: %v", err))
+	} else {
+		var want []string
+		for name := range frames {
+			want = append(want, name)
+		}
+		sort.Strings(want)
+		got := append([]string{}, meta.Frames...)
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			problems = append(problems, fmt.Sprintf("metadata lists frames %v, expected %v", got, want))
+		}
+	}
+
+	// Every frame must have been moved to the processed directory unchanged
+	for name, data := range frames {
+		moved, err := os.ReadFile(filepath.Join(ac.config.ProcessedDirectory, name))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("frame %s not in processed directory: %v", name, err))
+		} else if !bytes.Equal(moved, data) {
+			problems = append(problems, fmt.Sprintf("frame %s changed while processing", name))
+		}
+	}
+
+	// The uploaded archive must have been deleted locally
+	if pending, err := ac.getArchiveFiles(); err == nil && len(pending) > 0 {
+		problems = append(problems, fmt.Sprintf("%d archive(s) left in the temp directory after upload", len(pending)))
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			slog.Error("Self-test check failed", "problem", problem)
+		}
+		return errors.New(strings.Join(problems, "; "))
+	}
+	slog.Info("Self-test passed", "archive", upload.name, "format", ac.archiver, "frames", len(frames))
+	return nil
+}
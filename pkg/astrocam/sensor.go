@@ -0,0 +1,153 @@
+package astrocam
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// sensorSource reads the latest message of an observatory sensor, such as a
+// cloud sensor or the roof controller. It is a file, an HTTP(S) URL answering
+// GET requests, or an MQTT topic (mqtt://host[:port]/topic).
+type sensorSource interface {
+	// read returns the latest message and when it was written or received.
+	read(ctx context.Context) ([]byte, time.Time, error)
+	// close releases the source, e.g. its MQTT connection.
+	close()
+	String() string
+}
+
+// newSensorSource returns the source a sensor setting names. purpose tells
+// the MQTT connections of the sensors apart.
+func newSensorSource(source string, config *Config, purpose string) (sensorSource, error) {
+	lower := strings.ToLower(source)
+	switch {
+	case strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://"):
+		return httpSensor(source), nil
+	case strings.HasPrefix(lower, "mqtt://") || strings.HasPrefix(lower, "mqtts://"):
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MQTT source: %w", err)
+		}
+		return newMQTTSubscriber(u, mqttClientID(config, purpose))
+	}
+	return fileSensor(source), nil
+}
+
+// redactedSource returns a sensor setting with the password of a URL
+// masked, for the log.
+func redactedSource(source string) string {
+	if u, err := url.Parse(source); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return source
+}
+
+// sensorPoller reads a sensor setting on the scanner. It makes the source
+// again when the setting changes, and logs a failure once until the sensor
+// answers again.
+type sensorPoller struct {
+	source  sensorSource // nil while the setting is empty
+	name    string       // setting the source was made for
+	failing bool         // the last poll failed, so the failure was logged
+}
+
+// poll reads the sensor that the config setting key names as value and
+// passes its message to parse. It reports whether a message was read and
+// understood.
+func (p *sensorPoller) poll(key, value string, config *Config, parse func(data []byte, at time.Time) error) bool {
+	if p.name != value {
+		if p.source != nil {
+			p.source.close()
+			p.source = nil
+		}
+		p.name, p.failing = value, false
+		if value != "" {
+			source, err := newSensorSource(value, config, strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(key, "SAI_"), "_SOURCE")))
+			if err != nil {
+				slog.Warn("Invalid "+key+", ignoring it", "error", err)
+				return false
+			}
+			p.source = source
+		}
+	}
+	if p.source == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	data, at, err := p.source.read(ctx)
+	if err == nil {
+		err = parse(data, at)
+	}
+	if err != nil {
+		if !p.failing {
+			slog.Warn("Cannot read sensor", "setting", key, "source", p.source, "error", err)
+			p.failing = true
+		}
+		return false
+	}
+	if p.failing {
+		slog.Info("Sensor readable again", "setting", key, "source", p.source)
+		p.failing = false
+	}
+	return true
+}
+
+// sensorMaxSize limits the sensor messages read from files and HTTP answers.
+const sensorMaxSize = 64 << 10
+
+// fileSensor is a file the sensor software rewrites with every reading, such
+// as the Boltwood one-line data file. Its modification time is the time of
+// the reading.
+type fileSensor string
+
+func (f fileSensor) read(ctx context.Context) ([]byte, time.Time, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := io.ReadAll(io.LimitReader(file, sensorMaxSize))
+	return data, info.ModTime(), err
+}
+
+func (f fileSensor) close() {}
+
+func (f fileSensor) String() string { return string(f) }
+
+// httpSensor is an endpoint answering GET requests with the current reading.
+type httpSensor string
+
+func (h httpSensor) read(ctx context.Context) ([]byte, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, string(h), nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("User-Agent", "AstroCam-GO/"+softwareVersion())
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, time.Time{}, fmt.Errorf("sensor returned status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, sensorMaxSize))
+	return data, time.Now(), err
+}
+
+func (h httpSensor) close() {}
+
+func (h httpSensor) String() string { return string(h) }
@@ -0,0 +1,69 @@
+package astrocam
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// frameNumber returns the number of a frame within its acquisition sequence:
+// the first group of SAI_SEQUENCE_PATTERN in its file name, or the value of
+// the FITS keyword SAI_SEQUENCE_KEYWORD.
+func (ac *AstroCam) frameNumber(file string) (int, bool) {
+	var value string
+	if ac.config.SequenceKeyword != "" {
+		info, err := ac.fs.Stat(file)
+		if err != nil {
+			return 0, false
+		}
+		header, err := ac.frameHeader(file, info)
+		if err != nil {
+			return 0, false
+		}
+		value = strings.Trim(header[ac.config.SequenceKeyword], "' ")
+	} else if ac.config.SequencePattern != "" {
+		if ac.sequenceRegexp == nil || ac.sequenceRegexp.String() != ac.config.SequencePattern {
+			re, err := regexp.Compile(ac.config.SequencePattern)
+			if err != nil {
+				return 0, false
+			}
+			ac.sequenceRegexp = re
+		}
+		match := ac.sequenceRegexp.FindStringSubmatch(filepath.Base(file))
+		if len(match) < 2 {
+			return 0, false
+		}
+		value = match[1]
+	} else {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	return n, err == nil
+}
+
+// nextGroup picks the frames of the next archive from the sorted frames of an
+// area and reports whether the group is complete. Without sequence numbers it
+// is the first SAI_COUNT frames. With them it is the first sequence, which
+// ends where the numbering starts over: a sequence interrupted by a restart of
+// the acquisition is complete as it is, because no more frames will join it,
+// while the last sequence waits for its remaining frames. A frame without a
+// number continues the sequence before it.
+func (ac *AstroCam) nextGroup(files []string) ([]string, bool) {
+	count := ac.config.Count
+	if ac.config.SequencePattern == "" && ac.config.SequenceKeyword == "" {
+		return files[:min(count, len(files))], len(files) >= count
+	}
+	end, last := 0, -1
+	for end < len(files) && end < count {
+		n, ok := ac.frameNumber(files[end])
+		if ok && end > 0 && last >= 0 && n <= last {
+			return files[:end], true
+		}
+		if ok {
+			last = n
+		}
+		end++
+	}
+	return files[:end], end >= count
+}
@@ -0,0 +1,205 @@
+package astrocam
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_LOG_LINES is the number of log lines sent by upload-log without a
+// count.
+const DEFAULT_LOG_LINES = 200
+
+// pollServerCommands asks SAI_COMMAND_URL for commands every
+// SAI_COMMAND_INTERVAL and carries them out. It runs on the scanner, so the
+// commands take effect between scans. The request is a GET with the station
+// and version in the query, sent with the upload credentials; the answer is
+// plain text with one command per line:
+//
+//	interval DURATION   scan at this interval until restart ("default" undoes it)
+//	pause [DURATION]    pause uploads, until resume or for DURATION
+//	resume              end a pause
+//	scan                scan again right after this one
+//	reload              re-read config.env and areas.txt
+//	upload-log [LINES]  POST the latest log lines to SAI_COMMAND_URL
+//	update              install the latest release, if newer, and restart
+//	restart             restart the program
+//
+// Empty lines and lines starting with # are ignored. Failures are logged and
+// retried at the next poll.
+func (ac *AstroCam) pollServerCommands() {
+	if ac.config.CommandURL == "" {
+		return
+	}
+	if !ac.lastCommandPoll.IsZero() && ac.since(ac.lastCommandPoll) < ac.config.CommandInterval {
+		return
+	}
+	ac.lastCommandPoll = ac.clock.Now()
+
+	resp, err := ac.commandRequest(http.MethodGet, nil)
+	if err != nil {
+		slog.Warn("Cannot fetch server commands", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.Warn("Server commands request rejected", "url", ac.config.CommandURL, "status", resp.Status)
+		return
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 64*1024))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ac.runServerCommand(strings.Fields(line))
+	}
+}
+
+// runServerCommand carries out one command from SAI_COMMAND_URL.
+func (ac *AstroCam) runServerCommand(fields []string) {
+	name, args := strings.ToLower(fields[0]), fields[1:]
+	slog.Info("Server command", "command", strings.Join(fields, " "))
+	switch {
+	case name == "interval" && len(args) == 1:
+		if strings.EqualFold(args[0], "default") {
+			ac.intervalOverride = 0
+			slog.Info("Scan interval reset to SAI_INTERVAL", "interval", ac.scanInterval())
+			return
+		}
+		d, err := parseDuration(args[0])
+		if err != nil || d < MIN_INTERVAL*time.Second || d > MAX_INTERVAL*time.Second {
+			slog.Warn("Invalid interval from server", "value", args[0])
+			return
+		}
+		ac.intervalOverride = d
+		slog.Info("Scan interval set by server", "interval", d)
+	case name == "pause" && len(args) == 0:
+		ac.setOperatorPause(true)
+	case name == "pause" && len(args) == 1:
+		d, err := parseDuration(args[0])
+		if err != nil || d <= 0 {
+			slog.Warn("Invalid pause duration from server", "value", args[0])
+			return
+		}
+		ac.pauseUploads("Paused by the server", d, "")
+	case name == "resume" && len(args) == 0:
+		ac.pauseMu.Lock()
+		ac.uploadPauseUntil = time.Time{}
+		ac.pauseMu.Unlock()
+		ac.status.setPausedUntil(time.Time{})
+		ac.setOperatorPause(false)
+		slog.Info("Uploads resumed by the server")
+	case name == "scan" && len(args) == 0:
+		ac.requestScan()
+	case name == "reload" && len(args) == 0:
+		// The main loop applies it after this scan
+		reply := make(chan error, 1)
+		select {
+		case ac.reloadRequests <- reply:
+			go func() {
+				if err := <-reply; err != nil {
+					slog.Warn("Reload requested by the server failed", "error", err)
+				}
+			}()
+		default:
+		}
+	case name == "upload-log" && len(args) <= 1:
+		lines := DEFAULT_LOG_LINES
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 {
+				slog.Warn("Invalid line count from server", "value", args[0])
+				return
+			}
+			lines = n
+		}
+		ac.uploadRecentLog(lines)
+	case name == "update" && len(args) == 0:
+		ac.updateAndRestart()
+	case name == "restart" && len(args) == 0:
+		ac.requestRestart()
+	default:
+		slog.Warn("Unknown server command", "command", strings.Join(fields, " "))
+	}
+}
+
+// uploadRecentLog POSTs the latest log lines, oldest first, to
+// SAI_COMMAND_URL with log=1 in the query.
+func (ac *AstroCam) uploadRecentLog(lines int) {
+	entries := recentLog.list()
+	if len(entries) > lines {
+		entries = entries[:lines]
+	}
+	var b strings.Builder
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		fmt.Fprintf(&b, "%s %-5s %s\n", e.Time.Format("2006-01-02 15:04:05"), e.Level, e.Message)
+	}
+	resp, err := ac.commandRequest(http.MethodPost, strings.NewReader(b.String()))
+	if err != nil {
+		slog.Warn("Cannot send the log to the server", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.Warn("Server rejected the log", "status", resp.Status)
+		return
+	}
+	slog.Info("Log sent to the server", "lines", len(entries))
+}
+
+// commandRequest sends a request to SAI_COMMAND_URL with the station and
+// the software version added to the query, and log=1 for the POST of a log,
+// using the upload credentials and TLS settings.
+func (ac *AstroCam) commandRequest(method string, body io.Reader) (*http.Response, error) {
+	target, err := url.Parse(ac.config.CommandURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAI_COMMAND_URL: %w", err)
+	}
+	query := target.Query()
+	if ac.config.StationID != "" {
+		query.Set("station", ac.config.StationID)
+	}
+	query.Set("version", softwareVersion())
+	if method == http.MethodPost {
+		query.Set("log", "1")
+	}
+	target.RawQuery = query.Encode()
+	return authorizedRequest(ac.config, method, target, body)
+}
+
+// authorizedRequest sends a request with a plain-text body to a station
+// endpoint of the server, using the upload credentials and TLS settings of
+// config.
+func authorizedRequest(config *Config, method string, target *url.URL, body io.Reader) (*http.Response, error) {
+	uploader, err := newHTTPUploader(target, config)
+	if err != nil {
+		return nil, err
+	}
+	u := uploader.(*HTTPUploader)
+	req, err := http.NewRequest(method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", u.userAgent())
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	client := u.client(30 * time.Second)
+	if err := u.authenticate(client, req); err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,95 @@
+package astrocam
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SAI_SIGN_METHOD values; each is also the name of the signing tool.
+const (
+	signMinisign = "minisign"
+	signGPG      = "gpg"
+)
+
+// SIGN_TIMEOUT limits the signing tool, which may hang waiting for a
+// passphrase that cannot be entered.
+const SIGN_TIMEOUT = time.Minute
+
+// archiveSigner makes detached signatures of archives with minisign or GPG, so
+// that the server can verify that the data came from this station and not
+// from someone who learned the upload URL.
+type archiveSigner struct {
+	Method         string // signMinisign or signGPG
+	Key            string // minisign secret key file or GPG key ID (empty = the tool's default key)
+	PassphraseFile string // Its first line unlocks the key (empty = unencrypted key or gpg-agent)
+}
+
+// archiveSigner returns the signer configured with SAI_SIGN_METHOD, or nil if
+// archives are not signed.
+func (config *Config) archiveSigner() *archiveSigner {
+	if config.SignMethod == "" {
+		return nil
+	}
+	return &archiveSigner{Method: config.SignMethod, Key: config.SignKey, PassphraseFile: config.SignPassphraseFile}
+}
+
+// Sign returns the detached signature of a file and the name it is sent
+// under: FILE.minisig for minisign, FILE.asc (ASCII armored) for GPG.
+func (s *archiveSigner) Sign(ctx context.Context, path string) (name string, signature []byte, err error) {
+	dir, err := os.MkdirTemp("", "astrocam-sign-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var args []string
+	name = filepath.Base(path)
+	switch s.Method {
+	case signMinisign:
+		name += ".minisig"
+		args = []string{"-S", "-m", path, "-x", filepath.Join(dir, name)}
+		if s.Key != "" {
+			args = append(args, "-s", s.Key)
+		}
+	case signGPG:
+		name += ".asc"
+		args = []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", filepath.Join(dir, name)}
+		if s.Key != "" {
+			args = append(args, "--local-user", s.Key)
+		}
+		if s.PassphraseFile != "" {
+			args = append(args, "--pinentry-mode", "loopback", "--passphrase-fd", "0")
+		}
+		args = append(args, path)
+	default:
+		return "", nil, fmt.Errorf("unknown signing method %q", s.Method)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, SIGN_TIMEOUT)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.Method, args...)
+	if s.PassphraseFile != "" {
+		passphrase, err := readPasswordFile(s.PassphraseFile)
+		if err != nil {
+			return "", nil, err
+		}
+		cmd.Stdin = strings.NewReader(passphrase + "\n")
+	}
+	output, err := runChild(cmd)
+	if ctx.Err() != nil {
+		return "", nil, fmt.Errorf("%s killed after %s", s.Method, SIGN_TIMEOUT)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%s failed: %w, output: %s", s.Method, err, strings.TrimSpace(string(output)))
+	}
+	signature, err = os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", nil, fmt.Errorf("%s wrote no signature: %w", s.Method, err)
+	}
+	return name, signature, nil
+}
@@ -0,0 +1,502 @@
+package astrocam
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateFileName is the state database kept next to the executable.
+const stateFileName = "astrocam-state.jsonl"
+
+// DEFAULT_STATE_MAX_AGE is how long records stay in the state database.
+const DEFAULT_STATE_MAX_AGE = 90 * 24 * time.Hour
+
+const (
+	// stateMinAge is the shortest SAI_STATE_MAX_AGE: the nightly report and
+	// the results download read the records of the last day.
+	stateMinAge = 48 * time.Hour
+	// stateCompactInterval is the time between two compactions.
+	stateCompactInterval = 24 * time.Hour
+)
+
+// Record types and outcomes of the state database.
+const (
+	recordFrame  = "frame"
+	recordUpload = "upload"
+	recordFile   = "file"
+	recordRoof   = "roof"
+
+	outcomeArchived    = "archived"    // frame went into Archive
+	outcomeQuarantined = "quarantined" // frame failed the FITS sanity check
+	outcomeRejected    = "rejected"    // frame failed SAI_QUALITY_MIN_STARS or SAI_WEATHER_GATE
+	outcomeDuplicate   = "duplicate"   // frame contents were archived before
+	outcomeUploaded    = "uploaded"    // server confirmed Archive
+	outcomeFailed      = "failed"      // upload of Archive failed with Error
+	outcomeMoved       = "moved"       // file at Path moved to Target
+	outcomeDeleted     = "deleted"     // file at Path deleted
+)
+
+// stateRecord is one line of the state database. Frame records describe a
+// camera frame and what happened to it, upload records one upload attempt and
+// file records a frame or archive moved or deleted, failed attempts included
+// (with Error), and roof records a change of the roof state (in Outcome).
+type stateRecord struct {
+	Type     string     `json:"type"`
+	Time     time.Time  `json:"time"`
+	Outcome  string     `json:"outcome"`
+	Path     string     `json:"path,omitempty"`  // frame: camera path; file: path before the operation
+	Size     int64      `json:"size,omitempty"`  // frame or archive size in bytes
+	ModTime  *time.Time `json:"mtime,omitempty"` // frame: modification time when packed
+	SHA256   string     `json:"sha256,omitempty"`
+	Area     string     `json:"area,omitempty"`
+	Archive  string     `json:"archive,omitempty"`  // archive file name
+	Target   string     `json:"target,omitempty"`   // file: destination of a move
+	Server   string     `json:"server,omitempty"`   // upload: destination
+	Duration float64    `json:"duration,omitempty"` // upload: seconds
+	Error    string     `json:"error,omitempty"`
+}
+
+// frameKey identifies a frame version: a file rewritten under the same name
+// has a different size or modification time and counts as a new frame.
+type frameKey struct {
+	path    string
+	size    int64
+	modTime int64
+}
+
+// newFrameKey returns the key of a frame, by absolute path so that relative
+// and absolute spellings of the camera directory match.
+func newFrameKey(path string, info fs.FileInfo) frameKey {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return frameKey{path, info.Size(), info.ModTime().UnixNano()}
+}
+
+// stateDB records every frame that was packed, quarantined or rejected, with
+// its checksum and archive, every upload attempt and every frame or archive
+// moved or deleted, so that the fate of a missing frame can be reconstructed. It lets read-only mode
+// skip the frames already processed and duplicate detection find frames whose
+// contents were archived before. Records are appended to a JSON-lines
+// file, so a crash loses at most the line being written and the file can be
+// inspected with standard tools; only the frame and checksum indexes are kept
+// in memory. compact drops the records older than SAI_STATE_MAX_AGE, which
+// bounds both the file and the indexes.
+type stateDB struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	frames map[frameKey]bool
+	hashes map[string]string // SHA-256 of archived frames -> archive name
+	sizes  map[int64]bool    // Sizes of archived frames, checked before looking a checksum up
+	upload map[string]int64  // Bytes of the upload attempts by local day, for SAI_DAILY_UPLOAD_LIMIT
+}
+
+// openStateDB loads the frame index from path and opens it for appending.
+func openStateDB(path string) (*stateDB, error) {
+	db := &stateDB{path: path}
+	db.resetIndexes()
+
+	err := readStateRecords(path, db.index)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("cannot read state file: %w", err)
+	}
+
+	db.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open state file: %w", err)
+	}
+	return db, nil
+}
+
+// resetIndexes empties the in-memory indexes.
+func (db *stateDB) resetIndexes() {
+	db.frames = make(map[frameKey]bool)
+	db.hashes = make(map[string]string)
+	db.sizes = make(map[int64]bool)
+	db.upload = make(map[string]int64)
+}
+
+// index adds a record read from the file to the in-memory indexes.
+func (db *stateDB) index(rec *stateRecord) {
+	// Records without a type are frames, from the first read-only mode version
+	if (rec.Type == recordFrame || rec.Type == "") && rec.ModTime != nil {
+		db.frames[frameKey{rec.Path, rec.Size, rec.ModTime.UnixNano()}] = true
+	}
+	if rec.Type == recordFrame && rec.Outcome == outcomeArchived && rec.SHA256 != "" {
+		db.hashes[rec.SHA256] = rec.Archive
+		db.sizes[rec.Size] = true
+	}
+	if rec.Type == recordUpload {
+		db.upload[uploadDay(rec.Time)] += rec.Size
+	}
+}
+
+// readStateRecords calls fn for every record in the state file at path.
+func readStateRecords(path string, fn func(*stateRecord)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var rec stateRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A line cut short by a crash
+			slog.Warn("Ignoring damaged state record", "file", path, "line", line, "error", err)
+			continue
+		}
+		fn(&rec)
+	}
+	return scanner.Err()
+}
+
+// append writes a record and flushes it to disk.
+func (db *stateDB) append(rec *stateRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := db.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return db.file.Sync()
+}
+
+// processed reports whether the frame at path, as described by info, has
+// been recorded.
+func (db *stateDB) processed(path string, info fs.FileInfo) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.frames[newFrameKey(path, info)]
+}
+
+// newFrameRecord describes what happened to a camera frame. info describes
+// the frame when it was packed and hash is its SHA-256 at that time.
+func newFrameRecord(path string, info fs.FileInfo, hash, area, archive, outcome string, now time.Time) *stateRecord {
+	key := newFrameKey(path, info)
+	modTime := info.ModTime()
+	return &stateRecord{
+		Type: recordFrame, Time: now, Outcome: outcome,
+		Path: key.path, Size: info.Size(), ModTime: &modTime, SHA256: hash,
+		Area: area, Archive: archive,
+	}
+}
+
+// appendFrame writes a frame record and adds it to the indexes.
+func (db *stateDB) appendFrame(rec *stateRecord) error {
+	key := frameKey{rec.Path, rec.Size, rec.ModTime.UnixNano()}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.append(rec); err != nil {
+		return err
+	}
+	db.frames[key] = true
+	if rec.Outcome == outcomeArchived && rec.SHA256 != "" {
+		db.hashes[rec.SHA256] = rec.Archive
+		db.sizes[rec.Size] = true
+	}
+	return nil
+}
+
+// hasFrame reports whether the frame of a record has been recorded.
+func (db *stateDB) hasFrame(rec *stateRecord) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.frames[frameKey{rec.Path, rec.Size, rec.ModTime.UnixNano()}]
+}
+
+// archivedSize reports whether a frame of the given size was archived, so
+// that a frame of another size cannot be a duplicate.
+func (db *stateDB) archivedSize(size int64) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.sizes[size]
+}
+
+// archivedAs returns the archive a frame with the given SHA-256 went into,
+// or "" if no such frame was archived.
+func (db *stateDB) archivedAs(hash string) string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.hashes[hash]
+}
+
+// recordUpload records an upload attempt; uploadErr is nil on success.
+func (db *stateDB) recordUpload(archive, server string, size int64, hash string, duration time.Duration, uploadErr error, now time.Time) error {
+	rec := &stateRecord{
+		Type: recordUpload, Time: now, Outcome: outcomeUploaded,
+		Archive: archive, Server: server, Size: size, SHA256: hash, Duration: duration.Seconds(),
+	}
+	if uploadErr != nil {
+		rec.Outcome = outcomeFailed
+		rec.Error = uploadErr.Error()
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.upload[uploadDay(now)] += size
+	return db.append(rec)
+}
+
+// uploadedOn returns the bytes of the upload attempts made on the local day
+// named by uploadDay, successful or not: a failed upload may have sent most
+// of the archive.
+func (db *stateDB) uploadedOn(day string) int64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.upload[day]
+}
+
+// recordFile records a move (target set) or deletion of a file, with the size
+// and SHA-256 of its contents; fileErr is nil on success.
+func (db *stateDB) recordFile(path, target string, size int64, hash string, fileErr error, now time.Time) error {
+	rec := &stateRecord{
+		Type: recordFile, Time: now, Outcome: outcomeDeleted,
+		Path: path, Target: target, Size: size, SHA256: hash,
+	}
+	if target != "" {
+		rec.Outcome = outcomeMoved
+	}
+	if fileErr != nil {
+		rec.Error = fileErr.Error()
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.append(rec)
+}
+
+// recordRoof records that the roof was found in state.
+func (db *stateDB) recordRoof(state string, now time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.append(&stateRecord{Type: recordRoof, Time: now, Outcome: state})
+}
+
+// compact rewrites the state file without the records older than maxAge and
+// rebuilds the indexes from what is left. Two kinds of old records are kept:
+// a frame record whose frame is still unchanged at its path, which read-only
+// mode must go on skipping, and the last roof state, which the next nightly
+// report starts from. It returns the number of records dropped; the file is
+// left alone when there are none.
+func (db *stateDB) compact(maxAge time.Duration, now time.Time) (int, error) {
+	cutoff := now.Add(-maxAge)
+	keep := func(rec *stateRecord) bool {
+		return !rec.Time.Before(cutoff) || ((rec.Type == recordFrame || rec.Type == "") && frameUnchanged(rec))
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var dropped, lastRoof, roofs int
+	err := readStateRecords(db.path, func(rec *stateRecord) {
+		if rec.Type == recordRoof && rec.Time.Before(cutoff) {
+			roofs++
+			lastRoof = roofs
+		} else if !keep(rec) {
+			dropped++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if roofs > 1 {
+		dropped += roofs - 1
+	}
+	if dropped == 0 {
+		return 0, nil
+	}
+
+	tmp := db.path + partSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	compacted := &stateDB{}
+	compacted.resetIndexes()
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	roofs = 0
+	err = readStateRecords(db.path, func(rec *stateRecord) {
+		if rec.Type == recordRoof && rec.Time.Before(cutoff) {
+			if roofs++; roofs != lastRoof {
+				return
+			}
+		} else if !keep(rec) {
+			return
+		}
+		if encErr := enc.Encode(rec); encErr != nil && err == nil {
+			err = encErr
+		}
+		compacted.index(rec)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Windows cannot replace a file that is open
+	db.file.Close()
+	renameErr := os.Rename(tmp, db.path)
+	db.file, err = os.OpenFile(db.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if renameErr != nil {
+		return 0, renameErr
+	}
+	db.frames, db.hashes, db.sizes, db.upload = compacted.frames, compacted.hashes, compacted.sizes, compacted.upload
+	if err != nil {
+		return dropped, fmt.Errorf("cannot open state file: %w", err)
+	}
+	return dropped, nil
+}
+
+// frameUnchanged reports whether the frame of a record is still at its path
+// with the same size and modification time.
+func frameUnchanged(rec *stateRecord) bool {
+	if rec.ModTime == nil {
+		return false
+	}
+	info, err := os.Stat(rec.Path)
+	return err == nil && info.Size() == rec.Size && info.ModTime().Equal(*rec.ModTime)
+}
+
+// Close closes the state file.
+func (db *stateDB) Close() error {
+	return db.file.Close()
+}
+
+// hashFile returns the hex SHA-256 of a file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// stateDatabaseNeeded returns the setting that needs the state database, so
+// that SAI_STATE_DATABASE cannot turn it off, or "" if none does.
+func stateDatabaseNeeded(config *Config) string {
+	switch {
+	case config.CameraReadOnly:
+		return "SAI_CAMERA_READ_ONLY"
+	case config.DailyUploadLimit > 0:
+		return "SAI_DAILY_UPLOAD_LIMIT"
+	case config.ResultsURL != "":
+		return "SAI_RESULTS_URL"
+	case config.ReportDirectory != "":
+		return "SAI_REPORT_DIRECTORY"
+	case config.SkipDuplicates:
+		return "SAI_SKIP_DUPLICATES"
+	}
+	return ""
+}
+
+// compactState drops the records older than SAI_STATE_MAX_AGE from the
+// state database, at startup and then once a day.
+func (ac *AstroCam) compactState() {
+	if ac.state == nil || ac.config.StateMaxAge <= 0 {
+		return
+	}
+	now := ac.clock.Now()
+	if !ac.lastCompaction.IsZero() && now.Sub(ac.lastCompaction) < stateCompactInterval {
+		return
+	}
+	ac.lastCompaction = now
+	dropped, err := ac.state.compact(ac.config.StateMaxAge, now)
+	if err != nil {
+		slog.Warn("Cannot compact the state database", "error", err)
+	}
+	if dropped > 0 {
+		slog.Info("Dropped old records from the state database", "records", dropped, "older_than", ac.config.StateMaxAge)
+	}
+}
+
+// moveRecorded moves a frame or archive with moveFile and records the move,
+// with the checksum of the file at its destination, in the state database.
+func (ac *AstroCam) moveRecorded(src, dst string) error {
+	err := moveFile(ac.fs, src, dst)
+	if err == nil {
+		ac.forgetHeader(src)
+	}
+	if ac.state != nil {
+		digested := dst
+		if err != nil {
+			digested = src
+		}
+		size, hash := ac.fileDigest(digested)
+		ac.recordFile(src, dst, size, hash, err)
+	}
+	return err
+}
+
+// removeRecorded deletes a frame or archive and records the deletion, with
+// the checksum of what was deleted, in the state database.
+func (ac *AstroCam) removeRecorded(path string) error {
+	var size int64
+	var hash string
+	if ac.state != nil {
+		size, hash = ac.fileDigest(path)
+	}
+	err := ac.fs.Remove(path)
+	if err == nil {
+		ac.forgetHeader(path)
+	}
+	if ac.state != nil {
+		ac.recordFile(path, "", size, hash, err)
+	}
+	return err
+}
+
+// fileDigest returns the size and SHA-256 of a file, or zero values if it
+// cannot be read.
+func (ac *AstroCam) fileDigest(path string) (int64, string) {
+	info, err := ac.fs.Stat(path)
+	if err != nil {
+		return 0, ""
+	}
+	hash, _ := hashFile(path)
+	return info.Size(), hash
+}
+
+// recordFile writes a file record with absolute paths, logging a failure.
+func (ac *AstroCam) recordFile(path, target string, size int64, hash string, fileErr error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if target != "" {
+		if abs, err := filepath.Abs(target); err == nil {
+			target = abs
+		}
+	}
+	if err := ac.state.recordFile(path, target, size, hash, fileErr, ac.clock.Now()); err != nil {
+		slog.Warn("Cannot record file operation", "file", filepath.Base(path), "error", err)
+	}
+}
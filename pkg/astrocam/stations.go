@@ -0,0 +1,122 @@
+package astrocam
+
+import (
+	"slices"
+	"sync"
+)
+
+// uploadTurns shares the upload link between the stations run by one
+// process (see ShareUploads). At most slots uploads run at once, and when
+// several stations are waiting, the one served least recently goes next, so
+// a station with a long backlog cannot starve the others. A nil
+// *uploadTurns, for a process running a single station, grants every turn
+// at once.
+type uploadTurns struct {
+	mu      sync.Mutex
+	slots   int
+	busy    int
+	waiting []*turnRequest    // in order of arrival
+	served  map[string]uint64 // turn number each station last got
+	turn    uint64
+}
+
+// turnRequest is a station waiting for its turn; grant is closed when the
+// turn is given.
+type turnRequest struct {
+	station string
+	grant   chan struct{}
+}
+
+// ShareUploads makes the stations, run in one process, take turns for their
+// uploads: at most parallel of them upload at once (at least one), and a
+// waiting station that was served least recently goes first.
+func ShareUploads(stations []*AstroCam, parallel int) {
+	turns := &uploadTurns{slots: max(parallel, 1), served: make(map[string]uint64)}
+	for _, ac := range stations {
+		ac.turns = turns
+	}
+}
+
+// acquire waits for an upload turn of station and reports whether it got
+// one; it gives up when stop is closed. Every turn is given back with
+// release.
+func (t *uploadTurns) acquire(station string, stop <-chan struct{}) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	if t.busy < t.slots && len(t.waiting) == 0 {
+		t.give(station)
+		t.mu.Unlock()
+		return true
+	}
+	request := &turnRequest{station: station, grant: make(chan struct{})}
+	t.waiting = append(t.waiting, request)
+	t.mu.Unlock()
+
+	select {
+	case <-request.grant:
+		return true
+	case <-stop:
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if i := slices.Index(t.waiting, request); i >= 0 {
+			t.waiting = slices.Delete(t.waiting, i, i+1)
+			return false
+		}
+		// The turn was given meanwhile: pass it on
+		t.busy--
+		t.next()
+		return false
+	}
+}
+
+// tryAcquire takes a turn for station if one is free and no other station
+// is waiting, for uploads that have a fallback, such as streaming.
+func (t *uploadTurns) tryAcquire(station string) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.busy < t.slots && len(t.waiting) == 0 {
+		t.give(station)
+		return true
+	}
+	return false
+}
+
+// release gives back a turn taken with acquire or tryAcquire.
+func (t *uploadTurns) release() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.busy--
+	t.next()
+}
+
+// give records a turn given to station. The caller holds t.mu.
+func (t *uploadTurns) give(station string) {
+	t.busy++
+	t.turn++
+	t.served[station] = t.turn
+}
+
+// next gives the free turns to the waiting stations served least recently.
+// The caller holds t.mu.
+func (t *uploadTurns) next() {
+	for t.busy < t.slots && len(t.waiting) > 0 {
+		best := 0
+		for i, request := range t.waiting {
+			if t.served[request.station] < t.served[t.waiting[best].station] {
+				best = i
+			}
+		}
+		request := t.waiting[best]
+		t.waiting = slices.Delete(t.waiting, best, best+1)
+		t.give(request.station)
+		close(request.grant)
+	}
+}
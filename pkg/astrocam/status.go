@@ -0,0 +1,454 @@
+package astrocam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// healthStallMinimum is the shortest time without pipeline activity after
+// which /healthz reports the pipeline as stuck. A single upload attempt
+// (throttle wait, preflight and the POST itself) fits well within it.
+const healthStallMinimum = 10 * time.Minute
+
+// uploadHistoryLimit is the number of upload attempts kept for the dashboard.
+const uploadHistoryLimit = 20
+
+// uploadRecord is one upload attempt as shown in the dashboard history.
+type uploadRecord struct {
+	Time    time.Time
+	Archive string
+	Error   string // empty for a successful upload
+}
+
+// runtimeStatus tracks what the pipeline has been doing. It is updated by the
+// main loop and read concurrently by the status HTTP server.
+type runtimeStatus struct {
+	mu                sync.Mutex
+	started           time.Time
+	lastScan          time.Time // start of the most recent scan
+	lastScanDone      time.Time // end of the most recent completed scan
+	lastActivity      time.Time // last scan start or upload attempt
+	lastUpload        time.Time // last confirmed upload
+	lastUploadArchive string
+	uploads           int
+	uploadErrors      int
+	uploadedBytes     int64 // size of the archives uploaded
+	archives          int   // archives created
+	lastError         string
+	lastErrorTime     time.Time
+	pausedUntil       time.Time
+	areaCounts        map[string]int // frames waiting per area at the last scan
+	areas             map[string]*areaActivity
+	history           []uploadRecord // latest upload attempts, oldest first
+	packingArea       string         // area the packer is working on
+	uploadingArchive  string         // archive the uploader is sending
+	uploadStarted     time.Time
+}
+
+func newRuntimeStatus() *runtimeStatus {
+	now := time.Now()
+	return &runtimeStatus{started: now, lastActivity: now, areas: make(map[string]*areaActivity)}
+}
+
+func (s *runtimeStatus) scanStarted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastScan = time.Now()
+	s.lastActivity = s.lastScan
+}
+
+func (s *runtimeStatus) scanFinished() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastScanDone = time.Now()
+	s.lastActivity = s.lastScanDone
+}
+
+func (s *runtimeStatus) uploadSucceeded(archive string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUpload = time.Now()
+	s.lastUploadArchive = archive
+	s.lastActivity = s.lastUpload
+	s.uploads++
+	s.addHistory(uploadRecord{Time: s.lastUpload, Archive: archive})
+}
+
+func (s *runtimeStatus) uploadFailed(archive string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploadErrors++
+	s.lastActivity = time.Now()
+	s.lastError = err.Error()
+	s.lastErrorTime = s.lastActivity
+	s.addHistory(uploadRecord{Time: s.lastActivity, Archive: archive, Error: s.lastError})
+}
+
+// addHistory appends an upload attempt; the caller holds s.mu.
+func (s *runtimeStatus) addHistory(record uploadRecord) {
+	s.history = append(s.history, record)
+	if len(s.history) > uploadHistoryLimit {
+		s.history = s.history[len(s.history)-uploadHistoryLimit:]
+	}
+}
+
+func (s *runtimeStatus) setAreaCounts(counts map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.areaCounts = counts
+}
+
+func (s *runtimeStatus) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+	s.lastErrorTime = time.Now()
+}
+
+// uploadedSize adds the size of an uploaded archive.
+func (s *runtimeStatus) uploadedSize(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploadedBytes += size
+}
+
+// cycleCounts are the totals a cycle summary reports the growth of.
+type cycleCounts struct {
+	archives, uploads, uploadErrors int
+	bytes                           int64
+}
+
+func (s *runtimeStatus) counts() cycleCounts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return cycleCounts{archives: s.archives, uploads: s.uploads, uploadErrors: s.uploadErrors, bytes: s.uploadedBytes}
+}
+
+// setPacking records the area the packer works on, "" when it is done.
+func (s *runtimeStatus) setPacking(area string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packingArea = area
+}
+
+// setUploading records the archive the uploader sends, "" when it is done.
+func (s *runtimeStatus) setUploading(archive string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploadingArchive = archive
+	s.uploadStarted = time.Now()
+}
+
+func (s *runtimeStatus) setPausedUntil(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pausedUntil = t
+}
+
+// healthReport is the JSON document served on /healthz.
+type healthReport struct {
+	Status            string            `json:"status"` // "ok", "stuck" or "camera unreachable"
+	Version           string            `json:"version,omitempty"`
+	UptimeSeconds     int64             `json:"uptime_seconds"`
+	Time              time.Time         `json:"time"`
+	LastScan          *time.Time        `json:"last_scan,omitempty"`
+	LastScanDone      *time.Time        `json:"last_scan_completed,omitempty"`
+	LastUpload        *time.Time        `json:"last_upload,omitempty"`
+	LastUploadArchive string            `json:"last_upload_archive,omitempty"`
+	Uploads           int               `json:"uploads"`
+	UploadErrors      int               `json:"upload_errors"`
+	LastError         string            `json:"last_error,omitempty"`
+	LastErrorTime     *time.Time        `json:"last_error_time,omitempty"`
+	PausedUntil       *time.Time        `json:"paused_until,omitempty"`
+	UploadedToday     int64             `json:"uploaded_today_bytes"`
+	DailyUploadLimit  int64             `json:"daily_upload_limit_bytes,omitempty"`
+	UploadLimitHit    bool              `json:"daily_upload_limit_reached,omitempty"`
+	CameraUnreachable bool              `json:"camera_unreachable,omitempty"`
+	PendingArchives   int               `json:"pending_archives"`
+	DiskFreeBytes     map[string]uint64 `json:"disk_free_bytes"`
+}
+
+// timePtr returns nil for the zero time so that it is omitted from JSON.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// stallTimeout is how long the pipeline may go without activity before it is
+// considered stuck: three scan intervals, but never less than healthStallMinimum.
+func (ac *AstroCam) stallTimeout() time.Duration {
+	return max(3*ac.scanInterval(), healthStallMinimum)
+}
+
+// healthReport collects the current pipeline state. Like the other status
+// server helpers it must be called with ac.configMu held for reading.
+func (ac *AstroCam) healthReport() healthReport {
+	s := ac.status
+	s.mu.Lock()
+	report := healthReport{
+		Status:            "ok",
+		Version:           Version,
+		UptimeSeconds:     int64(time.Since(s.started).Seconds()),
+		Time:              time.Now(),
+		LastScan:          timePtr(s.lastScan),
+		LastScanDone:      timePtr(s.lastScanDone),
+		LastUpload:        timePtr(s.lastUpload),
+		LastUploadArchive: s.lastUploadArchive,
+		Uploads:           s.uploads,
+		UploadErrors:      s.uploadErrors,
+		LastError:         s.lastError,
+		LastErrorTime:     timePtr(s.lastErrorTime),
+		DiskFreeBytes:     make(map[string]uint64),
+	}
+	if s.pausedUntil.After(time.Now()) {
+		report.PausedUntil = timePtr(s.pausedUntil)
+	}
+	if time.Since(s.lastActivity) > ac.stallTimeout() {
+		report.Status = "stuck"
+	}
+	s.mu.Unlock()
+	report.CameraUnreachable = ac.cameraUnreachable.Load()
+	report.UploadedToday = ac.uploadedToday()
+	report.DailyUploadLimit = ac.config.DailyUploadLimit
+	report.UploadLimitHit = ac.uploadLimitReached()
+	if report.CameraUnreachable && report.Status == "ok" {
+		report.Status = "camera unreachable"
+	}
+
+	if archives, err := ac.getArchiveFiles(); err == nil {
+		report.PendingArchives = len(archives)
+	}
+	for name, dir := range ac.statusVolumes {
+		if free, err := diskFree(dir); err == nil {
+			report.DiskFreeBytes[name] = free
+		}
+	}
+	return report
+}
+
+// Activity is what the pipeline is doing, as shown by the Windows tray icon.
+type Activity string
+
+const (
+	ActivityIdle      Activity = "idle"
+	ActivityArchiving Activity = "archiving"
+	ActivityUploading Activity = "uploading"
+	ActivityPaused    Activity = "paused"
+	ActivityError     Activity = "error"
+)
+
+// Activity returns what the pipeline is doing with a one-line summary of its
+// state. It is an error while the pipeline is stuck, the camera directory or
+// the upload server cannot be reached or the last upload failed; uploading
+// and archiving take precedence over a pause, which only takes effect
+// between jobs.
+func (ac *AstroCam) Activity() (Activity, string) {
+	ac.configMu.RLock()
+	report := ac.healthReport()
+	ac.configMu.RUnlock()
+	return ac.activity(report)
+}
+
+// activity is Activity for a health report already collected.
+func (ac *AstroCam) activity(report healthReport) (Activity, string) {
+	ac.status.mu.Lock()
+	var lastFailure string
+	if n := len(ac.status.history); n > 0 {
+		lastFailure = ac.status.history[n-1].Error
+	}
+	ac.status.mu.Unlock()
+
+	summary := fmt.Sprintf("%d archive(s) waiting, %d uploaded", report.PendingArchives, report.Uploads)
+	switch {
+	case report.Status != "ok":
+		return ActivityError, report.Status
+	case ac.captivePortal.Load():
+		return ActivityError, "captive portal on the network"
+	case ac.offline.Load():
+		return ActivityError, "upload server unreachable"
+	case lastFailure != "":
+		return ActivityError, "upload failed: " + lastFailure
+	case ac.watchdog.working("uploader"):
+		return ActivityUploading, summary
+	case ac.watchdog.working("packer"):
+		return ActivityArchiving, summary
+	case ac.Paused() || report.PausedUntil != nil:
+		return ActivityPaused, summary
+	case report.UploadLimitHit:
+		return ActivityPaused, "daily upload limit reached, " + summary
+	default:
+		return ActivityIdle, summary
+	}
+}
+
+// handleHealth serves /healthz: 200 while the pipeline is making progress,
+// 503 once it has been inactive for longer than stallTimeout.
+func (ac *AstroCam) handleHealth(w http.ResponseWriter, r *http.Request) {
+	ac.configMu.RLock()
+	report := ac.healthReport()
+	ac.configMu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
+
+// resolveStatusVolumes records the absolute paths of the directories whose
+// free space is reported. They are resolved up front because the main loop
+// temporarily changes the working directory while packing archives.
+func (ac *AstroCam) resolveStatusVolumes() {
+	ac.statusVolumes = make(map[string]string)
+	for name, dir := range map[string]string{
+		"camera":    ac.config.CameraDirectory,
+		"temp":      ac.tempDirectory,
+		"processed": ac.config.ProcessedDirectory,
+	} {
+		if abs, err := filepath.Abs(dir); err == nil {
+			ac.statusVolumes[name] = abs
+		}
+	}
+}
+
+// FetchStatus returns the status of a running instance as JSON: the
+// /api/status document from its status server if SAI_STATUS_LISTEN is set,
+// otherwise the contents of SAI_STATUS_FILE.
+func FetchStatus(config *Config) ([]byte, error) {
+	if config.StatusListen != "" {
+		base, err := statusServerURL(config)
+		if err != nil {
+			return nil, err
+		}
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get(base + "/api/status")
+		if err != nil {
+			return nil, fmt.Errorf("status server not reachable (is astrocam running?): %w", err)
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+	if config.StatusFile != "" {
+		return os.ReadFile(config.StatusFile)
+	}
+	return nil, errors.New("neither SAI_STATUS_LISTEN nor SAI_STATUS_FILE is set")
+}
+
+// statusServerURL returns the base URL of the status server of a running
+// instance, on the loopback interface if it listens on all of them.
+func statusServerURL(config *Config) (string, error) {
+	host, port, err := net.SplitHostPort(config.StatusListen)
+	if err != nil {
+		return "", fmt.Errorf("invalid SAI_STATUS_LISTEN %q: %w", config.StatusListen, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port), nil
+}
+
+// ControlInstance sends a control API action ("trigger", "pause", "resume"
+// or "reload") to the running instance through its status server and returns
+// its answer.
+func ControlInstance(config *Config, action string) (string, error) {
+	if config.StatusListen == "" {
+		return "", errors.New("SAI_STATUS_LISTEN is not set, so the running instance cannot be reached")
+	}
+	base, err := statusServerURL(config)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, base+"/api/"+action, nil)
+	if err != nil {
+		return "", err
+	}
+	if config.StatusToken != "" {
+		req.Header.Set("Authorization", "Bearer "+config.StatusToken)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("status server not reachable (is astrocam running?): %w", err)
+	}
+	defer resp.Body.Close()
+	var answer apiResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return "", fmt.Errorf("unexpected answer from the status server (%s): %w", resp.Status, err)
+	}
+	if !answer.OK {
+		return "", fmt.Errorf("%s: %s", action, answer.Error)
+	}
+	return answer.Message, nil
+}
+
+// startStatusServer starts the HTTP status server on SAI_STATUS_LISTEN in the
+// background. It returns an error only if the address cannot be bound.
+func (ac *AstroCam) startStatusServer() (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", ac.handleHealth)
+	mux.HandleFunc("/metrics", ac.handleMetrics)
+	mux.HandleFunc("/", ac.handleDashboard)
+	mux.HandleFunc("/trigger", ac.handleDashboardAction)
+	mux.HandleFunc("/pause", ac.handleDashboardAction)
+	mux.HandleFunc("/resume", ac.handleDashboardAction)
+	ac.registerAPI(mux)
+	if ac.config.StatusPprof {
+		// CPU, heap and goroutine profiles, e.g. for
+		// go tool pprof http://127.0.0.1:8080/debug/pprof/profile?seconds=30
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	listener, err := net.Listen("tcp", ac.config.StatusListen)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Status server stopped", "error", err)
+		}
+	}()
+	slog.Info("Status server listening", "address", listener.Addr().String())
+	return server, nil
+}
+
+// writeStatusFile writes the status snapshot to SAI_STATUS_FILE. The file is
+// written under a temporary name and renamed into place, so a reader (or an
+// rsync job) never sees a partially written file.
+func (ac *AstroCam) writeStatusFile() {
+	if ac.config.StatusFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(ac.statusSnapshot(), "", "  ")
+	if err != nil {
+		slog.Warn("Cannot encode status file", "error", err)
+		return
+	}
+
+	tmpPath := ac.config.StatusFile + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0644); err != nil {
+		slog.Warn("Cannot write status file", "path", tmpPath, "error", err)
+		return
+	}
+	if err := os.Rename(tmpPath, ac.config.StatusFile); err != nil {
+		os.Remove(tmpPath)
+		slog.Warn("Cannot replace status file", "path", ac.config.StatusFile, "error", err)
+	}
+}
@@ -0,0 +1,105 @@
+package astrocam
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// STREAMED is returned by packGroup for an archive that was uploaded while it
+// was written (SAI_STREAM_UPLOAD) and so is not in the temp directory.
+const STREAMED = "STREAMED"
+
+// streamUnsupported returns why archives cannot be streamed to the server
+// with this configuration and archive format, or "" if they can.
+func streamUnsupported(config *Config, archiver Archiver) string {
+	if _, ok := archiver.(StreamArchiver); !ok {
+		return "the archive format cannot be streamed; use SAI_ARCHIVE_MODE=zip"
+	}
+	switch {
+	case config.UploadInterval > 0 || config.UploadBatch > 0:
+		return "SAI_UPLOAD_INTERVAL and SAI_UPLOAD_BATCH upload the archives in batches"
+	case config.SignMethod != "":
+		return "SAI_SIGN_METHOD signs the finished archive"
+	case config.PreUploadHook != "":
+		return "SAI_PRE_UPLOAD_HOOK runs on the finished archive"
+	case config.AckURL != "":
+		return "SAI_ACK_URL keeps the archive until the server confirms its ingestion"
+	case config.UploadedDirectory != "":
+		return "SAI_UPLOADED_DIRECTORY keeps a copy of every uploaded archive"
+	}
+	return ""
+}
+
+// streamArchive uploads the archive of files while writing it, so that it
+// takes no room in the temp directory, and reports whether the server
+// confirmed it. It returns false without trying when the upload cannot go
+// ahead now: uploads are paused or outside SAI_UPLOAD_HOURS, the server is
+// unreachable or busy, another station of the process is uploading (see
+// ShareUploads), or its uploader cannot stream; err is the failure of
+// an upload that was tried. The caller then writes the archive to the temp
+// directory, from where it is uploaded as usual. The metadata sidecar of the
+// archive must be written already.
+func (ac *AstroCam) streamArchive(area, archiveFileName string, files []string) (bool, error) {
+	archiver, ok := ac.archiver.(StreamArchiver)
+	if !ok || ac.isUploadPaused() || !ac.config.UploadHours.active(ac.clock.Now()) {
+		return false, nil
+	}
+	if ac.config.DailyUploadLimit > 0 {
+		// The frames are an upper bound of the compressed archive
+		var size int64
+		for _, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				size += info.Size()
+			}
+		}
+		if _, ok := ac.tryReserveUpload(size); !ok {
+			return false, nil
+		}
+		defer ac.releaseUpload(size)
+	}
+	server := ac.archiveServer(archiveFileName)
+	if !ac.serverReachable(server) {
+		return false, nil
+	}
+	uploader, err := ac.uploaderFor(server)
+	if err != nil {
+		return false, nil // reported by the upload stage
+	}
+	streamer, ok := uploader.(StreamUploader)
+	if !ok {
+		slog.Debug("The uploader of this destination cannot stream, writing the archive to the temp directory", "server", server)
+		return false, nil
+	}
+	if !ac.preflight(uploader) {
+		return false, nil
+	}
+	if !ac.turns.tryAcquire(ac.profile) {
+		return false, nil // another station of this process is uploading
+	}
+	defer ac.turns.release()
+
+	name := filepath.Base(archiveFileName)
+	slog.Info("Streaming archive to server", "area", area, "archive", name, "format", ac.archiver, "server", server)
+	event := ac.newUploadEvent(archiveFileName, server)
+	start := ac.clock.Now()
+	size, hash, err := streamer.UploadStream(context.Background(), name, readArchiveMetaRaw(archiveFileName), func(w io.Writer) error {
+		return archiver.Stream(w, filepath.Join(ac.tempDirectory, partialDirName), files)
+	})
+	event.Size = size
+	ac.recordUploadAttempt(name, server, size, hash, start, err)
+	ac.uploadFinished(event, err)
+	if err != nil {
+		slog.Error("Upload error, writing the archive to the temp directory", "archive", name, "error", err)
+		ac.status.uploadFailed(name, err)
+		ac.writeStatusFile()
+		ac.pauseForRejection(err)
+		return false, err
+	}
+	ac.status.uploadSucceeded(name)
+	ac.writeStatusFile()
+	slog.Info("Successfully uploaded", "archive", name)
+	return true, nil
+}
@@ -0,0 +1,111 @@
+package astrocam
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SAI_TEMP_CLEANUP values.
+const (
+	tempCleanupQuarantine = "quarantine" // move to the failed directory, from where reupload can send it
+	tempCleanupRemove     = "remove"
+)
+
+// cleanTempDirectory deletes what an interrupted run may have left in the temp
+// directory and its per-area subdirectories next to the archives: files still
+// being written, sidecars whose archive was never completed and empty files.
+// Empty archives, and archives older than SAI_TEMP_MAX_AGE, are quarantined
+// or removed (SAI_TEMP_CLEANUP). Other files, such as previews and the PAUSE
+// file, are left alone. Area subdirectories left empty are removed.
+func (ac *AstroCam) cleanTempDirectory() {
+	ac.cleanTempFiles(ac.tempDirectory)
+	dirs, err := ac.tempAreaDirectories()
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		ac.cleanTempFiles(dir)
+		// Fails unless empty
+		os.Remove(dir)
+	}
+}
+
+// cleanTempFiles cleans up the files of one directory for cleanTempDirectory.
+func (ac *AstroCam) cleanTempFiles(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	ext := ac.archiver.Extension()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == pauseFileName {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, partSuffix) {
+			os.Remove(path)
+			continue
+		}
+		if archive, ok := strings.CutSuffix(path, ackSuffix); ok {
+			if _, err := os.Stat(archive); errors.Is(err, fs.ErrNotExist) {
+				os.Remove(path)
+			}
+			continue
+		}
+		if archive, ok := strings.CutSuffix(path, ".json"); ok {
+			// The sidecar of an archive disposed of above has gone with it
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			if _, err := os.Stat(archive); errors.Is(err, fs.ErrNotExist) {
+				slog.Info("Removing metadata of an incomplete archive", "file", name)
+				os.Remove(path)
+			}
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		isArchive := strings.HasSuffix(name, ext)
+		switch {
+		case info.Size() == 0 && !isArchive:
+			slog.Warn("Removing empty file from the temp directory", "file", name)
+			os.Remove(path)
+		case info.Size() == 0:
+			ac.disposeTempArchive(path, "the archive is empty")
+		case isArchive && ac.config.TempMaxAge > 0:
+			if age := ac.clock.Now().Sub(info.ModTime()); age > ac.config.TempMaxAge {
+				ac.disposeTempArchive(path, fmt.Sprintf("not uploaded for %s (SAI_TEMP_MAX_AGE=%s)", age.Round(time.Second), ac.config.TempMaxAge))
+			}
+		}
+	}
+}
+
+// disposeTempArchive quarantines or removes an archive found unusable at
+// startup, as SAI_TEMP_CLEANUP says.
+func (ac *AstroCam) disposeTempArchive(archive, reason string) {
+	name := filepath.Base(archive)
+	if ac.config.TempCleanup == tempCleanupRemove {
+		slog.Warn("Removing archive from the temp directory", "archive", name, "reason", reason)
+		if err := ac.removeRecorded(archive); err != nil {
+			slog.Warn("Cannot remove archive", "archive", name, "error", err)
+			return
+		}
+		os.Remove(archiveMetaPath(archive))
+		return
+	}
+	dst, err := ac.moveToFailed(archive, reason, nil)
+	if err != nil {
+		slog.Warn("Cannot move archive to the failed directory", "archive", name, "error", err)
+		return
+	}
+	slog.Warn("Moved archive from the temp directory to the failed directory", "archive", name, "reason", reason, "path", dst)
+}
@@ -0,0 +1,69 @@
+package astrocam
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// uploadThrottle spaces the uploads to each destination at least
+// SAI_UPLOAD_THROTTLE apart. Every destination has a token bucket holding
+// one token, refilled one throttle period after it was taken, so uploads to
+// different servers do not wait for each other. It is safe for concurrent
+// use: two workers waiting for the same destination get successive tokens
+// instead of both starting when the period is over.
+type uploadThrottle struct {
+	mu   sync.Mutex
+	next map[string]time.Time // when the token of a destination is refilled
+}
+
+func newUploadThrottle() *uploadThrottle {
+	return &uploadThrottle{next: make(map[string]time.Time)}
+}
+
+// reserve takes the token of destination and returns how long to wait
+// before it may be used; the next token comes interval after that.
+func (t *uploadThrottle) reserve(destination string, now time.Time, interval time.Duration) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	start := now
+	if next := t.next[destination]; next.After(now) {
+		start = next
+	}
+	t.next[destination] = start.Add(interval)
+	return start.Sub(now)
+}
+
+// bandwidthLimiter reads an upload body no faster than rate bytes per second
+// on average (SAI_UPLOAD_BANDWIDTH), so that uploads leave room on a shared
+// link. Reads are kept to a tenth of a second's worth to send evenly.
+type bandwidthLimiter struct {
+	body  io.ReadCloser
+	rate  int64
+	start time.Time
+	sent  int64
+}
+
+func newBandwidthLimiter(body io.ReadCloser, rate int64) *bandwidthLimiter {
+	return &bandwidthLimiter{body: body, rate: rate}
+}
+
+func (l *bandwidthLimiter) Read(p []byte) (int, error) {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	if chunk := l.rate/10 + 1; int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+	n, err := l.body.Read(p)
+	l.sent += int64(n)
+	due := time.Duration(float64(l.sent) / float64(l.rate) * float64(time.Second))
+	if wait := due - time.Since(l.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+func (l *bandwidthLimiter) Close() error {
+	return l.body.Close()
+}
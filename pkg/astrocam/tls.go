@@ -0,0 +1,34 @@
+package astrocam
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// tlsConfig returns the TLS settings for connections to the upload server:
+// the certificates of SAI_CA_FILE trusted in addition to the system ones, and
+// no verification at all with SAI_TLS_INSECURE. It returns nil when neither
+// is set, so the Go defaults apply.
+func (config *Config) tlsConfig() (*tls.Config, error) {
+	if config.CAFile == "" && !config.TLSInsecure {
+		return nil, nil
+	}
+	conf := &tls.Config{InsecureSkipVerify: config.TLSInsecure}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read SAI_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("SAI_CA_FILE %s contains no PEM certificate", config.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
@@ -0,0 +1,195 @@
+package astrocam
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// tuiRefresh is the time between two redraws of the terminal dashboard.
+const tuiRefresh = time.Second
+
+// tuiThroughputWindow is the time over which the live upload rate is
+// averaged.
+const tuiThroughputWindow = 5 * time.Second
+
+// tuiWidth is the width lines are cut to when COLUMNS is not set.
+const tuiWidth = 100
+
+// tuiLogLines is the number of recent log lines on the terminal dashboard.
+const tuiLogLines = 8
+
+// uploadedBytes counts the bytes sent in upload bodies by every station of
+// the process, for the live throughput of the terminal dashboard.
+var uploadedBytes atomic.Int64
+
+// sentCounter adds what is read from an upload body to uploadedBytes.
+type sentCounter struct {
+	io.ReadCloser
+}
+
+func (c sentCounter) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	uploadedBytes.Add(int64(n))
+	return n, err
+}
+
+// throughputSample is the byte count of uploadedBytes at a time.
+type throughputSample struct {
+	time  time.Time
+	bytes int64
+}
+
+// RunTUI draws a dashboard of the pipeline on the ANSI terminal out every
+// second until stop is closed, in place of the scrolling log: the state, what
+// the packer and uploader work on, the queues, the live upload rate, the
+// frames waiting per area, the latest uploads and the latest log lines. The
+// caller sends the log elsewhere meanwhile (SetLogOutput).
+func (ac *AstroCam) RunTUI(out io.Writer, stop <-chan struct{}) {
+	width := tuiWidth
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 20 {
+		width = n - 1
+	}
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	fmt.Fprint(out, "\x1b[2J")
+	var samples []throughputSample
+	for {
+		now := time.Now()
+		samples = append(samples, throughputSample{now, uploadedBytes.Load()})
+		for len(samples) > 2 && now.Sub(samples[1].time) >= tuiThroughputWindow {
+			samples = samples[1:]
+		}
+		var rate float64
+		if first := samples[0]; now.After(first.time) {
+			rate = float64(samples[len(samples)-1].bytes-first.bytes) / now.Sub(first.time).Seconds()
+		}
+
+		var b strings.Builder
+		b.WriteString("\x1b[H")
+		ac.drawTUI(&b, width, rate)
+		b.WriteString("\x1b[J> ")
+		io.WriteString(out, b.String())
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			fmt.Fprintln(out)
+			return
+		}
+	}
+}
+
+// drawTUI writes one frame of the terminal dashboard, cutting lines to width.
+func (ac *AstroCam) drawTUI(b *strings.Builder, width int, rate float64) {
+	line := func(format string, args ...any) {
+		text := fmt.Sprintf(format, args...)
+		if utf8.RuneCountInString(text) > width {
+			text = string([]rune(text)[:width-1]) + "…"
+		}
+		b.WriteString(text)
+		b.WriteString("\x1b[K\n")
+	}
+
+	ac.configMu.RLock()
+	data := ac.dashboardData()
+	station := ac.config.StationID
+	ac.configMu.RUnlock()
+	health := data.Health
+	activity, summary := ac.activity(health)
+	s := ac.status
+	s.mu.Lock()
+	packing, uploading, uploadStarted := s.packingArea, s.uploadingArchive, s.uploadStarted
+	s.mu.Unlock()
+	ac.pipeline.mu.Lock()
+	packQueue, uploadQueue := len(ac.pipeline.packing), len(ac.pipeline.uploading)
+	ac.pipeline.mu.Unlock()
+
+	title := "AstroCam-GO " + softwareVersion()
+	if station != "" {
+		title += "  station " + station
+	}
+	line("%s  %s  up %s", title, time.Now().Format("2006-01-02 15:04:05"), (time.Duration(health.UptimeSeconds) * time.Second).String())
+	line("State: %s (%s)", strings.ToUpper(string(activity)), summary)
+	switch {
+	case data.OperatorPaused:
+		line("Pipeline paused by the operator")
+	case data.PauseFile:
+		line("Pipeline paused by the PAUSE file")
+	case data.Unsafe:
+		line("Uploads paused: observatory unsafe")
+	case health.PausedUntil != nil:
+		line("Uploads paused until %s", health.PausedUntil.Format("15:04:05"))
+	case health.UploadLimitHit:
+		line("Daily upload limit reached: %s of %s uploaded today, uploads wait until midnight",
+			formatDataSize(health.UploadedToday), formatDataSize(health.DailyUploadLimit))
+	}
+	line("")
+
+	if packing != "" {
+		line("Packing:    area %s", packing)
+	} else {
+		line("Packing:    -")
+	}
+	if uploading != "" {
+		line("Uploading:  %s (%s)", uploading, time.Since(uploadStarted).Round(time.Second))
+	} else {
+		line("Uploading:  -")
+	}
+	line("Throughput: %.2f MB/s now, %.1f MB sent since start", rate/1e6, float64(uploadedBytes.Load())/1e6)
+	frames := 0
+	for _, a := range data.Areas {
+		frames += a.Count
+	}
+	line("Queue:      %d frame(s) waiting, %d area(s) to pack, %d archive(s) to upload, %d in the temp directory",
+		frames, packQueue, uploadQueue, health.PendingArchives)
+	line("Scans:      last %s, uploads %d ok / %d failed", ago(data.LastScan), health.Uploads, health.UploadErrors)
+	line("")
+
+	line("Frames waiting by area:")
+	if len(data.Areas) == 0 {
+		line("  none")
+	}
+	var row strings.Builder
+	for i, a := range data.Areas {
+		fmt.Fprintf(&row, "  %-12s %5d", a.Name, a.Count)
+		if (i+1)%4 == 0 || i == len(data.Areas)-1 {
+			line("%s", row.String())
+			row.Reset()
+		}
+	}
+	line("")
+
+	line("Last uploads:")
+	if len(data.History) == 0 {
+		line("  none")
+	}
+	for i, r := range data.History {
+		if i == 5 {
+			break
+		}
+		if r.Error != "" {
+			line("  %s  FAILED  %s: %s", r.Time.Format("15:04:05"), r.Archive, r.Error)
+		} else {
+			line("  %s  ok      %s", r.Time.Format("15:04:05"), r.Archive)
+		}
+	}
+	line("")
+
+	line("Log:")
+	entries := recentLog.list()
+	if len(entries) > tuiLogLines {
+		entries = entries[:tuiLogLines]
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		line("  %s %-5s %s", e.Time.Format("15:04:05"), e.Level, translate(e.Message))
+	}
+	line("")
+	line("Type p and Enter to pause or resume uploads, s to scan now, q to quit")
+}
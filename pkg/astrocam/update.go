@@ -0,0 +1,367 @@
+package astrocam
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_RELEASES_URL is the GitHub API endpoint of the latest release.
+const DEFAULT_RELEASES_URL = "https://api.github.com/repos/kirxkirx/astrocam-go/releases/latest"
+
+const (
+	// updateCheckInterval is the time between two checks for a new release.
+	updateCheckInterval = 24 * time.Hour
+	// updateMaxSize limits a downloaded executable.
+	updateMaxSize = 200 << 20
+	// checksumsAsset lists the SHA-256 of the release executables, as
+	// written by sha256sum; checksumsAsset+".minisig" is its signature.
+	checksumsAsset = "checksums.txt"
+)
+
+// Release is a published version of AstroCam-GO.
+type Release struct {
+	Version string            // tag, e.g. v1.4.0
+	URL     string            // release page
+	Assets  map[string]string // download URL by file name
+}
+
+// LatestRelease asks SAI_RELEASES_URL, a GitHub "latest release" API
+// endpoint, for the newest release.
+func LatestRelease(ctx context.Context, config *Config) (*Release, error) {
+	source := config.ReleasesURL
+	if source == "" {
+		source = DEFAULT_RELEASES_URL
+	}
+	resp, err := updateGet(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var answer struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+		Assets  []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("invalid answer from %s: %w", source, err)
+	}
+	if answer.TagName == "" {
+		return nil, fmt.Errorf("no release version in the answer from %s", source)
+	}
+	release := &Release{Version: answer.TagName, URL: answer.HTMLURL, Assets: make(map[string]string)}
+	for _, asset := range answer.Assets {
+		release.Assets[asset.Name] = asset.URL
+	}
+	return release, nil
+}
+
+// NewerThanRunning reports whether the release is newer than this build.
+// A development build is never outdated.
+func (r *Release) NewerThanRunning() bool {
+	return Version != "" && compareVersions(r.Version, Version) > 0
+}
+
+// compareVersions compares two version tags such as v1.4.0 number by number
+// and returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	numbers := func(v string) []int {
+		var n []int
+		for _, field := range strings.FieldsFunc(v, func(r rune) bool { return r < '0' || r > '9' }) {
+			i, _ := strconv.Atoi(field)
+			n = append(n, i)
+		}
+		return n
+	}
+	na, nb := numbers(a), numbers(b)
+	for i := 0; i < len(na) || i < len(nb); i++ {
+		var x, y int
+		if i < len(na) {
+			x = na[i]
+		}
+		if i < len(nb) {
+			y = nb[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// releaseAsset returns the name of the release executable for this system,
+// as built by the release workflow.
+func releaseAsset() (string, error) {
+	switch runtime.GOOS + "/" + runtime.GOARCH {
+	case "linux/amd64":
+		return "astrocam-go", nil
+	case "windows/amd64":
+		return "astrocam-go-win64.exe", nil
+	case "windows/386":
+		return "astrocam-go-win32.exe", nil
+	}
+	return "", fmt.Errorf("no release executable for %s/%s, build it from source", runtime.GOOS, runtime.GOARCH)
+}
+
+// InstallRelease replaces the running executable with that of the release.
+// The download is checked against the release's checksums.txt, whose
+// minisign signature must verify with the release key, or SAI_UPDATE_PUBKEY;
+// an unsigned release is then refused. A build without either key checks
+// the checksum only.
+// The previous executable is kept as EXECUTABLE.old; the new one runs after
+// a restart.
+func InstallRelease(ctx context.Context, config *Config, release *Release) error {
+	asset, err := releaseAsset()
+	if err != nil {
+		return err
+	}
+	if release.Assets[asset] == "" || release.Assets[checksumsAsset] == "" {
+		return fmt.Errorf("release %s has no %s or %s", release.Version, asset, checksumsAsset)
+	}
+	key, err := updatePublicKey(config)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		slog.Warn("This build has no release signing key, the update is checked against checksums.txt only; set SAI_UPDATE_PUBKEY to require a signature")
+	} else if release.Assets[checksumsAsset+".minisig"] == "" {
+		return fmt.Errorf("release %s is not signed (no %s.minisig), refusing it", release.Version, checksumsAsset)
+	}
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not get executable path: %w", err)
+	}
+	if execPath, err = filepath.EvalSymlinks(execPath); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(execPath), ".astrocam-update-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	checksums := filepath.Join(dir, checksumsAsset)
+	if err := downloadAsset(ctx, release.Assets[checksumsAsset], checksums); err != nil {
+		return fmt.Errorf("cannot download %s: %w", checksumsAsset, err)
+	}
+	if key != nil {
+		signature := filepath.Join(dir, checksumsAsset+".minisig")
+		if err := downloadAsset(ctx, release.Assets[checksumsAsset+".minisig"], signature); err != nil {
+			return fmt.Errorf("cannot download the signature: %w", err)
+		}
+		if err := verifyChecksums(key, checksums, signature); err != nil {
+			return err
+		}
+	}
+	want, err := listedChecksum(checksums, asset)
+	if err != nil {
+		return err
+	}
+
+	download := filepath.Join(dir, asset)
+	if err := downloadAsset(ctx, release.Assets[asset], download); err != nil {
+		return fmt.Errorf("cannot download %s: %w", asset, err)
+	}
+	got, err := hashFile(download)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, %s lists %s", asset, got, checksumsAsset, want)
+	}
+	if err := os.Chmod(download, 0755); err != nil {
+		return err
+	}
+
+	// A running executable can be renamed, also on Windows, but not replaced
+	old := execPath + ".old"
+	os.Remove(old)
+	if err := os.Rename(execPath, old); err != nil {
+		return fmt.Errorf("cannot move the running executable aside: %w", err)
+	}
+	if err := os.Rename(download, execPath); err != nil {
+		if restoreErr := os.Rename(old, execPath); restoreErr != nil {
+			return fmt.Errorf("cannot install %s: %w (and cannot restore %s: %v)", asset, err, filepath.Base(old), restoreErr)
+		}
+		return fmt.Errorf("cannot install %s: %w", asset, err)
+	}
+	slog.Info("Update installed, restart to run it", "version", release.Version, "executable", execPath, "previous", old)
+	return nil
+}
+
+// listedChecksum returns the SHA-256 of name in a sha256sum listing.
+func listedChecksum(path, name string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			if sum, err := hex.DecodeString(fields[0]); err != nil || len(sum) != sha256.Size {
+				return "", fmt.Errorf("invalid checksum of %s in %s", name, checksumsAsset)
+			}
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s does not list %s", checksumsAsset, name)
+}
+
+// verifyChecksums checks the minisign signature of the downloaded
+// checksums.txt.
+func verifyChecksums(key *minisignKey, checksums, signature string) error {
+	message, err := os.ReadFile(checksums)
+	if err != nil {
+		return err
+	}
+	sig, err := os.ReadFile(signature)
+	if err != nil {
+		return err
+	}
+	if err := key.Verify(message, sig); err != nil {
+		return fmt.Errorf("signature of %s does not verify: %w", checksumsAsset, err)
+	}
+	return nil
+}
+
+// downloadAsset saves a release file to path.
+func downloadAsset(ctx context.Context, source, path string) error {
+	resp, err := updateGet(ctx, source)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(resp.Body, updateMaxSize+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > updateMaxSize {
+		err = fmt.Errorf("larger than %d MB", updateMaxSize>>20)
+	}
+	return err
+}
+
+// updateGet sends a GET request to the release server.
+func updateGet(ctx context.Context, source string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "AstroCam-GO/"+softwareVersion())
+	req.Header.Set("Accept", "application/vnd.github+json, application/octet-stream")
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned status %s", source, resp.Status)
+	}
+	return resp, nil
+}
+
+// checkForUpdates asks, once a day until stop is closed, whether a newer
+// release is out, and logs and notifies every new one once. It is off with
+// SAI_UPDATE_CHECK=no and for development builds.
+func (ac *AstroCam) checkForUpdates(stop <-chan struct{}) {
+	announced := ""
+	for {
+		ac.jobsMu.RLock()
+		config := ac.config
+		ac.jobsMu.RUnlock()
+		if config.UpdateCheck && Version != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			release, err := LatestRelease(ctx, config)
+			cancel()
+			switch {
+			case err != nil:
+				slog.Debug("Cannot check for a new release", "error", err)
+			case release.NewerThanRunning() && release.Version != announced:
+				announced = release.Version
+				slog.Warn("A newer version of AstroCam-GO is available", "running", Version, "latest", release.Version, "url", release.URL)
+				ac.notify("update available", fmt.Sprintf("AstroCam-GO %s is available, this station runs %s. Install it with \"astrocam-go update\" or the \"update\" server command.\n%s", release.Version, Version, release.URL))
+			}
+		}
+		select {
+		case <-time.After(updateCheckInterval):
+		case <-stop:
+			return
+		}
+	}
+}
+
+// updateAndRestart installs the latest release, when it is newer, and
+// restarts the uploader to run it; for the "update" server command.
+func (ac *AstroCam) updateAndRestart() {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	defer cancel()
+	release, err := LatestRelease(ctx, ac.config)
+	if err != nil {
+		slog.Warn("Cannot check for a new release", "error", err)
+		return
+	}
+	if !release.NewerThanRunning() {
+		slog.Info("Already running the latest release", "running", softwareVersion(), "latest", release.Version)
+		return
+	}
+	if err := InstallRelease(ctx, ac.config, release); err != nil {
+		slog.Error("Update failed, keeping the running version", "version", release.Version, "error", err)
+		ac.notify("update failed", fmt.Sprintf("Installing AstroCam-GO %s failed: %v", release.Version, err))
+		return
+	}
+	ac.requestRestart()
+}
+
+// requestRestart makes Run return after the current scan, so that the
+// program starts again, e.g. with an updated executable.
+func (ac *AstroCam) requestRestart() {
+	ac.restartOnce.Do(func() { close(ac.restart) })
+}
+
+// untilRestart returns a channel that is closed with stop or when a restart
+// is requested, to stop the uploader in both cases.
+func (ac *AstroCam) untilRestart(stop <-chan struct{}) <-chan struct{} {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+		case <-ac.restart:
+		}
+		close(stopped)
+	}()
+	return stopped
+}
+
+// RestartRequested is closed when the uploader stopped running because it
+// wants to be started again, to run an updated executable.
+func (ac *AstroCam) RestartRequested() <-chan struct{} {
+	return ac.restart
+}
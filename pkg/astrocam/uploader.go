@@ -0,0 +1,631 @@
+package astrocam
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Uploader delivers an archive to its destination. Upload must return nil only
+// when the destination confirmed that it received the archive, because the
+// local copy is deleted afterwards. metadata is the archive description JSON
+// (area, frames, quality metrics), or nil if there is none.
+type Uploader interface {
+	Upload(ctx context.Context, path string, metadata []byte) error
+}
+
+// StreamUploader is implemented by uploaders that can send an archive while it
+// is being written, for SAI_STREAM_UPLOAD. UploadStream uploads what write
+// writes as the archive name and returns its size and SHA-256; like Upload,
+// it returns nil only when the destination confirmed the archive.
+type StreamUploader interface {
+	UploadStream(ctx context.Context, name string, metadata []byte, write func(io.Writer) error) (int64, string, error)
+}
+
+// Preflighter is implemented by uploaders that can ask the destination whether
+// it currently accepts uploads. Preflight returns "ok", "warning", "error" or
+// "unknown" and the server's message.
+type Preflighter interface {
+	Preflight(ctx context.Context) (status, message string)
+}
+
+// UploaderFactory creates an Uploader for a destination URL.
+type UploaderFactory func(destination *url.URL, config *Config) (Uploader, error)
+
+var (
+	uploaderFactoriesMu sync.Mutex
+	uploaderFactories   = map[string]UploaderFactory{
+		"http":  newHTTPUploader,
+		"https": newHTTPUploader,
+	}
+)
+
+// RegisterUploader makes a backend available for destination URLs with the
+// given scheme (e.g. "sftp"), replacing any earlier registration.
+func RegisterUploader(scheme string, factory UploaderFactory) {
+	uploaderFactoriesMu.Lock()
+	defer uploaderFactoriesMu.Unlock()
+	uploaderFactories[strings.ToLower(scheme)] = factory
+}
+
+// NewUploader returns the uploader for a destination URL, chosen by its scheme.
+func NewUploader(destination string, config *Config) (Uploader, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload URL %q: %w", destination, err)
+	}
+	uploaderFactoriesMu.Lock()
+	factory, ok := uploaderFactories[strings.ToLower(u.Scheme)]
+	uploaderFactoriesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported upload URL scheme %q in %s", u.Scheme, destination)
+	}
+	return factory(u, config)
+}
+
+// UploadFile uploads one archive with the configured destination and
+// credentials, for manual uploads. Unlike the upload stage it does not wait
+// for the upload throttle and leaves the file in place.
+func UploadFile(ctx context.Context, config *Config, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	server := archiveDestination(config, path)
+	if config.TLSInsecure {
+		slog.Warn("SAI_TLS_INSECURE is set: the upload server's certificate is NOT verified")
+	}
+	uploader, err := NewUploader(server, config)
+	if err != nil {
+		return err
+	}
+	if preflighter, ok := uploader.(Preflighter); ok {
+		if status, msg := preflighter.Preflight(ctx); status == "error" {
+			return fmt.Errorf("server does not accept uploads: %s", strings.TrimSpace(msg))
+		}
+	}
+	slog.Info("Uploading to server", "archive", filepath.Base(path), "server", server)
+	if err := uploader.Upload(ctx, path, readArchiveMetaRaw(path)); err != nil {
+		return err
+	}
+	slog.Info("Successfully uploaded", "archive", filepath.Base(path))
+	return nil
+}
+
+// AmbiguousUploadError reports, with SAI_STRICT_UPLOAD, a 2xx answer that
+// does not confirm the upload: the archive may or may not have arrived, and
+// whatever answered may not have been the server. Body holds the start of the
+// answer.
+type AmbiguousUploadError struct {
+	Status string
+	Body   string
+}
+
+func (e *AmbiguousUploadError) Error() string {
+	return fmt.Sprintf("answer %s does not confirm the upload: %s", e.Status, e.Body)
+}
+
+// UploadRejectedError reports that the server answered but did not accept the
+// archive. Body holds the start of the server response, whose UNMW_STATUS
+// message tells whether the server is out of disk space or overloaded.
+type UploadRejectedError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	RetryAfter time.Duration // Wait requested by a 429 or 503 answer (0 = none)
+}
+
+func (e *UploadRejectedError) Error() string {
+	switch {
+	case e.StatusCode >= 200 && e.StatusCode < 300:
+		return fmt.Sprintf("upload not confirmed by server (HTTP %d): %s", e.StatusCode, e.Body)
+	case e.StatusCode == http.StatusInsufficientStorage:
+		return fmt.Sprintf("server out of disk space (status 507): %s", e.Body)
+	case e.RetryAfter > 0:
+		return fmt.Sprintf("server returned status %d: %s, retry after %d seconds; %s", e.StatusCode, e.Status, retryAfterSeconds(e.RetryAfter), e.Body)
+	default:
+		return fmt.Sprintf("server returned status %d: %s; %s", e.StatusCode, e.Status, e.Body)
+	}
+}
+
+// retryAfter returns the wait a Retry-After header asks for, given in
+// seconds or as an HTTP date, or 0 if there is none.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// retryAfterSeconds rounds a Retry-After wait up to whole seconds.
+func retryAfterSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// HTTPUploader posts archives as multipart/form-data to an upload.py-style
+// endpoint, with optional HTTP basic authentication.
+type HTTPUploader struct {
+	URL      string
+	Username string
+	Password string
+	Timeout  time.Duration // Upload request timeout; 0 means DEFAULT_UPLOAD_TIMEOUT
+	Client   *http.Client  // nil uses a client with Timeout and TLS
+	TLS      *tls.Config   // Custom CA or disabled verification (nil = Go defaults)
+	// AuthMethod is "basic" (the default), "digest" or "ntlm"
+	AuthMethod string
+	// Bandwidth limits the upload rate in bytes per second (0 = unlimited)
+	Bandwidth int64
+
+	transport *http.Transport // shared by the requests of this uploader, for TLS and NTLM
+	receipt   string          // archive ID in the answer to the last successful upload
+
+	StationID   string            // Sent in the User-Agent and the "station" field (empty = not sent)
+	ExtraFields map[string]string // Additional form fields sent with every upload
+
+	ChecksumSidecar bool           // Send ARCHIVE.sha256 in the "checksum" field
+	Signer          *archiveSigner // Signs archives for the "signature" field (nil = unsigned)
+	AckURL          string         // Ingestion status endpoint with {id} and {name}, for IngestionChecker (empty = none)
+
+	// Strict accepts only UNMW_STATUS:OK, a JSON "status" of "ok" or
+	// "success", or SuccessToken as a confirmation; any other 2xx answer is
+	// an AmbiguousUploadError instead of a failure to retry
+	Strict       bool
+	SuccessToken string // Text of the answer that confirms an upload, in addition to the markers of upload.py (empty = none)
+}
+
+// reservedFormFields are the upload form fields set by HTTPUploader, which
+// SAI_UPLOAD_FIELD_<NAME> cannot replace.
+var reservedFormFields = map[string]bool{
+	"file": true, "metadata": true, "checksum": true, "signature": true, "station": true, "software_version": true,
+	"area": true, "frames": true, "sha256": true, "observation_date": true,
+}
+
+func newHTTPUploader(destination *url.URL, config *Config) (Uploader, error) {
+	tlsConf, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	policy := config.uploadPolicy(destination.String())
+	return &HTTPUploader{
+		URL:       destination.String(),
+		Username:  config.Username,
+		Password:  config.Password,
+		Timeout:   policy.Timeout,
+		Bandwidth: policy.Bandwidth,
+		TLS:       tlsConf,
+
+		AuthMethod: config.AuthMethod,
+
+		StationID:   config.StationID,
+		ExtraFields: config.UploadFields,
+
+		ChecksumSidecar: config.ChecksumSidecar,
+		Signer:          config.archiveSigner(),
+		AckURL:          config.AckURL,
+
+		Strict:       config.StrictUpload,
+		SuccessToken: config.SuccessToken,
+	}, nil
+}
+
+// userAgent identifies the software and, if set, the station.
+func (u *HTTPUploader) userAgent() string {
+	agent := "AstroCam-GO/" + softwareVersion()
+	if u.StationID != "" {
+		agent += " (station " + u.StationID + ")"
+	}
+	return agent
+}
+
+// formFields returns the form fields sent along with an archive: the station,
+// software version, SHA-256 of the archive and, from its metadata, the area,
+// frame count and observation date (the evening of the night), followed by
+// ExtraFields. The server need not parse them out of the file name.
+func (u *HTTPUploader) formFields(metadata []byte, sha string) [][2]string {
+	fields := [][2]string{{"software_version", softwareVersion()}, {"sha256", sha}}
+	if u.StationID != "" {
+		fields = append(fields, [2]string{"station", u.StationID})
+	}
+	var meta archiveMeta
+	if metadata != nil && json.Unmarshal(metadata, &meta) == nil {
+		fields = append(fields, [2]string{"area", meta.Area}, [2]string{"frames", strconv.Itoa(len(meta.Frames))})
+		if !meta.Created.IsZero() {
+			night := meta.Created.Local().Add(-nightStartHour * time.Hour)
+			fields = append(fields, [2]string{"observation_date", night.Format("2006-01-02")})
+		}
+	}
+	var names []string
+	for name := range u.ExtraFields {
+		if !reservedFormFields[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, [2]string{name, u.ExtraFields[name]})
+	}
+	return fields
+}
+
+func (u *HTTPUploader) client(timeout time.Duration) *http.Client {
+	if u.Client != nil {
+		return u.Client
+	}
+	if u.transport == nil && (u.TLS != nil || u.AuthMethod == authNTLM) {
+		u.transport = http.DefaultTransport.(*http.Transport).Clone()
+		u.transport.TLSClientConfig = u.TLS
+		if u.AuthMethod == authNTLM {
+			// NTLM authenticates the connection, so the handshake and the
+			// request must share it
+			u.transport.MaxConnsPerHost = 1
+		}
+	}
+	client := &http.Client{Timeout: timeout}
+	if u.transport != nil {
+		client.Transport = u.transport
+	}
+	return client
+}
+
+// hasCredentials checks if username and password are provided
+func (u *HTTPUploader) hasCredentials() bool {
+	return u.Username != "" && u.Password != ""
+}
+
+// Preflight sends a GET request to check server disk space and system load.
+func (u *HTTPUploader) Preflight(ctx context.Context) (string, string) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.URL, nil)
+	if err != nil {
+		return "unknown", fmt.Sprintf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("User-Agent", u.userAgent())
+	client := u.client(30 * time.Second)
+	if err := u.authenticate(client, req); err != nil {
+		return "unknown", fmt.Sprintf("preflight authentication failed: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "unknown", fmt.Sprintf("preflight request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	body := string(bodyBytes)
+
+	if strings.Contains(body, "UNMW_STATUS:ERROR") {
+		return "error", body
+	}
+	if strings.Contains(body, "UNMW_STATUS:WARNING") {
+		return "warning", body
+	}
+	if strings.Contains(body, "UNMW_STATUS:OK") {
+		return "ok", ""
+	}
+
+	// No UNMW_STATUS marker — old server or unexpected response
+	if resp.StatusCode == 507 {
+		return "error", fmt.Sprintf("server returned 507: %s", body)
+	}
+	// Rate limited: uploading now would only be rejected again
+	wait := retryAfter(resp.Header, time.Now())
+	if resp.StatusCode == http.StatusTooManyRequests || (resp.StatusCode == http.StatusServiceUnavailable && wait > 0) {
+		msg := fmt.Sprintf("server returned status %d", resp.StatusCode)
+		if wait > 0 {
+			msg += fmt.Sprintf(", retry after %d seconds", retryAfterSeconds(wait))
+		}
+		return "error", msg
+	}
+
+	return "unknown", ""
+}
+
+// get sends a GET request for target with the credentials of the uploader,
+// for the endpoints next to the upload script. The caller closes the body.
+func (u *HTTPUploader) get(ctx context.Context, target string, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", u.userAgent())
+	client := u.client(timeout)
+	if u.hasCredentials() {
+		if err := u.authenticate(client, req); err != nil {
+			return nil, err
+		}
+	}
+	return client.Do(req)
+}
+
+// uploadResponseIndicatesSuccess reports whether a 2xx upload response body
+// actually confirms success. upload.py returns HTTP 200 even for several POST
+// failures (it only sets a non-2xx status for out-of-disk-space), so success is
+// recognized by a positive marker: the redirect page printed on success
+// ("Upload successful") or an explicit UNMW_STATUS:OK from a newer server. An
+// explicit UNMW_STATUS:ERROR always counts as failure.
+func uploadResponseIndicatesSuccess(body string) bool {
+	lower := strings.ToLower(body)
+	if strings.Contains(lower, "unmw_status:error") {
+		return false
+	}
+	return strings.Contains(lower, "upload successful") ||
+		strings.Contains(lower, "unmw_status:ok")
+}
+
+// strictUploadConfirmation reports whether an upload answer explicitly
+// confirms success, for SAI_STRICT_UPLOAD: an UNMW_STATUS:OK line, or a JSON
+// object whose "status" is "ok" or "success". The "Upload successful" page of
+// older upload.py versions does not count, nor does any page a proxy or
+// portal might show.
+func strictUploadConfirmation(body string) bool {
+	lower := strings.ToLower(body)
+	if strings.Contains(lower, "unmw_status:error") {
+		return false
+	}
+	if strings.Contains(lower, "unmw_status:ok") {
+		return true
+	}
+	var answer struct {
+		Status string `json:"status"`
+	}
+	if json.Unmarshal([]byte(body), &answer) != nil {
+		return false
+	}
+	status := strings.ToLower(answer.Status)
+	return status == "ok" || status == "success"
+}
+
+// confirmsUpload reports whether a 2xx answer to an upload confirms it.
+func (u *HTTPUploader) confirmsUpload(body string) bool {
+	if u.SuccessToken != "" && strings.Contains(body, u.SuccessToken) && !strings.Contains(strings.ToLower(body), "unmw_status:error") {
+		return true
+	}
+	if u.Strict {
+		return strictUploadConfirmation(body)
+	}
+	return uploadResponseIndicatesSuccess(body)
+}
+
+// Upload posts the archive in the "file" form field, its checksum file and
+// detached signature, if enabled, in the "checksum" and "signature" fields,
+// the metadata, if any, in the "metadata" field and the fields described at
+// formFields.
+func (u *HTTPUploader) Upload(ctx context.Context, filePath string, metadata []byte) error {
+	// Open file with proper resource management
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// Create multipart form
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	// Add file to form
+	name := filepath.Base(filePath)
+	part, err := writer.CreateFormFile("file", name)
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %w", err)
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(part, hash), file)
+	if err != nil {
+		return fmt.Errorf("failed to copy file data: %w", err)
+	}
+	sha := hex.EncodeToString(hash.Sum(nil))
+
+	var signatureName string
+	var signature []byte
+	if u.Signer != nil {
+		signatureName, signature, err = u.Signer.Sign(ctx, filePath)
+		if err != nil {
+			return fmt.Errorf("cannot sign archive: %w", err)
+		}
+	}
+	if err := u.writeFormTrailer(writer, name, sha, metadata, signatureName, signature); err != nil {
+		return err
+	}
+	writer.Close()
+
+	return u.post(ctx, name, &body, writer.FormDataContentType())
+}
+
+// UploadStream posts the archive as Upload does, sending it while write
+// produces it instead of reading it from a file. The archive cannot be signed
+// before it is complete, so a Signer makes it fail.
+func (u *HTTPUploader) UploadStream(ctx context.Context, name string, metadata []byte, write func(io.Writer) error) (int64, string, error) {
+	if u.Signer != nil {
+		return 0, "", errors.New("signed archives cannot be streamed")
+	}
+	body, pipe := io.Pipe()
+	writer := multipart.NewWriter(pipe)
+
+	var size byteCounter
+	var sha string
+	written := make(chan error, 1)
+	go func() {
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			err = fmt.Errorf("failed to create form file: %w", err)
+		}
+		hash := sha256.New()
+		if err == nil {
+			err = write(io.MultiWriter(part, hash, &size))
+		}
+		if err == nil {
+			sha = hex.EncodeToString(hash.Sum(nil))
+			err = u.writeFormTrailer(writer, name, sha, metadata, "", nil)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pipe.CloseWithError(err)
+		written <- err
+	}()
+
+	err := u.post(ctx, name, body, writer.FormDataContentType())
+	// A request that ended early leaves the archive writer blocked on the pipe
+	body.CloseWithError(errUploadEnded)
+	writeErr := <-written
+	switch {
+	case writeErr == nil:
+	case !errors.Is(writeErr, errUploadEnded):
+		err = fmt.Errorf("failed to write archive: %w", writeErr)
+	case err == nil:
+		// The server answered before it had the whole archive
+		err = fmt.Errorf("upload failed: %w before the archive was sent", errUploadEnded)
+	}
+	return int64(size), sha, err
+}
+
+// errUploadEnded stops the archive writer of a streamed upload whose request
+// is over.
+var errUploadEnded = errors.New("upload request ended")
+
+// writeFormTrailer adds what follows the archive in the upload form: its
+// checksum file and signature, if enabled, the metadata, if any, and the
+// fields described at formFields.
+func (u *HTTPUploader) writeFormTrailer(writer *multipart.Writer, name, sha string, metadata []byte, signatureName string, signature []byte) error {
+	// The checksum as a file in sha256sum format, for servers that verify
+	// sidecar hashes of delivered data
+	if u.ChecksumSidecar {
+		part, err := writer.CreateFormFile("checksum", name+".sha256")
+		if err != nil {
+			return fmt.Errorf("failed to create checksum file: %w", err)
+		}
+		if _, err := fmt.Fprintf(part, "%s  %s\n", sha, name); err != nil {
+			return fmt.Errorf("failed to add checksum file: %w", err)
+		}
+	}
+	if signature != nil {
+		part, err := writer.CreateFormFile("signature", signatureName)
+		if err != nil {
+			return fmt.Errorf("failed to create signature file: %w", err)
+		}
+		if _, err := part.Write(signature); err != nil {
+			return fmt.Errorf("failed to add signature file: %w", err)
+		}
+	}
+
+	// Attach the archive description (area, frames, quality metrics) if present
+	if metadata != nil {
+		if err := writer.WriteField("metadata", string(metadata)); err != nil {
+			return fmt.Errorf("failed to add metadata: %w", err)
+		}
+	}
+	for _, field := range u.formFields(metadata, sha) {
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return fmt.Errorf("failed to add form field %s: %w", field[0], err)
+		}
+	}
+	return nil
+}
+
+// post sends an upload form and checks the server's answer; name is the
+// archive, for log messages.
+func (u *HTTPUploader) post(ctx context.Context, name string, body io.Reader, contentType string) error {
+	// Create HTTP request
+	req, err := http.NewRequestWithContext(ctx, "POST", u.URL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", u.userAgent())
+	if u.Bandwidth > 0 {
+		req.Body = newBandwidthLimiter(req.Body, u.Bandwidth)
+	}
+	if req.Body != nil {
+		req.Body = sentCounter{req.Body}
+	}
+
+	// Send request with timeout for large files/slow server
+	timeout := u.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_UPLOAD_TIMEOUT
+	}
+	client := u.client(timeout)
+
+	// Only set authentication if credentials are provided
+	if u.hasCredentials() {
+		if err := u.authenticate(client, req); err != nil {
+			return err
+		}
+		slog.Debug("Using authentication for upload", "username", u.Username, "method", u.AuthMethod)
+	} else {
+		slog.Debug("Uploading without authentication (no credentials provided)")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response body to detect disk space warnings/errors
+	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	bodyStr := string(bodyBytes)
+
+	// A portal that took over the network redirects the POST to its login
+	// page, which the client follows and which may well answer 200.
+	if resp.Request != nil && resp.Request.URL.Host != req.URL.Host {
+		return fmt.Errorf("upload redirected to %s, probably by a captive portal", resp.Request.URL.Redacted())
+	}
+
+	// Check response.
+	//
+	// A 2xx status alone does NOT mean the upload succeeded: upload.py returns
+	// HTTP 200 with an HTML error body for several POST failures (high system
+	// load, missing upload directory, archive validation failure, processing
+	// error) -- it only sets a non-2xx status (507) for out-of-disk-space. So
+	// treat the upload as successful ONLY when the body carries a positive
+	// success marker; otherwise return an error so the caller keeps the local
+	// archive for retry instead of deleting it.
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 && u.confirmsUpload(bodyStr) {
+		if strings.Contains(bodyStr, "UNMW_STATUS:WARNING") {
+			slog.Warn("Warning from server", "archive", name, "response", strings.TrimSpace(bodyStr))
+		}
+		u.receipt = parseArchiveID(bodyStr)
+		return nil
+	}
+
+	// A 2xx answer without an error marker may still mean that the archive
+	// arrived; in strict mode it is neither retried nor deleted.
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 && u.Strict && !strings.Contains(strings.ToLower(bodyStr), "unmw_status:error") {
+		return &AmbiguousUploadError{Status: resp.Status, Body: strings.TrimSpace(bodyStr)}
+	}
+
+	// Include the response body so the caller can classify the cause (e.g. a
+	// 503 "system load too high" -> short pause) from the server's message.
+	rejected := &UploadRejectedError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(bodyStr)}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		rejected.RetryAfter = retryAfter(resp.Header, time.Now())
+	}
+	return rejected
+}
@@ -0,0 +1,196 @@
+package astrocam
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	AUTH_FAILURE_PAUSE = 1 * time.Hour // pause after the server rejected the credentials
+	MAX_RETRY_BACKOFF  = 1 * time.Hour // longest wait before retrying a failed archive
+)
+
+// Kinds of upload failure, by what retrying can achieve.
+const (
+	failureTransient = iota // network error, timeout, 5xx: retry with back-off
+	failureAuth             // 401/403: no upload can succeed until the credentials are fixed
+	failurePermanent        // other 4xx: this archive will never be accepted as it is
+	failureAmbiguous        // unconfirmed 2xx with SAI_STRICT_UPLOAD: the archive may have arrived
+)
+
+// classifyUploadFailure tells transient upload errors from those that
+// retrying cannot fix.
+func classifyUploadFailure(err error) int {
+	var ambiguous *AmbiguousUploadError
+	if errors.As(err, &ambiguous) {
+		return failureAmbiguous
+	}
+	var rejected *UploadRejectedError
+	if !errors.As(err, &rejected) {
+		return failureTransient
+	}
+	switch code := rejected.StatusCode; {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return failureAuth
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests:
+		return failureTransient
+	case code >= 400 && code < 500:
+		return failurePermanent
+	}
+	return failureTransient
+}
+
+// uploadFailure is the retry state of an archive whose upload failed.
+type uploadFailure struct {
+	attempts  int
+	errors    []string // one line per failed attempt
+	retryAt   time.Time
+	permanent bool // not retried until restart
+}
+
+// recordUploadFailure notes a failed upload of archive and decides what to do
+// about it: credential errors pause all uploads and alert the operator,
+// permanent rejections and archives that failed SAI_MAX_UPLOAD_ATTEMPTS times
+// go to the failed directory, answers that neither confirm nor reject the
+// upload go to the needs-review directory, and anything else is retried after a back-off
+// that doubles with every attempt. The upload policy of the archive's
+// destination can set its own attempts and throttle, the first back-off.
+func (ac *AstroCam) recordUploadFailure(archive string, err error) {
+	name := filepath.Base(archive)
+	kind := classifyUploadFailure(err)
+	if kind == failureAuth {
+		slog.Error("The upload server rejected the username or password; check SAI_USERNAME and SAI_PASSWORD", "archive", name, "error", err)
+		ac.notify("upload credentials rejected",
+			fmt.Sprintf("The upload server rejected the credentials of this station:\n%v\n\nUploads are paused for %s or until the configuration is reloaded.", err, formatPauseDuration(AUTH_FAILURE_PAUSE)))
+		ac.pauseUploads("Server rejected the credentials", AUTH_FAILURE_PAUSE, err.Error())
+		return
+	}
+
+	policy := ac.config.uploadPolicy(ac.archiveServer(archive))
+	now := ac.clock.Now()
+	ac.failuresMu.Lock()
+	f := ac.uploadFailures[archive]
+	if f == nil {
+		f = &uploadFailure{}
+		ac.uploadFailures[archive] = f
+	}
+	f.attempts++
+	f.errors = append(f.errors, now.Format("2006-01-02 15:04:05")+" "+err.Error())
+	attempts, history := f.attempts, append([]string(nil), f.errors...)
+	giveUp := kind == failurePermanent || kind == failureAmbiguous || (policy.MaxAttempts > 0 && attempts >= policy.MaxAttempts)
+	if giveUp {
+		f.permanent = true
+	} else {
+		backoff := min(policy.Throttle<<min(attempts-1, 20), MAX_RETRY_BACKOFF)
+		f.retryAt = now.Add(max(backoff, time.Minute))
+		slog.Info("Upload will be retried", "archive", name, "attempt", attempts, "retry_after", f.retryAt.Format("15:04:05"))
+	}
+	ac.failuresMu.Unlock()
+	if !giveUp {
+		return
+	}
+
+	hint := fmt.Sprintf("gave up after %d failed attempts", attempts)
+	if kind == failurePermanent {
+		hint = "the server will not accept this archive as it is"
+		var rejected *UploadRejectedError
+		if errors.As(err, &rejected) && rejected.StatusCode == http.StatusRequestEntityTooLarge {
+			hint = "the archive is larger than the server accepts; lower SAI_COUNT or raise the server's upload limit"
+		}
+	}
+	if kind == failureAmbiguous {
+		ac.holdForReview(archive, err, history)
+		return
+	}
+	moved, moveErr := ac.moveToFailed(archive, hint, history)
+	if moveErr != nil {
+		slog.Error("Cannot move archive to the failed directory; it stays in temp and is not retried until restart",
+			"archive", name, "error", moveErr)
+		moved = archive
+	} else {
+		ac.clearUploadFailure(archive)
+	}
+	slog.Error("Upload given up", "archive", name, "error", err, "reason", hint, "archive_path", moved)
+	ac.notify("upload given up", fmt.Sprintf("%s: %v\n\n%s.\nThe archive is kept at %s; send it with \"astrocam-go reupload\" once the problem is solved.", name, err, hint, moved))
+}
+
+// holdForReview moves an archive whose upload was answered without a
+// confirmation, with SAI_STRICT_UPLOAD, into the needs-review directory: it
+// is neither deleted, since the answer may not have come from the server,
+// nor uploaded again, since it may have arrived. The operator checks the
+// server and sends it with reupload if needed.
+func (ac *AstroCam) holdForReview(archive string, err error, history []string) {
+	name := filepath.Base(archive)
+	hint := "the answer did not confirm the upload; check on the server whether the archive arrived before sending it again"
+	moved, moveErr := ac.moveArchiveAside(ac.reviewDirectory, archive, hint, history)
+	if moveErr != nil {
+		slog.Error("Cannot move archive to the needs-review directory; it stays in temp and is not retried until restart",
+			"archive", name, "error", moveErr)
+		moved = archive
+	} else {
+		ac.clearUploadFailure(archive)
+	}
+	slog.Error("Upload not confirmed, archive kept for review", "archive", name, "error", err, "archive_path", moved)
+	ac.notify("upload needs review", fmt.Sprintf("%s: %v\n\nThe archive was neither deleted nor uploaded again; it is kept at %s. Check on the server whether it arrived, and send it with \"astrocam-go reupload\" if not.", name, err, moved))
+}
+
+// moveToFailed moves an archive that is not retried any more, with its
+// metadata, into the failed directory and writes NAME.errors.txt next to it
+// with the reason and the error of every attempt. It returns the new path.
+func (ac *AstroCam) moveToFailed(archive, reason string, history []string) (string, error) {
+	return ac.moveArchiveAside(ac.failedDirectory, archive, reason, history)
+}
+
+// moveArchiveAside moves an archive with its metadata into dir and writes
+// NAME.errors.txt next to it, for moveToFailed and holdForReview.
+func (ac *AstroCam) moveArchiveAside(dir, archive, reason string, history []string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, filepath.Base(archive))
+	if err := ac.moveRecorded(archive, dst); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(archiveMetaPath(archive)); err == nil {
+		if err := moveFile(ac.fs, archiveMetaPath(archive), archiveMetaPath(dst)); err != nil {
+			slog.Warn("Cannot move archive metadata along with the archive", "archive", filepath.Base(archive), "path", dst, "error", err)
+		}
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "Archive:   %s\n", filepath.Base(archive))
+	fmt.Fprintf(&report, "Given up:  %s\n", ac.clock.Now().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&report, "Reason:    %s\n\nFailed attempts:\n", reason)
+	for _, line := range history {
+		fmt.Fprintf(&report, "%s\n", line)
+	}
+	if err := os.WriteFile(dst+".errors.txt", []byte(report.String()), 0644); err != nil {
+		slog.Warn("Cannot write the error report of a failed archive", "archive", filepath.Base(archive), "error", err)
+	}
+	return dst, nil
+}
+
+// clearUploadFailure forgets the failures of an uploaded archive.
+func (ac *AstroCam) clearUploadFailure(archive string) {
+	ac.failuresMu.Lock()
+	delete(ac.uploadFailures, archive)
+	ac.failuresMu.Unlock()
+}
+
+// uploadRetryDue reports whether an archive may be uploaded now: it has not
+// failed before, or its back-off has passed and the failure was not permanent.
+func (ac *AstroCam) uploadRetryDue(archive string) bool {
+	ac.failuresMu.Lock()
+	defer ac.failuresMu.Unlock()
+	f := ac.uploadFailures[archive]
+	if f == nil {
+		return true
+	}
+	return !f.permanent && !ac.clock.Now().Before(f.retryAt)
+}
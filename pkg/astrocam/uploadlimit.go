@@ -0,0 +1,165 @@
+package astrocam
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dataUnits are the units of SAI_DAILY_UPLOAD_LIMIT, decimal like the data
+// plans of mobile carriers.
+var dataUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1},
+}
+
+// parseDataSize converts an amount of data such as "20GB", "500 MB" or "1.5TB"
+// to bytes. A bare number is in gigabytes.
+func parseDataSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := 1e9
+	for _, unit := range dataUnits {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			s, multiplier = strings.TrimSpace(number), unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid amount of data %q", value)
+	}
+	return int64(n * multiplier), nil
+}
+
+// formatDataSize renders a byte count in the decimal units of
+// SAI_DAILY_UPLOAD_LIMIT.
+func formatDataSize(bytes int64) string {
+	for _, unit := range dataUnits[:4] {
+		if float64(bytes) >= unit.bytes {
+			return fmt.Sprintf("%.2f %s", float64(bytes)/unit.bytes, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
+
+// uploadDay names the local day that t falls on, the period of
+// SAI_DAILY_UPLOAD_LIMIT.
+func uploadDay(t time.Time) string {
+	return t.Local().Format("2006-01-02")
+}
+
+// uploadedToday returns the bytes of the upload attempts made today,
+// including those made before a restart.
+func (ac *AstroCam) uploadedToday() int64 {
+	if ac.state == nil {
+		return 0
+	}
+	return ac.state.uploadedOn(uploadDay(ac.clock.Now()))
+}
+
+// reserveUpload reports whether an upload of size bytes fits in what is left
+// of SAI_DAILY_UPLOAD_LIMIT today, counting the uploads in progress, and if
+// so reserves the size until releaseUpload, so that uploads running side by
+// side (streaming and the upload stage) cannot together overshoot the limit.
+// The first archive held back on a day is logged; the archives wait in the
+// temp directory until the next day.
+func (ac *AstroCam) reserveUpload(archive string, size int64) bool {
+	used, fits := ac.tryReserveUpload(size)
+	if fits {
+		return true
+	}
+	limit := ac.config.DailyUploadLimit
+	now := ac.clock.Now()
+	today := uploadDay(now)
+	ac.pauseMu.Lock()
+	first := ac.uploadLimitDay != today
+	ac.uploadLimitDay = today
+	ac.pauseMu.Unlock()
+	if first {
+		y, m, d := now.Local().Date()
+		slog.Warn("Daily upload limit reached, archives wait until midnight",
+			"uploaded_today", formatDataSize(used), "limit", formatDataSize(limit),
+			"archive", archive, "archive_size", formatDataSize(size),
+			"resumes", time.Date(y, m, d+1, 0, 0, 0, 0, time.Local).Format("2006-01-02 15:04"))
+		ac.notify("daily upload limit reached", fmt.Sprintf("%s uploaded today, SAI_DAILY_UPLOAD_LIMIT is %s. Archives are still created and wait in the temp directory until midnight.", formatDataSize(used), formatDataSize(limit)))
+	} else {
+		slog.Debug("Archive waits for the daily upload limit to reset", "archive", archive, "archive_size", formatDataSize(size))
+	}
+	return false
+}
+
+// tryReserveUpload reserves size bytes of SAI_DAILY_UPLOAD_LIMIT if they fit
+// in what is left today, or always without a limit, which may be set by a
+// reload while the upload runs. It returns the bytes used today, with those of the
+// uploads in progress, and whether the reservation was made.
+func (ac *AstroCam) tryReserveUpload(size int64) (int64, bool) {
+	ac.pauseMu.Lock()
+	defer ac.pauseMu.Unlock()
+	used := ac.uploadedToday() + ac.uploadReserved
+	if limit := ac.config.DailyUploadLimit; limit > 0 && used+size > limit {
+		return used, false
+	}
+	ac.uploadReserved += size
+	return used, true
+}
+
+// releaseUpload returns a reservation of reserveUpload once the upload has
+// been recorded in the state database, where it now counts.
+func (ac *AstroCam) releaseUpload(size int64) {
+	ac.pauseMu.Lock()
+	ac.uploadReserved = max(ac.uploadReserved-size, 0)
+	ac.pauseMu.Unlock()
+}
+
+// exceedsUploadLimit reports whether an archive is larger than
+// SAI_DAILY_UPLOAD_LIMIT, so that it would wait in the temp directory
+// forever. Such an archive is moved to the failed directory.
+func (ac *AstroCam) exceedsUploadLimit(archive string, size int64) bool {
+	limit := ac.config.DailyUploadLimit
+	if limit <= 0 || size <= limit {
+		return false
+	}
+	name := filepath.Base(archive)
+	hint := fmt.Sprintf("the archive (%s) is larger than SAI_DAILY_UPLOAD_LIMIT (%s); lower SAI_COUNT or raise the limit", formatDataSize(size), formatDataSize(limit))
+	moved, err := ac.moveToFailed(archive, hint, nil)
+	if err != nil {
+		slog.Error("Cannot move archive to the failed directory; it stays in temp and is not retried until restart",
+			"archive", name, "error", err)
+		moved = archive
+		ac.failuresMu.Lock()
+		ac.uploadFailures[archive] = &uploadFailure{permanent: true}
+		ac.failuresMu.Unlock()
+	}
+	slog.Warn("Archive is larger than the daily upload limit, not uploading it", "archive", name,
+		"archive_size", formatDataSize(size), "limit", formatDataSize(limit), "archive_path", moved)
+	ac.notify("archive over the daily upload limit", fmt.Sprintf("%s: %s.\nThe archive is kept at %s; send it with \"astrocam-go reupload\" once the limit allows.", name, hint, moved))
+	return true
+}
+
+// uploadLimitReached reports whether an upload was held back by
+// SAI_DAILY_UPLOAD_LIMIT today.
+func (ac *AstroCam) uploadLimitReached() bool {
+	ac.pauseMu.Lock()
+	defer ac.pauseMu.Unlock()
+	return ac.uploadLimitDay != "" && ac.uploadLimitDay == uploadDay(ac.clock.Now())
+}
+
+// checkUploadLimitReset logs, once, that the day on which the daily upload
+// limit was reached has ended. The scanner calls it before queueing the
+// waiting archives.
+func (ac *AstroCam) checkUploadLimitReset() {
+	ac.pauseMu.Lock()
+	ended := ac.uploadLimitDay != "" && (ac.uploadLimitDay != uploadDay(ac.clock.Now()) || ac.config.DailyUploadLimit <= 0)
+	if ended {
+		ac.uploadLimitDay = ""
+	}
+	ac.pauseMu.Unlock()
+	if ended {
+		slog.Info("Daily upload limit reset, uploading the waiting archives")
+	}
+}
@@ -0,0 +1,129 @@
+package astrocam
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uploadPolicyPrefix starts the config.env keys that give upload
+// destinations their own settings.
+const uploadPolicyPrefix = "SAI_UPLOAD_POLICY_"
+
+// uploadPolicy is how archives are uploaded to one destination.
+type uploadPolicy struct {
+	Throttle    time.Duration // Minimum time between upload attempts
+	Timeout     time.Duration // Limit for a single upload request
+	MaxAttempts int           // Failed attempts before an archive is given up (0 = retry forever)
+	Bandwidth   int64         // Upload rate limit in bytes per second (0 = unlimited)
+}
+
+// destinationPolicy overrides the upload settings for the destinations it
+// matches, from SAI_UPLOAD_POLICY_<NAME>=DESTINATION throttle=... timeout=...
+// attempts=... bandwidth=.... Settings it does not give are -1 and keep
+// the global SAI_UPLOAD_THROTTLE, SAI_UPLOAD_TIMEOUT,
+// SAI_MAX_UPLOAD_ATTEMPTS and SAI_UPLOAD_BANDWIDTH.
+type destinationPolicy struct {
+	Name        string
+	Destination string // Upload URL prefix, or host name
+	uploadPolicy
+}
+
+// parseDestinationPolicy parses the value of SAI_UPLOAD_POLICY_<NAME>.
+func parseDestinationPolicy(name, value string) (destinationPolicy, error) {
+	fields := strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+	if len(fields) == 0 || strings.Contains(fields[0], "=") {
+		return destinationPolicy{}, fmt.Errorf("no destination before the settings")
+	}
+	p := destinationPolicy{Name: name, Destination: fields[0], uploadPolicy: uploadPolicy{-1, -1, -1, -1}}
+	for _, field := range fields[1:] {
+		setting, v, _ := strings.Cut(field, "=")
+		var err error
+		switch strings.ToLower(setting) {
+		case "throttle":
+			p.Throttle, err = parseDuration(v)
+		case "timeout":
+			p.Timeout, err = parseDuration(v)
+			if err == nil && p.Timeout <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "attempts":
+			p.MaxAttempts, err = strconv.Atoi(v)
+		case "bandwidth":
+			var kb int
+			kb, err = strconv.Atoi(v)
+			p.Bandwidth = int64(kb) << 10
+		default:
+			return destinationPolicy{}, fmt.Errorf("unknown setting %q (throttle, timeout, attempts or bandwidth)", setting)
+		}
+		if err == nil && (p.Throttle < -1 || p.MaxAttempts < -1 || p.Bandwidth < -1) {
+			err = fmt.Errorf("must not be negative")
+		}
+		if err != nil {
+			return destinationPolicy{}, fmt.Errorf("invalid %s %q: %w", setting, v, err)
+		}
+	}
+	return p, nil
+}
+
+// matches reports whether the policy applies to an upload URL: the URL
+// starts with Destination or, if Destination is a bare host name, is on
+// that host.
+func (p destinationPolicy) matches(destination string) bool {
+	if strings.Contains(p.Destination, "://") {
+		return strings.HasPrefix(destination, p.Destination)
+	}
+	u, err := url.Parse(destination)
+	return err == nil && strings.EqualFold(u.Hostname(), p.Destination)
+}
+
+// String lists the settings the policy overrides, for the log.
+func (p destinationPolicy) String() string {
+	var settings []string
+	if p.Throttle >= 0 {
+		settings = append(settings, "throttle="+p.Throttle.String())
+	}
+	if p.Timeout >= 0 {
+		settings = append(settings, "timeout="+p.Timeout.String())
+	}
+	if p.MaxAttempts >= 0 {
+		settings = append(settings, "attempts="+strconv.Itoa(p.MaxAttempts))
+	}
+	if p.Bandwidth >= 0 {
+		settings = append(settings, "bandwidth="+strconv.FormatInt(p.Bandwidth>>10, 10))
+	}
+	return p.Destination + " " + strings.Join(settings, " ")
+}
+
+// uploadPolicy returns how archives are uploaded to destination: the global
+// settings, overridden by the first SAI_UPLOAD_POLICY_<NAME> (in name order)
+// that matches it.
+func (config *Config) uploadPolicy(destination string) uploadPolicy {
+	policy := uploadPolicy{
+		Throttle:    config.UploadThrottle,
+		Timeout:     config.UploadTimeout,
+		MaxAttempts: config.MaxUploadAttempts,
+		Bandwidth:   config.UploadBandwidth,
+	}
+	for _, p := range config.UploadPolicies {
+		if !p.matches(destination) {
+			continue
+		}
+		if p.Throttle >= 0 {
+			policy.Throttle = p.Throttle
+		}
+		if p.Timeout >= 0 {
+			policy.Timeout = p.Timeout
+		}
+		if p.MaxAttempts >= 0 {
+			policy.MaxAttempts = p.MaxAttempts
+		}
+		if p.Bandwidth >= 0 {
+			policy.Bandwidth = p.Bandwidth
+		}
+		break
+	}
+	return policy
+}
@@ -0,0 +1,197 @@
+package astrocam
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The watchdog notices a scan, pack or upload job that has been running for
+// longer than SAI_WATCHDOG_TIMEOUT, e.g. on a wedged rar.exe or a file-system
+// call that never returns on a dropped network share. It kills the child
+// processes running for that long, which fails the job so that it is retried
+// later, and writes a crash report with the stacks of all goroutines, which
+// is also sent to SAI_NOTIFY_URL and SAI_CRASH_URL. A file-system call cannot
+// be interrupted; the job goes on when it returns. A hang is reported once,
+// until the job ends.
+
+// DEFAULT_WATCHDOG_TIMEOUT is the SAI_WATCHDOG_TIMEOUT default.
+const DEFAULT_WATCHDOG_TIMEOUT = 30 * time.Minute
+
+// watchdogCheck is the time between two checks for hung jobs.
+const watchdogCheck = time.Minute
+
+// childWaitDelay bounds the wait for the output of a child process after it
+// exited or was killed.
+const childWaitDelay = 10 * time.Second
+
+// watchdog holds the start of the job each task is working on.
+type watchdog struct {
+	mu       sync.Mutex
+	busy     map[string]time.Time // task -> start of its current job
+	reported map[string]bool      // tasks whose current job was reported as hung
+}
+
+func newWatchdog() *watchdog {
+	return &watchdog{busy: make(map[string]time.Time), reported: make(map[string]bool)}
+}
+
+// begin records that task started a job and returns the function recording
+// its end, for use as defer w.begin("packer")().
+func (w *watchdog) begin(task string) func() {
+	w.mu.Lock()
+	w.busy[task] = time.Now()
+	w.mu.Unlock()
+	return func() {
+		w.mu.Lock()
+		delete(w.busy, task)
+		if w.reported[task] {
+			delete(w.reported, task)
+			slog.Info("Hung job finished", "task", task)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// working reports whether task is in the middle of a job.
+func (w *watchdog) working(task string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.busy[task]
+	return ok
+}
+
+// hung returns the tasks whose job started before deadline and was not
+// reported yet, with the job start, and marks them as reported.
+func (w *watchdog) hung(deadline time.Time) map[string]time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	hung := make(map[string]time.Time)
+	for task, since := range w.busy {
+		if since.Before(deadline) && !w.reported[task] {
+			hung[task] = since
+			w.reported[task] = true
+		}
+	}
+	return hung
+}
+
+// watchJobs checks every watchdogCheck for hung jobs until stop is closed.
+func (ac *AstroCam) watchJobs(stop <-chan struct{}) {
+	for {
+		select {
+		case <-time.After(watchdogCheck):
+		case <-stop:
+			return
+		}
+		ac.configMu.RLock()
+		timeout := ac.config.WatchdogTimeout
+		ac.configMu.RUnlock()
+		if timeout > 0 {
+			ac.checkHungJobs(timeout)
+		}
+	}
+}
+
+// checkHungJobs reports the jobs running for longer than timeout and kills
+// the child processes running for that long.
+func (ac *AstroCam) checkHungJobs(timeout time.Duration) {
+	now := time.Now()
+	hung := ac.watchdog.hung(now.Add(-timeout))
+	if len(hung) == 0 {
+		return
+	}
+	killed := children.killStartedBefore(now.Add(-timeout))
+
+	var tasks []string
+	for task := range hung {
+		tasks = append(tasks, task)
+	}
+	sort.Strings(tasks)
+	var b strings.Builder
+	fmt.Fprintf(&b, "AstroCam-GO %s found hung jobs at %s\n", softwareVersion(), now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "%s/%s, %s\n\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	for _, task := range tasks {
+		busy := now.Sub(hung[task]).Round(time.Second)
+		slog.Error("Job hung, watchdog intervening", "task", task, "busy", busy, "killed", killed)
+		ac.status.recordError(fmt.Errorf("%s hung for %s", task, busy))
+		fmt.Fprintf(&b, "The %s has been busy for %s (SAI_WATCHDOG_TIMEOUT %s)\n", task, busy, timeout)
+	}
+	if len(killed) > 0 {
+		fmt.Fprintf(&b, "Killed child processes: %s\n", strings.Join(killed, ", "))
+	} else {
+		fmt.Fprintln(&b, "No child process to kill; a file-system or network call may be blocked")
+	}
+	fmt.Fprintf(&b, "\nGoroutines:\n%s", allStacks())
+	ac.sendCrashReport("hung "+strings.Join(tasks, ", "), b.String(), now)
+}
+
+// allStacks returns the stack traces of all goroutines.
+func allStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 64<<20 {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// childProcesses tracks the running child processes of the pipeline, so that
+// the watchdog can kill those that hang.
+type childProcesses struct {
+	mu      sync.Mutex
+	running map[*exec.Cmd]time.Time // process -> start
+}
+
+// children are the child processes of every station in the process.
+var children = &childProcesses{running: make(map[*exec.Cmd]time.Time)}
+
+// runChild runs cmd like cmd.CombinedOutput, tracked so that the watchdog can
+// kill it. Output pipes still held by a grandchild of a killed process are
+// closed after childWaitDelay.
+func runChild(cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.WaitDelay = childWaitDelay
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	children.mu.Lock()
+	children.running[cmd] = time.Now()
+	children.mu.Unlock()
+	err := cmd.Wait()
+	children.mu.Lock()
+	delete(children.running, cmd)
+	children.mu.Unlock()
+	return output.Bytes(), err
+}
+
+// killStartedBefore kills the child processes started before t and returns
+// their names and process IDs.
+func (c *childProcesses) killStartedBefore(t time.Time) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var killed []string
+	for cmd, start := range c.running {
+		if !start.Before(t) {
+			continue
+		}
+		if err := cmd.Process.Kill(); err != nil {
+			slog.Warn("Cannot kill hung child process", "command", filepath.Base(cmd.Path), "pid", cmd.Process.Pid, "error", err)
+			continue
+		}
+		killed = append(killed, fmt.Sprintf("%s (pid %d)", filepath.Base(cmd.Path), cmd.Process.Pid))
+	}
+	sort.Strings(killed)
+	return killed
+}
@@ -0,0 +1,271 @@
+package astrocam
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_WEATHER_OVERCAST is the cloud cover, in percent, from which the
+// sky counts as overcast.
+const DEFAULT_WEATHER_OVERCAST = 90
+
+const (
+	// weatherHistory is how long the readings of the cloud sensor are kept
+	// for the frames packed later.
+	weatherHistory = 24 * time.Hour
+	// weatherMatchWindow is how far the nearest reading may be from the
+	// time a frame was written for it to describe that frame.
+	weatherMatchWindow = 15 * time.Minute
+	// weatherClearBelow is the cloud cover, in percent, below which the sky
+	// counts as clear.
+	weatherClearBelow = 20
+)
+
+// Sky conditions of a weatherReading
+const (
+	skyClear    = "clear"
+	skyCloudy   = "cloudy"
+	skyOvercast = "overcast"
+)
+
+// weatherReading is one reading of the cloud sensor, as sent to the server
+// in the metadata of each frame.
+type weatherReading struct {
+	Time            time.Time `json:"time"`
+	Sky             string    `json:"sky,omitempty"`               // skyClear, skyCloudy, skyOvercast or "" if unknown
+	CloudCover      *float64  `json:"cloud_cover,omitempty"`       // percent
+	SkyMinusAmbient *float64  `json:"sky_minus_ambient,omitempty"` // sky minus ambient temperature in °C, lower under a clear sky
+}
+
+// parseWeather interprets a message of SAI_WEATHER_SOURCE taken at the given
+// time: a JSON object, a Boltwood Cloud Sensor one-line data file, or a bare
+// cloud cover percentage or sky condition. overcast is SAI_WEATHER_OVERCAST.
+func parseWeather(data []byte, at time.Time, overcast int) (weatherReading, error) {
+	r := weatherReading{Time: at}
+	text := strings.TrimSpace(string(data))
+	fields := strings.Fields(text)
+	switch {
+	case strings.HasPrefix(text, "{"):
+		if err := r.parseJSON(data); err != nil {
+			return r, err
+		}
+	case len(fields) >= 16 && (fields[2] == "C" || fields[2] == "F"):
+		r.parseBoltwood(fields)
+	case len(fields) > 0:
+		if cover, err := strconv.ParseFloat(strings.TrimSuffix(text, "%"), 64); err == nil {
+			r.CloudCover = &cover
+		} else {
+			r.Sky = skyCondition(text)
+		}
+	}
+	if r.Sky == "" && r.CloudCover != nil {
+		switch {
+		case *r.CloudCover >= float64(overcast):
+			r.Sky = skyOvercast
+		case *r.CloudCover >= weatherClearBelow:
+			r.Sky = skyCloudy
+		default:
+			r.Sky = skyClear
+		}
+	}
+	if r.Sky == "" && r.CloudCover == nil && r.SkyMinusAmbient == nil {
+		return r, errors.New("no sky condition, cloud cover or sky temperature in the sensor message")
+	}
+	return r, nil
+}
+
+// parseJSON reads the sky condition ("sky", "condition" or
+// "cloud_condition"), cloud cover ("cloud_cover" or "clouds", in percent) and
+// sky and ambient temperatures ("sky_temperature", "ambient_temperature")
+// from a JSON object. Key names are matched without regard to case.
+func (r *weatherReading) parseJSON(data []byte) error {
+	var object map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return fmt.Errorf("invalid JSON from the cloud sensor: %w", err)
+	}
+	values := make(map[string]any, len(object))
+	for key, value := range object {
+		values[strings.ToLower(key)] = value
+	}
+	number := func(keys ...string) *float64 {
+		for _, key := range keys {
+			switch v := values[key].(type) {
+			case json.Number:
+				if f, err := v.Float64(); err == nil {
+					return &f
+				}
+			case string:
+				if f, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64); err == nil {
+					return &f
+				}
+			}
+		}
+		return nil
+	}
+	for _, key := range []string{"sky", "condition", "cloud_condition", "sky_condition"} {
+		if v, ok := values[key]; ok && r.Sky == "" {
+			r.Sky = skyCondition(fmt.Sprint(v))
+		}
+	}
+	r.CloudCover = number("cloud_cover", "cloudcover", "clouds")
+	sky, ambient := number("sky_temperature", "sky_temp"), number("ambient_temperature", "ambient_temp", "temperature")
+	if sky != nil && ambient != nil {
+		diff := *sky - *ambient
+		r.SkyMinusAmbient = &diff
+	}
+	return nil
+}
+
+// parseBoltwood reads the one-line data file of the Boltwood Cloud Sensor
+// II (Clarity II): date, time, temperature and wind units, sky, ambient and
+// sensor temperatures, ..., and in the 16th field the cloud condition, 1 for
+// clear, 2 for cloudy and 3 for very cloudy.
+func (r *weatherReading) parseBoltwood(fields []string) {
+	r.Sky = skyCondition(fields[15])
+	sky, err1 := strconv.ParseFloat(fields[4], 64)
+	ambient, err2 := strconv.ParseFloat(fields[5], 64)
+	// -998 and 999 mark a wet or out-of-range sky sensor
+	if err1 == nil && err2 == nil && sky > -990 && sky < 990 {
+		diff := sky - ambient
+		if fields[2] == "F" {
+			diff = diff * 5 / 9
+		}
+		r.SkyMinusAmbient = &diff
+	}
+}
+
+// skyCondition normalizes a sky condition: a word or a Boltwood code.
+func skyCondition(value string) string {
+	switch strings.ToLower(strings.Join(strings.Fields(value), " ")) {
+	case "clear", "1":
+		return skyClear
+	case "cloudy", "2":
+		return skyCloudy
+	case "very cloudy", "verycloudy", "overcast", "3":
+		return skyOvercast
+	}
+	return ""
+}
+
+// String describes the reading for the log.
+func (r weatherReading) String() string {
+	var parts []string
+	if r.Sky != "" {
+		parts = append(parts, r.Sky)
+	}
+	if r.CloudCover != nil {
+		parts = append(parts, fmt.Sprintf("%.0f%% cloud cover", *r.CloudCover))
+	}
+	if r.SkyMinusAmbient != nil {
+		parts = append(parts, fmt.Sprintf("sky %.1f °C below ambient", -*r.SkyMinusAmbient))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// weatherSensor polls SAI_WEATHER_SOURCE on the scanner and keeps the
+// readings of the last day, which the packer looks up by frame time.
+type weatherSensor struct {
+	poller sensorPoller
+
+	mu       sync.Mutex
+	readings []weatherReading // oldest first
+}
+
+// pollWeather takes a reading of the cloud sensor, once per scan. Failures
+// are logged once until the sensor answers again; frames packed meanwhile
+// have no reading and are kept.
+func (ac *AstroCam) pollWeather() {
+	w := ac.weather
+	var reading weatherReading
+	if !w.poller.poll("SAI_WEATHER_SOURCE", ac.config.WeatherSource, ac.config, func(data []byte, at time.Time) (err error) {
+		reading, err = parseWeather(data, at, ac.config.WeatherOvercast)
+		return err
+	}) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n := len(w.readings); n > 0 {
+		last := w.readings[n-1]
+		if !reading.Time.After(last.Time) {
+			return // the sensor has not written a new reading
+		}
+		if last.Sky != reading.Sky && reading.Sky != "" {
+			slog.Info("Sky conditions changed", "sky", reading.Sky, "reading", reading.String())
+		}
+	} else {
+		slog.Info("Cloud sensor reading", "reading", reading.String())
+	}
+	w.readings = append(w.readings, reading)
+	cutoff := reading.Time.Add(-weatherHistory)
+	for len(w.readings) > 0 && w.readings[0].Time.Before(cutoff) {
+		w.readings = w.readings[1:]
+	}
+}
+
+// at returns the reading nearest to t, if one was taken within
+// weatherMatchWindow of it.
+func (w *weatherSensor) at(t time.Time) *weatherReading {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var nearest *weatherReading
+	var distance time.Duration
+	for i := range w.readings {
+		d := w.readings[i].Time.Sub(t)
+		if d < 0 {
+			d = -d
+		}
+		if d <= weatherMatchWindow && (nearest == nil || d < distance) {
+			reading := w.readings[i]
+			nearest, distance = &reading, d
+		}
+	}
+	return nearest
+}
+
+// frameWeather returns the cloud sensor reading taken nearest to when each
+// frame was written, by frame path. Frames without one are left out.
+func (ac *AstroCam) frameWeather(frames []string) map[string]*weatherReading {
+	if ac.config.WeatherSource == "" {
+		return nil
+	}
+	weather := make(map[string]*weatherReading)
+	for _, frame := range frames {
+		info, err := ac.fs.Stat(frame)
+		if err != nil {
+			continue
+		}
+		if reading := ac.weather.at(info.ModTime()); reading != nil {
+			weather[frame] = reading
+		}
+	}
+	return weather
+}
+
+// overcastFrames returns the frames taken while the cloud sensor reported an
+// overcast sky, which SAI_WEATHER_GATE keeps from being uploaded.
+func (ac *AstroCam) overcastFrames(frames []string, weather map[string]*weatherReading) []string {
+	if !ac.config.WeatherGate {
+		return nil
+	}
+	var overcast []string
+	for _, frame := range frames {
+		if reading := weather[frame]; reading != nil && reading.Sky == skyOvercast {
+			slog.Warn("Sky overcast when the frame was taken, frame will not be uploaded",
+				"file", filepath.Base(frame), "reading", reading.String())
+			overcast = append(overcast, frame)
+		}
+	}
+	return overcast
+}